@@ -1,9 +0,0 @@
-package main
-
-type Client struct {
-	FirstName string
-	LastName  string
-	Status    string
-	Level     int
-	Signature string
-}
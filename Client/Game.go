@@ -1,42 +1,56 @@
 package main
 
 import (
-	"github.com/google/uuid"
+	"fmt"
+	"strings"
+
+	"Client/chessclient"
 	"github.com/notnil/chess"
-	"sync"
 )
 
-// Global game instance with mutex for synchronization
-var GlobalGame = struct {
-	sync.RWMutex
-	gameId uuid.UUID   // Unique game identifier
-	state  *chess.Game // Chess game state
-}{}
-
-// Function to get the global game ID
-func GetGlobalGameID() uuid.UUID {
-	GlobalGame.RLock()
-	defer GlobalGame.RUnlock()
-	return GlobalGame.gameId
-}
+// printBoard draws a position and tells how the game ended, when it did
+func printBoard(game *chess.Game) {
+	fmt.Println(game.Position().Board().Draw())
 
-// Function to set the global game ID
-func SetGlobalGameID(newID uuid.UUID) {
-	GlobalGame.Lock()
-	defer GlobalGame.Unlock()
-	GlobalGame.gameId = newID
+	if game.Outcome() != chess.NoOutcome {
+		fmt.Printf("Game completed. %s by %s.\n", game.Outcome(), game.Method())
+	}
 }
 
-// Function to get the global game state (the chess game)
-func GetGlobalGameState() *chess.Game {
-	GlobalGame.RLock()
-	defer GlobalGame.RUnlock()
-	return GlobalGame.state
+// printState draws the board of a game other than chess, written as "x.o/.x./... o": the
+// rows from the top, then the stone to play
+func printState(state string) {
+	fields := strings.Fields(state)
+	if len(fields) == 0 {
+		return
+	}
+	for _, row := range strings.Split(fields[0], "/") {
+		fmt.Println(strings.Join(strings.Split(row, ""), " "))
+	}
+	if len(fields) > 1 {
+		fmt.Printf("%s to play\n", fields[1])
+	}
 }
 
-// Function to set the global game state
-func SetGlobalGameState(state *chess.Game) {
-	GlobalGame.Lock()
-	defer GlobalGame.Unlock()
-	GlobalGame.state = state
+// printBoardUpdate prints the board pushed by the server after the opponent moved, or joined
+// the lobby, with the opening it reached when the server knows it
+func printBoardUpdate(update chessclient.BoardEvent) {
+	if update.Move == "" && update.Mover != "" {
+		fmt.Printf("\n%s joined the game\n", update.Mover)
+	} else {
+		fmt.Printf("\n%s played %s\n", update.Mover, update.Move)
+	}
+	if update.Game != nil {
+		fmt.Println(update.Game.Position().Board().Draw())
+	} else {
+		printState(update.State)
+	}
+
+	if update.OpeningCode != "" {
+		fmt.Printf("Opening: %s %s\n", update.OpeningCode, update.OpeningName)
+	}
+
+	if update.Outcome != chess.NoOutcome {
+		fmt.Printf("Game completed. %s by %s.\n", update.Outcome, update.Method)
+	}
 }
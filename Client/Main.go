@@ -2,397 +2,557 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/google/uuid"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
+
+	"Client/chessclient"
+	"github.com/google/uuid"
 )
 
-var client = &Client{
+var player = chessclient.Player{
 	FirstName: "John",
 	LastName:  "Doe",
 	Status:    "Active",
 	Level:     500,
 }
 
+// requestTimeout bounds the wait for the server's answer, a UDP datagram may be lost
+const requestTimeout = 10 * time.Second
+
+// analysisTimeout bounds the wait for the engine analysing a game
+const analysisTimeout = 5 * time.Minute
+
 // Main function
 func main() {
-	scanner := bufio.NewScanner(os.Stdin)
+	setupLogging(os.Stderr)
 
-	// Create a wait group to manage graceful shutdown
-	var wg sync.WaitGroup
+	// A subcommand runs once without prompting, for scripts
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
+	tlsOptions := registerTLSFlags(flag.CommandLine)
+	serverAddr := flag.String("server", "", "server address, skips the connection prompts")
+	useUDP := flag.Bool("udp", false, "with -server, connect over UDP instead of TCP")
+	flag.StringVar(&player.FirstName, "name", player.FirstName, "first name the server knows the player by")
+	flag.IntVar(&player.Level, "level", player.Level, "player rating, used by the matchmaking queue")
+	flag.Usage = usage
+	flag.Parse()
+	tlsConfig, err := tlsOptions.Config()
+	if err != nil {
+		slog.Error("Invalid TLS settings", "err", err)
+		os.Exit(1)
+	}
 
-	// Channel to handle graceful shutdown
-	stop := make(chan struct{})
+	scanner := bufio.NewScanner(os.Stdin)
 
-	// Listen for shutdown signals in a separate goroutine
+	// Leave on Ctrl+C even while the prompt waits for input
 	go func() {
-		// Create a channel to receive OS signals
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-		// Wait for a signal (e.g., Ctrl+C)
 		<-sigChan
-
-		// Notify all goroutines to stop
-		fmt.Println("\nReceived shutdown signal. Stopping listener...")
-		close(stop)
+		fmt.Println("\nReceived shutdown signal. Exiting client.")
+		os.Exit(0)
 	}()
 
-	// Flag to check if the connection is established
-	var connectionEstablished bool
-	// Channel to notify when the connection is established
-	connectionReady := make(chan struct{})
+	if *serverAddr != "" {
+		transport := chessclient.TCP
+		if *useUDP {
+			transport = chessclient.UDP
+		}
+		client, err := connect(transport, *serverAddr, tlsConfig, player, requestTimeout)
+		if err != nil {
+			fmt.Printf("Error connecting to the server: %v\n", err)
+			os.Exit(1)
+		}
+		runMenu(scanner, client, *serverAddr)
+		return
+	}
 
-	// Main loop to keep asking for connections
+	// Keep asking for a connection until one is established
 	for {
-		// If the connection is not yet established, prompt for connection type
-		if !connectionEstablished {
-			fmt.Print("\nEnter connection type (tcp/udp or 'exit' to quit): ")
-			scanner.Scan()
-			connectionType := strings.ToLower(scanner.Text())
+		fmt.Print("\nEnter connection type (tcp/udp or 'exit' to quit): ")
+		if !scanner.Scan() {
+			return
+		}
+		connectionType := strings.ToLower(strings.TrimSpace(scanner.Text()))
 
-			if connectionType == "exit" {
-				fmt.Println("Exiting client.")
-				close(stop)
-				break
-			}
+		if connectionType == "exit" {
+			fmt.Println("Exiting client.")
+			return
+		}
 
-			if connectionType != "tcp" && connectionType != "udp" {
-				fmt.Println("Invalid connection type. Please use 'tcp' or 'udp'.")
-				continue
-			}
+		if connectionType != "tcp" && connectionType != "udp" {
+			fmt.Println("Invalid connection type. Please use 'tcp' or 'udp'.")
+			continue
+		}
 
-			// Prompt for server address
-			fmt.Print("Enter server address (e.g., localhost:8080): ")
-			scanner.Scan()
-			serverAddr := scanner.Text()
-
-			var conn interface{}
-			var isTCP bool
-
-			// Create a listener based on TCP or UDP
-			if connectionType == "tcp" {
-				// Create a new continuous TCP listener
-				listener := NewContinuousTCPListener(serverAddr, client)
-				conn = listener
-				isTCP = true
-
-				// Add one goroutine to the wait group
-				wg.Add(1)
-
-				// Start the listener in a separate goroutine
-				go func() {
-					defer wg.Done()
-					// Connect and send initial hello message
-					if err := listener.Connect(); err != nil {
-						log.Printf("Failed to connect: %v", err)
-						return
-					}
-
-					// Send initial hello message
-					if err := listener.SendInitialHello(); err != nil {
-						log.Printf("Failed to send initial hello: %v", err)
-						return
-					}
-
-					// Start listening for messages
-					fmt.Println("Starting TCP listener...")
-					listener.Listen()
-
-					// After the listener starts, notify the main loop
-					connectionReady <- struct{}{}
-				}()
-			} else if connectionType == "udp" {
-				// Create a new continuous UDP listener
-				listener := NewContinuousUDPListener(serverAddr, client)
-				conn = listener
-				isTCP = false
-
-				// Add one goroutine to the wait group
-				wg.Add(1)
-
-				// Start the listener in a separate goroutine
-				go func() {
-					defer wg.Done()
-					// Connect and send initial hello message
-					if err := listener.Connect(); err != nil {
-						log.Printf("Failed to connect: %v", err)
-						return
-					}
-
-					// Send initial hello message
-					if err := listener.SendInitialHelloUDP(); err != nil {
-						log.Printf("Failed to send initial hello: %v", err)
-						return
-					}
-
-					// Start listening for messages
-					fmt.Println("Starting UDP listener...")
-					listener.Listen()
-
-					// After the listener starts, notify the main loop
-					connectionReady <- struct{}{}
-				}()
-			}
+		// Prompt for server address
+		fmt.Print("Enter server address (e.g., localhost:8080): ")
+		scanner.Scan()
+		serverAddr := strings.TrimSpace(scanner.Text())
 
-			// Wait for the connection to be established
-			<-connectionReady
-			connectionEstablished = true
+		client, err := connect(chessclient.Transport(connectionType), serverAddr, tlsConfig, player, requestTimeout)
+		if err != nil {
+			fmt.Printf("Error connecting to the server: %v\n", err)
+			continue
+		}
+		runMenu(scanner, client, serverAddr)
+		return
+	}
+}
 
-			// Once the connection is established, prompt for user actions
-			handleUserActions(scanner, client, conn, isTCP)
+// usage describes the interactive mode and the subcommands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: client [flags]                    interactive menu")
+	fmt.Fprintln(out, "       client COMMAND [flags] [args]     one command, e.g. for scripts")
+	fmt.Fprintln(out, "\nCommands (client COMMAND -h for their flags):")
+	for _, name := range commandNames() {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// runMenu runs the interactive menu over a connection until the player leaves
+func runMenu(scanner *bufio.Scanner, client *chessclient.Client, serverAddr string) {
+	fmt.Printf("Connected to %s over %s as %s.\n", serverAddr, client.Transport(), player.FirstName)
+	matches := make(chan chessclient.Match, 1)
+	go printEvents(client, matches)
+	handleUserActions(scanner, client, matches)
+	client.Close()
+}
+
+// connect connects to the server and introduces the player
+func connect(transport chessclient.Transport, serverAddr string, tlsConfig *tls.Config, me chessclient.Player, timeout time.Duration) (*chessclient.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	dialer := chessclient.Dialer{}
+	if transport == chessclient.TCP {
+		dialer.TLSConfig = tlsConfig
+	}
+	client, err := dialer.Connect(ctx, transport, serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Hello(ctx, me); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// printEvents prints what the server sends on its own until the connection ends, and wakes
+// up the prompt waiting for a match
+func printEvents(client *chessclient.Client, matches chan<- chessclient.Match) {
+	for event := range client.Events() {
+		switch event := event.(type) {
+		case chessclient.BoardEvent:
+			printBoardUpdate(event)
+		case chessclient.QueueEvent:
+			printQueueStatus(event)
+		case chessclient.MatchEvent:
+			printMatch(event.Match)
+			// Without blocking if nobody is waiting
+			select {
+			case matches <- event.Match:
+			default:
+			}
+		case chessclient.ChatEvent:
+			printChat(event)
+		case chessclient.UndoOfferEvent:
+			printUndoOffer(event)
+		case chessclient.UndoResultEvent:
+			printUndoResult(event)
+		case chessclient.NoticeEvent:
+			printNotice(event)
+		case chessclient.ErrorEvent:
+			printServerError(event.Err)
 		}
 	}
 
-	// Wait for all goroutines to finish before exiting the program
-	wg.Wait()
+	if err := client.Err(); !errors.Is(err, chessclient.ErrClosed) {
+		fmt.Printf("\n%v\n", err)
+	}
 }
 
-func handleUserActions(scanner *bufio.Scanner, client *Client, conn interface{}, isTCP bool) {
+func handleUserActions(scanner *bufio.Scanner, client *chessclient.Client, matches <-chan chessclient.Match) {
 	for {
 		// Display available actions
 		fmt.Println("\nSelect an action:")
 		fmt.Println("1. Create a Game")
 		fmt.Println("2. Join a Game")
 		fmt.Println("3. See Lobby List")
-		fmt.Println("4. Exit")
-		fmt.Print("Enter your choice (1-4): ")
-
-		scanner.Scan()
+		fmt.Println("4. Find a Match")
+		fmt.Println("5. Play against the Computer")
+		fmt.Println("6. Analyze a Finished Game")
+		fmt.Println("7. Browse Finished Games")
+		fmt.Println("8. Claim a Game Your Opponent Left")
+		fmt.Println("9. Exit")
+		fmt.Print("Enter your choice (1-9): ")
+
+		if !scanner.Scan() {
+			return
+		}
 		choice := strings.TrimSpace(scanner.Text())
 
 		switch choice {
 		case "1":
-			// Create a game (send request to server)
+			// Create a game and wait in its lobby for an opponent
+			fmt.Println("Enter the variant: standard, chess960 [POSITION 0-959], fromposition FEN, kingofthehill, threecheck or horde, or the game tictactoe or connectfour (press Enter for standard):")
+			scanner.Scan()
+			opts := gameOptions(strings.Fields(scanner.Text()))
+
 			fmt.Println("Creating a new game...")
+			ctx, cancel := requestContext()
+			gameID, err := client.CreateGame(ctx, opts)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error creating game: %v\n", err)
+				break
+			}
+			fmt.Printf("Game %s created, waiting in Lobby-%s for an opponent.\n", gameID, player.FirstName)
 
-			// Prepare the data for the GameRequest
-			if isTCP {
-				// Assert the conn to *ContinuousTCPListener
-				tcpListener, ok := conn.(*ContinuousTCPListener)
-				if !ok {
-					fmt.Println("Error: Invalid TCP connection type")
-					continue
-				}
-				// Send the game request using TCP connection
-				if err := SendGameRequest(tcpListener.conn, client); err != nil {
-					fmt.Printf("Error creating game: %v\n", err)
-					continue
-				}
-			} else {
-				// Assert the conn to *ContinuousUDPListener
-				udpListener, ok := conn.(*ContinuousUDPListener)
-				if !ok {
-					fmt.Println("Error: Invalid UDP connection type")
-					continue
-				}
-				// Send the game request using UDP connection
-				if err := SendGameRequest(udpListener.conn, client); err != nil {
-					fmt.Printf("Error creating game: %v\n", err)
-					continue
-				}
+			showBoard(client)
+			playMoves(scanner, client)
+
+		case "2":
+			// Join a lobby from the lobby list
+			fmt.Println("Enter the lobby to join (e.g., Lobby-Jane):")
+			scanner.Scan()
+			lobby := strings.TrimSpace(scanner.Text())
+			if lobby == "" {
+				fmt.Println("Lobby name cannot be empty. Please enter a valid lobby name.")
+				break
 			}
-			// Simulate server response for game creation
-			fmt.Println("Game created successfully!")
-
-			// Send the board request after game creation
-			signatureBytes := []byte(client.Signature) // Convert signature from string to []byte
-			gameID := GlobalGame.gameId.String()       // Assuming the game ID is available
-
-			// Send BoardRequest and Signature TLVs to the server
-			if isTCP {
-				tcpListener, ok := conn.(*ContinuousTCPListener)
-				if !ok {
-					fmt.Println("Error: Invalid TCP connection type")
-					continue
-				}
-				if err := SendBoardRequest(tcpListener.conn, gameID, signatureBytes); err != nil {
-					fmt.Printf("Error sending board request: %v\n", err)
-					continue
-				}
-			} else {
-				udpListener, ok := conn.(*ContinuousUDPListener)
-				if !ok {
-					fmt.Println("Error: Invalid UDP connection type")
-					continue
-				}
-				if err := SendBoardRequest(udpListener.conn, gameID, signatureBytes); err != nil {
-					fmt.Printf("Error sending board request: %v\n", err)
-					continue
-				}
+
+			ctx, cancel := requestContext()
+			gameID, err := client.Join(ctx, lobby)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error joining game: %v\n", err)
+				break
 			}
+			fmt.Printf("Successfully joined %s, game %s!\n", lobby, gameID)
 
-			// Simulate server response for board request
-			fmt.Println("Board request sent successfully!")
+			showBoard(client)
+			playMoves(scanner, client)
 
-			// Enter the loop to send moves
-			for {
-				// Ask the user to enter a move
-				fmt.Println("Enter your move (e.g., 'e2e4') or type 'exit' to quit:")
-				scanner.Scan()
-				move := strings.TrimSpace(scanner.Text())
+		case "3":
+			// See lobby list, optionally filtered by name
+			fmt.Print("Filter lobbies by name (leave empty for all): ")
+			scanner.Scan()
+			filter := strings.TrimSpace(scanner.Text())
 
-				// Exit the loop if the user types 'exit'
-				if move == "exit" {
-					fmt.Println("Exiting move input loop...")
-					break
-				}
+			fmt.Println("Fetching lobby list...")
+			ctx, cancel := requestContext()
+			lobbies, err := client.ListLobbies(ctx, filter)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error fetching lobby list: %v\n", err)
+				break
+			}
 
-				// Send the move to the server
-				if isTCP {
-					tcpListener, ok := conn.(*ContinuousTCPListener)
-					if !ok {
-						fmt.Println("Error: Invalid TCP connection type")
-						continue
-					}
-					if err := SendMoveRequest(tcpListener.conn, client, move); err != nil {
-						fmt.Printf("Error sending move request: %v\n", err)
-						continue
-					}
-				} else {
-					udpListener, ok := conn.(*ContinuousUDPListener)
-					if !ok {
-						fmt.Println("Error: Invalid UDP connection type")
-						continue
-					}
-					if err := SendMoveRequest(udpListener.conn, client, move); err != nil {
-						fmt.Printf("Error sending move request: %v\n", err)
-						continue
-					}
-				}
+			if len(lobbies) == 0 {
+				fmt.Println("No lobby is waiting for a player.")
+				break
+			}
+			fmt.Println("Available lobbies:")
+			for _, lobby := range lobbies {
+				fmt.Println(lobby)
+			}
 
-				// Simulate server response for move (this could be more detailed)
-				fmt.Printf("Move '%s' sent successfully!\n", move)
-
-				// Now, send the board request to fetch the updated board state after the move
-				if isTCP {
-					tcpListener, ok := conn.(*ContinuousTCPListener)
-					if !ok {
-						fmt.Println("Error: Invalid TCP connection type")
-						continue
-					}
-					if err := SendBoardRequest(tcpListener.conn, GlobalGame.gameId.String(), signatureBytes); err != nil {
-						fmt.Printf("Error sending board request after move: %v\n", err)
-					} else {
-						fmt.Println("Board state request sent successfully!")
-					}
-				} else {
-					udpListener, ok := conn.(*ContinuousUDPListener)
-					if !ok {
-						fmt.Println("Error: Invalid UDP connection type")
-						continue
-					}
-					if err := SendBoardRequest(udpListener.conn, GlobalGame.gameId.String(), signatureBytes); err != nil {
-						fmt.Printf("Error sending board request after move: %v\n", err)
-					} else {
-						fmt.Println("Board state request sent successfully!")
-					}
-				}
+		case "4":
+			// Join the matchmaking queue
+			fmt.Print("Enter a time control (minutes+increment, e.g. 5+3): ")
+			scanner.Scan()
+			timeControl := strings.TrimSpace(scanner.Text())
+			if timeControl == "" {
+				timeControl = "5+3"
 			}
 
-		case "2":
-			// Join a game (send request to server)
-			fmt.Println("Enter the game ID to join (UUID):")
+			fmt.Print("Rated game? (y/n): ")
 			scanner.Scan()
-			gameID := strings.TrimSpace(scanner.Text())
+			rated := strings.ToLower(strings.TrimSpace(scanner.Text())) == "y"
 
-			// Validate UUID format (optional, for robust error handling)
-			if _, err := uuid.Parse(gameID); err != nil {
-				fmt.Println("Invalid UUID format. Please enter a valid game ID.")
-				continue
+			ctx, cancel := requestContext()
+			status, err := client.JoinQueue(ctx, timeControl, rated)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error joining the matchmaking queue: %v\n", err)
+				break
 			}
+			printQueueStatus(status)
 
-			// Prompt the user for their player name
-			fmt.Println("Enter your player name:")
+			if waitForMatch(scanner, client, matches) {
+				playMoves(scanner, client)
+			}
+
+		case "5":
+			// Play against the server's engine
+			fmt.Print("Enter the computer level (1-5): ")
+			scanner.Scan()
+			level, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+			if err != nil {
+				fmt.Println("Invalid level, please enter a number.")
+				break
+			}
+
+			fmt.Print("Play as (white/black/random): ")
+			scanner.Scan()
+			color := strings.ToLower(strings.TrimSpace(scanner.Text()))
+
+			fmt.Print("Engine (alphabeta/uci, leave empty for the server default): ")
+			scanner.Scan()
+			engine := strings.ToLower(strings.TrimSpace(scanner.Text()))
+
+			ctx, cancel := requestContext()
+			match, err := client.PlayBot(ctx, chessclient.BotOptions{Level: level, Color: color, Engine: engine})
+			cancel()
+			if err != nil {
+				fmt.Printf("Error creating a game against the computer: %v\n", err)
+				break
+			}
+			printMatch(match)
+			playMoves(scanner, client)
+
+		case "6":
+			// Ask the server to analyse a finished game, the last one played by default
+			gameID, ok := promptGameID(scanner, client, "analyze")
+			if !ok {
+				break
+			}
+
+			fmt.Println("Analysing the game, this may take a while...")
+			ctx, cancel := context.WithTimeout(context.Background(), analysisTimeout)
+			analysis, err := client.Analyze(ctx, gameID)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error analysing the game: %v\n", err)
+				break
+			}
+			printAnalysis(analysis)
+
+		case "7":
+			// List finished games, optionally filtered by opening and player
+			fmt.Print("Filter by opening (ECO code like C6 or a name like sicilian, leave empty for all): ")
+			scanner.Scan()
+			opening := strings.TrimSpace(scanner.Text())
+
+			fmt.Print("Filter by player (leave empty for all): ")
 			scanner.Scan()
 			playerName := strings.TrimSpace(scanner.Text())
 
-			// Validate player name (optional check)
-			if playerName == "" {
-				fmt.Println("Player name cannot be empty. Please enter a valid player name.")
-				continue
+			ctx, cancel := requestContext()
+			games, err := client.Archive(ctx, chessclient.ArchiveFilter{Opening: opening, Player: playerName})
+			cancel()
+			if err != nil {
+				fmt.Printf("Error fetching finished games: %v\n", err)
+				break
 			}
+			printArchive(games)
 
-			fmt.Printf("Attempting to join game with ID %s and player name %s...\n", gameID, playerName)
+		case "8":
+			// Claim the win once the opponent has been gone for the server's abandon timeout
+			gameID, ok := promptGameID(scanner, client, "claim")
+			if !ok {
+				break
+			}
 
-			if isTCP {
-				// Assert the conn to *ContinuousTCPListener
-				tcpListener, ok := conn.(*ContinuousTCPListener)
-				if !ok {
-					fmt.Println("Error: Invalid TCP connection type")
-					continue
-				}
-				// Send the request with game ID, player name, and client signature
-				if err := SendJoinGameRequest(tcpListener.conn, client, playerName); err != nil {
-					fmt.Printf("Error joining game: %v\n", err)
-					continue
-				}
-			} else {
-				// If it's a UDP connection:
-				udpListener, ok := conn.(*ContinuousUDPListener)
-				if !ok {
-					fmt.Println("Error: Invalid UDP connection type")
-					continue
-				}
-				// Send the request with game ID, player name, and client signature
-				if err := SendJoinGameRequest(udpListener.conn, client, playerName); err != nil {
-					fmt.Printf("Error joining game: %v\n", err)
-					continue
-				}
+			ctx, cancel := requestContext()
+			err := client.ClaimAbandoned(ctx, gameID)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error claiming the game: %v\n", err)
+				break
 			}
-			fmt.Printf("Successfully joined game %s as player %s!\n", gameID, playerName)
+			fmt.Println("Claim sent, the final board will be shown if the server accepts it.")
 
-		case "3":
-			// See lobby list (send request to server)
-			fmt.Println("Fetching lobby list...")
+		case "9":
+			fmt.Println("Exiting...")
+			return
+
+		default:
+			fmt.Println("Invalid choice, please select a valid option.")
+		}
+
+		// Nothing more can be done once the server is gone
+		select {
+		case <-client.Done():
+			return
+		default:
+		}
+	}
+}
+
+// requestContext bounds the wait for the answer to a request
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
+
+// promptGameID asks for a game ID, the current game when left empty
+func promptGameID(scanner *bufio.Scanner, client *chessclient.Client, action string) (uuid.UUID, bool) {
+	fmt.Printf("Enter the game ID to %s (leave empty for %s): ", action, client.GameID())
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+	if input == "" {
+		return client.GameID(), true
+	}
+
+	gameID, err := uuid.Parse(input)
+	if err != nil {
+		fmt.Println("Invalid UUID format. Please enter a valid game ID.")
+		return uuid.Nil, false
+	}
+	return gameID, true
+}
+
+// showBoard fetches the position of the current game from the server and draws it
+func showBoard(client *chessclient.Client) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	board, err := client.Board(ctx)
+	if err != nil {
+		fmt.Printf("Error fetching the board: %v\n", err)
+		return
+	}
+	if board == nil {
+		printState(client.State())
+		return
+	}
+	printBoard(board)
+}
 
-			var lobbyList []string
-			var err error
+// playMoves reads moves from the prompt and sends them to the server until the player types 'exit'
+func playMoves(scanner *bufio.Scanner, client *chessclient.Client) {
+	for {
+		// Ask the user to enter a move
+		fmt.Println("Enter your move (e.g., 'e4', 'Nf3' or 'g1f3'), 'moves' or 'moves g1' for the legal moves,")
+		fmt.Println("'takeback' or 'takeback 2' to ask to undo moves, 'accept' or 'decline' to answer, or 'exit' to quit:")
+		if !scanner.Scan() {
+			return
+		}
+		move := strings.TrimSpace(scanner.Text())
+
+		// Exit the loop if the user types 'exit'
+		if move == "exit" {
+			fmt.Println("Exiting move input loop...")
+			return
+		}
 
-			// Ensure the correct connection type (TCP or UDP) and call SendLobbyListRequest
-			if isTCP {
-				// Assert the conn to *ContinuousTCPListener
-				tcpListener, ok := conn.(*ContinuousTCPListener)
-				if !ok {
-					fmt.Println("Error: Invalid TCP connection type")
+		// List the legal moves, of a piece when a square or piece letter follows
+		if filter, ok := strings.CutPrefix(move, "moves"); ok {
+			moves, err := client.LegalMoves(strings.TrimSpace(filter))
+			if err != nil {
+				fmt.Printf("Error listing the legal moves: %v\n", err)
+				continue
+			}
+			fmt.Printf("Legal moves: %s\n", strings.Join(moves, " "))
+			continue
+		}
+
+		// Takebacks: the answers come as events
+		if plies, ok := strings.CutPrefix(move, "takeback"); ok {
+			count := 1
+			if plies = strings.TrimSpace(plies); plies != "" {
+				var err error
+				if count, err = strconv.Atoi(plies); err != nil {
+					fmt.Println("Usage: takeback [1|2]")
 					continue
 				}
-				// Send the lobby list request using TCP connection
-				lobbyList, err = SendLobbyListRequest(tcpListener.conn, client)
+			}
+			ctx, cancel := requestContext()
+			err := client.RequestUndo(ctx, count)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error asking for a takeback: %v\n", err)
 			} else {
-				udpListener, ok := conn.(*ContinuousUDPListener)
-				if !ok {
-					fmt.Println("Error: Invalid UDP connection type")
-					continue
-				}
-				// Send the lobby list request using UDP connection
-				lobbyList, err = SendLobbyListRequest(udpListener.conn, client)
+				fmt.Println("Takeback asked, waiting for your opponent's answer.")
 			}
-
-			// Handle the error if the lobby list request fails
+			continue
+		}
+		if move == "accept" || move == "decline" {
+			ctx, cancel := requestContext()
+			err := client.AnswerUndo(ctx, move == "accept")
+			cancel()
 			if err != nil {
-				fmt.Printf("Error fetching lobby list: %v\n", err)
-				continue
+				fmt.Printf("Error answering the takeback: %v\n", err)
 			}
+			continue
+		}
 
-			// Display the lobby list
-			fmt.Println("Available lobbies:")
-			for _, lobby := range lobbyList {
-				fmt.Println(lobby)
+		ctx, cancel := requestContext()
+		err := client.Move(ctx, move)
+		cancel()
+		if err != nil {
+			fmt.Printf("Move '%s' not played: %v\n", move, err)
+			if errors.Is(err, chessclient.ErrClosed) || client.Err() != nil {
+				return
 			}
+			continue
+		}
 
-		case "4":
+		// The server answers with the position after the move, or the board of another game
+		if board := client.Position(); board != nil {
+			printBoard(board)
+		} else {
+			printState(client.State())
+		}
+	}
+}
 
-			fmt.Println("Exiting...")
-			return
+// waitForMatch blocks until the matchmaker finds an opponent or the player cancels.
+// It returns true when a game was found.
+func waitForMatch(scanner *bufio.Scanner, client *chessclient.Client, matches <-chan chessclient.Match) bool {
+	// Read the prompt in the background so a match can interrupt the wait
+	readLine := func() chan string {
+		input := make(chan string, 1)
+		go func() {
+			if scanner.Scan() {
+				input <- strings.TrimSpace(scanner.Text())
+			}
+			close(input)
+		}()
+		return input
+	}
 
-		default:
-			fmt.Println("Invalid choice, please select a valid option.")
+	fmt.Println("Waiting for an opponent... type 'cancel' to leave the queue.")
+	input := readLine()
+	for {
+		select {
+		case match := <-matches:
+			fmt.Printf("Game %s is starting. Press Enter to continue.\n", match.GameID)
+			<-input
+			return true
+		case <-client.Done():
+			return false
+		case line, ok := <-input:
+			if !ok || line == "cancel" {
+				ctx, cancel := requestContext()
+				if err := client.CancelQueue(ctx); err != nil {
+					fmt.Printf("Error leaving the matchmaking queue: %v\n", err)
+				} else {
+					fmt.Println("You left the matchmaking queue.")
+				}
+				cancel()
+				return false
+			}
+			fmt.Println("Still waiting... type 'cancel' to leave the queue.")
+			input = readLine()
 		}
 	}
 }
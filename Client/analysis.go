@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"Client/chessclient"
+)
+
+// printAnalysis prints the analysis of a game: annotated PGN, both accuracies and the moves
+// that went wrong
+func printAnalysis(analysis *chessclient.Analysis) {
+	fmt.Printf("\n%s\n", analysis.PGN)
+	fmt.Printf("Accuracy: White %.1f%%, Black %.1f%%\n", analysis.WhiteAccuracy, analysis.BlackAccuracy)
+
+	for _, move := range analysis.Moves {
+		if move.Classification == "" {
+			continue
+		}
+
+		number := fmt.Sprintf("%d.", (move.Ply+1)/2)
+		if move.Ply%2 == 0 {
+			number += ".."
+		}
+		fmt.Printf("%s %s: %s (eval %s), %s was best\n", number, move.SAN, move.Classification, move.Eval, move.BestMove)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"Client/chessclient"
+)
+
+// printArchive lists finished games sent by the server
+func printArchive(games []chessclient.ArchivedGame) {
+	if len(games) == 0 {
+		fmt.Println("\nNo finished game matches.")
+		return
+	}
+
+	fmt.Printf("\n%d finished game(s):\n", len(games))
+	for _, game := range games {
+		opening := "Unknown opening"
+		if game.OpeningCode != "" {
+			opening = fmt.Sprintf("%s %s", game.OpeningCode, game.OpeningName)
+		}
+		fmt.Printf("%s  %s - %s  %s  %s, %d moves, %s\n",
+			game.ID, game.White, game.Black, game.Result, opening, game.Moves, game.EndedAt)
+	}
+}
@@ -0,0 +1,631 @@
+// Package chessclient is a client for the chess server's TLV protocol, over TCP with or
+// without TLS, or over UDP. It keeps no global state: each Client holds its own connection,
+// player and current game, so bots, tests and user interfaces can run as many as they need.
+//
+// A Client is used in three steps: Connect to the server, introduce the player with Hello,
+// then create, join or find a game and play it with Move. Requests block until the server
+// answers, is refused with a *ServerError, or the context ends. What the server sends on its
+// own, such as the opponent's moves, comes on the Events channel.
+//
+// The server takes a single request per read, so a Client sends its requests one at a time,
+// each waiting for the previous one to be answered. Over UDP a lost datagram is never answered:
+// give the requests a context with a deadline.
+package chessclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// Transport is the network protocol used to reach the server
+type Transport string
+
+const (
+	TCP Transport = "tcp"
+	UDP Transport = "udp"
+)
+
+// defaultEventBuffer is the number of events a Client keeps for a slow reader
+const defaultEventBuffer = 64
+
+// ErrClosed is returned by the requests of a Client that was closed
+var ErrClosed = errors.New("chessclient: client closed")
+
+// ErrNoGame is returned by the requests about the current game when there is none
+var ErrNoGame = errors.New("chessclient: no current game, create, join or find one first")
+
+// Dialer holds the settings of the connections to the server. The zero value connects
+// without TLS and logs with slog.Default().
+type Dialer struct {
+	// TLSConfig encrypts TCP connections, nil for plain TCP. Without a ServerName, the
+	// certificate must be valid for the host of the server address.
+	TLSConfig *tls.Config
+
+	Logger *slog.Logger
+
+	// EventBuffer is the number of events kept while nobody reads them, 64 when zero.
+	// Events that do not fit are dropped.
+	EventBuffer int
+}
+
+// Connect connects to the server at addr with the default settings
+func Connect(ctx context.Context, transport Transport, addr string) (*Client, error) {
+	var d Dialer
+	return d.Connect(ctx, transport, addr)
+}
+
+// Connect connects to the server at addr. The player introduces itself with Hello next.
+func (d *Dialer) Connect(ctx context.Context, transport Transport, addr string) (*Client, error) {
+	logger := d.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With("server", addr, "transport", string(transport))
+
+	var dialer net.Dialer
+	var conn net.Conn
+	switch transport {
+	case TCP:
+		tcpConn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to server: %w", err)
+		}
+		conn = tcpConn
+		if d.TLSConfig != nil {
+			// Verify the server's certificate before anything, signatures included, is sent
+			tlsConn := tls.Client(tcpConn, tlsConfigFor(d.TLSConfig, addr))
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				tcpConn.Close()
+				return nil, fmt.Errorf("TLS handshake with the server failed: %w", err)
+			}
+			logger.Info("TLS connection established", "version", tls.VersionName(tlsConn.ConnectionState().Version))
+			conn = tlsConn
+		}
+	case UDP:
+		udpConn, err := dialer.DialContext(ctx, "udp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to server: %w", err)
+		}
+		conn = udpConn
+	default:
+		return nil, fmt.Errorf("unknown transport %q, use tcp or udp", transport)
+	}
+
+	buffer := d.EventBuffer
+	if buffer <= 0 {
+		buffer = defaultEventBuffer
+	}
+	c := &Client{
+		conn:      conn,
+		transport: transport,
+		logger:    logger,
+		requests:  make(chan struct{}, 1),
+		events:    make(chan Event, buffer),
+		done:      make(chan struct{}),
+	}
+	go c.readLoop()
+	logger.Info("Connected to server")
+	return c, nil
+}
+
+// tlsConfigFor completes the settings for a server address: without an explicit server
+// name, the certificate must be valid for the host the client connects to
+func tlsConfigFor(config *tls.Config, serverAddr string) *tls.Config {
+	config = config.Clone()
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(serverAddr); err == nil {
+			config.ServerName = host
+		}
+	}
+	return config
+}
+
+// Client is a connection to the server and the session of the player on it. Its methods
+// may be called from several goroutines.
+type Client struct {
+	conn      net.Conn
+	transport Transport
+	logger    *slog.Logger
+
+	requests chan struct{} // Holds a token while a request waits for its answer
+	writeMu  sync.Mutex    // Requests and pongs are written from different goroutines
+
+	mu        sync.Mutex
+	player    Player
+	signature string      // Random secret given in Hello, proves the following requests come from us
+	gameID    uuid.UUID   // Current game, created, joined or found
+	color     string      // Color played in the current game, "" when there is none
+	alone     bool        // The current game is a lobby we opened that nobody joined yet
+	board     *chess.Game // Last position known of the current game, nil in a game other than chess
+	state     string      // Last board of the current game as the server wrote it, see State
+	queued    string      // Game the player queued for, "" for chess, see JoinGameQueue
+	waiter    *waiter     // Request waiting for its answer
+	err       error       // Why the connection ended
+
+	events    chan Event
+	done      chan struct{} // Closed when the connection ends
+	closeOnce sync.Once
+}
+
+// waiter is a request waiting for the message that answers it
+type waiter struct {
+	request  Tag
+	response Tag
+	reply    chan reply
+}
+
+type reply struct {
+	value []byte
+	err   error
+}
+
+// Events returns the channel of what the server sends on its own: board updates, queue
+// status, matches found, notices and errors no request waited for. It is closed when the
+// connection ends, Err then tells why.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Done returns a channel closed when the connection ends
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, nil while it is open
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection. Pending requests fail with ErrClosed.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = ErrClosed
+		}
+		c.mu.Unlock()
+		err = c.conn.Close()
+		<-c.done
+	})
+	return err
+}
+
+// Transport returns the network protocol of the connection
+func (c *Client) Transport() Transport {
+	return c.transport
+}
+
+// Player returns the player introduced with Hello
+func (c *Client) Player() Player {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.player
+}
+
+// GameID returns the ID of the current game, uuid.Nil when there is none
+func (c *Client) GameID() uuid.UUID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gameID
+}
+
+// Color returns the color played in the current game, "white" or "black", or "" when there
+// is no current game
+func (c *Client) Color() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.color
+}
+
+// AwaitingOpponent tells whether the current game is a lobby the player opened that nobody
+// joined yet. The server refuses moves until then; the board it pushes when the opponent
+// joins ends the wait.
+func (c *Client) AwaitingOpponent() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.alone
+}
+
+// Position returns a copy of the replica of the current game, nil when there is none. The
+// replica follows the moves played and pushed, so it holds the moves since the client
+// joined the game; a position that does not follow from it, e.g. after a lost datagram,
+// replaces it. Board asks the server instead.
+func (c *Client) Position() *chess.Game {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.board == nil {
+		return nil
+	}
+	return c.board.Clone()
+}
+
+// State returns the board of the current game as the server last wrote it: the position in
+// FEN, or the state of a game other than chess such as "x.o/.x./... o" (see Position). It is
+// "" until the server sends one.
+func (c *Client) State() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// setGame makes gameID the current game, its replica starting from board, nil until the
+// server sends a position when it is not known
+func (c *Client) setGame(gameID uuid.UUID, color string, board *chess.Game) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gameID = gameID
+	c.color = color
+	c.alone = false
+	c.board = board
+	c.state = ""
+	if board != nil {
+		c.state = board.Position().String()
+	}
+}
+
+// syncBoard brings the replica of the current game to a position the server sent, after
+// the move san when it is known. The move is played on the replica when it leads there,
+// keeping the move history; otherwise the position replaces the replica, unless it is older:
+// the answer to a move may come after the opponent's reply was pushed. It returns a copy of
+// the replica when it reached the position, the position itself otherwise. A game other than
+// chess has no replica, board is nil and only its state is kept.
+func (c *Client) syncBoard(board *chess.Game, state string, san string) *chess.Game {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case board == nil:
+		c.board, c.state = nil, state
+		return nil
+	case c.board == nil:
+		c.board = board.Clone()
+	case sameBoard(c.board.Position(), board.Position()):
+	case c.playOnReplica(san, board.Position()):
+	case plyOf(board) < plyOf(c.board):
+		return board
+	default:
+		c.board = board.Clone()
+	}
+	c.state = state
+	return c.board.Clone()
+}
+
+// takeBack removes the last plies from the replica, replaying the others from its start. The
+// board the server pushes next brings it to the position if the replica did not hold them.
+func (c *Client) takeBack(plies int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.board == nil {
+		return
+	}
+	moves := c.board.Moves()
+	if plies > len(moves) {
+		c.board = nil
+		return
+	}
+	start, err := chess.FEN(c.board.Positions()[0].String())
+	if err != nil {
+		c.board = nil
+		return
+	}
+	board := chess.NewGame(start)
+	for _, move := range moves[:len(moves)-plies] {
+		replayed, err := chess.UCINotation{}.Decode(board.Position(), move.String())
+		if err != nil || board.Move(replayed) != nil {
+			c.board = nil
+			return
+		}
+	}
+	c.board = board
+}
+
+// playOnReplica plays a move on the replica if it leads to the position given (the caller
+// must hold mu). The move may also answer one of ours the server has not confirmed yet: a
+// premove of the opponent is pushed before the answer to the move it replies to.
+func (c *Client) playOnReplica(san string, position *chess.Position) bool {
+	if san == "" {
+		return false
+	}
+	if next, ok := playSAN(c.board, san, position); ok {
+		c.board = next
+		return true
+	}
+	for _, move := range c.board.ValidMoves() {
+		ours := c.board.Clone()
+		if ours.Move(move) != nil {
+			continue
+		}
+		if next, ok := playSAN(ours, san, position); ok {
+			c.board = next
+			return true
+		}
+	}
+	return false
+}
+
+// playSAN plays a move on a copy of game and returns it if it leads to the position given
+func playSAN(game *chess.Game, san string, position *chess.Position) (*chess.Game, bool) {
+	next := game.Clone()
+	move, err := chess.AlgebraicNotation{}.Decode(next.Position(), san)
+	if err != nil || next.Move(move) != nil || !sameBoard(next.Position(), position) {
+		return nil, false
+	}
+	return next, true
+}
+
+// sameBoard tells whether two positions have the same pieces, side to move and rights
+func sameBoard(a, b *chess.Position) bool {
+	fieldsA, fieldsB := strings.Fields(a.String()), strings.Fields(b.String())
+	return len(fieldsA) >= 3 && len(fieldsB) >= 3 && slices.Equal(fieldsA[:3], fieldsB[:3])
+}
+
+// plyOf returns the number of half-moves played before a position, as its FEN tells
+func plyOf(game *chess.Game) int {
+	position := game.Position()
+	ply := 2 * (fullMoveOf(position) - 1)
+	if position.Turn() == chess.Black {
+		ply++
+	}
+	return ply
+}
+
+// fullMoveOf reads the full move number, the last field of the FEN
+func fullMoveOf(position *chess.Position) int {
+	fields := strings.Fields(position.String())
+	if len(fields) < 6 {
+		return 1
+	}
+	number, err := strconv.Atoi(fields[5])
+	if err != nil {
+		return 1
+	}
+	return number
+}
+
+// call sends a request and waits for the message answering it, or the ErrorResponse
+// refusing it
+func (c *Client) call(ctx context.Context, request Tag, response Tag, message []byte) ([]byte, error) {
+	if err := c.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.release()
+
+	w := &waiter{request: request, response: response, reply: make(chan reply, 1)}
+	c.mu.Lock()
+	c.waiter = w
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		if c.waiter == w {
+			c.waiter = nil
+		}
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(message); err != nil {
+		return nil, fmt.Errorf("error sending %s: %w", request, err)
+	}
+	select {
+	case r := <-w.reply:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, c.Err()
+	}
+}
+
+// send sends a request the server does not answer when it succeeds
+func (c *Client) send(ctx context.Context, request Tag, message []byte) error {
+	if err := c.acquire(ctx); err != nil {
+		return err
+	}
+	defer c.release()
+	if err := c.write(message); err != nil {
+		return fmt.Errorf("error sending %s: %w", request, err)
+	}
+	return nil
+}
+
+// acquire waits for the previous request to be answered
+func (c *Client) acquire(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+	select {
+	case c.requests <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return c.Err()
+	}
+}
+
+func (c *Client) release() {
+	<-c.requests
+}
+
+// write sends a whole message, in one datagram over UDP
+func (c *Client) write(message []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(message)
+	return err
+}
+
+// readLoop reads the messages of the server until the connection ends
+func (c *Client) readLoop() {
+	defer close(c.events)
+	defer close(c.done)
+
+	buf := make([]byte, 65535)
+	var pending []byte
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = fmt.Errorf("connection to the server lost: %w", err)
+				c.logger.Info("Connection to the server lost", "err", err)
+			}
+			c.mu.Unlock()
+			return
+		}
+
+		// TCP is a stream: a read may hold several messages or only part of one, so the bytes
+		// are kept until whole TLV messages are available. A datagram holds whole messages.
+		if c.transport == UDP {
+			pending = nil
+		}
+		pending = append(pending, buf[:n]...)
+		for {
+			tag, value, consumed, err := decodeTLV(pending)
+			if err != nil {
+				break
+			}
+			pending = pending[consumed:]
+			c.dispatch(tag, bytes.Clone(value))
+		}
+	}
+}
+
+// dispatch hands a message to the request it answers, or turns it into an event
+func (c *Client) dispatch(tag Tag, value []byte) {
+	if tag == Ping {
+		c.answerPing(value)
+		return
+	}
+
+	var serverErr *ServerError
+	if tag == ErrorResponse {
+		var err error
+		if serverErr, err = decodeServerError(value); err != nil {
+			c.logger.Warn("Error decoding ErrorResponse", "err", err)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	w := c.waiter
+	answered := w != nil && (tag == w.response || serverErr != nil && serverErr.Request == w.request)
+	if answered {
+		c.waiter = nil
+	}
+	c.mu.Unlock()
+	if answered {
+		if serverErr != nil {
+			w.reply <- reply{err: serverErr}
+		} else {
+			w.reply <- reply{value: value}
+		}
+		return
+	}
+
+	var event Event
+	switch tag {
+	case BoardUpdate:
+		update, err := decodeBoardUpdate(value)
+		if err != nil {
+			c.logger.Warn("Error decoding BoardUpdate", "err", err)
+			return
+		}
+		update.Game = c.syncBoard(update.Game, update.State, update.Move)
+		c.mu.Lock()
+		c.alone = false
+		c.mu.Unlock()
+		event = update
+	case MatchFound:
+		match, err := decodeMatch(value)
+		if err != nil {
+			c.logger.Warn("Error decoding MatchFound", "err", err)
+			return
+		}
+		var board *chess.Game
+		c.mu.Lock()
+		if c.queued == "" {
+			board = chess.NewGame()
+		}
+		c.mu.Unlock()
+		c.setGame(match.GameID, match.Color, board)
+		event = MatchEvent{Match: match}
+	case QueueStatus:
+		status, err := decodeQueueStatus(value)
+		if err != nil {
+			c.logger.Warn("Error decoding QueueStatus", "err", err)
+			return
+		}
+		event = status
+	case ServerNotice:
+		notice, err := decodeNotice(value)
+		if err != nil {
+			c.logger.Warn("Error decoding ServerNotice", "err", err)
+			return
+		}
+		event = notice
+	case ChatMessage:
+		chat, err := decodeChat(value)
+		if err != nil {
+			c.logger.Warn("Error decoding ChatMessage", "err", err)
+			return
+		}
+		event = chat
+	case UndoOffer:
+		offer, err := decodeUndoOffer(value)
+		if err != nil {
+			c.logger.Warn("Error decoding UndoOffer", "err", err)
+			return
+		}
+		event = offer
+	case UndoResult:
+		result, err := decodeUndoResult(value)
+		if err != nil {
+			c.logger.Warn("Error decoding UndoResult", "err", err)
+			return
+		}
+		if result.Accepted {
+			c.takeBack(result.Plies)
+		}
+		event = result
+	case ErrorResponse:
+		event = ErrorEvent{Err: serverErr}
+	default:
+		c.logger.Debug("Ignoring a message no request waits for", "tag", tag.String())
+		return
+	}
+
+	select {
+	case c.events <- event:
+	default:
+		c.logger.Warn("Dropping an event, nobody reads them", "tag", tag.String())
+	}
+}
+
+// answerPing sends the Pong the server expects back, echoing the Ping value. A client that
+// stops answering is disconnected once the server's idle timeout expires.
+func (c *Client) answerPing(value []byte) {
+	pong, err := encodeTLV(Pong, value)
+	if err != nil {
+		c.logger.Error("Error encoding Pong", "err", err)
+		return
+	}
+	if err := c.write(pong); err != nil {
+		c.logger.Warn("Error answering Ping", "err", err)
+	}
+}
@@ -0,0 +1,378 @@
+package chessclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// Event is something the server sent on its own: a BoardEvent, QueueEvent, MatchEvent,
+// ChatEvent, UndoOfferEvent, UndoResultEvent, NoticeEvent or ErrorEvent
+type Event interface {
+	isEvent()
+}
+
+// BoardEvent is the board pushed after a move of the opponent, of the computer, or one ending
+// the game such as a timeout or an abandonment. The board pushed when an opponent joins a
+// lobby the player opened has no move, its Mover being the opponent.
+type BoardEvent struct {
+	Game        *chess.Game // Replica after the move, see Client.Position, nil in a game other than chess
+	State       string      // Board as the server wrote it, see Client.State
+	Move        string      // Last move, in algebraic notation
+	Mover       string      // Name of the player who moved
+	Outcome     chess.Outcome
+	Method      string // How the game ended, when it did
+	OpeningCode string // ECO code of the opening, empty when unknown or from older servers
+	OpeningName string
+	// Time left to each side when the board was sent, for timed games on newer servers
+	Clocked    bool
+	WhiteClock time.Duration
+	BlackClock time.Duration
+}
+
+// QueueEvent tells where a player waiting in the matchmaking queue stands
+type QueueEvent struct {
+	State    string // queued, searching or cancelled
+	Waited   time.Duration
+	Window   int // Rating difference accepted for an opponent, ± around the player's
+	PoolSize int // Players waiting for the same time control
+}
+
+// Match is a game the server started: paired by the matchmaking queue, or against the computer
+type Match struct {
+	GameID         uuid.UUID
+	Color          string // Color played, "white" or "black"
+	Opponent       string
+	OpponentRating int
+	TimeControl    string // minutes+increment, e.g. 5+3
+}
+
+// MatchEvent tells the matchmaking queue found an opponent, the game became the current one
+type MatchEvent struct {
+	Match
+}
+
+// ChatEvent is a chat line from another player of the current game
+type ChatEvent struct {
+	From string
+	Text string
+}
+
+// UndoOfferEvent is the opponent asking to take back the last moves, see AnswerUndo
+type UndoOfferEvent struct {
+	From  string
+	Plies int // Half-moves to take back, 1 or 2
+}
+
+// UndoResultEvent tells how a takeback request was answered. The replica is taken back
+// already when it was accepted, a BoardEvent with the position follows.
+type UndoResultEvent struct {
+	Accepted bool
+	Plies    int
+	By       string // Player who answered
+}
+
+// NoticeEvent is an announcement the server sent to every client, e.g. before a shutdown
+type NoticeEvent struct {
+	Message string
+}
+
+// ErrorEvent is an error the server reported for a request nobody waited for, e.g. a refused
+// ClaimAbandoned or an answer that came after its request gave up
+type ErrorEvent struct {
+	Err *ServerError
+}
+
+func (BoardEvent) isEvent()      {}
+func (QueueEvent) isEvent()      {}
+func (MatchEvent) isEvent()      {}
+func (ChatEvent) isEvent()       {}
+func (UndoOfferEvent) isEvent()  {}
+func (UndoResultEvent) isEvent() {}
+func (NoticeEvent) isEvent()     {}
+func (ErrorEvent) isEvent()      {}
+
+// ServerError is a request the server refused, with its reason
+type ServerError struct {
+	Request Tag
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("the server rejected %s: %s", e.Request, e.Message)
+}
+
+// Analysis is the server's analysis of a finished game
+type Analysis struct {
+	PGN           string         `json:"pgn"` // Game annotated with the evaluations and classifications
+	WhiteAccuracy float64        `json:"white_accuracy"`
+	BlackAccuracy float64        `json:"black_accuracy"`
+	Moves         []MoveAnalysis `json:"moves"`
+}
+
+// MoveAnalysis is the analysis of one move
+type MoveAnalysis struct {
+	Ply            int    `json:"ply"`            // Half-move number, 1 for White's first move
+	SAN            string `json:"san"`            // Move played
+	Eval           string `json:"eval"`           // Evaluation after the move in pawns, e.g. -0.35, or #3 for a mate
+	BestMove       string `json:"best_move"`      // Move the engine preferred
+	Classification string `json:"classification"` // Inaccuracy, Mistake or Blunder, empty for a good move
+}
+
+// ArchivedGame is a finished game as the archive lists it
+type ArchivedGame struct {
+	ID          uuid.UUID `json:"id"`
+	White       string    `json:"white"`
+	Black       string    `json:"black"`
+	Result      string    `json:"result"` // 1-0, 0-1 or 1/2-1/2
+	OpeningCode string    `json:"opening_code"`
+	OpeningName string    `json:"opening_name"`
+	Moves       int       `json:"moves"`    // Full moves
+	EndedAt     string    `json:"ended_at"` // "2006-01-02 15:04:05" in the server's time zone
+}
+
+// decodeServerError reads an ErrorResponse: request tag and message
+func decodeServerError(value []byte) (*ServerError, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected 2 fields, got %d", len(fields))
+	}
+	requestTag, err := strconv.Atoi(string(fields[0].Value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request tag: %w", err)
+	}
+	return &ServerError{Request: Tag(requestTag), Message: string(fields[1].Value)}, nil
+}
+
+// decodeBoardUpdate reads a BoardUpdate: FEN or the state of a game other than chess, last
+// move, mover, outcome, method and, from newer servers, the ECO code and name of the opening
+// then the milliseconds left to each side
+func decodeBoardUpdate(value []byte) (BoardEvent, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return BoardEvent{}, err
+	}
+	if len(fields) < 5 {
+		return BoardEvent{}, fmt.Errorf("expected at least 5 fields, got %d", len(fields))
+	}
+	update := BoardEvent{
+		Game:    boardOf(string(fields[0].Value)),
+		State:   string(fields[0].Value),
+		Move:    string(fields[1].Value),
+		Mover:   string(fields[2].Value),
+		Outcome: chess.Outcome(fields[3].Value),
+		Method:  string(fields[4].Value),
+	}
+	if len(fields) >= 7 {
+		update.OpeningCode = string(fields[5].Value)
+		update.OpeningName = string(fields[6].Value)
+	}
+	if len(fields) >= 9 && len(fields[7].Value) > 0 {
+		white, whiteErr := strconv.ParseInt(string(fields[7].Value), 10, 64)
+		black, blackErr := strconv.ParseInt(string(fields[8].Value), 10, 64)
+		if whiteErr == nil && blackErr == nil {
+			update.Clocked = true
+			update.WhiteClock = time.Duration(white) * time.Millisecond
+			update.BlackClock = time.Duration(black) * time.Millisecond
+		}
+	}
+	return update, nil
+}
+
+// decodeMatch reads a MatchFound: game UUID, color, opponent name, rating and time control
+func decodeMatch(value []byte) (Match, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return Match{}, err
+	}
+	if len(fields) < 5 {
+		return Match{}, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+
+	var match Match
+	if err := match.GameID.UnmarshalBinary(fields[0].Value); err != nil {
+		return Match{}, fmt.Errorf("invalid game ID: %w", err)
+	}
+	match.Color = string(fields[1].Value)
+	match.Opponent = string(fields[2].Value)
+	match.OpponentRating, _ = strconv.Atoi(string(fields[3].Value))
+	match.TimeControl = string(fields[4].Value)
+	return match, nil
+}
+
+// decodeChat reads a ChatMessage: sender and text
+func decodeChat(value []byte) (ChatEvent, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return ChatEvent{}, err
+	}
+	if len(fields) < 2 {
+		return ChatEvent{}, fmt.Errorf("expected 2 fields, got %d", len(fields))
+	}
+	return ChatEvent{From: string(fields[0].Value), Text: string(fields[1].Value)}, nil
+}
+
+// decodeUndoOffer reads an UndoOffer: who asks and how many half-moves
+func decodeUndoOffer(value []byte) (UndoOfferEvent, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return UndoOfferEvent{}, err
+	}
+	if len(fields) < 2 {
+		return UndoOfferEvent{}, fmt.Errorf("expected 2 fields, got %d", len(fields))
+	}
+	plies, err := strconv.Atoi(string(fields[1].Value))
+	if err != nil {
+		return UndoOfferEvent{}, fmt.Errorf("invalid number of moves: %w", err)
+	}
+	return UndoOfferEvent{From: string(fields[0].Value), Plies: plies}, nil
+}
+
+// decodeUndoResult reads an UndoResult: accepted or declined, half-moves and who answered
+func decodeUndoResult(value []byte) (UndoResultEvent, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return UndoResultEvent{}, err
+	}
+	if len(fields) < 3 {
+		return UndoResultEvent{}, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	plies, err := strconv.Atoi(string(fields[1].Value))
+	if err != nil {
+		return UndoResultEvent{}, fmt.Errorf("invalid number of moves: %w", err)
+	}
+	return UndoResultEvent{Accepted: string(fields[0].Value) == "accepted", Plies: plies, By: string(fields[2].Value)}, nil
+}
+
+// decodeQueueStatus reads a QueueStatus: state, seconds waited, rating window and pool size
+func decodeQueueStatus(value []byte) (QueueEvent, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return QueueEvent{}, err
+	}
+	if len(fields) < 4 {
+		return QueueEvent{}, fmt.Errorf("expected 4 fields, got %d", len(fields))
+	}
+
+	status := QueueEvent{State: string(fields[0].Value)}
+	waited, _ := strconv.Atoi(string(fields[1].Value))
+	status.Waited = time.Duration(waited) * time.Second
+	status.Window, _ = strconv.Atoi(string(fields[2].Value))
+	status.PoolSize, _ = strconv.Atoi(string(fields[3].Value))
+	return status, nil
+}
+
+// decodeNotice reads a ServerNotice: its message
+func decodeNotice(value []byte) (NoticeEvent, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return NoticeEvent{}, err
+	}
+	if len(fields) < 1 {
+		return NoticeEvent{}, fmt.Errorf("the notice has no message")
+	}
+	return NoticeEvent{Message: string(fields[0].Value)}, nil
+}
+
+// decodeAnalysis reads an AnalyzeResponse: annotated PGN, both accuracies, then one group
+// per move (ply, SAN, evaluation, best move, classification)
+func decodeAnalysis(value []byte) (*Analysis, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected at least 3 fields, got %d", len(fields))
+	}
+
+	analysis := &Analysis{PGN: string(fields[0].Value)}
+	analysis.WhiteAccuracy, _ = strconv.ParseFloat(string(fields[1].Value), 64)
+	analysis.BlackAccuracy, _ = strconv.ParseFloat(string(fields[2].Value), 64)
+	for _, field := range fields[3:] {
+		move, err := decodeTLVFields(field.Value)
+		if err != nil || len(move) < 5 {
+			return nil, fmt.Errorf("invalid move analysis: %v", err)
+		}
+		ply, _ := strconv.Atoi(string(move[0].Value))
+		analysis.Moves = append(analysis.Moves, MoveAnalysis{
+			Ply:            ply,
+			SAN:            string(move[1].Value),
+			Eval:           string(move[2].Value),
+			BestMove:       string(move[3].Value),
+			Classification: string(move[4].Value),
+		})
+	}
+	return analysis, nil
+}
+
+// decodeArchive reads an ArchiveResponse, one group per game: game UUID, white, black,
+// result, ECO code, opening name, number of moves and end date
+func decodeArchive(value []byte) ([]ArchivedGame, error) {
+	fields, err := decodeTLVFields(value)
+	if err != nil {
+		return nil, err
+	}
+
+	games := make([]ArchivedGame, 0, len(fields))
+	for _, field := range fields {
+		group, err := decodeTLVFields(field.Value)
+		if err != nil || len(group) < 8 {
+			return nil, fmt.Errorf("invalid archived game: %v", err)
+		}
+		game := ArchivedGame{
+			White:       string(group[1].Value),
+			Black:       string(group[2].Value),
+			Result:      string(group[3].Value),
+			OpeningCode: string(group[4].Value),
+			OpeningName: string(group[5].Value),
+			EndedAt:     string(group[7].Value),
+		}
+		if game.ID, err = uuid.Parse(string(group[0].Value)); err != nil {
+			return nil, fmt.Errorf("invalid archived game ID: %w", err)
+		}
+		game.Moves, _ = strconv.Atoi(string(group[6].Value))
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+// chess960Tag marks a replica of a Chess960 game, see gameFromFEN
+var chess960Tag = chess.TagPair{Key: "Variant", Value: "Chess960"}
+
+// gameFromFEN starts a game from a position in FEN. The castling rights of Chess960 games,
+// written with the files of the rooks as in "HAha", are left out: the chess package only
+// castles from the standard squares. The game is tagged with chess960Tag instead. The checks
+// a Three-check position ends with, e.g. "+1+0", are left out too.
+func gameFromFEN(fen string) (*chess.Game, error) {
+	var tags []*chess.TagPair
+	if fields := strings.Fields(fen); len(fields) > 6 {
+		fen = strings.Join(fields[:6], " ")
+	}
+	if fields := strings.Fields(fen); len(fields) >= 3 && strings.Trim(fields[2], "KQkq-") != "" {
+		fields[2] = "-"
+		fen = strings.Join(fields, " ")
+		tags = append(tags, &chess960Tag)
+	}
+	position, err := chess.FEN(fen)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing FEN string: %w", err)
+	}
+	return chess.NewGame(position, chess.TagPairs(tags)), nil
+}
+
+// boardOf returns the position of a board the server sent, nil when it is the state of a game
+// other than chess
+func boardOf(state string) *chess.Game {
+	game, err := gameFromFEN(state)
+	if err != nil {
+		return nil
+	}
+	return game
+}
@@ -0,0 +1,311 @@
+package chessclient
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// maxSuggestions bounds the moves a MoveError suggests
+const maxSuggestions = 8
+
+// MoveError is a move the client refused before sending it: not a move, illegal, ambiguous
+// or out of turn. Suggestions are the legal moves the player may have meant.
+type MoveError struct {
+	Input       string
+	Reason      string
+	Suggestions []string // In algebraic notation
+}
+
+func (e *MoveError) Error() string {
+	message := fmt.Sprintf("%s: %s", e.Input, e.Reason)
+	if len(e.Suggestions) > 0 {
+		message += ", did you mean " + strings.Join(e.Suggestions, ", ") + "?"
+	}
+	return message
+}
+
+// ParseMove finds the legal move of the current position written in algebraic (Nf3, exd5,
+// O-O, e8=Q) or UCI (g1f3, e7e8q) notation. It forgives the usual slips when only one move
+// fits: a lowercase piece letter, castling written with zeros, a dash between squares, a
+// missing or extra capture, check or disambiguation sign. Other inputs get a *MoveError.
+func (c *Client) ParseMove(input string) (*chess.Move, error) {
+	board := c.Position()
+	if board == nil {
+		return nil, ErrNoGame
+	}
+	return parseMove(board.Position(), input)
+}
+
+// LegalMoves lists the legal moves of the current position in algebraic notation, sorted:
+// all of them, those of the piece on a square ("g1"), or those of a kind of piece ("N", or
+// "P" for the pawns)
+func (c *Client) LegalMoves(filter string) ([]string, error) {
+	board := c.Position()
+	if board == nil {
+		return nil, ErrNoGame
+	}
+	return legalMoves(board.Position(), filter)
+}
+
+// checkMove refuses a move the current position rules out before it reaches the server, and
+// returns it in the algebraic notation the server reads. A move is sent as it is when no
+// position is known yet.
+func (c *Client) checkMove(input string) (string, error) {
+	if c.AwaitingOpponent() {
+		return "", &MoveError{Input: input, Reason: "nobody joined the lobby yet"}
+	}
+	board := c.Position()
+	if board == nil {
+		return input, nil
+	}
+	if board.Outcome() != chess.NoOutcome {
+		return "", &MoveError{Input: input, Reason: "the game is over"}
+	}
+	position := board.Position()
+	if color := c.Color(); color != "" && !strings.EqualFold(position.Turn().Name(), color) {
+		return "", &MoveError{Input: input, Reason: "it is not your turn"}
+	}
+	if board.GetTagPair(chess960Tag.Key) != nil && chess960Castling(position, input) {
+		// The server knows the castling rights the replica left out
+		return strings.TrimSpace(input), nil
+	}
+	text := strings.ToLower(strings.TrimRight(strings.TrimSpace(input), "+#!?"))
+	for _, step := range firstRankSteps(position) {
+		if text == step || text == step[2:] {
+			// A Horde pawn the replica does not let move two squares from the first rank
+			return step, nil
+		}
+	}
+	move, err := parseMove(position, input)
+	if err != nil {
+		return "", err
+	}
+	return chess.AlgebraicNotation{}.Encode(position, move), nil
+}
+
+// chess960Castling tells whether input is castling in a Chess960 game, written O-O, O-O-O
+// or as the king taking its own rook in UCI notation (b1a1)
+func chess960Castling(position *chess.Position, input string) bool {
+	text := strings.TrimRight(strings.TrimSpace(input), "+#!?")
+	if castling := strings.ToUpper(strings.ReplaceAll(text, "0", "O")); castling == "O-O" || castling == "O-O-O" {
+		return true
+	}
+	parts := uciPattern.FindStringSubmatch(strings.ToLower(text))
+	if parts == nil {
+		return false
+	}
+	turn := position.Turn()
+	board := position.Board()
+	return board.Piece(squareOf(parts[1])) == chess.NewPiece(chess.King, turn) &&
+		board.Piece(squareOf(parts[2])) == chess.NewPiece(chess.Rook, turn)
+}
+
+// firstRankSteps lists the moves of White's pawns two squares up from the first rank in UCI
+// notation, which only Horde games have and the chess package does not know
+func firstRankSteps(position *chess.Position) []string {
+	if position.Turn() != chess.White {
+		return nil
+	}
+	board := position.Board()
+	var steps []string
+	for file := chess.FileA; file <= chess.FileH; file++ {
+		if board.Piece(chess.NewSquare(file, chess.Rank1)) == chess.WhitePawn &&
+			board.Piece(chess.NewSquare(file, chess.Rank2)) == chess.NoPiece &&
+			board.Piece(chess.NewSquare(file, chess.Rank3)) == chess.NoPiece {
+			steps = append(steps, chess.NewSquare(file, chess.Rank1).String()+chess.NewSquare(file, chess.Rank3).String())
+		}
+	}
+	return steps
+}
+
+// Patterns of the forgiving move parser
+var (
+	uciPattern       = regexp.MustCompile(`^([a-h][1-8])[-x:]?([a-h][1-8])=?([qrbn])?$`)
+	algebraicPattern = regexp.MustCompile(`^([KQRBNkqrn])?([a-h])?([1-8])?[x:]?([a-h][1-8])=?([QRBNqrbn])?$`)
+)
+
+// parseMove finds the legal move of a position written input, see ParseMove
+func parseMove(position *chess.Position, input string) (*chess.Move, error) {
+	text := strings.TrimRight(strings.TrimSpace(input), "+#!?")
+	if text == "" {
+		return nil, &MoveError{Input: input, Reason: "no move given"}
+	}
+
+	legal := position.ValidMoves()
+	for _, move := range legal {
+		if sanOf(position, move) == text || move.String() == text {
+			return move, nil
+		}
+	}
+
+	// Castling written with zeros or in lowercase
+	if castling := strings.ToUpper(strings.ReplaceAll(text, "0", "O")); castling == "O-O" || castling == "O-O-O" {
+		for _, move := range legal {
+			if sanOf(position, move) == castling {
+				return move, nil
+			}
+		}
+		return nil, &MoveError{Input: input, Reason: "castling is not legal here", Suggestions: suggest(position, legal, chess.King, chess.NoSquare)}
+	}
+
+	// Squares written with a dash or in uppercase: e2-e4, E7E8Q
+	if parts := uciPattern.FindStringSubmatch(strings.ToLower(text)); parts != nil {
+		var candidates []*chess.Move
+		for _, move := range legal {
+			if move.S1().String() == parts[1] && move.S2().String() == parts[2] &&
+				(parts[3] == "" || move.Promo().String() == parts[3]) {
+				candidates = append(candidates, move)
+			}
+		}
+		piece := position.Board().Piece(squareOf(parts[1]))
+		return pickMove(position, input, candidates, legal, piece.Type(), squareOf(parts[2]))
+	}
+
+	parts := algebraicPattern.FindStringSubmatch(text)
+	if parts == nil {
+		return nil, &MoveError{Input: input, Reason: "not a move in algebraic or UCI notation"}
+	}
+	pieceType := chess.Pawn
+	if parts[1] != "" {
+		pieceType = pieceTypeOf(parts[1])
+	}
+	destination := squareOf(parts[4])
+	candidates := matchAlgebraic(position, legal, pieceType, parts[2], parts[3], destination, parts[5])
+
+	// A lowercase b is a file for the pawns, or a sloppy bishop
+	if len(candidates) == 0 && parts[1] == "" && parts[2] == "b" {
+		pieceType = chess.Bishop
+		candidates = matchAlgebraic(position, legal, pieceType, "", parts[3], destination, parts[5])
+	}
+	return pickMove(position, input, candidates, legal, pieceType, destination)
+}
+
+// matchAlgebraic keeps the legal moves of a kind of piece to a destination, from the file
+// and rank given and promoting to the piece given, when they are
+func matchAlgebraic(position *chess.Position, legal []*chess.Move, pieceType chess.PieceType, file, rank string, destination chess.Square, promotion string) []*chess.Move {
+	var candidates []*chess.Move
+	for _, move := range legal {
+		from := move.S1()
+		switch {
+		case move.S2() != destination:
+		case position.Board().Piece(from).Type() != pieceType:
+		case file != "" && from.File().String() != file:
+		case rank != "" && from.Rank().String() != rank:
+		case promotion != "" && move.Promo() != pieceTypeOf(promotion):
+		default:
+			candidates = append(candidates, move)
+		}
+	}
+	return candidates
+}
+
+// pickMove returns the only candidate, or the error telling the input is illegal or
+// ambiguous with the moves it may have meant
+func pickMove(position *chess.Position, input string, candidates, legal []*chess.Move, pieceType chess.PieceType, destination chess.Square) (*chess.Move, error) {
+	switch len(candidates) {
+	case 1:
+		return candidates[0], nil
+	case 0:
+		return nil, &MoveError{Input: input, Reason: "illegal in this position", Suggestions: suggest(position, legal, pieceType, destination)}
+	default:
+		suggestions := make([]string, 0, len(candidates))
+		for _, move := range candidates {
+			suggestions = append(suggestions, sanOf(position, move))
+		}
+		slices.Sort(suggestions)
+		return nil, &MoveError{Input: input, Reason: "ambiguous", Suggestions: suggestions}
+	}
+}
+
+// suggest lists the legal moves close to an illegal one: those reaching its destination, or
+// else those of the same kind of piece, on the destination's file first (e4 for e5)
+func suggest(position *chess.Position, legal []*chess.Move, pieceType chess.PieceType, destination chess.Square) []string {
+	var toDestination, toFile, ofPiece []string
+	for _, move := range legal {
+		san := sanOf(position, move)
+		if destination != chess.NoSquare && move.S2() == destination {
+			toDestination = append(toDestination, san)
+		}
+		if position.Board().Piece(move.S1()).Type() == pieceType {
+			ofPiece = append(ofPiece, san)
+			if destination != chess.NoSquare && move.S2().File() == destination.File() {
+				toFile = append(toFile, san)
+			}
+		}
+	}
+	suggestions := ofPiece
+	switch {
+	case len(toDestination) > 0:
+		suggestions = toDestination
+	case len(toFile) > 0:
+		suggestions = toFile
+	}
+	slices.Sort(suggestions)
+	return suggestions[:min(len(suggestions), maxSuggestions)]
+}
+
+// legalMoves lists the legal moves of a position, see LegalMoves
+func legalMoves(position *chess.Position, filter string) ([]string, error) {
+	keep := func(*chess.Move) bool { return true }
+	switch {
+	case filter == "":
+	case len(filter) == 2 && squareOf(strings.ToLower(filter)) != chess.NoSquare:
+		square := squareOf(strings.ToLower(filter))
+		keep = func(move *chess.Move) bool { return move.S1() == square }
+	case len(filter) == 1 && strings.ContainsAny(strings.ToUpper(filter), "KQRBNP"):
+		pieceType := pieceTypeOf(filter)
+		keep = func(move *chess.Move) bool { return position.Board().Piece(move.S1()).Type() == pieceType }
+	default:
+		return nil, fmt.Errorf("%q is neither a square (g1) nor a piece (N)", filter)
+	}
+
+	moves := []string{}
+	for _, move := range position.ValidMoves() {
+		if keep(move) {
+			moves = append(moves, chess.AlgebraicNotation{}.Encode(position, move))
+		}
+	}
+	for _, step := range firstRankSteps(position) {
+		if move, err := (chess.UCINotation{}).Decode(nil, step); err == nil && keep(move) {
+			moves = append(moves, step[2:])
+		}
+	}
+	slices.Sort(moves)
+	return moves, nil
+}
+
+// sanOf writes a move in algebraic notation without its check sign
+func sanOf(position *chess.Position, move *chess.Move) string {
+	return strings.TrimRight(chess.AlgebraicNotation{}.Encode(position, move), "+#")
+}
+
+// squareOf reads a square such as e4, chess.NoSquare when it is not one
+func squareOf(name string) chess.Square {
+	if len(name) != 2 || name[0] < 'a' || name[0] > 'h' || name[1] < '1' || name[1] > '8' {
+		return chess.NoSquare
+	}
+	return chess.NewSquare(chess.File(name[0]-'a'), chess.Rank(name[1]-'1'))
+}
+
+// pieceTypeOf reads a piece letter in either case, P standing for the pawns
+func pieceTypeOf(letter string) chess.PieceType {
+	switch strings.ToUpper(letter) {
+	case "K":
+		return chess.King
+	case "Q":
+		return chess.Queen
+	case "R":
+		return chess.Rook
+	case "B":
+		return chess.Bishop
+	case "N":
+		return chess.Knight
+	case "P":
+		return chess.Pawn
+	}
+	return chess.NoPieceType
+}
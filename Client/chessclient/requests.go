@@ -0,0 +1,508 @@
+package chessclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// Player is the identity a client introduces itself with. The server knows the player by
+// its first name.
+type Player struct {
+	FirstName string
+	LastName  string
+	Status    string
+	Level     int // Rating, used by the matchmaking queue
+}
+
+// GameOptions are the settings of a new lobby
+type GameOptions struct {
+	// Creator is seated in the lobby, named "Lobby-<Creator>". The player's first name when empty.
+	Creator string
+	// Variant is standard, chess960, fromposition, kingofthehill, threecheck or horde, standard
+	// chess when empty. It may also name a game other than chess: tictactoe or connectfour.
+	Variant string
+	// Position is the number of a Chess960 starting position, 0 to 959 with 518 the standard
+	// one and a random one when empty, or the FEN a fromposition game starts from
+	Position string
+}
+
+// standardVariant tells whether a variant name stands for standard chess
+func standardVariant(variant string) bool {
+	return variant == "" || strings.EqualFold(variant, "standard")
+}
+
+// BotOptions are the settings of a game against the computer
+type BotOptions struct {
+	Level  int    // 1 to 5
+	Color  string // white, black or random
+	Engine string // alphabeta or uci, the server's default when empty
+}
+
+// ArchiveFilter narrows the finished games listed by Archive. Both filters are optional.
+type ArchiveFilter struct {
+	Opening string // ECO code prefix ("C6") or part of an opening name ("sicilian")
+	Player  string // Games played by that name
+}
+
+// Hello introduces the player to the server. It must come before any other request.
+func (c *Client) Hello(ctx context.Context, player Player) error {
+	message, err := encodeTLVFields(
+		tlvField{HelloRequest, []byte("HelloRequest")},
+		tlvField{String, []byte(player.FirstName)},
+		tlvField{String, []byte(player.LastName)},
+		tlvField{String, []byte(player.Status)},
+		tlvField{Int, []byte(strconv.Itoa(player.Level))},
+	)
+	if err != nil {
+		return err
+	}
+	hash := hashOf(message)
+
+	// The signature is a secret shared with the server, it is not derived from the message
+	signature, err := randomSignature()
+	if err != nil {
+		return err
+	}
+	trailer, err := encodeTLVFields(tlvField{ByteData, []byte(signature)}, tlvField{ByteData, []byte(hash)})
+	if err != nil {
+		return err
+	}
+
+	value, err := c.call(ctx, HelloRequest, HelloResponse, append(message, trailer...))
+	if err != nil {
+		return err
+	}
+	if string(value) != hash {
+		return fmt.Errorf("the server answered Hello with an unexpected hash")
+	}
+
+	c.mu.Lock()
+	c.player = player
+	c.signature = signature
+	c.mu.Unlock()
+	return nil
+}
+
+// CreateGame opens a lobby for another player to join and makes it the current game, played
+// as white: the server seats the creator white once the lobby is full. The replica of a game
+// of another variant than standard chess starts from the position the server reports; the
+// game ID is returned even when that position could not be fetched.
+func (c *Client) CreateGame(ctx context.Context, opts GameOptions) (uuid.UUID, error) {
+	creator := opts.Creator
+	if creator == "" {
+		creator = c.Player().FirstName
+	}
+	fields := []tlvField{
+		{GameRequest, []byte("GameRequest")},
+		{ByteData, []byte(creator)},
+	}
+	if !standardVariant(opts.Variant) || opts.Position != "" {
+		fields = append(fields, tlvField{String, []byte(opts.Variant)}, tlvField{String, []byte(opts.Position)})
+	}
+	message, err := c.signMessage(fields...)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// The game ID comes wrapped in a second UUIDPartie TLV
+	value, err := c.call(ctx, GameRequest, UUIDPartie, message)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if len(value) < 16 {
+		return uuid.Nil, fmt.Errorf("game ID of %d bytes is too short", len(value))
+	}
+	gameID, err := uuid.FromBytes(value[len(value)-16:])
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid game ID: %w", err)
+	}
+
+	var board *chess.Game
+	if standardVariant(opts.Variant) {
+		board = chess.NewGame()
+	}
+	c.setGame(gameID, "white", board)
+	c.mu.Lock()
+	c.alone = true
+	c.mu.Unlock()
+	if board == nil {
+		_, err = c.Board(ctx)
+	}
+	return gameID, err
+}
+
+// ListLobbies returns the names of the lobbies waiting for a player, sorted, keeping the
+// ones containing filter regardless of case. An empty filter keeps them all.
+func (c *Client) ListLobbies(ctx context.Context, filter string) ([]string, error) {
+	message, err := c.signMessage(tlvField{LobbyRequest, []byte("LobbyRequest")})
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.call(ctx, LobbyRequest, LobbyResponse, message)
+	if err != nil {
+		return nil, err
+	}
+
+	// The names come as String TLVs wrapped in a second LobbyResponse TLV
+	_, names, _, err := decodeTLV(value)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the lobby list: %w", err)
+	}
+	fields, err := decodeTLVFields(names)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the lobby list: %w", err)
+	}
+
+	filter = strings.ToLower(filter)
+	lobbies := []string{}
+	for _, field := range fields {
+		name := string(field.Value)
+		if strings.Contains(strings.ToLower(name), filter) {
+			lobbies = append(lobbies, name)
+		}
+	}
+	slices.Sort(lobbies)
+	return lobbies, nil
+}
+
+// Join takes the free seat of a lobby, black, and makes its game the current one
+func (c *Client) Join(ctx context.Context, lobby string) (uuid.UUID, error) {
+	message, err := c.signMessage(tlvField{JoinLobbyRequest, []byte(lobby)})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	value, err := c.call(ctx, JoinLobbyRequest, JoinLobbyRequest, message)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// The game ID comes as a string in a ByteData TLV
+	_, id, _, err := decodeTLV(value)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("error decoding the game ID: %w", err)
+	}
+	gameID, err := uuid.ParseBytes(id)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid game ID: %w", err)
+	}
+
+	// The lobby may play another variant, the replica starts from the server's position
+	c.setGame(gameID, "black", nil)
+	_, err = c.Board(ctx)
+	return gameID, err
+}
+
+// Move plays a move in the current game, in algebraic notation (e4, Nf3, O-O, exd8=Q) or in
+// UCI notation (e2e4, e7e8q). A move the replica rules out, illegal, ambiguous or out of
+// turn, is refused with a *MoveError without reaching the server; the server may still
+// refuse one with a *ServerError.
+func (c *Client) Move(ctx context.Context, move string) error {
+	gameID := c.GameID()
+	if gameID == uuid.Nil {
+		return ErrNoGame
+	}
+	san, err := c.checkMove(move)
+	if err != nil {
+		return err
+	}
+	message, err := c.signMessage(
+		tlvField{ActionRequest, []byte(san)},
+		tlvField{ByteData, []byte(gameID.String())},
+		tlvField{ByteData, []byte(c.Player().FirstName)},
+	)
+	if err != nil {
+		return err
+	}
+	value, err := c.call(ctx, ActionRequest, ActionResponse, message)
+	if err != nil {
+		return err
+	}
+
+	// The position after the move comes in a second ActionResponse TLV
+	board, state, err := decodeBoardState(value)
+	if err != nil {
+		return err
+	}
+	c.syncBoard(board, state, san)
+	return nil
+}
+
+// Board asks the server for the position of the current game and brings the replica to it,
+// returning the replica with its move history when the position follows from it. A game
+// other than chess has no replica: Board returns nil, and State its board.
+func (c *Client) Board(ctx context.Context) (*chess.Game, error) {
+	if c.GameID() == uuid.Nil {
+		return nil, ErrNoGame
+	}
+
+	// The server knows which game is ours, the request only carries the signature, unhashed
+	message, err := encodeTLVFields(
+		tlvField{BoardRequest, []byte(c.GameID().String())},
+		tlvField{ByteData, []byte(c.currentSignature())},
+	)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.call(ctx, BoardRequest, BoardResponse, message)
+	if err != nil {
+		return nil, err
+	}
+
+	board, state, err := decodeBoardState(value)
+	if err != nil {
+		return nil, err
+	}
+	return c.syncBoard(board, state, ""), nil
+}
+
+// JoinQueue places the player in the matchmaking pool for a time control (minutes+increment,
+// e.g. 5+3). The server answers with the queue status, and later sends a MatchEvent once an
+// opponent is found, with QueueEvent updates in between.
+func (c *Client) JoinQueue(ctx context.Context, timeControl string, rated bool) (QueueEvent, error) {
+	return c.JoinGameQueue(ctx, "", timeControl, rated)
+}
+
+// JoinGameQueue is JoinQueue for a game other than chess, tictactoe or connectfour, which is
+// never rated. An empty game stands for standard chess.
+func (c *Client) JoinGameQueue(ctx context.Context, game string, timeControl string, rated bool) (QueueEvent, error) {
+	ratedFlag := "0"
+	if rated {
+		ratedFlag = "1"
+	}
+	fields := []tlvField{
+		{QueueRequest, []byte("QueueRequest")},
+		{String, []byte(timeControl)},
+		{Int, []byte(ratedFlag)},
+	}
+	if game != "" {
+		// Older servers only pair chess players and refuse the field
+		fields = append(fields, tlvField{String, []byte(game)})
+	}
+	message, err := c.signMessage(fields...)
+	if err != nil {
+		return QueueEvent{}, err
+	}
+	// Known before the server may pair the player, and put back if it refuses the request
+	c.mu.Lock()
+	previous := c.queued
+	c.queued = game
+	c.mu.Unlock()
+	value, err := c.call(ctx, QueueRequest, QueueStatus, message)
+	if err != nil {
+		c.mu.Lock()
+		c.queued = previous
+		c.mu.Unlock()
+		return QueueEvent{}, err
+	}
+	return decodeQueueStatus(value)
+}
+
+// CancelQueue takes the player out of the matchmaking pool
+func (c *Client) CancelQueue(ctx context.Context) error {
+	message, err := c.signMessage(tlvField{QueueCancelRequest, []byte("QueueCancelRequest")})
+	if err != nil {
+		return err
+	}
+	_, err = c.call(ctx, QueueCancelRequest, QueueStatus, message)
+	return err
+}
+
+// PlayBot starts a game against the computer and makes it the current game
+func (c *Client) PlayBot(ctx context.Context, opts BotOptions) (Match, error) {
+	fields := []tlvField{
+		{BotGameRequest, []byte("BotGameRequest")},
+		{Int, []byte(strconv.Itoa(opts.Level))},
+		{String, []byte(opts.Color)},
+	}
+	if opts.Engine != "" {
+		fields = append(fields, tlvField{String, []byte(opts.Engine)})
+	}
+	message, err := c.signMessage(fields...)
+	if err != nil {
+		return Match{}, err
+	}
+
+	// The server answers with the same MatchFound as the matchmaking queue
+	value, err := c.call(ctx, BotGameRequest, MatchFound, message)
+	if err != nil {
+		return Match{}, err
+	}
+	match, err := decodeMatch(value)
+	if err != nil {
+		return Match{}, err
+	}
+	c.setGame(match.GameID, match.Color, chess.NewGame())
+	return match, nil
+}
+
+// Analyze asks the server to analyse a finished game. The engine may take a while, and no
+// other request is sent meanwhile.
+func (c *Client) Analyze(ctx context.Context, gameID uuid.UUID) (*Analysis, error) {
+	message, err := c.signMessage(
+		tlvField{AnalyzeRequest, []byte("AnalyzeRequest")},
+		tlvField{String, []byte(gameID.String())},
+	)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.call(ctx, AnalyzeRequest, AnalyzeResponse, message)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAnalysis(value)
+}
+
+// Archive lists the finished games matching the filter, most recent first
+func (c *Client) Archive(ctx context.Context, filter ArchiveFilter) ([]ArchivedGame, error) {
+	message, err := c.signMessage(
+		tlvField{ArchiveRequest, []byte("ArchiveRequest")},
+		tlvField{String, []byte(filter.Opening)},
+		tlvField{String, []byte(filter.Player)},
+	)
+	if err != nil {
+		return nil, err
+	}
+	value, err := c.call(ctx, ArchiveRequest, ArchiveResponse, message)
+	if err != nil {
+		return nil, err
+	}
+	return decodeArchive(value)
+}
+
+// ClaimAbandoned claims the win in a game whose opponent left and did not come back within
+// the server's abandon timeout. The server does not answer a granted claim, the final board
+// comes as a BoardEvent; a refused one comes as an ErrorEvent.
+func (c *Client) ClaimAbandoned(ctx context.Context, gameID uuid.UUID) error {
+	message, err := c.signMessage(
+		tlvField{AbandonClaimRequest, []byte("AbandonClaimRequest")},
+		tlvField{String, []byte(gameID.String())},
+	)
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, AbandonClaimRequest, message)
+}
+
+// Chat sends a line to the other players of the current game. The server does not answer a
+// relayed line; a refused one, e.g. too long, comes as an ErrorEvent.
+func (c *Client) Chat(ctx context.Context, text string) error {
+	if c.GameID() == uuid.Nil {
+		return ErrNoGame
+	}
+	message, err := c.signMessage(tlvField{ChatRequest, []byte(text)})
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, ChatRequest, message)
+}
+
+// QueueMoves queues moves for the server to play as soon as the opponent's move comes in,
+// replacing those queued before, and returns them as the server understood them. Lines are
+// separated by semicolons, each alternating the opponent's move and the reply to it, in
+// algebraic or UCI notation: "Nf6 e5 Nd5 d4; d5 exd5". A "*" stands for any move of the
+// opponent, and a line of a single move is a premove. An empty text clears the queue.
+// Moves are queued while the opponent is to move; a BoardEvent tells when one is played.
+func (c *Client) QueueMoves(ctx context.Context, lines string) (string, error) {
+	if c.GameID() == uuid.Nil {
+		return "", ErrNoGame
+	}
+	message, err := c.signMessage(tlvField{PremoveRequest, []byte(lines)})
+	if err != nil {
+		return "", err
+	}
+	value, err := c.call(ctx, PremoveRequest, PremoveResponse, message)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// RequestUndo asks the opponent to take back the last plies, 1 or 2 to be the one to move
+// again. The server does not answer a request it accepts: the opponent's answer comes as an
+// UndoResultEvent, a refused request as an ErrorEvent.
+func (c *Client) RequestUndo(ctx context.Context, plies int) error {
+	if c.GameID() == uuid.Nil {
+		return ErrNoGame
+	}
+	message, err := c.signMessage(tlvField{UndoRequest, []byte(strconv.Itoa(plies))})
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, UndoRequest, message)
+}
+
+// AnswerUndo accepts or declines the takeback of an UndoOfferEvent. Both players get an
+// UndoResultEvent; a refused answer comes as an ErrorEvent.
+func (c *Client) AnswerUndo(ctx context.Context, accept bool) error {
+	if c.GameID() == uuid.Nil {
+		return ErrNoGame
+	}
+	answer := "decline"
+	if accept {
+		answer = "accept"
+	}
+	message, err := c.signMessage(tlvField{UndoAnswer, []byte(answer)})
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, UndoAnswer, message)
+}
+
+// currentSignature returns the signature given in Hello
+func (c *Client) currentSignature() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.signature
+}
+
+// signMessage encodes the fields of a request, then the client signature and a hash
+// covering everything before it
+func (c *Client) signMessage(fields ...tlvField) ([]byte, error) {
+	signature := c.currentSignature()
+	if signature == "" {
+		return nil, fmt.Errorf("say Hello to the server first")
+	}
+	message, err := encodeTLVFields(append(fields, tlvField{ByteData, []byte(signature)})...)
+	if err != nil {
+		return nil, err
+	}
+	hashTLV, err := encodeTLV(ByteData, []byte(hashOf(message)))
+	if err != nil {
+		return nil, err
+	}
+	return append(message, hashTLV...), nil
+}
+
+// hashOf computes the hex SHA-256 the server checks messages with
+func hashOf(message []byte) string {
+	sum := sha256.Sum256(message)
+	return hex.EncodeToString(sum[:])
+}
+
+// randomSignature creates the secret identifying the client's requests
+func randomSignature() (string, error) {
+	signature := make([]byte, 32)
+	if _, err := rand.Read(signature); err != nil {
+		return "", fmt.Errorf("error generating the client signature: %w", err)
+	}
+	return hex.EncodeToString(signature), nil
+}
+
+// decodeBoardState reads a board sent in a second TLV: a position in FEN, or the state of a
+// game other than chess for which no position is returned
+func decodeBoardState(value []byte) (*chess.Game, string, error) {
+	_, state, _, err := decodeTLV(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding the board: %w", err)
+	}
+	return boardOf(string(state)), string(state), nil
+}
@@ -0,0 +1,226 @@
+package chessclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Tag represents the type of the TLV tag (Tag-Length-Value)
+type Tag byte
+
+// Enum for TLV tags
+const (
+	HelloRequest     Tag = 0
+	HelloResponse    Tag = 100
+	UUIDClient       Tag = 1
+	UUIDPartie       Tag = 2
+	Signature        Tag = 3
+	String           Tag = 11
+	Int              Tag = 12
+	ByteData         Tag = 13
+	GameRequest      Tag = 30
+	GameResponse     Tag = 130
+	BoardRequest     Tag = 50
+	BoardResponse    Tag = 150
+	ActionRequest    Tag = 40
+	ActionResponse   Tag = 140
+	LobbyRequest     Tag = 169
+	LobbyResponse    Tag = 170
+	JoinLobbyRequest Tag = 178
+
+	// Matchmaking queue
+	QueueRequest       Tag = 60
+	QueueCancelRequest Tag = 61
+	QueueStatus        Tag = 160
+	MatchFound         Tag = 161
+
+	// Games against the server
+	BotGameRequest Tag = 31
+	BoardUpdate    Tag = 151
+
+	// Post-game analysis
+	AnalyzeRequest  Tag = 71
+	AnalyzeResponse Tag = 171
+
+	// Finished games
+	ArchiveRequest  Tag = 72
+	ArchiveResponse Tag = 172
+
+	// Abandoned games, claimed by the player whose opponent left
+	AbandonClaimRequest Tag = 41
+
+	// Chat between the players of a game, relayed by the server to the others
+	ChatRequest Tag = 42
+	ChatMessage Tag = 152
+
+	// Premove or conditional moves, played as soon as the opponent's move comes in
+	PremoveRequest  Tag = 43
+	PremoveResponse Tag = 143
+
+	// Takebacks: a player asks, the opponent is offered to accept or decline, both are told
+	UndoRequest Tag = 44
+	UndoAnswer  Tag = 45
+	UndoOffer   Tag = 153
+	UndoResult  Tag = 154
+
+	// Liveness checks, sent by the server and answered by the client
+	Ping Tag = 80
+	Pong Tag = 180
+
+	// Message from the server to every client, e.g. before a shutdown
+	ServerNotice Tag = 254
+
+	// Error reported by the server for a request
+	ErrorResponse Tag = 255
+)
+
+// errInsufficientData is returned while a message is not completely received
+var errInsufficientData = errors.New("insufficient data")
+
+// maxTLVLength is the largest value the 2-byte length field can describe
+const maxTLVLength = 0xFFFF
+
+// encodeTLV encodes a message in TLV (Tag-Length-Value) format
+func encodeTLV(tag Tag, value []byte) ([]byte, error) {
+	if len(value) > maxTLVLength {
+		return nil, fmt.Errorf("value of %d bytes is too long for a TLV message", len(value))
+	}
+	buf := make([]byte, 0, 3+len(value))
+	buf = append(buf, byte(tag))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...), nil
+}
+
+// decodeTLV decodes the TLV at the start of data, returning the number of bytes it takes
+func decodeTLV(data []byte) (Tag, []byte, int, error) {
+	if len(data) < 3 {
+		return 0, nil, 0, errInsufficientData
+	}
+	length := int(binary.BigEndian.Uint16(data[1:3]))
+	if len(data) < 3+length {
+		return 0, nil, 0, errInsufficientData
+	}
+	return Tag(data[0]), data[3 : 3+length], 3 + length, nil
+}
+
+// tlvField is one element of a message made of several consecutive TLVs
+type tlvField struct {
+	Tag   Tag
+	Value []byte
+}
+
+// encodeTLVFields encodes fields as consecutive TLVs
+func encodeTLVFields(fields ...tlvField) ([]byte, error) {
+	var message []byte
+	for _, field := range fields {
+		tlv, err := encodeTLV(field.Tag, field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding %s: %w", field.Tag, err)
+		}
+		message = append(message, tlv...)
+	}
+	return message, nil
+}
+
+// decodeTLVFields splits a buffer of consecutive TLVs into its fields
+func decodeTLVFields(data []byte) ([]tlvField, error) {
+	var fields []tlvField
+	for len(data) > 0 {
+		tag, value, consumed, err := decodeTLV(data)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, tlvField{Tag: tag, Value: value})
+		data = data[consumed:]
+	}
+	return fields, nil
+}
+
+// String returns the name of the tag
+func (t Tag) String() string {
+	switch t {
+	case HelloRequest:
+		return "HelloRequest"
+	case HelloResponse:
+		return "HelloResponse"
+	case UUIDClient:
+		return "UUIDClient"
+	case UUIDPartie:
+		return "UUIDPartie"
+	case Signature:
+		return "Signature"
+	case String:
+		return "String"
+	case Int:
+		return "Int"
+	case ByteData:
+		return "Byte"
+	case GameRequest:
+		return "GameRequest"
+	case GameResponse:
+		return "GameResponse"
+	case BoardRequest:
+		return "BoardRequest"
+	case BoardResponse:
+		return "BoardResponse"
+	case ActionRequest:
+		return "ActionRequest"
+	case ActionResponse:
+		return "ActionResponse"
+	case LobbyRequest:
+		return "LobbyRequest"
+	case LobbyResponse:
+		return "LobbyResponse"
+	case JoinLobbyRequest:
+		return "JoinLobbyRequest"
+	case QueueRequest:
+		return "QueueRequest"
+	case QueueCancelRequest:
+		return "QueueCancelRequest"
+	case QueueStatus:
+		return "QueueStatus"
+	case MatchFound:
+		return "MatchFound"
+	case BotGameRequest:
+		return "BotGameRequest"
+	case BoardUpdate:
+		return "BoardUpdate"
+	case AnalyzeRequest:
+		return "AnalyzeRequest"
+	case AnalyzeResponse:
+		return "AnalyzeResponse"
+	case ArchiveRequest:
+		return "ArchiveRequest"
+	case ArchiveResponse:
+		return "ArchiveResponse"
+	case AbandonClaimRequest:
+		return "AbandonClaimRequest"
+	case ChatRequest:
+		return "ChatRequest"
+	case ChatMessage:
+		return "ChatMessage"
+	case PremoveRequest:
+		return "PremoveRequest"
+	case PremoveResponse:
+		return "PremoveResponse"
+	case UndoRequest:
+		return "UndoRequest"
+	case UndoAnswer:
+		return "UndoAnswer"
+	case UndoOffer:
+		return "UndoOffer"
+	case UndoResult:
+		return "UndoResult"
+	case Ping:
+		return "Ping"
+	case Pong:
+		return "Pong"
+	case ServerNotice:
+		return "ServerNotice"
+	case ErrorResponse:
+		return "ErrorResponse"
+	default:
+		return fmt.Sprintf("Unknown(%d)", byte(t))
+	}
+}
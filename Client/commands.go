@@ -0,0 +1,610 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"Client/chessclient"
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// commandOptions are the flags every subcommand takes
+type commandOptions struct {
+	Server  string
+	UDP     bool
+	Name    string
+	Level   int
+	JSON    bool
+	Timeout time.Duration
+	TLS     *TLSOptions
+}
+
+// registerCommandFlags declares the flags every subcommand takes on fs
+func registerCommandFlags(fs *flag.FlagSet) *commandOptions {
+	o := &commandOptions{}
+	fs.StringVar(&o.Server, "server", "localhost:8080", "server address")
+	fs.BoolVar(&o.UDP, "udp", false, "connect over UDP instead of TCP")
+	fs.StringVar(&o.Name, "name", player.FirstName, "first name the server knows the player by")
+	fs.IntVar(&o.Level, "level", player.Level, "player rating, used by the matchmaking queue")
+	fs.BoolVar(&o.JSON, "json", false, "print each result as one line of JSON instead of text")
+	fs.DurationVar(&o.Timeout, "timeout", requestTimeout, "wait for an answer of the server, or for a move of the opponent")
+	o.TLS = registerTLSFlags(fs)
+	return o
+}
+
+// command is a subcommand of the non-interactive client
+type command struct {
+	args       string // Positional arguments, for the usage
+	summary    string
+	fullScreen bool // Takes the terminal over, the logs are kept off it
+	// setup declares the subcommand's own flags on fs and returns the function running it
+	setup func(fs *flag.FlagSet) func(s *session, args []string) error
+}
+
+// commands are the subcommands, each running over its own connection
+var commands = map[string]command{
+	"lobbies": {
+		summary: "List the lobbies waiting for a player.",
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			filter := fs.String("filter", "", "keep the lobbies whose name contains this, regardless of case")
+			return func(s *session, args []string) error {
+				return s.lobbies(*filter)
+			}
+		},
+	},
+	"create": {
+		summary: "Open a lobby named after the player, then play the moves given.",
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			moves := registerMoveFlags(fs)
+			game := registerGameFlags(fs)
+			return func(s *session, args []string) error {
+				if err := s.create(*game); err != nil {
+					return err
+				}
+				return moves.play(s)
+			}
+		},
+	},
+	"join": {
+		args:    "LOBBY",
+		summary: "Take the free seat of a lobby, then play the moves given.",
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			moves := registerMoveFlags(fs)
+			return func(s *session, args []string) error {
+				if len(args) != 1 {
+					return errors.New("join takes the name of a lobby, e.g. join Lobby-Alice")
+				}
+				if err := s.join(args[0]); err != nil {
+					return err
+				}
+				return moves.play(s)
+			}
+		},
+	},
+	"play": {
+		summary: "Play the moves given in a new lobby, in a lobby joined, or against the computer.",
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			moves := registerMoveFlags(fs)
+			game := registerGameFlags(fs)
+			lobby := fs.String("join", "", "join this lobby instead of opening one")
+			bot := fs.Int("bot", 0, "play against the computer at this level (1-5), -color picks the side")
+			color := fs.String("color", "", "side played against the computer, white or black (default: the server's pick)")
+			engine := fs.String("engine", "", "engine of the computer, alphabeta or uci (default: the server's)")
+			return func(s *session, args []string) error {
+				var err error
+				switch {
+				case *bot > 0:
+					err = s.bot(chessclient.BotOptions{Level: *bot, Color: strings.ToLower(*color), Engine: *engine})
+				case *lobby != "":
+					err = s.join(*lobby)
+				default:
+					err = s.create(*game)
+				}
+				if err != nil {
+					return err
+				}
+				return moves.play(s)
+			}
+		},
+	},
+	"archive": {
+		summary: "List the finished games, most recent first.",
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			var filter chessclient.ArchiveFilter
+			fs.StringVar(&filter.Opening, "opening", "", "ECO code prefix (C6) or part of an opening name (sicilian)")
+			fs.StringVar(&filter.Player, "player", "", "games played by that name")
+			return func(s *session, args []string) error {
+				return s.archive(filter)
+			}
+		},
+	},
+	"analyze": {
+		args:    "GAME-ID",
+		summary: "Analyse a finished game with the server's engine.",
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			return func(s *session, args []string) error {
+				if len(args) != 1 {
+					return errors.New("analyze takes the ID of a finished game")
+				}
+				return s.analyze(args[0])
+			}
+		},
+	},
+	"tui": {
+		summary:    "Play in a full-screen terminal interface: board, move list, clocks and chat.",
+		fullScreen: true,
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			return func(s *session, args []string) error {
+				return runTUI(s)
+			}
+		},
+	},
+	"script": {
+		args:    "FILE",
+		summary: "Run the commands of a script over one connection, one per line, '-' reading the standard input.",
+		setup: func(fs *flag.FlagSet) func(*session, []string) error {
+			return func(s *session, args []string) error {
+				if len(args) != 1 {
+					return errors.New("script takes a file, or - for the standard input")
+				}
+				if args[0] == "-" {
+					return s.runScript(os.Stdin, "stdin")
+				}
+				file, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				return s.runScript(file, args[0])
+			}
+		},
+	},
+}
+
+// moveFlags are the flags of the subcommands playing moves
+type moveFlags struct {
+	moves string
+}
+
+// registerMoveFlags declares the flags of the subcommands playing moves on fs
+func registerMoveFlags(fs *flag.FlagSet) *moveFlags {
+	m := &moveFlags{}
+	fs.StringVar(&m.moves, "moves", "", "moves to play, comma-separated, in algebraic (e4,Nf3) or UCI (e2e4,g1f3) notation")
+	return m
+}
+
+// play plays the moves of the flags, if any
+func (m *moveFlags) play(s *session) error {
+	moves := splitMoves(m.moves)
+	if len(moves) == 0 {
+		return nil
+	}
+	return s.play(moves)
+}
+
+// splitMoves splits a list of moves separated by commas or spaces
+func splitMoves(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// commandNames returns the names of the subcommands, sorted
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// runCommand runs a subcommand given on the command line and returns the exit status
+func runCommand(name string, args []string) int {
+	cmd := commands[name]
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	opts := registerCommandFlags(fs)
+	run := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: client %s [flags] %s\n\n%s\n\nFlags:\n", name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	args, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		// The flag package already printed the error and the usage
+		return 2
+	}
+
+	if cmd.fullScreen {
+		setupLogging(io.Discard)
+	}
+	s, err := openSession(opts)
+	if err != nil {
+		reportError(opts.JSON, err)
+		return 1
+	}
+	defer s.client.Close()
+
+	if err := run(s, args); err != nil {
+		reportError(opts.JSON, err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed parses the flags of a subcommand wherever they stand, before or after its
+// arguments, and returns the arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// reportError prints the error a subcommand failed with, as JSON on the standard output when
+// the results are JSON so scripts read it from the same stream
+func reportError(asJSON bool, err error) {
+	if asJSON {
+		json.NewEncoder(os.Stdout).Encode(errorResult{Error: err.Error()})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+}
+
+// session is the connection a subcommand, or a whole script, runs over, with what the
+// commands before learnt
+type session struct {
+	client  *chessclient.Client
+	json    bool
+	timeout time.Duration
+}
+
+// openSession connects to the server and introduces the player
+func openSession(opts *commandOptions) (*session, error) {
+	tlsConfig, err := opts.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS settings: %w", err)
+	}
+	transport := chessclient.TCP
+	if opts.UDP {
+		transport = chessclient.UDP
+	}
+
+	me := player
+	me.FirstName = opts.Name
+	me.Level = opts.Level
+	client, err := connect(transport, opts.Server, tlsConfig, me, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &session{client: client, json: opts.JSON, timeout: opts.Timeout}, nil
+}
+
+// Results printed as JSON, one object per line
+type (
+	errorResult struct {
+		Error string `json:"error"`
+	}
+
+	lobbiesResult struct {
+		Lobbies []string `json:"lobbies"`
+	}
+
+	gameResult struct {
+		GameID         uuid.UUID `json:"game_id"`
+		Lobby          string    `json:"lobby,omitempty"`
+		Color          string    `json:"color,omitempty"`
+		Opponent       string    `json:"opponent,omitempty"`
+		OpponentRating int       `json:"opponent_rating,omitempty"`
+		TimeControl    string    `json:"time_control,omitempty"`
+	}
+
+	boardResult struct {
+		GameID  uuid.UUID `json:"game_id"`
+		Played  []string  `json:"played,omitempty"` // Moves just played by this client
+		FEN     string    `json:"fen"`
+		Turn    string    `json:"turn"`    // white or black
+		Outcome string    `json:"outcome"` // * while the game goes on
+		Method  string    `json:"method,omitempty"`
+	}
+
+	legalResult struct {
+		Moves []string `json:"moves"`
+	}
+
+	queuedResult struct {
+		Queued string `json:"queued"`
+	}
+
+	archiveResult struct {
+		Games []chessclient.ArchivedGame `json:"games"`
+	}
+)
+
+// emit prints a result, as JSON or as text
+func (s *session) emit(value any, text func()) error {
+	if s.json {
+		return json.NewEncoder(os.Stdout).Encode(value)
+	}
+	text()
+	return nil
+}
+
+// context bounds the wait for the answer to a request
+func (s *session) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *session) lobbies(filter string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	lobbies, err := s.client.ListLobbies(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return s.emit(lobbiesResult{Lobbies: lobbies}, func() {
+		for _, lobby := range lobbies {
+			fmt.Println(lobby)
+		}
+	})
+}
+
+// registerGameFlags declares the flags choosing the variant of a new lobby on fs
+func registerGameFlags(fs *flag.FlagSet) *chessclient.GameOptions {
+	o := &chessclient.GameOptions{}
+	fs.StringVar(&o.Variant, "variant", "standard", "rules of the game: standard, chess960, fromposition, kingofthehill, threecheck or horde, or the game tictactoe or connectfour")
+	fs.StringVar(&o.Position, "position", "", "Chess960 starting position from 0 to 959 (default: random), or the FEN of a fromposition game")
+	return o
+}
+
+// gameOptions reads the variant of a new lobby from words: none for standard chess, or the
+// variant followed by its starting position, e.g. "chess960 518" or "fromposition FEN"
+func gameOptions(words []string) chessclient.GameOptions {
+	if len(words) == 0 {
+		return chessclient.GameOptions{}
+	}
+	return chessclient.GameOptions{Variant: words[0], Position: strings.Join(words[1:], " ")}
+}
+
+func (s *session) create(opts chessclient.GameOptions) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	gameID, err := s.client.CreateGame(ctx, opts)
+	if err != nil {
+		return err
+	}
+	lobby := "Lobby-" + s.client.Player().FirstName
+	return s.emit(gameResult{GameID: gameID, Lobby: lobby, Color: s.client.Color()}, func() {
+		fmt.Printf("Game %s created in %s, playing %s.\n", gameID, lobby, s.client.Color())
+	})
+}
+
+func (s *session) join(lobby string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	gameID, err := s.client.Join(ctx, lobby)
+	if err != nil {
+		return err
+	}
+	return s.emit(gameResult{GameID: gameID, Lobby: lobby, Color: s.client.Color()}, func() {
+		fmt.Printf("Joined %s, game %s, playing %s.\n", lobby, gameID, s.client.Color())
+	})
+}
+
+func (s *session) bot(opts chessclient.BotOptions) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	match, err := s.client.PlayBot(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return s.emit(gameResult{
+		GameID:         match.GameID,
+		Color:          match.Color,
+		Opponent:       match.Opponent,
+		OpponentRating: match.OpponentRating,
+		TimeControl:    match.TimeControl,
+	}, func() {
+		printMatch(match)
+	})
+}
+
+// play plays moves in the current game, each waiting for the opponent's
+func (s *session) play(moves []string) error {
+	var played []string
+	for _, move := range moves {
+		if err := s.waitTurn(); err != nil {
+			return err
+		}
+		ctx, cancel := s.context()
+		err := s.client.Move(ctx, move)
+		cancel()
+		var moveErr *chessclient.MoveError
+		switch {
+		case errors.As(err, &moveErr):
+			return err
+		case err != nil:
+			return fmt.Errorf("move %s: %w", move, err)
+		}
+		played = append(played, move)
+	}
+
+	board := s.client.Position()
+	result := s.boardResult(board)
+	result.Played = played
+	return s.emit(result, func() {
+		fmt.Printf("Played %s\n", strings.Join(played, " "))
+		printBoard(board)
+	})
+}
+
+// wait waits for the opponent to move, until it is the turn of the side played
+func (s *session) wait() error {
+	if err := s.waitTurn(); err != nil {
+		return err
+	}
+	board := s.client.Position()
+	return s.emit(s.boardResult(board), func() {
+		printBoard(board)
+	})
+}
+
+// waitTurn waits for the boards the server pushes until it is the turn of the side played, in
+// a lobby opened by the player once someone joined it
+func (s *session) waitTurn() error {
+	timeout := time.NewTimer(s.timeout)
+	defer timeout.Stop()
+	for {
+		// The client keeps the newest position, whichever event brought it
+		board := s.client.Position()
+		if board == nil {
+			return chessclient.ErrNoGame
+		}
+		if board.Outcome() != chess.NoOutcome {
+			return fmt.Errorf("the game is over: %s by %s", board.Outcome(), board.Method())
+		}
+		if !s.client.AwaitingOpponent() && strings.EqualFold(board.Position().Turn().Name(), s.client.Color()) {
+			return nil
+		}
+
+		select {
+		case event, ok := <-s.client.Events():
+			if !ok {
+				return s.client.Err()
+			}
+			switch event := event.(type) {
+			case chessclient.ErrorEvent:
+				return event.Err
+			case chessclient.NoticeEvent:
+				slog.Info("Server notice", "message", event.Message)
+			}
+		case <-timeout.C:
+			return fmt.Errorf("the opponent did not move within %s", s.timeout)
+		}
+	}
+}
+
+// legal lists the legal moves of the current position, of a piece when filter names it
+func (s *session) legal(filter string) error {
+	moves, err := s.client.LegalMoves(filter)
+	if err != nil {
+		return err
+	}
+	return s.emit(legalResult{Moves: moves}, func() {
+		fmt.Println(strings.Join(moves, " "))
+	})
+}
+
+// takeback asks the opponent to take back the last plies, the answer coming as an event
+func (s *session) takeback(plies int) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.RequestUndo(ctx, plies)
+}
+
+// answerTakeback accepts or declines the takeback the opponent asked for
+func (s *session) answerTakeback(accept bool) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	return s.client.AnswerUndo(ctx, accept)
+}
+
+// premove queues lines of moves for the server to play once the opponent moved
+func (s *session) premove(lines string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	queued, err := s.client.QueueMoves(ctx, lines)
+	if err != nil {
+		return err
+	}
+	return s.emit(queuedResult{Queued: queued}, func() {
+		if queued == "" {
+			fmt.Println("No move queued")
+			return
+		}
+		fmt.Println("Queued:", queued)
+	})
+}
+
+func (s *session) board() error {
+	ctx, cancel := s.context()
+	defer cancel()
+	board, err := s.client.Board(ctx)
+	if err != nil {
+		return err
+	}
+	return s.emit(s.boardResult(board), func() {
+		printBoard(board)
+	})
+}
+
+func (s *session) archive(filter chessclient.ArchiveFilter) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	games, err := s.client.Archive(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return s.emit(archiveResult{Games: games}, func() {
+		printArchive(games)
+	})
+}
+
+// analyze analyses a finished game, the current one when id is empty
+func (s *session) analyze(id string) error {
+	gameID := s.client.GameID()
+	if id != "" {
+		var err error
+		if gameID, err = uuid.Parse(id); err != nil {
+			return fmt.Errorf("invalid game ID: %w", err)
+		}
+	}
+
+	// The engine takes longer than the other requests
+	ctx, cancel := context.WithTimeout(context.Background(), max(s.timeout, analysisTimeout))
+	defer cancel()
+	analysis, err := s.client.Analyze(ctx, gameID)
+	if err != nil {
+		return err
+	}
+	return s.emit(analysis, func() {
+		printAnalysis(analysis)
+	})
+}
+
+// boardResult describes a position of the current game
+func (s *session) boardResult(board *chess.Game) boardResult {
+	result := boardResult{
+		GameID:  s.client.GameID(),
+		FEN:     board.Position().String(),
+		Turn:    strings.ToLower(board.Position().Turn().Name()),
+		Outcome: string(board.Outcome()),
+	}
+	if board.Method() != chess.NoMethod {
+		result.Method = board.Method().String()
+	}
+	return result
+}
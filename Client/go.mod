@@ -5,4 +5,7 @@ go 1.23.2
 require (
 	github.com/google/uuid v1.6.0
 	github.com/notnil/chess v1.10.0
+	golang.org/x/term v0.27.0
 )
+
+require golang.org/x/sys v0.28.0 // indirect
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// redactedValue replaces secrets in log records
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeys are attribute key fragments whose values never reach the logs
+var sensitiveKeys = []string{"signature", "hash", "token", "secret", "password"}
+
+// redactAttr is the slog ReplaceAttr hook hiding signatures, hashes and tokens
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(key, fragment) && attr.Value.Kind() != slog.KindGroup {
+			return slog.String(attr.Key, redactedValue)
+		}
+	}
+	return attr
+}
+
+// setupLogging installs a logger writing to w, usually the standard error, its level and
+// format taken from TP2_LOG_LEVEL (debug, info, warn or error) and TP2_LOG_FORMAT (text or json)
+func setupLogging(w io.Writer) {
+	var level slog.Level
+	if name, ok := os.LookupEnv("TP2_LOG_LEVEL"); ok {
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			level = slog.LevelInfo
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if os.Getenv("TP2_LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"Client/chessclient"
+)
+
+// printQueueStatus prints where we stand in the matchmaking queue
+func printQueueStatus(status chessclient.QueueEvent) {
+	switch status.State {
+	case "cancelled":
+		fmt.Println("You left the matchmaking queue.")
+	default:
+		fmt.Printf("Matchmaking %s: waited %.0fs, rating window ±%d, %d player(s) in queue\n",
+			status.State, status.Waited.Seconds(), status.Window, status.PoolSize)
+	}
+}
+
+// printMatch prints the game we were paired into
+func printMatch(match chessclient.Match) {
+	fmt.Printf("\nMatch found! You play %s against %s (%d) at %s.\n",
+		match.Color, match.Opponent, match.OpponentRating, match.TimeControl)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"Client/chessclient"
+)
+
+// printServerError prints an error the server reported for one of our requests
+func printServerError(err *chessclient.ServerError) {
+	fmt.Printf("\nThe server rejected %s: %s\n", err.Request, err.Message)
+}
+
+// printNotice prints an announcement the server sent to every client
+func printNotice(notice chessclient.NoticeEvent) {
+	fmt.Printf("\n*** Server notice: %s ***\n", notice.Message)
+}
+
+// printUndoOffer prints a takeback the opponent asks for
+func printUndoOffer(offer chessclient.UndoOfferEvent) {
+	fmt.Printf("\n%s asks to take back %d move(s): type 'accept' or 'decline'.\n", offer.From, offer.Plies)
+}
+
+// printUndoResult prints the answer to a takeback request
+func printUndoResult(result chessclient.UndoResultEvent) {
+	if !result.Accepted {
+		fmt.Printf("\n%s declined the takeback.\n", result.By)
+		return
+	}
+	fmt.Printf("\n%s accepted the takeback of %d move(s).\n", result.By, result.Plies)
+}
+
+// printChat prints a chat line from another player of the game
+func printChat(chat chessclient.ChatEvent) {
+	fmt.Printf("\n[%s] %s\n", chat.From, chat.Text)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"Client/chessclient"
+)
+
+// scriptCommand is a command of a script, run with the words following its name
+type scriptCommand struct {
+	usage string
+	run   func(s *session, args []string) error
+}
+
+// scriptCommands are the commands of the scripts run by the script subcommand. Lines are
+// split on spaces, those starting with # are comments.
+var scriptCommands = map[string]scriptCommand{
+	"lobbies": {"lobbies [FILTER]", func(s *session, args []string) error {
+		return s.lobbies(strings.Join(args, " "))
+	}},
+	"create": {"create [VARIANT [POSITION]]", func(s *session, args []string) error {
+		return s.create(gameOptions(args))
+	}},
+	"join": {"join LOBBY", func(s *session, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		return s.join(args[0])
+	}},
+	"bot": {"bot LEVEL [white|black|random [ENGINE]]", func(s *session, args []string) error {
+		if len(args) < 1 || len(args) > 3 {
+			return errUsage
+		}
+		level, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid level %q", args[0])
+		}
+		opts := chessclient.BotOptions{Level: level}
+		if len(args) > 1 {
+			opts.Color = strings.ToLower(args[1])
+		}
+		if len(args) > 2 {
+			opts.Engine = strings.ToLower(args[2])
+		}
+		return s.bot(opts)
+	}},
+	"move": {"move MOVE...", func(s *session, args []string) error {
+		moves := splitMoves(strings.Join(args, " "))
+		if len(moves) == 0 {
+			return errUsage
+		}
+		return s.play(moves)
+	}},
+	"legal": {"legal [SQUARE|PIECE]", func(s *session, args []string) error {
+		if len(args) > 1 {
+			return errUsage
+		}
+		return s.legal(strings.Join(args, ""))
+	}},
+	"premove": {"premove [MOVES; ...]", func(s *session, args []string) error {
+		return s.premove(strings.Join(args, " "))
+	}},
+	"takeback": {"takeback [1|2]", func(s *session, args []string) error {
+		plies := 1
+		switch len(args) {
+		case 0:
+		case 1:
+			var err error
+			if plies, err = strconv.Atoi(args[0]); err != nil {
+				return errUsage
+			}
+		default:
+			return errUsage
+		}
+		return s.takeback(plies)
+	}},
+	"accept": {"accept", func(s *session, args []string) error {
+		return s.answerTakeback(true)
+	}},
+	"decline": {"decline", func(s *session, args []string) error {
+		return s.answerTakeback(false)
+	}},
+	"wait": {"wait", func(s *session, args []string) error {
+		return s.wait()
+	}},
+	"board": {"board", func(s *session, args []string) error {
+		return s.board()
+	}},
+	"expect": {"expect fen|turn|outcome VALUE", func(s *session, args []string) error {
+		if len(args) < 2 {
+			return errUsage
+		}
+		return s.expect(args[0], strings.Join(args[1:], " "))
+	}},
+	"archive": {"archive [opening=OPENING] [player=NAME]", func(s *session, args []string) error {
+		var filter chessclient.ArchiveFilter
+		for _, arg := range args {
+			key, value, _ := strings.Cut(arg, "=")
+			switch key {
+			case "opening":
+				filter.Opening = value
+			case "player":
+				filter.Player = value
+			default:
+				return errUsage
+			}
+		}
+		return s.archive(filter)
+	}},
+	"analyze": {"analyze [GAME-ID]", func(s *session, args []string) error {
+		if len(args) > 1 {
+			return errUsage
+		}
+		return s.analyze(strings.Join(args, ""))
+	}},
+	"sleep": {"sleep DURATION", func(s *session, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		delay, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+		time.Sleep(delay)
+		return nil
+	}},
+}
+
+// errUsage is returned by a script command given the wrong arguments
+var errUsage = errors.New("wrong arguments")
+
+// runScript runs the commands of a script over the session's connection and stops at the
+// first that fails, telling on which line
+func (s *session) runScript(r io.Reader, name string) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+
+		command, ok := scriptCommands[strings.ToLower(words[0])]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown command %q", name, line, words[0])
+		}
+		if err := command.run(s, words[1:]); err != nil {
+			if errors.Is(err, errUsage) {
+				return fmt.Errorf("%s:%d: usage: %s", name, line, command.usage)
+			}
+			return fmt.Errorf("%s:%d: %s: %w", name, line, words[0], err)
+		}
+	}
+	return scanner.Err()
+}
+
+// expect checks the current position, failing the script when it differs
+func (s *session) expect(what, want string) error {
+	board := s.client.Position()
+	if board == nil {
+		return chessclient.ErrNoGame
+	}
+
+	var got string
+	switch strings.ToLower(what) {
+	case "fen":
+		got = board.Position().String()
+	case "turn":
+		got = strings.ToLower(board.Position().Turn().Name())
+	case "outcome":
+		got = string(board.Outcome())
+	default:
+		return errUsage
+	}
+	if got != want {
+		return fmt.Errorf("expected %s %q, got %q", what, want, got)
+	}
+	return nil
+}
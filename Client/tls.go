@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// TLSOptions are the command-line settings of the TLS connection to the server's TCP port
+type TLSOptions struct {
+	Enabled    bool
+	CAFile     string // Trusted instead of the system roots, pins the server's CA
+	ServerName string // Name checked in the server certificate, the server host by default
+	CertFile   string // Client certificate for mutual TLS, e.g. for bots
+	KeyFile    string
+}
+
+// registerTLSFlags declares the TLS flags on fs
+func registerTLSFlags(fs *flag.FlagSet) *TLSOptions {
+	o := &TLSOptions{}
+	fs.BoolVar(&o.Enabled, "tls", false, "connect to the TCP port with TLS (implied by the other -tls flags)")
+	fs.StringVar(&o.CAFile, "tls-ca", "", "PEM CA trusted instead of the system roots, e.g. the server's self-signed certificate")
+	fs.StringVar(&o.ServerName, "tls-server-name", "", "name verified in the server certificate (default: the host of the server address)")
+	fs.StringVar(&o.CertFile, "tls-cert", "", "PEM client certificate for mutual TLS")
+	fs.StringVar(&o.KeyFile, "tls-key", "", "PEM private key of the client certificate")
+	return o
+}
+
+// Config builds the TLS settings from the options, nil when TLS is not used
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	if !o.Enabled && o.CAFile == "" && o.ServerName == "" && o.CertFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName: o.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if o.CAFile != "" {
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("-tls-cert and -tls-key go together")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"Client/chessclient"
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+	"golang.org/x/term"
+)
+
+// tuiCommands are the commands of the TUI's command bar, with their arguments. A line not
+// starting with / is a move.
+var tuiCommands = []string{
+	"/create [VARIANT [POSITION]]",
+	"/join LOBBY",
+	"/lobbies [FILTER]",
+	"/bot LEVEL [white|black|random [ENGINE]]",
+	"/queue [5+3] [rated]",
+	"/cancel",
+	"/board",
+	"/moves [SQUARE|PIECE]",
+	"/premove [MOVES; ...]",
+	"/takeback [1|2]",
+	"/accept",
+	"/decline",
+	"/flip",
+	"/say TEXT",
+	"/claim",
+	"/help",
+	"/quit",
+}
+
+// maxLogLines is how many lines the chat pane keeps
+const maxLogLines = 200
+
+// tui is the full-screen terminal interface: board, move list, clocks, chat and a command
+// bar. Its state is only touched by the goroutine running loop, the requests running in the
+// background hand their results back through updates.
+type tui struct {
+	client  *chessclient.Client
+	timeout time.Duration
+
+	game           *chess.Game // Replica of the current game as the client last held it
+	gameID         uuid.UUID
+	color          string // Side played, white or black, empty before the first game
+	bottom         chess.Color
+	flipped        bool
+	opponent       string
+	opponentRating int
+	opening        string
+	result         string // How the game ended when the server ended it, e.g. on time
+	queued         string // Premove or conditional moves the server holds for us, as it wrote them back
+
+	clocked    bool
+	increment  time.Duration
+	whiteClock time.Duration
+	blackClock time.Duration
+	clockSince time.Time // When the clock of the side to move last read its value
+
+	log     []string // Chat pane: chat lines, notices and results
+	status  string   // Line above the command bar: completions and errors
+	input   []rune
+	history []string
+	recall  int // Position in history while browsing it with the arrows
+
+	updates chan func()
+	width   int
+	height  int
+	quit    bool
+}
+
+// runTUI takes the terminal over until the player quits
+func runTUI(s *session) error {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return errors.New("the TUI needs a terminal, scripts can use the other commands")
+	}
+	saved, err := term.MakeRaw(in)
+	if err != nil {
+		return fmt.Errorf("error switching the terminal to raw mode: %w", err)
+	}
+	defer term.Restore(in, saved)
+	fmt.Print(enterFullScreen)
+	defer fmt.Print(leaveFullScreen)
+
+	t := &tui{
+		client:  s.client,
+		timeout: s.timeout,
+		bottom:  chess.White,
+		updates: make(chan func(), 16),
+	}
+	t.logf("Connected as %s. Type /help for the commands, Tab completes moves.", s.client.Player().FirstName)
+	t.loop(in, out)
+	return nil
+}
+
+// loop draws the screen and reacts to keys, server events and request results until quit
+func (t *tui) loop(in, out int) {
+	keys := make(chan key, 16)
+	go readKeys(os.Stdin, keys)
+	events := t.client.Events()
+
+	// Redraw regularly for the clocks and a resized terminal
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !t.quit {
+		t.game = t.client.Position()
+		t.draw(out)
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				return
+			}
+			t.handleKey(k)
+		case event, ok := <-events:
+			if !ok {
+				// Stay on screen so the player can read why, /quit leaves
+				t.logf("Connection lost: %v", t.client.Err())
+				events = nil
+				continue
+			}
+			t.handleEvent(event)
+		case update := <-t.updates:
+			update()
+		case <-ticker.C:
+		}
+	}
+}
+
+// logf adds a line to the chat pane
+func (t *tui) logf(format string, args ...any) {
+	t.log = append(t.log, fmt.Sprintf(format, args...))
+	if len(t.log) > maxLogLines {
+		t.log = t.log[len(t.log)-maxLogLines:]
+	}
+}
+
+// fail reports an error in the status line and keeps it in the chat pane
+func (t *tui) fail(err error) {
+	t.status = "Error: " + err.Error()
+	t.logf("%s", t.status)
+}
+
+// request runs a request in the background. The function it returns, if any, is applied to
+// the state by the loop once the request succeeded.
+func (t *tui) request(do func(ctx context.Context) (func(), error)) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+		defer cancel()
+		apply, err := do(ctx)
+		t.updates <- func() {
+			if err != nil {
+				t.fail(err)
+				return
+			}
+			if apply != nil {
+				apply()
+			}
+		}
+	}()
+}
+
+// submit runs the line typed in the command bar
+func (t *tui) submit(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	t.history = append(t.history, line)
+	t.recall = len(t.history)
+	t.status = ""
+
+	if !strings.HasPrefix(line, "/") {
+		t.play(line)
+		return
+	}
+
+	words := strings.Fields(line)
+	args := words[1:]
+	switch words[0] {
+	case "/create":
+		t.request(func(ctx context.Context) (func(), error) {
+			gameID, err := t.client.CreateGame(ctx, gameOptions(args))
+			if err != nil {
+				return nil, err
+			}
+			return func() {
+				t.startGame(gameID, chessclient.Match{}, chess.White)
+				t.logf("Game created in Lobby-%s, waiting for an opponent.", t.client.Player().FirstName)
+			}, nil
+		})
+
+	case "/join":
+		if len(args) != 1 {
+			t.status = "Usage: /join LOBBY"
+			return
+		}
+		t.request(func(ctx context.Context) (func(), error) {
+			gameID, err := t.client.Join(ctx, args[0])
+			if err != nil {
+				return nil, err
+			}
+			return func() {
+				// The creator of a lobby plays white
+				t.startGame(gameID, chessclient.Match{Opponent: strings.TrimPrefix(args[0], "Lobby-")}, chess.Black)
+				t.logf("Joined %s.", args[0])
+			}, nil
+		})
+
+	case "/lobbies":
+		filter := strings.Join(args, " ")
+		t.request(func(ctx context.Context) (func(), error) {
+			lobbies, err := t.client.ListLobbies(ctx, filter)
+			if err != nil {
+				return nil, err
+			}
+			return func() {
+				if len(lobbies) == 0 {
+					t.logf("No lobby is waiting for a player.")
+					return
+				}
+				t.logf("Lobbies: %s", strings.Join(lobbies, ", "))
+			}, nil
+		})
+
+	case "/bot":
+		if len(args) < 1 || len(args) > 3 {
+			t.status = "Usage: /bot LEVEL [white|black|random [ENGINE]]"
+			return
+		}
+		level, err := strconv.Atoi(args[0])
+		if err != nil {
+			t.status = "The level is a number from 1 to 5"
+			return
+		}
+		opts := chessclient.BotOptions{Level: level}
+		if len(args) > 1 {
+			opts.Color = strings.ToLower(args[1])
+		}
+		if len(args) > 2 {
+			opts.Engine = strings.ToLower(args[2])
+		}
+		t.request(func(ctx context.Context) (func(), error) {
+			match, err := t.client.PlayBot(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return func() { t.startMatch(match) }, nil
+		})
+
+	case "/queue":
+		timeControl, rated := "5+3", false
+		for _, arg := range args {
+			if arg == "rated" {
+				rated = true
+			} else {
+				timeControl = arg
+			}
+		}
+		t.request(func(ctx context.Context) (func(), error) {
+			status, err := t.client.JoinQueue(ctx, timeControl, rated)
+			if err != nil {
+				return nil, err
+			}
+			return func() { t.logQueueStatus(status) }, nil
+		})
+
+	case "/cancel":
+		t.request(func(ctx context.Context) (func(), error) {
+			if err := t.client.CancelQueue(ctx); err != nil {
+				return nil, err
+			}
+			return func() { t.logf("You left the matchmaking queue.") }, nil
+		})
+
+	case "/board":
+		t.request(func(ctx context.Context) (func(), error) {
+			_, err := t.client.Board(ctx)
+			return nil, err
+		})
+
+	case "/moves":
+		moves, err := t.client.LegalMoves(strings.Join(args, ""))
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		if len(moves) == 0 {
+			t.logf("No legal move.")
+			return
+		}
+		t.logf("Legal moves: %s", strings.Join(moves, " "))
+
+	case "/premove":
+		t.queueMoves(strings.TrimSpace(strings.TrimPrefix(line, "/premove")))
+
+	case "/takeback":
+		plies := 1
+		if len(args) > 0 {
+			var err error
+			if plies, err = strconv.Atoi(args[0]); err != nil || len(args) > 1 {
+				t.status = "Usage: /takeback [1|2]"
+				return
+			}
+		}
+		t.request(func(ctx context.Context) (func(), error) {
+			if err := t.client.RequestUndo(ctx, plies); err != nil {
+				return nil, err
+			}
+			return func() { t.logf("Takeback of %d move(s) asked, waiting for the answer.", plies) }, nil
+		})
+
+	case "/accept", "/decline":
+		accept := words[0] == "/accept"
+		t.request(func(ctx context.Context) (func(), error) {
+			return nil, t.client.AnswerUndo(ctx, accept)
+		})
+
+	case "/flip":
+		t.flipped = !t.flipped
+
+	case "/say":
+		text := strings.TrimSpace(strings.TrimPrefix(line, "/say"))
+		if text == "" {
+			t.status = "Usage: /say TEXT"
+			return
+		}
+		t.request(func(ctx context.Context) (func(), error) {
+			if err := t.client.Chat(ctx, text); err != nil {
+				return nil, err
+			}
+			return func() { t.logf("<%s> %s", t.client.Player().FirstName, text) }, nil
+		})
+
+	case "/claim":
+		gameID := t.gameID
+		t.request(func(ctx context.Context) (func(), error) {
+			if err := t.client.ClaimAbandoned(ctx, gameID); err != nil {
+				return nil, err
+			}
+			return func() { t.logf("Claim sent, the final board comes if the server grants it.") }, nil
+		})
+
+	case "/help":
+		t.logf("Type a move (e4, Nf3, e2e4) or a command: %s", strings.Join(tuiCommands, ", "))
+
+	case "/quit":
+		t.quit = true
+
+	default:
+		t.status = fmt.Sprintf("Unknown command %s, /help lists them", words[0])
+	}
+}
+
+// play checks a move against the replica and sends it to the server
+func (t *tui) play(input string) {
+	if t.game == nil {
+		t.status = "No game yet: /create, /join LOBBY, /bot LEVEL or /queue"
+		return
+	}
+	if t.result != "" {
+		t.status = "The game is over"
+		return
+	}
+	// A move typed while the opponent thinks is a premove, checked once their move is in
+	position := t.game.Position()
+	if t.color != "" && !strings.EqualFold(position.Turn().Name(), t.color) {
+		t.queueMoves(input)
+		return
+	}
+	move, err := t.client.ParseMove(input)
+	if err != nil {
+		t.status = err.Error()
+		return
+	}
+
+	san := chess.AlgebraicNotation{}.Encode(position, move)
+	mover := position.Turn()
+	t.request(func(ctx context.Context) (func(), error) {
+		if err := t.client.Move(ctx, san); err != nil {
+			return nil, err
+		}
+		return func() { t.punchClock(mover) }, nil
+	})
+}
+
+// queueMoves hands premove or conditional lines to the server, an empty text clearing them
+func (t *tui) queueMoves(lines string) {
+	if t.game == nil {
+		t.status = "No game yet: /create, /join LOBBY, /bot LEVEL or /queue"
+		return
+	}
+	t.request(func(ctx context.Context) (func(), error) {
+		queued, err := t.client.QueueMoves(ctx, lines)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			t.queued = queued
+			if queued == "" {
+				t.logf("Queued moves cleared.")
+				return
+			}
+			t.logf("Queued: %s", queued)
+		}, nil
+	})
+}
+
+// startGame makes a game the current one, bottom being the side shown at the bottom
+func (t *tui) startGame(gameID uuid.UUID, match chessclient.Match, bottom chess.Color) {
+	t.gameID = gameID
+	t.color = t.client.Color()
+	t.bottom = bottom
+	t.flipped = false
+	t.opponent = match.Opponent
+	t.opponentRating = match.OpponentRating
+	t.opening = ""
+	t.result = ""
+	t.queued = ""
+
+	// The server starts the clocks with the game
+	initial, increment, ok := parseTimeControl(match.TimeControl)
+	t.clocked = ok && initial > 0
+	t.increment = increment
+	t.whiteClock, t.blackClock = initial, initial
+	t.clockSince = time.Now()
+}
+
+// startMatch makes a game the server started the current one
+func (t *tui) startMatch(match chessclient.Match) {
+	bottom := chess.White
+	if match.Color == "black" {
+		bottom = chess.Black
+	}
+	t.startGame(match.GameID, match, bottom)
+	t.logf("You play %s against %s (%d) at %s.", match.Color, match.Opponent, match.OpponentRating, match.TimeControl)
+}
+
+// handleEvent applies what the server sent on its own
+func (t *tui) handleEvent(event chessclient.Event) {
+	switch event := event.(type) {
+	case chessclient.BoardEvent:
+		t.applyBoard(event)
+	case chessclient.MatchEvent:
+		t.startMatch(event.Match)
+	case chessclient.QueueEvent:
+		t.logQueueStatus(event)
+	case chessclient.ChatEvent:
+		t.logf("<%s> %s", event.From, event.Text)
+	case chessclient.UndoOfferEvent:
+		t.logf("%s asks to take back %d move(s): /accept or /decline.", event.From, event.Plies)
+	case chessclient.UndoResultEvent:
+		if !event.Accepted {
+			t.logf("%s declined the takeback.", event.By)
+			return
+		}
+		t.queued = "" // The server drops the queued moves with the position
+		t.logf("%s accepted the takeback of %d move(s).", event.By, event.Plies)
+	case chessclient.NoticeEvent:
+		t.logf("*** Server notice: %s ***", event.Message)
+	case chessclient.ErrorEvent:
+		t.fail(event.Err)
+	}
+}
+
+// applyBoard takes the players, opening, clocks and result from a board the server pushed,
+// the client having already brought the replica to it
+func (t *tui) applyBoard(update chessclient.BoardEvent) {
+	me := t.client.Player().FirstName
+	if update.Mover != "" && update.Mover != me && t.opponent == "" {
+		t.opponent = update.Mover
+	}
+
+	// The server answers the opponent's move with our queued one, or drops the queue. Our
+	// own moves are not pushed to us, so a move of ours here is a queued one.
+	switch {
+	case update.Move == "" && update.Mover != "" && update.Mover != me:
+		t.logf("%s joined, the game starts.", update.Mover)
+	case update.Mover == me:
+		t.logf("Queued move %s played.", update.Move)
+	case update.Mover != "":
+		t.queued = ""
+	}
+	if update.OpeningCode != "" {
+		t.opening = update.OpeningCode + " " + update.OpeningName
+	}
+	if update.Clocked {
+		t.clocked = true
+		t.whiteClock, t.blackClock = update.WhiteClock, update.BlackClock
+		t.clockSince = time.Now()
+	} else if update.Game != nil {
+		t.punchClock(update.Game.Position().Turn().Other())
+	}
+	if update.Game == nil {
+		// The board of a game other than chess, which the board pane cannot draw
+		t.logf("%s played %s: %s", update.Mover, update.Move, update.State)
+	}
+	if update.Outcome != chess.NoOutcome {
+		t.result = fmt.Sprintf("%s by %s", update.Outcome, update.Method)
+		t.logf("Game over: %s.", t.result)
+	}
+}
+
+// logQueueStatus adds where we stand in the matchmaking queue to the chat pane
+func (t *tui) logQueueStatus(status chessclient.QueueEvent) {
+	if status.State == "cancelled" {
+		t.logf("You left the matchmaking queue.")
+		return
+	}
+	t.logf("Matchmaking %s: waited %.0fs, rating window ±%d, %d player(s) in queue",
+		status.State, status.Waited.Seconds(), status.Window, status.PoolSize)
+}
+
+// punchClock stops the clock of the side that just moved, adding the increment, until the
+// server's next board tells the exact times
+func (t *tui) punchClock(mover chess.Color) {
+	if !t.clocked {
+		return
+	}
+	now := time.Now()
+	elapsed := now.Sub(t.clockSince)
+	if mover == chess.White {
+		t.whiteClock = max(t.whiteClock-elapsed, 0) + t.increment
+	} else {
+		t.blackClock = max(t.blackClock-elapsed, 0) + t.increment
+	}
+	t.clockSince = now
+}
+
+// clock returns the time left to a side, the clock of the side to move running
+func (t *tui) clock(side chess.Color) time.Duration {
+	left := t.whiteClock
+	if side == chess.Black {
+		left = t.blackClock
+	}
+	if t.game != nil && t.game.Position().Turn() == side && t.game.Outcome() == chess.NoOutcome && t.result == "" {
+		left = max(left-time.Since(t.clockSince), 0)
+	}
+	return left
+}
+
+// plyOf returns the number of half-moves played before a position, from its FEN
+func plyOf(position *chess.Position) int {
+	fields := strings.Fields(position.String())
+	if len(fields) < 6 {
+		return 0
+	}
+	fullMove, _ := strconv.Atoi(fields[5])
+	ply := (fullMove - 1) * 2
+	if position.Turn() == chess.Black {
+		ply++
+	}
+	return ply
+}
+
+// parseTimeControl reads a time control written minutes+increment in seconds, e.g. 5+3
+func parseTimeControl(tc string) (initial, increment time.Duration, ok bool) {
+	minutes, seconds, found := strings.Cut(tc, "+")
+	if !found {
+		return 0, 0, false
+	}
+	m, err := strconv.ParseFloat(minutes, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	s, err := strconv.Atoi(seconds)
+	if err != nil {
+		return 0, 0, false
+	}
+	return time.Duration(m * float64(time.Minute)), time.Duration(s) * time.Second, true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/notnil/chess"
+)
+
+// keyCode tells the keys of the command bar apart
+type keyCode int
+
+const (
+	keyRune keyCode = iota
+	keyEnter
+	keyBackspace
+	keyTab
+	keyUp
+	keyDown
+	keyInterrupt // Ctrl+C
+	keyEOF       // Ctrl+D
+)
+
+// key is a key pressed, r holding the character typed for keyRune
+type key struct {
+	code keyCode
+	r    rune
+}
+
+// maxCompletions is how many candidates the status line lists
+const maxCompletions = 12
+
+// readKeys decodes the keys typed on the terminal in raw mode until it is closed
+func readKeys(r io.Reader, keys chan<- key) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		data := buf[:n]
+		for len(data) > 0 {
+			switch b := data[0]; {
+			case b == 0x1b:
+				// Escape sequence: ESC [ parameters final, only the arrows are used
+				length := 1
+				if len(data) > 1 && (data[1] == '[' || data[1] == 'O') {
+					length = 2
+					for length < len(data) && data[length] >= 0x30 && data[length] <= 0x3f {
+						length++
+					}
+					if length < len(data) {
+						switch data[length] {
+						case 'A':
+							keys <- key{code: keyUp}
+						case 'B':
+							keys <- key{code: keyDown}
+						}
+						length++
+					}
+				}
+				data = data[length:]
+			case b == '\r' || b == '\n':
+				keys <- key{code: keyEnter}
+				data = data[1:]
+			case b == 0x7f || b == 0x08:
+				keys <- key{code: keyBackspace}
+				data = data[1:]
+			case b == '\t':
+				keys <- key{code: keyTab}
+				data = data[1:]
+			case b == 0x03:
+				keys <- key{code: keyInterrupt}
+				data = data[1:]
+			case b == 0x04:
+				keys <- key{code: keyEOF}
+				data = data[1:]
+			case b < 0x20:
+				data = data[1:]
+			default:
+				r, size := utf8.DecodeRune(data)
+				keys <- key{code: keyRune, r: r}
+				data = data[size:]
+			}
+		}
+	}
+}
+
+// handleKey edits the command bar
+func (t *tui) handleKey(k key) {
+	switch k.code {
+	case keyRune:
+		t.input = append(t.input, k.r)
+		t.status = t.hint()
+	case keyBackspace:
+		if len(t.input) > 0 {
+			t.input = t.input[:len(t.input)-1]
+		}
+		t.status = t.hint()
+	case keyTab:
+		t.complete()
+	case keyUp:
+		if t.recall > 0 {
+			t.recall--
+			t.input = []rune(t.history[t.recall])
+		}
+	case keyDown:
+		if t.recall < len(t.history)-1 {
+			t.recall++
+			t.input = []rune(t.history[t.recall])
+		} else {
+			t.recall = len(t.history)
+			t.input = nil
+		}
+	case keyEnter:
+		line := string(t.input)
+		t.input = nil
+		t.submit(line)
+	case keyInterrupt:
+		t.quit = true
+	case keyEOF:
+		if len(t.input) == 0 {
+			t.quit = true
+		}
+	}
+}
+
+// hint lists the completions of what is typed in the status line
+func (t *tui) hint() string {
+	candidates := t.completions(string(t.input))
+	if len(t.input) == 0 || len(candidates) == 0 {
+		return ""
+	}
+	if len(candidates) > maxCompletions {
+		return strings.Join(candidates[:maxCompletions], " ") + " …"
+	}
+	return strings.Join(candidates, " ")
+}
+
+// complete extends what is typed with the completions: the only one, or their common prefix
+func (t *tui) complete() {
+	input := string(t.input)
+	candidates := t.completions(input)
+	switch len(candidates) {
+	case 0:
+		t.status = "No completion"
+		return
+	case 1:
+		t.input = []rune(candidates[0])
+		if strings.HasPrefix(candidates[0], "/") {
+			t.input = append(t.input, ' ')
+		}
+	default:
+		prefix := candidates[0]
+		for _, candidate := range candidates[1:] {
+			for !strings.HasPrefix(candidate, prefix) {
+				prefix = prefix[:len(prefix)-1]
+			}
+		}
+		if len(prefix) > len(input) {
+			t.input = []rune(prefix)
+		}
+	}
+	t.status = t.hint()
+}
+
+// completions returns the command names starting with a /, or the legal moves in algebraic
+// or UCI notation starting with what is typed when it is our turn
+func (t *tui) completions(input string) []string {
+	if strings.HasPrefix(input, "/") {
+		if strings.Contains(input, " ") {
+			return nil
+		}
+		var names []string
+		for _, command := range tuiCommands {
+			name, _, _ := strings.Cut(command, " ")
+			if strings.HasPrefix(name, input) {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	if t.game == nil || t.game.Outcome() != chess.NoOutcome {
+		return nil
+	}
+	// Premoves are only checked by the server, once the opponent moved
+	position := t.game.Position()
+	if t.color != "" && !strings.EqualFold(position.Turn().Name(), t.color) {
+		return nil
+	}
+	var moves []string
+	for _, move := range position.ValidMoves() {
+		san := chess.AlgebraicNotation{}.Encode(position, move)
+		switch {
+		case strings.HasPrefix(san, input):
+			moves = append(moves, san)
+		case len(input) >= 2 && strings.HasPrefix(move.String(), strings.ToLower(input)):
+			moves = append(moves, move.String())
+		}
+	}
+	slices.Sort(moves)
+	return moves
+}
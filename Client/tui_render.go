@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/notnil/chess"
+	"golang.org/x/term"
+)
+
+// Terminal control sequences
+const (
+	enterFullScreen = "\x1b[?1049h\x1b[2J"
+	leaveFullScreen = "\x1b[?1049l"
+	hideCursor      = "\x1b[?25l"
+	showCursor      = "\x1b[?25h"
+	clearLine       = "\x1b[K"
+	resetStyle      = "\x1b[0m"
+	dimStyle        = "\x1b[2m"
+	boldStyle       = "\x1b[1m"
+)
+
+// Square colors, from the 256-color palette
+const (
+	lightSquare     = 223
+	darkSquare      = 137
+	lightLastMove   = 186
+	darkLastMove    = 143
+	checkedKing     = 167
+	whitePieceColor = 231
+	blackPieceColor = 16
+)
+
+// Layout of the screen: the board with a player above and below it on the left, the move
+// list on its right, the chat pane below, then the status line and the command bar
+const (
+	boardWidth  = 3 + 8*3 // Rank label, then 8 squares of 3 columns
+	boardTop    = 1
+	boardHeight = 11 // Top player, 8 ranks, file labels, bottom player
+	movesLeft   = boardWidth + 3
+	minWidth    = 60
+	minHeight   = boardTop + boardHeight + 5
+)
+
+// pieceGlyphs draws the pieces of both sides with the solid glyphs, their color set apart
+// by the foreground
+var pieceGlyphs = map[chess.PieceType]string{
+	chess.King:   "♚",
+	chess.Queen:  "♛",
+	chess.Rook:   "♜",
+	chess.Bishop: "♝",
+	chess.Knight: "♞",
+	chess.Pawn:   "♟",
+}
+
+// draw redraws the whole screen
+func (t *tui) draw(out int) {
+	width, height, err := term.GetSize(out)
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	var frame strings.Builder
+	frame.WriteString(hideCursor)
+	if width != t.width || height != t.height {
+		frame.WriteString("\x1b[2J")
+		t.width, t.height = width, height
+	}
+
+	if width < minWidth || height < minHeight {
+		frame.WriteString("\x1b[1;1H" + fit(fmt.Sprintf("Enlarge the terminal to %dx%d", minWidth, minHeight), width) + clearLine)
+		os.Stdout.WriteString(frame.String())
+		return
+	}
+
+	lines := make([]string, height)
+	lines[0] = boldStyle + fit(t.header(), width) + resetStyle
+
+	board := t.boardLines()
+	moves := t.moveLines(boardHeight)
+	for i := range boardHeight {
+		right := ""
+		if i < len(moves) {
+			right = moves[i]
+		}
+		lines[boardTop+i] = board[i] + "   " + fit(right, width-movesLeft)
+	}
+
+	// The chat pane takes the rows left, showing its last lines
+	chatTop := boardTop + boardHeight
+	lines[chatTop] = dimStyle + fit("── Chat & messages "+strings.Repeat("─", width), width) + resetStyle
+	rows := height - 2 - (chatTop + 1)
+	logLines := t.log[max(len(t.log)-rows, 0):]
+	for i, line := range logLines {
+		lines[chatTop+1+i] = fit(line, width)
+	}
+
+	lines[height-2] = dimStyle + fit(t.status, width) + resetStyle
+	prompt := "> " + string(t.input)
+	lines[height-1] = fitTail(prompt, width-1)
+
+	for row, line := range lines {
+		fmt.Fprintf(&frame, "\x1b[%d;1H%s%s", row+1, line, clearLine)
+	}
+	fmt.Fprintf(&frame, "\x1b[%d;%dH%s", height, min(utf8.RuneCountInString(prompt), width-1)+1, showCursor)
+	os.Stdout.WriteString(frame.String())
+}
+
+// header describes the current game
+func (t *tui) header() string {
+	if t.game == nil {
+		return " No game: /create, /join LOBBY, /bot LEVEL or /queue"
+	}
+	header := fmt.Sprintf(" Game %s", t.gameID.String()[:8])
+	if t.opening != "" {
+		header += "  " + t.opening
+	}
+	switch {
+	case t.result != "":
+		header += "  Game over: " + t.result
+	case t.game.Outcome() != chess.NoOutcome:
+		header += fmt.Sprintf("  Game over: %s by %s", t.game.Outcome(), t.game.Method())
+	case t.inCheck():
+		header += "  Check!"
+	}
+	if t.queued != "" && t.result == "" && t.game.Outcome() == chess.NoOutcome {
+		header += "  Queued: " + t.queued
+	}
+	return header
+}
+
+// boardLines draws the board with the players above and below it, boardWidth columns wide
+func (t *tui) boardLines() []string {
+	bottom := t.bottom
+	if t.flipped {
+		bottom = bottom.Other()
+	}
+	lines := []string{t.playerLine(bottom.Other())}
+
+	var position *chess.Position
+	if t.game != nil {
+		position = t.game.Position()
+	} else {
+		position = chess.StartingPosition()
+	}
+	highlighted := t.lastMoveSquares()
+	checked := chess.NoSquare
+	if t.inCheck() {
+		checked = kingSquare(position, position.Turn())
+	}
+
+	for row := range 8 {
+		rank := chess.Rank(7 - row)
+		if bottom == chess.Black {
+			rank = chess.Rank(row)
+		}
+
+		var line strings.Builder
+		fmt.Fprintf(&line, " %s ", rank)
+		for col := range 8 {
+			file := chess.File(col)
+			if bottom == chess.Black {
+				file = chess.File(7 - col)
+			}
+			square := chess.NewSquare(file, rank)
+			line.WriteString(drawSquare(position.Board().Piece(square), square, highlighted[square], square == checked))
+		}
+		line.WriteString(resetStyle)
+		lines = append(lines, line.String())
+	}
+
+	files := "   "
+	for col := range 8 {
+		file := chess.File(col)
+		if bottom == chess.Black {
+			file = chess.File(7 - col)
+		}
+		files += " " + file.String() + " "
+	}
+	lines = append(lines, files, t.playerLine(bottom))
+	return lines
+}
+
+// drawSquare draws one square, 3 columns wide
+func drawSquare(piece chess.Piece, square chess.Square, lastMove, checked bool) string {
+	light := (int(square.File())+int(square.Rank()))%2 == 1
+	background := darkSquare
+	switch {
+	case checked:
+		background = checkedKing
+	case lastMove && light:
+		background = lightLastMove
+	case lastMove:
+		background = darkLastMove
+	case light:
+		background = lightSquare
+	}
+
+	glyph := " "
+	foreground := blackPieceColor
+	if piece != chess.NoPiece {
+		glyph = pieceGlyphs[piece.Type()]
+		if piece.Color() == chess.White {
+			foreground = whitePieceColor
+		}
+	}
+	return fmt.Sprintf("\x1b[48;5;%dm\x1b[38;5;%dm %s ", background, foreground, glyph)
+}
+
+// playerLine names the player of a side with their clock, boardWidth columns wide
+func (t *tui) playerLine(side chess.Color) string {
+	name := "White"
+	if side == chess.Black {
+		name = "Black"
+	}
+	me := t.client.Player()
+	switch {
+	case t.game == nil:
+	case side == t.bottom:
+		name = fmt.Sprintf("%s (%d)", me.FirstName, me.Level)
+	case t.opponent != "" && t.opponentRating > 0:
+		name = fmt.Sprintf("%s (%d)", t.opponent, t.opponentRating)
+	case t.opponent != "":
+		name = t.opponent
+	default:
+		name = "Waiting for an opponent"
+	}
+
+	marker := "  "
+	if t.game != nil && t.game.Position().Turn() == side {
+		marker = "▶ "
+	}
+	clock := ""
+	if t.clocked {
+		clock = formatClock(t.clock(side))
+	}
+	left := fit(marker+name, boardWidth-len(clock)-1)
+	return pad(left, boardWidth-len(clock)) + clock
+}
+
+// moveLines lists the moves of the replica in pairs, the last ones when they do not fit
+func (t *tui) moveLines(rows int) []string {
+	lines := []string{boldStyle + "Moves" + resetStyle}
+	if t.game == nil || len(t.game.Moves()) == 0 {
+		return lines
+	}
+
+	positions := t.game.Positions()
+	ply := plyOf(positions[0])
+	var pairs []string
+	for i, move := range t.game.Moves() {
+		san := chess.AlgebraicNotation{}.Encode(positions[i], move)
+		switch {
+		case ply%2 == 0:
+			pairs = append(pairs, fmt.Sprintf("%3d. %-8s", ply/2+1, san))
+		case len(pairs) == 0:
+			pairs = append(pairs, fmt.Sprintf("%3d. %-8s%s", ply/2+1, "...", san))
+		default:
+			pairs[len(pairs)-1] += san
+		}
+		ply++
+	}
+	return append(lines, pairs[max(len(pairs)-(rows-1), 0):]...)
+}
+
+// lastMoveSquares returns the squares of the last move of the replica
+func (t *tui) lastMoveSquares() map[chess.Square]bool {
+	if t.game == nil || len(t.game.Moves()) == 0 {
+		return nil
+	}
+	moves := t.game.Moves()
+	last := moves[len(moves)-1]
+	return map[chess.Square]bool{last.S1(): true, last.S2(): true}
+}
+
+// inCheck tells whether the last move of the replica gave check
+func (t *tui) inCheck() bool {
+	if t.game == nil || len(t.game.Moves()) == 0 {
+		return false
+	}
+	moves := t.game.Moves()
+	return moves[len(moves)-1].HasTag(chess.Check)
+}
+
+// kingSquare returns the square of a side's king
+func kingSquare(position *chess.Position, side chess.Color) chess.Square {
+	for square, piece := range position.Board().SquareMap() {
+		if piece.Type() == chess.King && piece.Color() == side {
+			return square
+		}
+	}
+	return chess.NoSquare
+}
+
+// formatClock writes the time left as m:ss, or h:mm:ss from an hour
+func formatClock(left time.Duration) string {
+	seconds := int(left.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// fit cuts s to width runes
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width])
+}
+
+// fitTail keeps the last width runes of s, for the command bar to show what is typed
+func fitTail(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[len(runes)-width:])
+}
+
+// pad fills s with spaces up to width runes
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
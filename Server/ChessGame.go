@@ -1,9 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/notnil/chess"
@@ -11,19 +16,257 @@ import (
 
 type GameSession struct {
 	ID            uuid.UUID
-	Game          *chess.Game
+	Game          *chess.Game // Nil in a game other than chess, see Board
 	CreatorName   string
+	Owner         string // Account that opened the lobby, counted against MaxLobbiesPerPlayer
 	LobbyName     string
 	JoinedPlayers []string
 	MaxPlayers    int
 	IsLocked      bool
+	WhitePlayer   string
+	BlackPlayer   string
+	TimeControl   TimeControl
+	Clock         Clock // Running for timed games only
+	Rated         bool
+	OpeningCode   string   // ECO code of the opening reached so far, e.g. "C60"
+	OpeningName   string   // ECO name of the opening, e.g. "Ruy Lopez"
+	Termination   string   // Set when the server ended the game, e.g. "Adjudication"
+	WhiteQueue    MoveTree // Premove or conditional moves of each side, see queueMoves
+	BlackQueue    MoveTree
+	ClockHistory  []Clock      // Clock before each move since the game was started or restored
+	PendingUndo   UndoProposal // Takeback waiting for the opponent's answer, see requestUndo
+	Variant       Variant
+	StartFEN      string        // Position the game started from
+	Castling      string        // Chess960 castling rights in Shredder-FEN, e.g. "HAha", see playCastling
+	Parts         []GamePart    // Game before each move the chess package could not play, see splitGame
+	Kind          string        // Game of gameRegistry played instead of chess, "" for chess
+	Board         TurnGame      // State of a game other than chess, nil for chess
+	Result        chess.Outcome // Set with Termination when the server ended a game other than chess
+	StartedAt     time.Time     // When the lobby was opened or the players matched, zero if unknown
+}
+
+// TurnGame returns the game played in the session: its game of gameRegistry, or its chess
+// game played by the rules of its variant
+func (s *GameSession) TurnGame() TurnGame {
+	if s.Board != nil {
+		return s.Board
+	}
+	return chessGame{s}
+}
+
+// Turn returns the seat to move, chess.White for the player who moves first
+func (s *GameSession) Turn() chess.Color {
+	return s.TurnGame().Turn()
+}
+
+// Outcome returns the result of the game: the server's decision when it ended a game other
+// than chess, otherwise the result by the rules of the game
+func (s *GameSession) Outcome() chess.Outcome {
+	if s.Result != "" {
+		return s.Result
+	}
+	outcome, _ := s.TurnGame().Outcome()
+	return outcome
+}
+
+// TerminationMethod tells how the game ended: the server's decision when there was one,
+// otherwise the method of the rules of the game such as "Checkmate"
+func (s *GameSession) TerminationMethod() string {
+	if s.Termination != "" {
+		return s.Termination
+	}
+	_, method := s.TurnGame().Outcome()
+	return method
+}
+
+// PlayerColor returns the color the given player holds in the session, or chess.NoColor
+func (s *GameSession) PlayerColor(playerName string) chess.Color {
+	if playerName == "" {
+		return chess.NoColor
+	}
+	switch playerName {
+	case s.WhitePlayer:
+		return chess.White
+	case s.BlackPlayer:
+		return chess.Black
+	}
+	return chess.NoColor
+}
+
+// LastMoveSAN returns the last move played in algebraic notation, or "" before the first move.
+// In a game other than chess it is the last action.
+func (s *GameSession) LastMoveSAN() string {
+	return s.TurnGame().LastAction()
+}
+
+// FEN returns the current position as the rules of its variant write it, or the state of a
+// game other than chess
+func (s *GameSession) FEN() string {
+	return s.TurnGame().State()
+}
+
+// Plies returns the number of half-moves, or actions, played
+func (s *GameSession) Plies() int {
+	if s.Board != nil {
+		return len(s.Board.Actions())
+	}
+	plies := len(s.Game.Moves())
+	for _, part := range s.Parts {
+		plies += len(part.Game.Moves()) + 1
+	}
+	return plies
+}
+
+// PlayedMove is a move of the game history
+type PlayedMove struct {
+	Before *chess.Position // Nil in a game other than chess
+	Mover  chess.Color
+	SAN    string
+	UCI    string // Chess960 castling is written as the king taking its own rook
+	FEN    string // Position after the move, written as FEN writes it
+}
+
+// History lists the moves played since the start of the game, those the chess package could
+// not play included. The moves of a game other than chess are its actions, SAN and UCI alike.
+func (s *GameSession) History() []PlayedMove {
+	if s.Board != nil {
+		history, _ := replayActions(s.Kind, s.Board.Actions())
+		return history
+	}
+	var history []PlayedMove
+	castling := ""
+	if s.Variant == Chess960 {
+		castling = strings.Fields(s.StartFEN)[2]
+	}
+	var whiteChecks, blackChecks int
+	fen := func(position *chess.Position) string {
+		switch s.Variant {
+		case Chess960:
+			return withCastling(position.String(), castling)
+		case ThreeCheck:
+			return position.String() + checkCount(whiteChecks, blackChecks)
+		}
+		return position.String()
+	}
+	parts := append(slices.Clip(s.Parts), GamePart{Game: s.Game})
+	for i, part := range parts {
+		moves, positions := part.Game.Moves(), part.Game.Positions()
+		for j, move := range moves {
+			castling = updateCastling(castling, positions[j], move)
+			switch {
+			case !move.HasTag(chess.Check):
+			case positions[j].Turn() == chess.White:
+				whiteChecks++
+			default:
+				blackChecks++
+			}
+			history = append(history, PlayedMove{
+				Before: positions[j],
+				Mover:  positions[j].Turn(),
+				SAN:    chess.AlgebraicNotation{}.Encode(positions[j], move),
+				UCI:    move.String(),
+				FEN:    fen(positions[j+1]),
+			})
+		}
+		if part.UCI == "" {
+			continue
+		}
+		castling = dropRights(castling, part.Game.Position().Turn())
+		history = append(history, PlayedMove{
+			Before: part.Game.Position(),
+			Mover:  part.Game.Position().Turn(),
+			SAN:    part.SAN,
+			UCI:    part.UCI,
+			FEN:    fen(parts[i+1].Game.Positions()[0]),
+		})
+	}
+	return history
+}
+
+// TimeControl describes the base time and per-move increment of a game
+type TimeControl struct {
+	Initial   time.Duration
+	Increment time.Duration
+}
+
+// String formats the time control as "minutes+seconds", e.g. "5+3"
+func (tc TimeControl) String() string {
+	minutes := strconv.FormatFloat(tc.Initial.Minutes(), 'f', -1, 64)
+	return fmt.Sprintf("%s+%d", minutes, int(tc.Increment.Seconds()))
+}
+
+// Clock holds the time left to each side of a timed game. It is reported to players and API
+// clients only: a side running out of time does not lose the game.
+type Clock struct {
+	White     time.Duration // Time left, for the side to move as of TurnStart
+	Black     time.Duration
+	TurnStart time.Time // When the side to move started thinking
+}
+
+// startClock returns the clock of a game starting now, the zero Clock for untimed games
+func startClock(tc TimeControl, now time.Time) Clock {
+	if tc.Initial <= 0 {
+		return Clock{}
+	}
+	return Clock{White: tc.Initial, Black: tc.Initial, TurnStart: now}
+}
+
+// Punch stops the clock of the side that just moved, adding the increment, and starts the other one
+func (c *Clock) Punch(mover chess.Color, increment time.Duration, now time.Time) {
+	if c.TurnStart.IsZero() {
+		return
+	}
+	c.White, c.Black = c.Remaining(mover, now)
+	if mover == chess.White {
+		c.White += increment
+	} else {
+		c.Black += increment
+	}
+	c.TurnStart = now
+}
+
+// Remaining returns the time left to each side at now, turn being the side whose clock runs
+func (c Clock) Remaining(turn chess.Color, now time.Time) (white time.Duration, black time.Duration) {
+	white, black = c.White, c.Black
+	if c.TurnStart.IsZero() {
+		return white, black
+	}
+	if turn == chess.White {
+		white = max(white-now.Sub(c.TurnStart), 0)
+	} else {
+		black = max(black-now.Sub(c.TurnStart), 0)
+	}
+	return white, black
+}
+
+// ParseTimeControl parses a "minutes+seconds" time control such as "3+2" or "0.5+0"
+func ParseTimeControl(s string) (TimeControl, error) {
+	parts := strings.Split(strings.TrimSpace(s), "+")
+	if len(parts) != 2 {
+		return TimeControl{}, fmt.Errorf("invalid time control %q, expected minutes+increment", s)
+	}
+
+	minutes, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || minutes <= 0 {
+		return TimeControl{}, fmt.Errorf("invalid initial time in %q", s)
+	}
+
+	increment, err := strconv.Atoi(parts[1])
+	if err != nil || increment < 0 {
+		return TimeControl{}, fmt.Errorf("invalid increment in %q", s)
+	}
+
+	return TimeControl{
+		Initial:   time.Duration(minutes * float64(time.Minute)),
+		Increment: time.Duration(increment) * time.Second,
+	}, nil
 }
 
 func (s *GameSession) GetBoardState() string {
-	if s.Game == nil {
+	if s.Game == nil && s.Board == nil {
 		return ""
 	}
-	return s.Game.Position().String() // Returns FEN by default
+	return s.FEN()
 }
 
 // GameStore is a map to store game sessions by their UUID
@@ -31,61 +274,177 @@ var GameStore = make(map[uuid.UUID]GameSession)
 var LobbyNameToUUID = make(map[string]uuid.UUID)
 var gameMutex = &sync.RWMutex{}
 
-// createNewGame creates a new chess game session and adds it to the GameStore
-func createNewGame(creatorName string, lobbyName string) uuid.UUID {
+// startSession sets the game of a session at its start: a new game of its Kind, or its
+// chess game at the position of its StartFEN
+func startSession(session *GameSession) error {
+	if session.Kind == "" {
+		return session.Variant.Rules().Start(session)
+	}
+	board, err := gameRegistry.New(session.Kind)
+	if err != nil {
+		return err
+	}
+	session.Board = board
+	return nil
+}
+
+// createNewGame creates a new game session, opened by the owner account, and adds it to the
+// GameStore. The game is the one of the setup, see ParseGameSetup.
+func createNewGame(owner string, creatorName string, lobbyName string, setup GameSetup) (uuid.UUID, error) {
+	session := GameSession{
+		CreatorName:   creatorName,
+		Owner:         owner,
+		LobbyName:     lobbyName,
+		JoinedPlayers: []string{creatorName},
+		MaxPlayers:    2,
+		IsLocked:      false,
+		Variant:       setup.Variant,
+		StartFEN:      setup.StartFEN,
+		Kind:          setup.Kind,
+		StartedAt:     time.Now(),
+	}
+	if err := startSession(&session); err != nil {
+		return uuid.Nil, err
+	}
+
 	gameMutex.Lock()
 	defer gameMutex.Unlock()
 
 	if _, exists := LobbyNameToUUID[lobbyName]; exists {
-		log.Printf("Lobby name %s already exists", lobbyName)
-		return uuid.Nil
+		slog.Info("Lobby name already exists", "lobby", lobbyName)
+		return uuid.Nil, fmt.Errorf("lobby %s already exists", lobbyName)
+	}
+
+	if config.MaxLobbies > 0 && openLobbyCount() >= config.MaxLobbies {
+		slog.Warn("Cannot create lobby: the limit of open lobbies is reached", "lobby", lobbyName, "max_lobbies", config.MaxLobbies)
+		return uuid.Nil, errors.New("the server has too many open lobbies, try again later")
+	}
+
+	if config.MaxLobbiesPerPlayer > 0 && ownedLobbyCount(owner) >= config.MaxLobbiesPerPlayer {
+		slog.Warn("Cannot create lobby: the player has too many open lobbies", "lobby", lobbyName, "owner", owner, "max_lobbies_per_player", config.MaxLobbiesPerPlayer)
+		return uuid.Nil, fmt.Errorf("you reached the limit of %d open lobbies", config.MaxLobbiesPerPlayer)
 	}
 
 	gameID := uuid.New()
-	game := chess.NewGame()
+	session.ID = gameID
+
+	GameStore[gameID] = session
+	LobbyNameToUUID[lobbyName] = gameID
+	return gameID, nil
+}
+
+// matchLobbyName names the lobby of a game created by createMatchedGame
+func matchLobbyName(whitePlayer string, blackPlayer string, gameID uuid.UUID) string {
+	return fmt.Sprintf("Match-%s-%s-%s", whitePlayer, blackPlayer, gameID.String()[:8])
+}
+
+// renameSeat gives the player of a seat in a game created by createMatchedGame another
+// name, for a player whose name depends on the game ID
+func renameSeat(gameID uuid.UUID, seat chess.Color, name string) error {
+	gameMutex.Lock()
+	defer gameMutex.Unlock()
+
+	session, exists := GameStore[gameID]
+	if !exists {
+		return fmt.Errorf("game %v not found", gameID)
+	}
+	player, index := &session.WhitePlayer, 0
+	if seat == chess.Black {
+		player, index = &session.BlackPlayer, 1
+	}
+	if session.CreatorName == *player {
+		session.CreatorName = name
+	}
+	*player = name
+	session.JoinedPlayers = slices.Clone(session.JoinedPlayers)
+	session.JoinedPlayers[index] = name
+
+	delete(LobbyNameToUUID, session.LobbyName)
+	session.LobbyName = matchLobbyName(session.WhitePlayer, session.BlackPlayer, gameID)
+	LobbyNameToUUID[session.LobbyName] = gameID
+	GameStore[gameID] = session
+	return nil
+}
+
+// createMatchedGame creates a locked game session for two players paired by the matchmaker,
+// of standard chess or of the game of gameRegistry named kind
+func createMatchedGame(whitePlayer string, blackPlayer string, timeControl TimeControl, rated bool, kind string) (uuid.UUID, error) {
+	gameMutex.Lock()
+	defer gameMutex.Unlock()
+
+	gameID := uuid.New()
+	lobbyName := matchLobbyName(whitePlayer, blackPlayer, gameID)
 
 	session := GameSession{
 		ID:            gameID,
-		Game:          game,
-		CreatorName:   creatorName,
+		CreatorName:   whitePlayer,
 		LobbyName:     lobbyName,
-		JoinedPlayers: []string{creatorName},
+		JoinedPlayers: []string{whitePlayer, blackPlayer},
 		MaxPlayers:    2,
-		IsLocked:      false,
+		IsLocked:      true,
+		WhitePlayer:   whitePlayer,
+		BlackPlayer:   blackPlayer,
+		TimeControl:   timeControl,
+		Clock:         startClock(timeControl, time.Now()),
+		Rated:         rated,
+		Kind:          kind,
+		StartedAt:     time.Now(),
+	}
+	if kind == "" {
+		session.Variant, session.StartFEN = Standard, chess.StartingPosition().String()
+	}
+	if err := startSession(&session); err != nil {
+		return uuid.Nil, err
 	}
 
 	GameStore[gameID] = session
 	LobbyNameToUUID[lobbyName] = gameID
-	return gameID
+	return gameID, nil
 }
 
-func Move(game *chess.Game, moveStr string) error {
-	// Print debug information before applying the move
-	fmt.Printf("Attempting to apply move: %s\n", moveStr)
+// Move applies a move, or an action of a game other than chess, to the session's game
+func Move(session *GameSession, moveStr string) error {
+	logger := gameLogger(session.ID).With("move", moveStr)
 
 	// Attempt to apply the move
-	err := game.MoveStr(moveStr)
+	err := playMove(session, moveStr)
 	if err != nil {
-		log.Printf("Failed to apply move: %v", err)
+		logger.Debug("Failed to apply move", "err", err)
 		return fmt.Errorf("failed to apply move: %v", err)
 	}
+	session.ClockHistory = append(session.ClockHistory, session.Clock)
+	session.PendingUndo = UndoProposal{} // A move answers a takeback request with a no
 
-	// Print debug information about the game state after the move
-	fmt.Println("Board state after the move:")
-	board := game.Position().Board().Draw()
-	fmt.Println(board) // Ensure board string is correctly printed
-
-	// Print debug information about the outcome
-	if game.Outcome() != chess.NoOutcome {
-		fmt.Printf("Game completed. Outcome: %s, Method: %s\n", game.Outcome(), game.Method())
+	if outcome := session.Outcome(); outcome != chess.NoOutcome {
+		logger.Info("Game completed", "outcome", outcome.String(), "method", session.TerminationMethod())
 	} else {
-		fmt.Printf("Move completed: %s\n", moveStr)
+		logger.Debug("Move applied", "fen", session.FEN())
 	}
 
-	// Optionally, print the full game state for debugging purposes
-	fmt.Println("Current full game state:")
-	fmt.Println(game)
+	return nil
+}
 
+// playMove plays a move written in algebraic or UCI notation by the rules of the session's
+// variant, or an action of its game other than chess, refusing it once the game is over
+func playMove(session *GameSession, moveStr string) error {
+	if session.Outcome() != chess.NoOutcome {
+		return errors.New("the game is over")
+	}
+	return session.TurnGame().Play(moveStr)
+}
+
+// replayMoves plays moves in UCI notation, or actions, from the start of a session's game,
+// which the session's Kind, Variant and StartFEN describe, setting its game and opening
+func replayMoves(session *GameSession, moves []string) error {
+	if err := startSession(session); err != nil {
+		return err
+	}
+	session.OpeningCode, session.OpeningName = "", ""
+	for _, move := range moves {
+		if err := playMove(session, move); err != nil {
+			return fmt.Errorf("error replaying %s: %w", move, err)
+		}
+	}
 	return nil
 }
 
@@ -124,10 +483,11 @@ func joinGameAndStartPlay(lobbyName string, playerName string) (uuid.UUID, error
 	// Add the player to the lobby
 	session.JoinedPlayers = append(session.JoinedPlayers, playerName)
 
-	// If max players reached, lock the game and notify both players to start playing
+	// If max players reached, seat the players and lock the game so they can start playing
 	if len(session.JoinedPlayers) >= session.MaxPlayers {
+		seatPlayers(&session)
 		session.IsLocked = true
-		log.Printf("Lobby %s is now locked. Both players can start playing.", lobbyName)
+		slog.Info("Lobby is now locked, both players can start playing", "lobby", lobbyName, "game", gameID.String())
 	}
 
 	// Update the game store
@@ -137,26 +497,133 @@ func joinGameAndStartPlay(lobbyName string, playerName string) (uuid.UUID, error
 	return gameID, nil
 }
 
-// MoveInLobby makes a move in the game corresponding to the given gameID
-func MoveInLobby(gameID uuid.UUID, moveStr string, playerName string) error {
+// MoveInLobby makes a move in the game corresponding to the given gameID and returns the
+// board state after it, before a queued move of the opponent answers it
+func MoveInLobby(gameID uuid.UUID, moveStr string, playerName string) (string, error) {
 	gameMutex.Lock()
-	defer gameMutex.Unlock()
 
 	// Retrieve the game session using the provided gameID
 	session, ok := GameStore[gameID]
 	if !ok {
-		return fmt.Errorf("game session not found for gameID %v", gameID)
+		gameMutex.Unlock()
+		return "", fmt.Errorf("game session not found for gameID %v", gameID)
+	}
+
+	// Only the player whose turn it is may move, the seats being given when the game starts
+	color := session.PlayerColor(playerName)
+	if color == chess.NoColor {
+		gameMutex.Unlock()
+		return "", fmt.Errorf("player %s is not seated in game %v", playerName, gameID)
+	}
+	if color != session.Turn() {
+		gameMutex.Unlock()
+		return "", fmt.Errorf("it is not %s's turn to move", playerName)
 	}
 
 	// Make the move
-	err := Move(session.Game, moveStr)
+	mover := session.Turn()
+	err := Move(&session, moveStr)
 	if err != nil {
-		return fmt.Errorf("failed to make move: %v", err)
+		gameMutex.Unlock()
+		return "", fmt.Errorf("failed to make move: %v", err)
+	}
+	now := time.Now()
+	session.Clock.Punch(mover, session.TimeControl.Increment, now)
+	serverMetrics.moves.Inc()
+	state := session.GetBoardState()
+	update, err := encodeBoardUpdate(session, playerName)
+	gameEvents.Publish(gameID, moveEvents(session, playerName, now)...)
+
+	// The opponent's premove or conditional move answers under the same lock, so no other
+	// move can come in between
+	replier, reply := playQueuedMove(&session, now)
+
+	// Update the session after the moves, archived under the lock as its game is shared
+	GameStore[gameID] = session
+	if session.Outcome() != chess.NoOutcome {
+		gameArchive.Add(session)
 	}
+	gameMutex.Unlock()
 
-	// Update the session after the move
+	// Let the other players know, outside of the lock since bots react to it. The replier
+	// did not send its queued move, so it is told too.
+	if err != nil {
+		gameLogger(gameID).Error("Error encoding BoardUpdate", "err", err)
+	} else {
+		notifyGameUpdate(session.JoinedPlayers, playerName, update)
+	}
+	if reply != nil {
+		gameLogger(gameID).Debug("Move answered by a queued move", "player", replier)
+		notifyGameUpdate(session.JoinedPlayers, "", reply)
+	}
+	return state, nil
+}
+
+// endGame ends a game in progress with the given outcome and records how it ended, then
+// archives it and pushes the final board to its players
+func endGame(gameID uuid.UUID, outcome chess.Outcome, termination string) (GameSession, error) {
+	gameMutex.Lock()
+	session, ok := GameStore[gameID]
+	if !ok {
+		gameMutex.Unlock()
+		return GameSession{}, fmt.Errorf("game session not found for gameID %v", gameID)
+	}
+	if session.Outcome() != chess.NoOutcome {
+		gameMutex.Unlock()
+		return GameSession{}, fmt.Errorf("game %v is already over", gameID)
+	}
+
+	switch {
+	case outcome != chess.WhiteWon && outcome != chess.BlackWon && outcome != chess.Draw:
+		gameMutex.Unlock()
+		return GameSession{}, fmt.Errorf("invalid outcome %q", outcome)
+	case session.Board != nil:
+		// Other games know no resignation, the server's result stands beside them
+		session.Result = outcome
+	case outcome == chess.WhiteWon:
+		session.Game.Resign(chess.Black)
+	case outcome == chess.BlackWon:
+		session.Game.Resign(chess.White)
+	default:
+		session.Game.Draw(chess.DrawOffer)
+	}
+	session.Termination = termination
+	session.IsLocked = true // An ended lobby can no longer be joined
+	session.WhiteQueue, session.BlackQueue = nil, nil
+	session.PendingUndo = UndoProposal{}
 	GameStore[gameID] = session
-	return nil
+	update, err := encodeBoardUpdate(session, "")
+	gameEvents.Publish(gameID, resultEventOf(session))
+	gameArchive.Add(session)
+	gameMutex.Unlock()
+
+	gameLogger(gameID).Info("Game ended by the server", "outcome", outcome.String(), "termination", termination)
+	if err != nil {
+		gameLogger(gameID).Error("Error encoding BoardUpdate", "err", err)
+		return session, nil
+	}
+	notifyGameUpdate(session.JoinedPlayers, "", update)
+	return session, nil
+}
+
+// closeLobby removes a lobby still waiting for players and returns the players who had joined it
+func closeLobby(lobbyName string) ([]string, error) {
+	gameMutex.Lock()
+	defer gameMutex.Unlock()
+
+	gameID, exists := LobbyNameToUUID[lobbyName]
+	if !exists {
+		return nil, fmt.Errorf("lobby %s does not exist", lobbyName)
+	}
+	session := GameStore[gameID]
+	if session.IsLocked {
+		return nil, fmt.Errorf("lobby %s already has its game in progress", lobbyName)
+	}
+
+	delete(GameStore, gameID)
+	delete(LobbyNameToUUID, lobbyName)
+	gameEvents.Close(gameID)
+	return session.JoinedPlayers, nil
 }
 
 // joinGame allows a player to join an existing game lobby
@@ -195,8 +662,9 @@ func joinGame(lobbyName string, playerName string) (uuid.UUID, error) {
 	// Add the player to the lobby
 	session.JoinedPlayers = append(session.JoinedPlayers, playerName)
 
-	// If max players reached, lock the game
+	// If max players reached, seat the players and lock the game
 	if len(session.JoinedPlayers) >= session.MaxPlayers {
+		seatPlayers(&session)
 		session.IsLocked = true
 	}
 
@@ -205,6 +673,48 @@ func joinGame(lobbyName string, playerName string) (uuid.UUID, error) {
 	return gameID, nil
 }
 
+// seatPlayers gives the seats of a lobby once full: the creator plays white, or moves first
+// in a game other than chess, and the player who joined black
+func seatPlayers(session *GameSession) {
+	session.WhitePlayer, session.BlackPlayer = session.JoinedPlayers[0], session.JoinedPlayers[1]
+}
+
+// openLobbyCount counts the lobbies still waiting for players (the caller must hold gameMutex)
+func openLobbyCount() int {
+	count := 0
+	for _, session := range GameStore {
+		if !session.IsLocked {
+			count++
+		}
+	}
+	return count
+}
+
+// ownedLobbyCount counts the open lobbies an account opened (the caller must hold gameMutex)
+func ownedLobbyCount(owner string) int {
+	count := 0
+	for _, session := range GameStore {
+		if !session.IsLocked && session.Owner == owner {
+			count++
+		}
+	}
+	return count
+}
+
+// activeGameCount counts the games being played: locked and without an outcome yet
+func activeGameCount() int {
+	gameMutex.RLock()
+	defer gameMutex.RUnlock()
+
+	count := 0
+	for _, session := range GameStore {
+		if session.IsLocked && session.Outcome() == chess.NoOutcome {
+			count++
+		}
+	}
+	return count
+}
+
 // listAvailableLobbies returns a list of available lobbies
 func listAvailableLobbies() []string {
 	gameMutex.RLock()
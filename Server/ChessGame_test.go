@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// openJoinedLobby creates a chess lobby for creator and has joiner join it
+func openJoinedLobby(t *testing.T, creator, joiner string) uuid.UUID {
+	t.Helper()
+	return openVariantLobby(t, creator, joiner, "standard", "")
+}
+
+// openVariantLobby creates a lobby of a variant for creator and has joiner join it
+func openVariantLobby(t *testing.T, creator, joiner, variant, position string) uuid.UUID {
+	t.Helper()
+	lobbyName := "Lobby-" + creator + "-" + uuid.NewString()[:8]
+	setup, err := ParseGameSetup(variant, position)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameID, err := createNewGame(creator, creator, lobbyName, setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		gameMutex.Lock()
+		delete(GameStore, gameID)
+		delete(LobbyNameToUUID, lobbyName)
+		gameMutex.Unlock()
+		gameEvents.Close(gameID)
+	})
+	if _, err := joinGame(lobbyName, joiner); err != nil {
+		t.Fatal(err)
+	}
+	return gameID
+}
+
+func TestJoinedLobbySeatsPlayers(t *testing.T) {
+	gameID := openJoinedLobby(t, "SeatAnn", "SeatBob")
+
+	gameMutex.RLock()
+	session := GameStore[gameID]
+	gameMutex.RUnlock()
+	if !session.IsLocked {
+		t.Fatal("the full lobby did not start")
+	}
+	if session.WhitePlayer != "SeatAnn" || session.BlackPlayer != "SeatBob" {
+		t.Errorf("white %q and black %q, want the creator white and the joiner black", session.WhitePlayer, session.BlackPlayer)
+	}
+	if session.PlayerColor("SeatAnn") != chess.White || session.PlayerColor("SeatBob") != chess.Black {
+		t.Error("the players were not given their colors")
+	}
+}
+
+func TestMoveInLobbyRefusesMovesOutOfTurn(t *testing.T) {
+	gameID := openJoinedLobby(t, "TurnAnn", "TurnBob")
+
+	if _, err := MoveInLobby(gameID, "e7e5", "TurnBob"); err == nil {
+		t.Error("black moved before white")
+	}
+	if _, err := MoveInLobby(gameID, "e2e4", "TurnEve"); err == nil {
+		t.Error("a player not seated in the game moved")
+	}
+	if _, err := MoveInLobby(gameID, "e2e4", "TurnAnn"); err != nil {
+		t.Fatalf("white could not open: %v", err)
+	}
+	if _, err := MoveInLobby(gameID, "d2d4", "TurnAnn"); err == nil {
+		t.Error("white moved twice in a row")
+	}
+	if _, err := MoveInLobby(gameID, "e7e5", "TurnBob"); err != nil {
+		t.Errorf("black could not answer: %v", err)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
-	"github.com/google/uuid"
+	"log/slog"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
 type Client struct {
@@ -41,6 +43,14 @@ func (cl *ClientList) AddClient(address string, client Client) {
 	cl.clients[address] = client
 }
 
+// RemoveClient removes the client stored at the given address
+func (cl *ClientList) RemoveClient(address string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	delete(cl.clients, address)
+}
+
 // GetClient retrieves a client by their address
 func (cl *ClientList) GetClient(address string) (Client, bool) {
 	cl.mu.Lock()
@@ -121,13 +131,12 @@ func (cl *ClientList) GetAllClients() []Client {
 	return allClients
 }
 
-// PrintAllClients prints out all the stored clients
-func (cl *ClientList) PrintAllClients() {
+// LogAllClients writes every stored client to the debug log, signatures redacted
+func (cl *ClientList) LogAllClients() {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
 
-	fmt.Println("All Clients:")
 	for _, client := range cl.clients {
-		fmt.Printf("Address: %s, Signature: %s, GameID: %s\n", client.Address, client.Signature, client.GameID)
+		slog.Debug("Client", "remote", client.Address, "signature", client.Signature, "game", client.GameID.String())
 	}
 }
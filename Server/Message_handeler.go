@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
+	"github.com/notnil/chess"
+	"log/slog"
+	"math/rand"
 	"net"
 	"strconv"
+	"time"
 )
 
 func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
-	log.Println("Entered HandleHelloRequest")
+	logger := requestLogger(conn, clientAddr, isTCP, HelloRequest)
+	logger.Debug("Handling request")
 
 	var currentIndex int
 	var combinedTLV []byte
@@ -17,15 +23,15 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 	// Decode the HelloRequest TLV (Tag=0)
 	tag, value, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding HelloRequest TLV: %v", err)
+		logger.Warn("Error decoding HelloRequest TLV", "err", err)
 		return fmt.Errorf("error decoding HelloRequest: %w", err)
 	}
 	currentIndex += len(value) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(value))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "value", string(value))
 
 	// Check if it's the HelloRequest (Tag=0)
 	if tag != HelloRequest {
-		log.Printf("Unexpected tag received: %d", tag)
+		logger.Warn("Unexpected tag received", "tag", GetTagName(tag))
 		return fmt.Errorf("expected HelloRequest, but got tag %d", tag)
 	}
 
@@ -41,11 +47,11 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 	for i, field := range tags {
 		tag, value, err = DecodeTLV(data[currentIndex:])
 		if err != nil {
-			log.Printf("Error decoding %s TLV: %v", field, err)
+			logger.Warn("Error decoding client field", "field", field, "err", err)
 			return fmt.Errorf("error decoding %s: %w", field, err)
 		}
 		currentIndex += len(value) + 3
-		log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(value))
+		logger.Debug("Decoded TLV", "tag", GetTagName(tag), "value", string(value))
 		combinedTLV = append(combinedTLV, data[currentIndex-len(value)-3:currentIndex]...)
 
 		if i < len(values) {
@@ -53,7 +59,7 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 		} else {
 			level, err = strconv.Atoi(string(value))
 			if err != nil {
-				log.Printf("Error converting Level to integer: %v", err)
+				logger.Warn("Error converting Level to integer", "err", err)
 				return fmt.Errorf("error converting Level: %w", err)
 			}
 		}
@@ -62,7 +68,7 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 	// Decode the Signature TLV (Tag for signature data)
 	tag, value, err = DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding Signature TLV: %v", err)
+		logger.Warn("Error decoding Signature TLV", "err", err)
 		return fmt.Errorf("error decoding Signature: %w", err)
 	}
 	currentIndex += len(value) + 3
@@ -70,7 +76,7 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 
 	tag, value, err = DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding Hash TLV: %v", err)
+		logger.Warn("Error decoding Hash TLV", "err", err)
 		return fmt.Errorf("error decoding Hash: %w", err)
 	}
 	currentIndex += len(value) + 3
@@ -78,26 +84,22 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 
 	// Compute the signature from the received message (excluding the signature and hash TLVs)
 	computedSignature := GenerateSignature(combinedTLV)
-	log.Printf("Computed Signature: %s", computedSignature)
-	log.Printf("Received Signature: %s", receivedHash)
 
 	// Verify that the computed signature matches the received signature
 	if computedSignature != receivedHash {
-		log.Printf("Signature mismatch: Computed=%s, Received=%s", computedSignature, receivedSignature)
-		return fmt.Errorf("signature mismatch")
+		logger.Warn("Signature mismatch", "computed_signature", computedSignature, "received_signature", receivedSignature)
+		return ErrSignatureMismatch
 	}
 
 	// Verify that the computed hash matches the received hash
 	computedHash := GenerateSignature(combinedTLV) // You can reuse the signature function to compute the message hash
-	log.Printf("Computed Hash: %s", computedHash)
-	log.Printf("Received Hash: %s", receivedHash)
 
 	if computedHash != receivedHash {
-		log.Printf("Hash mismatch: Computed=%s, Received=%s", computedHash, receivedHash)
-		return fmt.Errorf("hash mismatch")
+		logger.Warn("Hash mismatch", "computed_hash", computedHash, "received_hash", receivedHash)
+		return ErrHashMismatch
 	}
 
-	log.Println("Signature and Hash verified successfully.")
+	logger.Debug("Signature and hash verified")
 
 	// Save client information
 	clientKey := ""
@@ -118,7 +120,13 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 
 	// Add client to the client list (store the client)
 	clientList.AddClient(clientKey, client)
-	log.Printf("Client saved successfully: Key=%s, Client=%+v", clientKey, client)
+	heartbeat.PlayerReturned(client.FirstName)
+	logger.Info("Client registered", "player", client.FirstName, "level", client.Level, "status", client.Status)
+
+	// Remember how to reach the client for pushed notifications
+	if peer := newPeer(conn, udpConn, clientAddr, isTCP); peer != nil {
+		peers.Register(clientKey, peer)
+	}
 
 	// Send a response back to the client
 	if isTCP {
@@ -130,13 +138,14 @@ func HandleHelloRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 }
 
 func HandleGameRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
-	log.Println("Entered HandleGameRequest")
+	logger := requestLogger(conn, clientAddr, isTCP, GameRequest)
+	logger.Debug("Handling request")
 
 	var currentIndex int
 	// Decode the first TLV: GameRequest (RequestType)
 	tag, requestType, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding GameRequest TLV: %v", err)
+		logger.Warn("Error decoding GameRequest TLV", "err", err)
 		return fmt.Errorf("error decoding GameRequest: %w", err)
 	}
 	currentIndex += len(requestType) + 3
@@ -144,62 +153,73 @@ func HandleGameRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPA
 	// Decode the second TLV: Player Name
 	tag, playerName, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding player name TLV: %v", err)
+		logger.Warn("Error decoding player name TLV", "err", err)
 		return fmt.Errorf("error decoding player name: %w", err)
 	}
 	currentIndex += len(playerName) + 3
-	log.Printf("Decoded TLV: Tag=%d, Player Name=%s", tag, string(playerName))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "player", string(playerName))
 
 	// Check if the tag matches the expected tag for Player Name
 	if tag != ByteData {
-		log.Printf("Unexpected tag for player name: %d", tag)
+		logger.Warn("Unexpected tag for player name", "tag", GetTagName(tag))
 		return fmt.Errorf("expected ByteData tag for player name, but got tag %d", tag)
 	}
 
+	// Optional String TLVs: the variant or another game of gameRegistry, then the Chess960
+	// position number or the FEN to start from. Older clients send none and get standard chess.
+	var options []string
+	for len(data[currentIndex:]) >= 3 && Tag(data[currentIndex]) == String {
+		_, option, err := DecodeTLV(data[currentIndex:])
+		if err != nil {
+			logger.Warn("Error decoding game option TLV", "err", err)
+			return fmt.Errorf("error decoding game option: %w", err)
+		}
+		currentIndex += len(option) + 3
+		options = append(options, string(option))
+	}
+
 	// Decode the third TLV: Signature
 	tag, signature, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding Signature TLV: %v", err)
+		logger.Warn("Error decoding Signature TLV", "err", err)
 		return fmt.Errorf("error decoding Signature: %w", err)
 	}
 	currentIndex += len(signature) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(signature))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "signature", string(signature))
 
 	if tag != ByteData {
-		log.Printf("Unexpected tag for signature: %d", tag)
+		logger.Warn("Unexpected tag for signature", "tag", GetTagName(tag))
 		return fmt.Errorf("expected ByteData tag for signature, but got tag %d", tag)
 	}
 
 	// Decode the fourth TLV: Hash
 	// Check if there is enough data left to decode the hash
 	if len(data[currentIndex:]) < 3 {
-		log.Println("Not enough data left to decode Hash TLV")
+		logger.Warn("Not enough data left to decode Hash TLV")
 		return fmt.Errorf("not enough data to decode Hash TLV")
 	}
 
 	tag, providedHash, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding Hash TLV: %v", err)
+		logger.Warn("Error decoding Hash TLV", "err", err)
 		return fmt.Errorf("error decoding Hash: %w", err)
 	}
 	currentIndex += len(providedHash) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(providedHash))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "hash", string(providedHash))
 
 	if tag != ByteData {
-		log.Printf("Unexpected tag for hash: %d", tag)
+		logger.Warn("Unexpected tag for hash", "tag", GetTagName(tag))
 		return fmt.Errorf("expected ByteData tag for hash, but got tag %d", tag)
 	}
 
 	// Verify the integrity of the message by checking the hash
 	combinedData := data[:currentIndex-len(providedHash)-3]
 	calculatedHash := GenerateSignature(combinedData)
-	log.Printf("Calculated hash: %s", calculatedHash)
-	log.Printf("Provided hash: %s", string(providedHash))
 
 	// Compare the calculated hash with the provided hash
 	if string(providedHash) != calculatedHash {
-		log.Println("Hash mismatch: Provided hash does not match calculated hash")
-		return fmt.Errorf("hash mismatch")
+		logger.Warn("Hash mismatch", "calculated_hash", calculatedHash, "provided_hash", string(providedHash))
+		return ErrHashMismatch
 	}
 
 	// Determine the client address
@@ -209,139 +229,142 @@ func HandleGameRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPA
 	} else if clientAddr != nil {
 		clientAddress = clientAddr.String()
 	} else {
-		log.Println("Error: Unable to determine client address")
+		logger.Error("Unable to determine client address")
 		return fmt.Errorf("client address is missing")
 	}
 
 	// Fetch the client from ClientList
 	client, exists := clientList.GetClient(clientAddress)
 	if !exists {
-		log.Printf("Client with address %s not found", clientAddress)
-		return fmt.Errorf("client not found")
+		logger.Warn("Client not found", "address", clientAddress)
+		return ErrClientNotFound
 	}
 
 	// Validate the signature
 	if string(signature) != client.Signature {
-		log.Printf("Signature mismatch:\nProvided: %s\nStored: %s", string(signature), client.Signature)
-		return fmt.Errorf("signature mismatch")
+		logger.Warn("Signature mismatch", "provided_signature", string(signature), "stored_signature", client.Signature)
+		return ErrSignatureMismatch
 	}
 
-	log.Println("Signature validated successfully")
+	logger.Debug("Signature validated")
 
-	// Create a new game session with the player's name as the creator
-	gameID := createNewGame(string(playerName), fmt.Sprintf("Lobby-%s", string(playerName)))
-	if gameID == uuid.Nil {
-		log.Println("Failed to create a new game session. Lobby might already exist.")
-		return fmt.Errorf("failed to create new game session")
+	// Create a new game session with the player's name as the creator, owned by the account
+	options = append(options, "", "")
+	setup, err := ParseGameSetup(options[0], options[1])
+	var gameID uuid.UUID
+	if err == nil {
+		gameID, err = createNewGame(client.FirstName, string(playerName), fmt.Sprintf("Lobby-%s", string(playerName)), setup)
+	}
+	if err != nil {
+		// Refused rather than failed: the client is told why and stays connected
+		logger.Warn("Failed to create a new game session", "player", string(playerName), "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, GameRequest, err)
+		return nil
 	}
 
 	// Set the GameID for the client in ClientList
 	err = clientList.SetClientGameID(clientAddress, gameID)
 	if err != nil {
-		log.Printf("Error setting GameID for client: %v", err)
+		logger.Error("Error setting GameID for client", "err", err)
 		return fmt.Errorf("error setting GameID for client: %w", err)
 	}
 
 	// Convert the UUID to a byte array
 	uuidBytes, err := gameID.MarshalBinary()
 	if err != nil {
-		log.Printf("Error marshaling UUID to bytes: %v", err)
+		logger.Error("Error marshaling UUID to bytes", "err", err)
 		return fmt.Errorf("error marshaling UUID: %w", err)
 	}
 
 	// Encode the GameResponse TLV with the game UUID
 	gameUUID, err := EncodeTLV(UUIDPartie, uuidBytes)
 	if err != nil {
-		log.Printf("Error encoding GameResponse TLV: %v", err)
+		logger.Error("Error encoding GameResponse TLV", "err", err)
 		return fmt.Errorf("error encoding GameResponse: %w", err)
 	}
 
 	// Send the GameResponse back to the client
 	if isTCP {
 		if err := SendMessageTCP(conn, UUIDPartie, gameUUID); err != nil {
-			log.Printf("Error sending GameResponse over TCP: %v", err)
+			logger.Warn("Error sending GameResponse", "err", err)
 			return err
 		}
-		log.Println("GameResponse sent over TCP.")
+		logger.Debug("GameResponse sent")
 	} else if udpConn != nil && clientAddr != nil {
 		// Append the UUIDPartie TLV to the response
 		response := gameUUID
 		if err := SendMessageUDP(udpConn, clientAddr, UUIDPartie, response); err != nil {
-			log.Printf("Error sending GameResponse over UDP: %v", err)
+			logger.Warn("Error sending GameResponse", "err", err)
 			return err
 		}
-		log.Println("GameResponse sent over UDP.")
+		logger.Debug("GameResponse sent")
 	} else {
-		log.Println("Invalid connection type, cannot send response.")
+		logger.Error("Invalid connection type, cannot send response")
 		return fmt.Errorf("invalid connection type")
 	}
 
 	// Log the creator (player's name) for the created game session
-	log.Printf("Created new game session for player: %s, Game ID: %s", string(playerName), gameID.String())
-
-	// Ensure the player's name is printed
-	log.Printf("The player's name (creator): %s", string(playerName))
+	logger.Info("Created new game session", "game", gameID.String(), "player", string(playerName), "variant", setup.Variant, "kind", setup.Kind)
 
 	return nil
 }
 
 func HandleLobbyListRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
-	log.Println("Entered HandleLobbyListRequest")
+	logger := requestLogger(conn, clientAddr, isTCP, LobbyRequest)
+	logger.Debug("Handling request")
 
 	var currentIndex int
 
 	// Decode the first TLV: LobbyRequest (tag 169)
 	tag, lobbyData, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding LobbyRequest TLV: %v", err)
+		logger.Warn("Error decoding LobbyRequest TLV", "err", err)
 		return fmt.Errorf("error decoding LobbyRequest: %w", err)
 	}
 	currentIndex += len(lobbyData) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(lobbyData))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "value", string(lobbyData))
 
 	if tag != LobbyRequest {
-		log.Printf("Unexpected tag for LobbyRequest: %d", tag)
+		logger.Warn("Unexpected tag for LobbyRequest", "tag", GetTagName(tag))
 		return fmt.Errorf("expected LobbyRequest TLV, but got tag %d", tag)
 	}
 
 	// Proceed to decode the second TLV: Signature (tag 3)
 	tag, signature, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding Signature TLV: %v", err)
+		logger.Warn("Error decoding Signature TLV", "err", err)
 		return fmt.Errorf("error decoding Signature: %w", err)
 	}
 	currentIndex += len(signature) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(signature))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "signature", string(signature))
 
 	if tag != ByteData { // The signature is encoded with the ByteData tag
-		log.Printf("Unexpected tag for signature: %d", tag)
+		logger.Warn("Unexpected tag for signature", "tag", GetTagName(tag))
 		return fmt.Errorf("expected ByteData for signature, but got tag %d", tag)
 	}
 
 	// Decode the hash (if needed)
 	tag, providedHash, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding Hash TLV: %v", err)
+		logger.Warn("Error decoding Hash TLV", "err", err)
 		return fmt.Errorf("error decoding Hash: %w", err)
 	}
 	currentIndex += len(providedHash) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(providedHash))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "hash", string(providedHash))
 
 	if tag != ByteData { // The hash is also encoded with the ByteData tag
-		log.Printf("Unexpected tag for hash: %d", tag)
+		logger.Warn("Unexpected tag for hash", "tag", GetTagName(tag))
 		return fmt.Errorf("expected ByteData for hash, but got tag %d", tag)
 	}
 
 	// Prepare combined data for hash verification (exclude the hash itself)
 	combinedData := data[:currentIndex-len(providedHash)-3]
 	calculatedHash := GenerateSignature(combinedData)
-	log.Printf("Calculated hash: %s", calculatedHash)
-	log.Printf("Provided hash: %s", string(providedHash))
 
 	// Compare the calculated hash with the provided hash
 	if string(providedHash) != calculatedHash {
-		log.Println("Hash mismatch: Provided hash does not match calculated hash")
-		return fmt.Errorf("hash mismatch")
+		logger.Warn("Hash mismatch", "calculated_hash", calculatedHash, "provided_hash", string(providedHash))
+		return ErrHashMismatch
 	}
 
 	// Determine the client address
@@ -351,24 +374,24 @@ func HandleLobbyListRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net
 	} else if clientAddr != nil {
 		clientAddress = clientAddr.String()
 	} else {
-		log.Println("Error: Unable to determine client address")
+		logger.Error("Unable to determine client address")
 		return fmt.Errorf("client address is missing")
 	}
 
 	// Fetch the client from ClientList
 	client, exists := clientList.GetClient(clientAddress)
 	if !exists {
-		log.Printf("Client with address %s not found", clientAddress)
-		return fmt.Errorf("client not found")
+		logger.Warn("Client not found", "address", clientAddress)
+		return ErrClientNotFound
 	}
 
 	// Validate the signature
 	if string(signature) != client.Signature {
-		log.Printf("Signature mismatch:\nProvided: %s\nStored: %s", string(signature), client.Signature)
-		return fmt.Errorf("signature mismatch")
+		logger.Warn("Signature mismatch", "provided_signature", string(signature), "stored_signature", client.Signature)
+		return ErrSignatureMismatch
 	}
 
-	log.Println("Signature validated successfully")
+	logger.Debug("Signature validated")
 
 	// Get the list of available lobbies
 	gameMutex.RLock()
@@ -377,12 +400,12 @@ func HandleLobbyListRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net
 		session := GameStore[gameID]
 		if !session.IsLocked { // Include only unlocked lobbies
 			// Print the lobby name and its creator
-			log.Printf("Lobby: %s, Creator: %s", lobbyName, session.CreatorName)
+			logger.Debug("Listing lobby", "lobby", lobbyName, "creator", session.CreatorName)
 
 			// Encode each lobby name as a TLV
 			lobbyData, err := EncodeTLV(String, []byte(lobbyName))
 			if err != nil {
-				log.Printf("Error encoding lobby name %s: %v", lobbyName, err)
+				logger.Error("Error encoding lobby name", "lobby", lobbyName, "err", err)
 				gameMutex.RUnlock()
 				return fmt.Errorf("error encoding lobby name: %w", err)
 			}
@@ -394,25 +417,25 @@ func HandleLobbyListRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net
 	// Encode the full lobby response TLV
 	responseTLV, err := EncodeTLV(lobbyResponse, encodedLobbies)
 	if err != nil {
-		log.Printf("Error encoding LobbyResponse TLV: %v", err)
+		logger.Error("Error encoding LobbyResponse TLV", "err", err)
 		return fmt.Errorf("error encoding LobbyResponse: %w", err)
 	}
 
 	// Send the LobbyList response back to the client
 	if isTCP {
 		if err := SendMessageTCP(conn, lobbyResponse, responseTLV); err != nil {
-			log.Printf("Error sending LobbyList over TCP: %v", err)
+			logger.Warn("Error sending LobbyList", "err", err)
 			return err
 		}
-		log.Println("LobbyList sent over TCP.")
+		logger.Debug("LobbyList sent")
 	} else if udpConn != nil && clientAddr != nil {
 		if err := SendMessageUDP(udpConn, clientAddr, lobbyResponse, responseTLV); err != nil {
-			log.Printf("Error sending LobbyList over UDP: %v", err)
+			logger.Warn("Error sending LobbyList", "err", err)
 			return err
 		}
-		log.Println("LobbyList sent over UDP.")
+		logger.Debug("LobbyList sent")
 	} else {
-		log.Println("Invalid connection type, cannot send response.")
+		logger.Error("Invalid connection type, cannot send response")
 		return fmt.Errorf("invalid connection type")
 	}
 
@@ -422,36 +445,37 @@ func HandleLobbyListRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net
 var ddees = []byte("sldfkjasldkfjapwoi3")
 
 func HandleBoardRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
-	log.Println("Entered HandleBoardRequest")
+	logger := requestLogger(conn, clientAddr, isTCP, BoardRequest)
+	logger.Debug("Handling request")
 
 	var currentIndex int
 
 	// Decode the first TLV: BoardRequest (tag 50)
 	tag, boardRequestData, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding BoardRequest TLV: %v", err)
+		logger.Warn("Error decoding BoardRequest TLV", "err", err)
 		return fmt.Errorf("error decoding BoardRequest: %w", err)
 	}
 	currentIndex += len(boardRequestData) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(boardRequestData))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "value", string(boardRequestData))
 
 	if tag != BoardRequest {
-		log.Printf("Unexpected tag for BoardRequest: %d", tag)
+		logger.Warn("Unexpected tag for BoardRequest", "tag", GetTagName(tag))
 		return fmt.Errorf("expected BoardRequest TLV, but got tag %d", tag)
 	}
 
 	// Decode the second TLV: Signature (tag 3)
 	tag, signature, err := DecodeTLV(data[currentIndex:])
 	if err != nil {
-		log.Printf("Error decoding Signature TLV: %v", err)
+		logger.Warn("Error decoding Signature TLV", "err", err)
 		return fmt.Errorf("error decoding Signature: %w", err)
 	}
 	currentIndex += len(signature) + 3
-	log.Printf("Decoded TLV: Tag=%d, Value=%s", tag, string(signature))
+	logger.Debug("Decoded TLV", "tag", GetTagName(tag), "signature", string(signature))
 
 	if tag != ByteData {
-		log.Printf("Unexpected tag for Signature: %d", tag)
-		return fmt.Errorf("expected ByteData for Signature, but got tag %d")
+		logger.Warn("Unexpected tag for signature", "tag", GetTagName(tag))
+		return fmt.Errorf("expected ByteData for Signature, but got tag %d", tag)
 	}
 
 	// Determine the client address
@@ -461,56 +485,57 @@ func HandleBoardRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 	} else if clientAddr != nil {
 		clientAddress = clientAddr.String()
 	} else {
-		log.Println("Error: Unable to determine client address")
+		logger.Error("Unable to determine client address")
 		return fmt.Errorf("client address is missing")
 	}
 
 	// Fetch the client
 	client, exists := clientList.GetClient(clientAddress)
 	if !exists {
-		log.Printf("Client with address %s not found", clientAddress)
-		return fmt.Errorf("client not found")
+		logger.Warn("Client not found", "address", clientAddress)
+		return ErrClientNotFound
 	}
 
-	// Fetch the game session
+	// Get the board state of the game session, under the lock: moves change its game in place
 	gameMutex.RLock()
 	session, ok := GameStore[client.GameID]
+	var boardState string
+	if ok {
+		boardState = session.GetBoardState()
+	}
 	gameMutex.RUnlock()
 
 	if !ok {
-		log.Printf("No game session found for GameID: %s", client.GameID)
+		logger.Warn("No game session found", "game", client.GameID.String())
 		return fmt.Errorf("game session not found")
 	}
-
-	// Get the board state
-	boardState := session.GetBoardState()
 	if boardState == "" {
-		log.Printf("No valid board state for GameID: %s", client.GameID)
+		logger.Warn("No valid board state", "game", client.GameID.String())
 		return fmt.Errorf("invalid board state")
 	}
 
 	// Encode the board state as a TLV
 	boardResponseTLV, err := EncodeTLV(BoardResponse, []byte(boardState))
 	if err != nil {
-		log.Printf("Error encoding BoardResponse TLV: %v", err)
+		logger.Error("Error encoding BoardResponse TLV", "err", err)
 		return fmt.Errorf("error encoding BoardResponse: %w", err)
 	}
 
 	// Send the board state to the client
 	if isTCP {
 		if err := SendMessageTCP(conn, BoardResponse, boardResponseTLV); err != nil {
-			log.Printf("Error sending BoardResponse over TCP: %v", err)
+			logger.Warn("Error sending BoardResponse", "err", err)
 			return err
 		}
-		log.Println("BoardResponse sent over TCP.")
+		logger.Debug("BoardResponse sent")
 	} else if udpConn != nil && clientAddr != nil {
 		if err := SendMessageUDP(udpConn, clientAddr, BoardResponse, boardResponseTLV); err != nil {
-			log.Printf("Error sending BoardResponse over UDP: %v", err)
+			logger.Warn("Error sending BoardResponse", "err", err)
 			return err
 		}
-		log.Println("BoardResponse sent over UDP.")
+		logger.Debug("BoardResponse sent")
 	} else {
-		log.Println("Invalid connection type, cannot send response.")
+		logger.Error("Invalid connection type, cannot send response")
 		return fmt.Errorf("invalid connection type")
 	}
 
@@ -518,60 +543,30 @@ func HandleBoardRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDP
 }
 
 func HandleMoveRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
-	log.Println("Entered HandleMoveRequest")
-
-	var currentIndex int
-	var moveNotation, gameIDStr, playerName, signature string
-
-	// First TLV should be ActionRequest (move)
-	tag, moveRequestData, err := DecodeTLV(data[currentIndex:])
-	if err != nil {
-		log.Printf("Error decoding first TLV (ActionRequest): %v", err)
-		return fmt.Errorf("error decoding first TLV: %w", err)
-	}
-	if tag != ActionRequest {
-		log.Printf("Unexpected first tag: %d", tag)
-		return fmt.Errorf("expected ActionRequest, got tag %d", tag)
-	}
-	moveNotation = string(moveRequestData)
-	currentIndex += len(moveRequestData) + 3
-	log.Printf("Move Notation: %s", moveNotation)
+	logger := requestLogger(conn, clientAddr, isTCP, ActionRequest)
+	logger.Debug("Handling request")
 
-	// Second TLV should be GameID (ByteData)
-	tag, gameIDData, err := DecodeTLV(data[currentIndex:])
+	// ActionRequest (move), GameID (ByteData), PlayerName (ByteData), Signature, Hash. The
+	// move is played for the player the signature belongs to, the name sent is not trusted.
+	request, err := decodeSignedRequest(data, ActionRequest)
 	if err != nil {
-		log.Printf("Error decoding GameID TLV: %v", err)
-		return fmt.Errorf("error decoding GameID TLV: %w", err)
+		logger.Warn("Error decoding request", "err", err)
+		return err
 	}
-	if tag != ByteData {
-		log.Printf("Unexpected second tag: %d", tag)
-		return fmt.Errorf("expected ByteData for GameID, got tag %d", tag)
+	if len(request.Fields) != 2 || request.Fields[0].Tag != ByteData || request.Fields[1].Tag != ByteData {
+		return fmt.Errorf("ActionRequest expects a game ID and a player name")
 	}
-	gameIDStr = string(gameIDData)
-	currentIndex += len(gameIDData) + 3
-	log.Printf("Game ID: %s", gameIDStr)
 
-	// Third TLV should be PlayerName (ByteData)
-	tag, playerNameData, err := DecodeTLV(data[currentIndex:])
+	client, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
 	if err != nil {
-		log.Printf("Error decoding PlayerName TLV: %v", err)
-		return fmt.Errorf("error decoding PlayerName TLV: %w", err)
+		logger.Warn("Error authenticating request", "err", err)
+		return err
 	}
-	if tag != ByteData {
-		log.Printf("Unexpected third tag: %d", tag)
-		return fmt.Errorf("expected ByteData for PlayerName, got tag %d", tag)
-	}
-	playerName = string(playerNameData)
-	currentIndex += len(playerNameData) + 3
-	log.Printf("Player Name: %s", playerName)
 
-	// Optional: Parse Signature and Hash TLVs if needed
-	// For now, we'll just log them
-	tag, signatureData, err := DecodeTLV(data[currentIndex:])
-	if err == nil && tag == ByteData {
-		signature = string(signatureData)
-		log.Printf("Signature: %s", signature)
-	}
+	moveNotation := string(request.Value)
+	gameIDStr := string(request.Fields[0].Value)
+	logger = logger.With("game", gameIDStr, "player", client.FirstName)
+	logger.Debug("Decoded move", "move", moveNotation)
 
 	// Parse the game ID
 	gameID, err := uuid.Parse(gameIDStr)
@@ -579,134 +574,707 @@ func HandleMoveRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPA
 		return fmt.Errorf("invalid game ID: %v", err)
 	}
 
-	// Fetch the game session
-	gameMutex.Lock()
-	session, ok := GameStore[gameID]
-	gameMutex.Unlock()
+	// Check the game session exists
+	gameMutex.RLock()
+	_, ok := GameStore[gameID]
+	gameMutex.RUnlock()
 
 	if !ok {
-		log.Printf("No game session found for GameID: %s", gameID)
+		logger.Warn("No game session found")
 		return fmt.Errorf("game session not found")
 	}
 
-	// Attempt to move the piece
-	err = Move(session.Game, moveNotation)
-
-	// Get the current board state (whether the move was successful or not)
-	boardState := session.GetBoardState()
-
-	// Prepare the response: if the move failed, just send the current board state unchanged
-	moveResponseData := boardState
+	// Attempt to move the piece (the opponent is notified if it succeeds). An illegal move or
+	// one played out of turn is refused without closing the connection. The board state is
+	// the one after the move: a move may replace the session's game, see splitGame.
+	moveResponseData, err := MoveInLobby(gameID, moveNotation, client.FirstName)
+	if err != nil {
+		logger.Info("Move rejected", "move", moveNotation, "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, ActionRequest, err)
+		return nil
+	}
 
 	// Encode the response with the board state
 	moveResponseTLV, err := EncodeTLV(ActionResponse, []byte(moveResponseData))
 	if err != nil {
-		log.Printf("Error encoding MoveResponse TLV: %v", err)
+		logger.Error("Error encoding MoveResponse TLV", "err", err)
 		return fmt.Errorf("error encoding MoveResponse: %w", err)
 	}
 
-	// Send the updated board state (unchanged if the move failed)
+	// Send the updated board state
 	if isTCP {
 		if err := SendMessageTCP(conn, ActionResponse, moveResponseTLV); err != nil {
-			log.Printf("Error sending MoveResponse over TCP: %v", err)
+			logger.Warn("Error sending MoveResponse", "err", err)
 			return err
 		}
-		log.Println("MoveResponse sent over TCP.")
+		logger.Debug("MoveResponse sent")
 	} else if udpConn != nil && clientAddr != nil {
 		if err := SendMessageUDP(udpConn, clientAddr, ActionResponse, moveResponseTLV); err != nil {
-			log.Printf("Error sending MoveResponse over UDP: %v", err)
+			logger.Warn("Error sending MoveResponse", "err", err)
 			return err
 		}
-		log.Println("MoveResponse sent over UDP.")
+		logger.Debug("MoveResponse sent")
 	} else {
-		log.Println("Invalid connection type, cannot send response.")
+		logger.Error("Invalid connection type, cannot send response")
 		return fmt.Errorf("invalid connection type")
 	}
 
 	return nil
 }
 func HandleJoinRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
-	log.Println("Entered HandleJoinRequest")
+	logger := requestLogger(conn, clientAddr, isTCP, JoinLobbyRequest)
+	logger.Debug("Handling request")
 
-	var currentIndex int
-	var playerName string
+	// JoinLobbyRequest (lobby name), Signature, Hash
+	request, err := decodeSignedRequest(data, JoinLobbyRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
 
-	// First TLV should be ActionRequest (move)
-	tag, _, err := DecodeTLV(data[currentIndex:])
+	client, clientAddress, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
 	if err != nil {
-		log.Printf("Error decoding first TLV (ActionRequest): %v", err)
-		return fmt.Errorf("error decoding first TLV: %w", err)
+		logger.Warn("Error authenticating request", "err", err)
+		return err
 	}
-	if tag != JoinLobbyRequest {
-		log.Printf("Unexpected first tag: %d", tag)
-		return fmt.Errorf("expected ActionRequest, got tag %d", tag)
+
+	lobbyName := string(request.Value)
+	logger = logger.With("lobby", lobbyName, "player", client.FirstName)
+
+	// A missing, full or locked lobby is refused without closing the connection
+	gameID, err := joinGame(lobbyName, client.FirstName)
+	if err != nil {
+		logger.Info("Join refused", "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, JoinLobbyRequest, err)
+		return nil
 	}
 
-	// First TLV should be PlayerName (ByteData)
-	tag, playerNameData, err := DecodeTLV(data[currentIndex:])
+	// Board requests from this client now concern the lobby it joined
+	if err := clientList.SetClientGameID(clientAddress, gameID); err != nil {
+		logger.Error("Error setting GameID for client", "err", err)
+		return fmt.Errorf("error setting GameID for client: %w", err)
+	}
+	logger.Info("Player joined the lobby", "game", gameID.String())
+
+	// Answer with the game ID, as a string
+	responseTLV, err := EncodeTLV(ByteData, []byte(gameID.String()))
 	if err != nil {
-		log.Printf("Error decoding PlayerName TLV: %v", err)
-		return fmt.Errorf("error decoding PlayerName TLV: %w", err)
+		logger.Error("Error encoding GameID TLV", "err", err)
+		return fmt.Errorf("error encoding GameID TLV: %w", err)
+	}
+	if err := SendMessage(conn, udpConn, clientAddr, isTCP, JoinLobbyRequest, responseTLV); err != nil {
+		logger.Warn("Error sending response", "err", err)
+		return err
 	}
 
-	playerName = string(playerNameData)
-	log.Printf("Player Name: %s", playerName)
+	// The creator may not move before the seats are given, the starting board with the
+	// joiner as mover tells it the game began
+	gameMutex.RLock()
+	session := GameStore[gameID]
+	if !session.IsLocked {
+		gameMutex.RUnlock()
+		return nil
+	}
+	update, err := encodeBoardUpdate(session, client.FirstName)
+	gameMutex.RUnlock()
+	if err != nil {
+		logger.Error("Error encoding BoardUpdate", "err", err)
+		return nil
+	}
+	notifyGameUpdate(session.JoinedPlayers, client.FirstName, update)
+	return nil
+}
 
-	// Find clients with matching name
-	matchedClients := clientList.GetClientByName(playerName)
+func HandleQueueRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, QueueRequest)
+	logger.Debug("Handling request")
 
-	var gameIDStr string
-	var gameID uuid.UUID
+	// QueueRequest, TimeControl (String), Rated (Int), optional Game (String), Signature, Hash.
+	// Players who name no game are paired for standard chess.
+	request, err := decodeSignedRequest(data, QueueRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+	if len(request.Fields) < 2 || len(request.Fields) > 3 || request.Fields[0].Tag != String || request.Fields[1].Tag != Int ||
+		(len(request.Fields) == 3 && request.Fields[2].Tag != String) {
+		return fmt.Errorf("QueueRequest expects a time control, a rated flag and optionally a game")
+	}
 
-	// If exactly one client found, use its game ID
-	if len(matchedClients) == 1 {
-		gameID = matchedClients[0].GameID
-		gameIDStr = gameID.String()
-		log.Printf("Found game ID for player %s: %s", playerName, gameIDStr)
-	} else if len(matchedClients) > 1 {
-		log.Printf("Multiple clients found with name %s", playerName)
-		// Could implement additional logic to disambiguate if needed
-		// For now, we'll use the first matched client's game ID
-		gameID = matchedClients[0].GameID
-		gameIDStr = gameID.String()
-	} else {
-		// No client found, generate a new game ID
-		gameID = uuid.New()
-		gameIDStr = gameID.String()
-		log.Printf("No existing client found, generated new game ID: %s", gameIDStr)
+	client, clientAddress, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+
+	timeControl, err := ParseTimeControl(string(request.Fields[0].Value))
+	if err != nil {
+		logger.Info("Invalid time control", "player", client.FirstName, "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, QueueRequest, err)
+		return nil
+	}
+	rated := string(request.Fields[1].Value) == "1"
+
+	var game string
+	if len(request.Fields) == 3 {
+		setup, err := ParseGameSetup(string(request.Fields[2].Value), "")
+		if err == nil && setup.Kind == "" && setup.Variant != Standard {
+			err = fmt.Errorf("matchmaking pairs players for standard chess, not %s", setup.Variant.PGNName())
+		}
+		if err == nil && setup.Kind != "" && rated {
+			err = fmt.Errorf("only chess games are rated")
+		}
+		if err != nil {
+			logger.Info("Invalid game", "player", client.FirstName, "err", err)
+			SendErrorResponse(conn, udpConn, clientAddr, isTCP, QueueRequest, err)
+			return nil
+		}
+		game = setup.Kind
+	}
+
+	entry := &QueueEntry{
+		PlayerName:  client.FirstName,
+		Address:     clientAddress,
+		Rating:      client.Level,
+		Key:         QueueKey{Game: game, TimeControl: timeControl.String(), Rated: rated},
+		TimeControl: timeControl,
+		JoinedAt:    time.Now(),
 	}
+	entry.LastStatusAt = entry.JoinedAt
 
-	// Prepare the response with the GameID
-	responseData := []byte(gameIDStr)
+	if err := matchmaker.Enqueue(entry); err != nil {
+		logger.Info("Error queuing player", "player", client.FirstName, "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, QueueRequest, err)
+		return nil
+	}
+	logger.Info("Player queued", "player", client.FirstName, "rating", client.Level, "game", game, "time_control", entry.Key.TimeControl, "rated", rated)
+
+	sendQueueStatus(entry, "queued", matchmaker.Window(entry, entry.JoinedAt), matchmaker.QueueSize(entry.Key))
+	return nil
+}
 
-	// Encode the GameID as the response TLV
-	responseTLV, err := EncodeTLV(ByteData, responseData)
+func HandleQueueCancelRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, QueueCancelRequest)
+	logger.Debug("Handling request")
+
+	// QueueCancelRequest, Signature, Hash
+	request, err := decodeSignedRequest(data, QueueCancelRequest)
 	if err != nil {
-		log.Printf("Error encoding GameID TLV: %v", err)
-		return fmt.Errorf("error encoding GameID TLV: %w", err)
+		logger.Warn("Error decoding request", "err", err)
+		return err
 	}
 
-	// Send the response back to the client
-	if isTCP {
-		if err := SendMessageTCP(conn, JoinLobbyRequest, responseTLV); err != nil {
-			log.Printf("Error sending response over TCP: %v", err)
-			return err
+	client, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+
+	// A player who is not queued is refused without closing the connection
+	entry, removed := matchmaker.Cancel(client.FirstName)
+	if !removed {
+		err := fmt.Errorf("player %s is not in the matchmaking queue", client.FirstName)
+		logger.Info("Cancel refused", "player", client.FirstName, "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, QueueCancelRequest, err)
+		return nil
+	}
+	logger.Info("Player left the matchmaking queue", "player", client.FirstName)
+
+	sendQueueStatus(entry, "cancelled", 0, matchmaker.QueueSize(entry.Key))
+	return nil
+}
+
+func HandleBotGameRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, BotGameRequest)
+	logger.Debug("Handling request")
+
+	// BotGameRequest, Level (Int), Color (String), [Engine (String)], Signature, Hash
+	request, err := decodeSignedRequest(data, BotGameRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+	if len(request.Fields) < 2 || request.Fields[0].Tag != Int || request.Fields[1].Tag != String {
+		return fmt.Errorf("BotGameRequest expects a level and a color")
+	}
+
+	client, clientAddress, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+
+	level, err := strconv.Atoi(string(request.Fields[0].Value))
+	if err != nil {
+		return fmt.Errorf("invalid engine level: %w", err)
+	}
+	engineName := DefaultEngine
+	if len(request.Fields) > 2 {
+		engineName = string(request.Fields[2].Value)
+	}
+
+	engine, err := NewEngine(engineName, level)
+	if err != nil {
+		logger.Info("Error creating engine", "player", client.FirstName, "engine", engineName, "err", err)
+		return err
+	}
+
+	// Pick the human's color, the bot takes the other seat
+	humanColor := string(request.Fields[1].Value)
+	if humanColor != "white" && humanColor != "black" {
+		humanColor = "white"
+		if rand.Intn(2) == 1 {
+			humanColor = "black"
 		}
-		log.Println("Response sent over TCP.")
-	} else if udpConn != nil && clientAddr != nil {
-		if err := SendMessageUDP(udpConn, clientAddr, JoinLobbyRequest, responseTLV); err != nil {
-			log.Printf("Error sending response over UDP: %v", err)
-			return err
+	}
+
+	// The bot is named after its game, known once the game is created
+	seatName := fmt.Sprintf("Computer-%d", level)
+	whitePlayer, blackPlayer, botSeat := client.FirstName, seatName, chess.Black
+	if humanColor == "black" {
+		whitePlayer, blackPlayer, botSeat = seatName, client.FirstName, chess.White
+	}
+	gameID, err := createMatchedGame(whitePlayer, blackPlayer, TimeControl{}, false, "")
+	if err != nil {
+		return fmt.Errorf("error creating the game: %w", err)
+	}
+	botName := fmt.Sprintf("%s-%s", seatName, gameID.String()[:8])
+	if err := renameSeat(gameID, botSeat, botName); err != nil {
+		return fmt.Errorf("error seating the computer: %w", err)
+	}
+
+	rating := engineRating(engine)
+	bot := seatBot(gameID, botName, engine, rating)
+
+	if err := clientList.SetClientGameID(clientAddress, gameID); err != nil {
+		logger.Error("Error setting GameID for client", "err", err)
+		return fmt.Errorf("error setting GameID for client: %w", err)
+	}
+	logger.Info("Created game against the computer", "game", gameID.String(), "player", client.FirstName, "color", humanColor, "engine", engine.Name())
+
+	// Tell the human the game is on, exactly like a matchmaking pairing
+	message, err := encodeMatchFound(gameID[:], humanColor, &QueueEntry{PlayerName: botName, Rating: rating})
+	if err != nil {
+		return fmt.Errorf("error encoding MatchFound: %w", err)
+	}
+	if err := SendMessage(conn, udpConn, clientAddr, isTCP, MatchFound, message); err != nil {
+		logger.Warn("Error sending MatchFound", "err", err)
+		return err
+	}
+
+	// The bot gets the same notification, and moves first if it plays white
+	return bot.Send(MatchFound, nil)
+}
+
+func HandleAnalyzeRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, AnalyzeRequest)
+	logger.Debug("Handling request")
+
+	// AnalyzeRequest, GameID (String), Signature, Hash
+	request, err := decodeSignedRequest(data, AnalyzeRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+	if len(request.Fields) != 1 || request.Fields[0].Tag != String {
+		return fmt.Errorf("AnalyzeRequest expects a game ID")
+	}
+
+	if _, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature); err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+
+	gameID, err := uuid.Parse(string(request.Fields[0].Value))
+	if err != nil {
+		return fmt.Errorf("invalid game ID: %v", err)
+	}
+	logger = logger.With("game", gameID.String())
+
+	// Analysing a whole game takes a while, so answer in the background. The analysis counts
+	// as a request in flight, for the shutdown to wait for it or cancel it.
+	started := inFlightRequests.Go(func(ctx context.Context) {
+		session, report, err := runGameAnalysis(ctx, gameID)
+		// The player may have left during the analysis, its connection is closed then
+		if !clientConnected(conn, clientAddr, isTCP) {
+			logger.Info("Client gone, analysis not sent", "err", err)
+			return
+		}
+		if err != nil {
+			logger.Warn("Error analysing game", "err", err)
+			SendErrorResponse(conn, udpConn, clientAddr, isTCP, AnalyzeRequest, err)
+			return
+		}
+
+		message, err := encodeAnalysisResponse(session, report)
+		if err != nil {
+			logger.Error("Error encoding AnalyzeResponse", "err", err)
+			SendErrorResponse(conn, udpConn, clientAddr, isTCP, AnalyzeRequest, err)
+			return
+		}
+
+		if err := SendMessage(conn, udpConn, clientAddr, isTCP, AnalyzeResponse, message); err != nil {
+			logger.Warn("Error sending AnalyzeResponse", "err", err)
+		}
+	})
+	if !started {
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, AnalyzeRequest, ErrShuttingDown)
+	}
+	return nil
+}
+
+// clientConnected tells whether the client of a request is still connected, which it no
+// longer is once disconnectClient forgot its address
+func clientConnected(conn net.Conn, clientAddr *net.UDPAddr, isTCP bool) bool {
+	var address string
+	if isTCP && conn != nil {
+		address = conn.RemoteAddr().String()
+	} else if clientAddr != nil {
+		address = clientAddr.String()
+	}
+	_, connected := clientList.GetClient(address)
+	return connected
+}
+
+func HandleArchiveRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, ArchiveRequest)
+	logger.Debug("Handling request")
+
+	// ArchiveRequest, [Opening (String), [Player (String)]], Signature, Hash
+	request, err := decodeSignedRequest(data, ArchiveRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+
+	if _, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature); err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+
+	filter := ArchiveFilter{Limit: maxArchiveResponseGames}
+	for i, field := range request.Fields {
+		if field.Tag != String {
+			return fmt.Errorf("ArchiveRequest expects String filters, got %s", GetTagName(field.Tag))
+		}
+		switch i {
+		case 0:
+			filter.Opening = string(field.Value)
+		case 1:
+			filter.Player = string(field.Value)
 		}
-		log.Println("Response sent over UDP.")
-	} else {
-		log.Println("Invalid connection type, cannot send response.")
-		return fmt.Errorf("invalid connection type")
 	}
 
+	games := gameArchive.Find(filter)
+	logger.Debug("Archive search", "opening", filter.Opening, "player", filter.Player, "games", len(games))
+
+	message, err := encodeArchiveResponse(games)
+	if err != nil {
+		return fmt.Errorf("error encoding ArchiveResponse: %v", err)
+	}
+	return SendMessage(conn, udpConn, clientAddr, isTCP, ArchiveResponse, message)
+}
+
+// signedRequest is a request made of a tag TLV, optional fields, the client signature and a hash of everything before it
+type signedRequest struct {
+	Value     []byte
+	Fields    []tlvField
+	Signature string
+}
+
+// decodeSignedRequest decodes a signed request and verifies its hash
+func decodeSignedRequest(data []byte, expected Tag) (*signedRequest, error) {
+	fields, err := decodeTLVFields(data)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", GetTagName(expected), err)
+	}
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("%s is missing its signature or hash", GetTagName(expected))
+	}
+	if fields[0].Tag != expected {
+		return nil, fmt.Errorf("expected %s, but got tag %d", GetTagName(expected), fields[0].Tag)
+	}
+
+	signatureField := fields[len(fields)-2]
+	hashField := fields[len(fields)-1]
+	if signatureField.Tag != ByteData || hashField.Tag != ByteData {
+		return nil, fmt.Errorf("expected ByteData for signature and hash")
+	}
+
+	// The hash covers every TLV that precedes it
+	hashedLength := len(data) - (len(hashField.Value) + 3)
+	if GenerateSignature(data[:hashedLength]) != string(hashField.Value) {
+		return nil, ErrHashMismatch
+	}
+
+	return &signedRequest{
+		Value:     fields[0].Value,
+		Fields:    fields[1 : len(fields)-2],
+		Signature: string(signatureField.Value),
+	}, nil
+}
+
+// HandlePing answers a client's Ping with a Pong carrying the same value
+func HandlePing(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	_, value, err := DecodeTLV(data)
+	if err != nil {
+		return fmt.Errorf("error decoding Ping: %w", err)
+	}
+	return SendMessage(conn, udpConn, clientAddr, isTCP, Pong, value)
+}
+
+// HandlePong accepts the answer to a server Ping. Its arrival is all that matters: it reset
+// the read deadline of the TCP connection or the idle timer of the UDP client.
+func HandlePong(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
 	return nil
 }
 
+func HandleAbandonClaimRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, AbandonClaimRequest)
+	logger.Debug("Handling request")
+
+	// AbandonClaimRequest, GameID (String), Signature, Hash
+	request, err := decodeSignedRequest(data, AbandonClaimRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+	if len(request.Fields) != 1 || request.Fields[0].Tag != String {
+		return fmt.Errorf("AbandonClaimRequest expects a game ID")
+	}
+
+	client, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+
+	gameID, err := uuid.Parse(string(request.Fields[0].Value))
+	if err != nil {
+		return fmt.Errorf("invalid game ID: %v", err)
+	}
+	logger = logger.With("game", gameID.String(), "player", client.FirstName)
+
+	// A refused claim is answered without closing the connection, the player may retry later.
+	// A granted one ends the game, which pushes the final BoardUpdate to both players.
+	if err := claimAbandonedGame(gameID, client.FirstName, time.Now()); err != nil {
+		logger.Info("Abandonment claim refused", "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, AbandonClaimRequest, err)
+		return nil
+	}
+	logger.Info("Abandonment claim granted")
+	return nil
+}
+
+func HandleChatRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, ChatRequest)
+	logger.Debug("Handling request")
+
+	// ChatRequest (text), Signature, Hash
+	request, err := decodeSignedRequest(data, ChatRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+
+	client, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+	logger = logger.With("game", client.GameID.String(), "player", client.FirstName)
+
+	// The line goes to the other players of the sender's current game. The server does not
+	// answer a relayed line, a refused one is reported without closing the connection.
+	if err := relayChat(client.GameID, client.FirstName, string(request.Value)); err != nil {
+		logger.Info("Chat line refused", "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, ChatRequest, err)
+		return nil
+	}
+	logger.Debug("Chat line relayed")
+	return nil
+}
+
+func HandlePremoveRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, PremoveRequest)
+	logger.Debug("Handling request")
+
+	// PremoveRequest (lines of moves, empty to clear them), Signature, Hash
+	request, err := decodeSignedRequest(data, PremoveRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+
+	client, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+	logger = logger.With("game", client.GameID.String(), "player", client.FirstName)
+
+	// The moves replace those queued before, the response tells them back normalized
+	tree, err := queueMoves(client.GameID, client.FirstName, string(request.Value))
+	if err != nil {
+		logger.Info("Queued moves refused", "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, PremoveRequest, err)
+		return nil
+	}
+	return SendMessage(conn, udpConn, clientAddr, isTCP, PremoveResponse, []byte(tree.String()))
+}
+
+func HandleUndoRequest(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, UndoRequest)
+	logger.Debug("Handling request")
+
+	// UndoRequest (plies to take back, 1 when empty), Signature, Hash
+	request, err := decodeSignedRequest(data, UndoRequest)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+
+	client, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+	logger = logger.With("game", client.GameID.String(), "player", client.FirstName)
+
+	plies := 1
+	if len(request.Value) > 0 {
+		if plies, err = strconv.Atoi(string(request.Value)); err != nil {
+			return fmt.Errorf("invalid number of moves to take back: %v", err)
+		}
+	}
+
+	// The opponent gets an UndoOffer, both players an UndoResult once it is answered. A refused
+	// request is reported without closing the connection.
+	if err := requestUndo(client.GameID, client.FirstName, plies); err != nil {
+		logger.Info("Takeback request refused", "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, UndoRequest, err)
+	}
+	return nil
+}
+
+func HandleUndoAnswer(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error {
+	logger := requestLogger(conn, clientAddr, isTCP, UndoAnswer)
+	logger.Debug("Handling request")
+
+	// UndoAnswer ("accept" or "decline"), Signature, Hash
+	request, err := decodeSignedRequest(data, UndoAnswer)
+	if err != nil {
+		logger.Warn("Error decoding request", "err", err)
+		return err
+	}
+
+	client, _, err := authenticateRequest(conn, clientAddr, isTCP, request.Signature)
+	if err != nil {
+		logger.Warn("Error authenticating request", "err", err)
+		return err
+	}
+	logger = logger.With("game", client.GameID.String(), "player", client.FirstName)
+
+	var accept bool
+	switch answer := string(request.Value); answer {
+	case "accept":
+		accept = true
+	case "decline":
+	default:
+		return fmt.Errorf("invalid takeback answer %q, expected accept or decline", answer)
+	}
+
+	if err := answerUndo(client.GameID, client.FirstName, accept); err != nil {
+		logger.Info("Takeback answer refused", "err", err)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, UndoAnswer, err)
+	}
+	return nil
+}
+
+// Errors shared by the handlers, told apart by the handler error metrics
+var (
+	ErrHashMismatch      = errors.New("hash mismatch")
+	ErrSignatureMismatch = errors.New("signature mismatch")
+	ErrClientNotFound    = errors.New("client not found")
+	ErrMaintenance       = errors.New("the server is in maintenance")
+	ErrRateLimited       = errors.New("too many requests, slow down")
+	ErrShuttingDown      = errors.New("the server is shutting down")
+)
+
+// handlerErrorCode classifies a handler error for the metrics
+func handlerErrorCode(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrHashMismatch):
+		return "hash_mismatch"
+	case errors.Is(err, ErrSignatureMismatch):
+		return "signature_mismatch"
+	case errors.Is(err, ErrClientNotFound):
+		return "unknown_client"
+	case errors.Is(err, ErrMaintenance):
+		return "maintenance"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrInsufficientData):
+		return "malformed"
+	case errors.Is(err, net.ErrClosed), errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+// clientAddressOf returns the key identifying the client a request came from
+func clientAddressOf(conn net.Conn, clientAddr *net.UDPAddr, isTCP bool) (string, error) {
+	if isTCP && conn != nil {
+		return conn.RemoteAddr().String(), nil
+	} else if clientAddr != nil {
+		return clientAddr.String(), nil
+	}
+	return "", fmt.Errorf("client address is missing")
+}
+
+// authenticateRequest finds the client behind a request and checks its signature
+func authenticateRequest(conn net.Conn, clientAddr *net.UDPAddr, isTCP bool, signature string) (Client, string, error) {
+	clientAddress, err := clientAddressOf(conn, clientAddr, isTCP)
+	if err != nil {
+		return Client{}, "", err
+	}
+
+	client, exists := clientList.GetClient(clientAddress)
+	if !exists {
+		return Client{}, clientAddress, ErrClientNotFound
+	}
+	if signature != client.Signature {
+		return Client{}, clientAddress, ErrSignatureMismatch
+	}
+	return client, clientAddress, nil
+}
+
+// SendMessage sends a message with a specified tag over whichever transport the request came in on
+func SendMessage(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, isTCP bool, tag Tag, message []byte) error {
+	if isTCP {
+		return SendMessageTCP(conn, tag, message)
+	} else if udpConn != nil && clientAddr != nil {
+		return SendMessageUDP(udpConn, clientAddr, tag, message)
+	}
+	return fmt.Errorf("invalid connection type")
+}
+
+// SendErrorResponse tells the client why a request failed: the request tag and the error message
+func SendErrorResponse(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, isTCP bool, requestTag Tag, cause error) {
+	message, err := encodeTLVFields(
+		tlvField{Int, []byte(strconv.Itoa(int(requestTag)))},
+		tlvField{String, []byte(cause.Error())},
+	)
+	if err != nil {
+		slog.Error("Error encoding ErrorResponse", "request", GetTagName(requestTag), "err", err)
+		return
+	}
+	if err := SendMessage(conn, udpConn, clientAddr, isTCP, ErrorResponse, message); err != nil {
+		slog.Warn("Error sending ErrorResponse", "request", GetTagName(requestTag), "err", err)
+	}
+}
+
 // SendHelloResponseTCP sends a HelloResponse (Tag 101) to the TCP client with the signature
 func SendHelloResponseTCP(conn net.Conn, signature string) error {
 	// Send the HelloResponse (Tag 101) to the TCP client
@@ -728,12 +1296,13 @@ func SendMessageTCP(conn net.Conn, tag Tag, message []byte) error {
 	}
 
 	// Send the encoded message to the TCP client
-	_, err = conn.Write(encodedMessage)
+	n, err := conn.Write(encodedMessage)
+	serverMetrics.bytesSent.Add(float64(n), streamTransport(conn))
 	if err != nil {
 		return fmt.Errorf("error sending message with tag %d: %w", tag, err)
 	}
 
-	log.Printf("Message sent with tag %d to TCP client: %s\n", tag, string(message))
+	slog.Debug("Message sent", "transport", streamTransport(conn), "remote", conn.RemoteAddr().String(), "tag", GetTagName(tag), "length", len(message))
 	return nil
 }
 
@@ -746,11 +1315,12 @@ func SendMessageUDP(conn *net.UDPConn, clientAddr *net.UDPAddr, tag Tag, message
 	}
 
 	// Send the encoded message to the UDP client at the specified address
-	_, err = conn.WriteToUDP(encodedMessage, clientAddr)
+	n, err := conn.WriteToUDP(encodedMessage, clientAddr)
+	serverMetrics.bytesSent.Add(float64(n), "udp")
 	if err != nil {
 		return fmt.Errorf("error sending message with tag %d to %s: %w", tag, clientAddr, err)
 	}
 
-	log.Printf("Message sent with tag %d to %s: %s\n", tag, clientAddr, string(message))
+	slog.Debug("Message sent", "transport", "udp", "remote", clientAddr.String(), "tag", GetTagName(tag), "length", len(message))
 	return nil
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// testAddr is the address of a simulated connection
+type testAddr string
+
+func (a testAddr) Network() string { return "tcp" }
+func (a testAddr) String() string  { return string(a) }
+
+// recordingConn is a simulated TCP connection keeping what the handlers write to it
+type recordingConn struct {
+	net.Conn
+	addr    testAddr
+	mu      sync.Mutex
+	pending []byte
+}
+
+func (c *recordingConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *recordingConn) Write(data []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, data...)
+	return len(data), nil
+}
+
+// next returns the oldest message written to the connection not returned yet
+func (c *recordingConn) next(t *testing.T) (Tag, []byte) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tag, value, length, err := SafeDecodeTLV(c.pending)
+	if err != nil {
+		t.Fatalf("no answer from the server: %v", err)
+	}
+	c.pending = c.pending[length:]
+	return tag, value
+}
+
+// connectClient registers a player who said Hello with the given signature, seated in gameID
+func connectClient(t *testing.T, name, signature string, gameID uuid.UUID) *recordingConn {
+	t.Helper()
+	conn := &recordingConn{addr: testAddr("handler-" + name + "-" + uuid.NewString()[:8])}
+	clientList.AddClient(conn.addr.String(), Client{FirstName: name, Signature: signature, Address: conn.addr.String(), GameID: gameID})
+	t.Cleanup(func() { clientList.RemoveClient(conn.addr.String()) })
+	return conn
+}
+
+// moveRequest builds the ActionRequest the client sends for a move
+func moveRequest(t *testing.T, signature, move string, gameID uuid.UUID, playerName string) []byte {
+	t.Helper()
+	return signRequest(t, signature,
+		tlvField{ActionRequest, []byte(move)},
+		tlvField{ByteData, []byte(gameID.String())},
+		tlvField{ByteData, []byte(playerName)},
+	)
+}
+
+func TestMoveRequestPlaysForTheSigner(t *testing.T) {
+	gameID := openJoinedLobby(t, "SignerAnn", "SignerBob")
+	ann := connectClient(t, "SignerAnn", "ann-signature", gameID)
+	bob := connectClient(t, "SignerBob", "bob-signature", gameID)
+
+	// Black names white to move in its place
+	if err := HandleMoveRequest(bob, nil, nil, moveRequest(t, "bob-signature", "e4", gameID, "SignerAnn"), true); err != nil {
+		t.Fatal(err)
+	}
+	if tag, _ := bob.next(t); tag != ErrorResponse {
+		t.Errorf("got %s, want the move refused as out of turn", GetTagName(tag))
+	}
+
+	// The signature of another player is refused
+	if err := HandleMoveRequest(bob, nil, nil, moveRequest(t, "ann-signature", "e4", gameID, "SignerAnn"), true); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("got %v for a move signed by another player, want %v", err, ErrSignatureMismatch)
+	}
+
+	// The name sent does not matter to the player whose turn it is
+	if err := HandleMoveRequest(ann, nil, nil, moveRequest(t, "ann-signature", "e4", gameID, "SignerBob"), true); err != nil {
+		t.Fatal(err)
+	}
+	if tag, _ := ann.next(t); tag != ActionResponse {
+		t.Errorf("got %s, want the move played for white", GetTagName(tag))
+	}
+}
+
+func TestMoveResponseIsThePositionAfterTheMove(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		variant  string
+		position string
+		moves    []string // Played before the last one, White first
+		last     string
+		want     string
+	}{
+		{
+			name: "Chess960 castling", variant: "chess960", position: "518",
+			moves: []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "f8c5"},
+			last:  "O-O",
+			want:  "r1bqk1nr/pppp1ppp/2n5/2b1p3/2B1P3/5N2/PPPP1PPP/RNBQ1RK1 b ha - 5 4",
+		},
+		{
+			// Black's knight clears a2 and a3 for the pawn of a1
+			name: "Horde double step", variant: "horde",
+			moves: []string{"h4h5", "b8a6", "d4d5", "a6b4", "e4e5", "b4a2", "h3h4", "a2c3",
+				"h2h3", "c3b1", "d3d4", "b1a3", "e3e4", "a3b1"},
+			last: "a1a3",
+			want: "r1bqkbnr/pppppppp/8/1PPPPPPP/P1PPPPPP/PP3PPP/1PPPPPP1/1nPPPPPP b kq - 0 8",
+		},
+		{
+			name: "Three-check check", variant: "threecheck",
+			moves: []string{"e2e4", "f7f6"},
+			last:  "Qh5+",
+			want:  "rnbqkbnr/ppppp1pp/5p2/7Q/4P3/8/PPPP1PPP/RNB1KBNR b KQkq - 1 2 +1+0",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			white, black := "AfterWhite-"+test.variant, "AfterBlack-"+test.variant
+			gameID := openVariantLobby(t, white, black, test.variant, test.position)
+			players := []string{white, black}
+			for i, move := range test.moves {
+				if _, err := MoveInLobby(gameID, move, players[i%2]); err != nil {
+					t.Fatalf("error playing %s: %v", move, err)
+				}
+			}
+
+			mover := players[len(test.moves)%2]
+			conn := connectClient(t, mover, "after-signature", gameID)
+			if err := HandleMoveRequest(conn, nil, nil, moveRequest(t, "after-signature", test.last, gameID, mover), true); err != nil {
+				t.Fatal(err)
+			}
+			tag, value := conn.next(t)
+			if tag != ActionResponse {
+				t.Fatalf("got %s %q, want the ActionResponse", GetTagName(tag), value)
+			}
+			// The board comes in a second ActionResponse TLV
+			if _, board, _, err := SafeDecodeTLV(value); err != nil || string(board) != test.want {
+				t.Errorf("answered %q after %s, want %q", board, test.last, test.want)
+			}
+		})
+	}
+}
+
+func TestBoardRequestWhileMoving(t *testing.T) {
+	gameID := openJoinedLobby(t, "RaceAnn", "RaceBob")
+	conn := connectClient(t, "RaceAnn", "race-signature", gameID)
+	request := append(mustEncodeTLV(t, BoardRequest, gameID.String()), mustEncodeTLV(t, ByteData, "race-signature")...)
+
+	// The knights go back and forth while the board is requested, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		players := []string{"RaceAnn", "RaceBob"}
+		for i, move := range []string{"g1f3", "g8f6", "f3g1", "f6g8", "b1c3", "b8c6", "c3b1", "c6b8"} {
+			if _, err := MoveInLobby(gameID, move, players[i%2]); err != nil {
+				t.Errorf("error playing %s: %v", move, err)
+				return
+			}
+		}
+	}()
+	for {
+		if err := HandleBoardRequest(conn, nil, nil, request, true); err != nil {
+			t.Fatal(err)
+		}
+		if tag, _ := conn.next(t); tag != BoardResponse {
+			t.Fatalf("got %s, want the BoardResponse", GetTagName(tag))
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// The admin channel is a JSON HTTP API for operators, served on its own address so it can
+// stay bound to localhost. Every request must carry "Authorization: Bearer <admin token>".
+//
+//	GET    /admin/clients                 connected clients
+//	POST   /admin/clients/{address}/kick  disconnect a client
+//	GET    /admin/bans                    active bans
+//	POST   /admin/bans                    ban an IP and disconnect its clients
+//	DELETE /admin/bans/{ip}               lift a ban
+//	GET    /admin/games                   games in progress and open lobbies
+//	GET    /admin/games/{id}              FEN and PGN of a game, in progress or archived
+//	POST   /admin/games/{id}/end          adjudicate a game
+//	DELETE /admin/lobbies/{name}          close a lobby waiting for players
+//	POST   /admin/announce                send a ServerNotice to every client
+//	GET    /admin/maintenance             maintenance state
+//	PUT    /admin/maintenance             turn maintenance mode on or off
+
+// maxAdminBody bounds the JSON bodies the admin API reads
+const maxAdminBody = 64 << 10
+
+// adminHandler builds the admin API, authorizing every request with token
+func adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/clients", handleAdminClients)
+	mux.HandleFunc("POST /admin/clients/{address}/kick", handleAdminKick)
+	mux.HandleFunc("GET /admin/bans", handleAdminBans)
+	mux.HandleFunc("POST /admin/bans", handleAdminBan)
+	mux.HandleFunc("DELETE /admin/bans/{ip}", handleAdminUnban)
+	mux.HandleFunc("GET /admin/games", handleAdminGames)
+	mux.HandleFunc("GET /admin/games/{id}", handleAdminGame)
+	mux.HandleFunc("POST /admin/games/{id}/end", handleAdminEndGame)
+	mux.HandleFunc("DELETE /admin/lobbies/{name}", handleAdminCloseLobby)
+	mux.HandleFunc("POST /admin/announce", handleAdminAnnounce)
+	mux.HandleFunc("GET /admin/maintenance", handleAdminMaintenance)
+	mux.HandleFunc("PUT /admin/maintenance", handleAdminSetMaintenance)
+	return requireAdminToken(token, mux)
+}
+
+// requireAdminToken rejects requests without the admin bearer token and logs the others
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			slog.Warn("Unauthorized admin request", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		slog.Info("Admin request", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// readJSONBody decodes a request body, refusing unknown fields
+func readJSONBody(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+// adminClient is a connected client as listed by the admin API. The signature is left out.
+type adminClient struct {
+	Address   string `json:"address"`
+	Transport string `json:"transport"` // tcp, udp or bot, empty when the client can no longer be reached
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Status    string `json:"status"`
+	Level     int    `json:"level"`
+	GameID    string `json:"game_id,omitempty"`
+}
+
+// peerTransport names the transport a peer is reached over
+func peerTransport(peer Peer) string {
+	switch p := peer.(type) {
+	case *tcpPeer:
+		return streamTransport(p.conn)
+	case *udpPeer:
+		return "udp"
+	case *botPeer:
+		return "bot"
+	}
+	return ""
+}
+
+func handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	clients := []adminClient{}
+	for _, client := range clientList.GetAllClients() {
+		entry := adminClient{
+			Address:   client.Address,
+			FirstName: client.FirstName,
+			LastName:  client.LastName,
+			Status:    client.Status,
+			Level:     client.Level,
+		}
+		if peer, exists := peers.Get(client.Address); exists {
+			entry.Transport = peerTransport(peer)
+		}
+		if client.GameID != uuid.Nil {
+			entry.GameID = client.GameID.String()
+		}
+		clients = append(clients, entry)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Address < clients[j].Address })
+	writeJSON(w, http.StatusOK, clients)
+}
+
+// kickClient tells a client it is disconnected, forgets it and closes its TCP connection.
+// Its signature is no longer valid, so it has to say hello again to play.
+func kickClient(address string, reason string) error {
+	_, known := clientList.GetClient(address)
+	peer, reachable := peers.Get(address)
+	if !known && !reachable {
+		return fmt.Errorf("no client at %s", address)
+	}
+
+	if reachable {
+		notice, err := encodeTLVFields(tlvField{String, []byte("You have been disconnected by an administrator: " + reason)})
+		if err == nil {
+			peer.Send(ServerNotice, notice)
+		}
+	}
+
+	disconnectClient(address, "kicked: "+reason)
+	if tcp, ok := peer.(*tcpPeer); ok {
+		tcp.conn.Close()
+	}
+	return nil
+}
+
+// kickIP disconnects every client connected from an IP, returning how many there were
+func kickIP(ip string, reason string) int {
+	kicked := 0
+	for _, client := range clientList.GetAllClients() {
+		if hostOf(client.Address) == ip && kickClient(client.Address, reason) == nil {
+			kicked++
+		}
+	}
+	return kicked
+}
+
+func handleAdminKick(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := readJSONBody(w, r, &body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if body.Reason == "" {
+		body.Reason = "kicked"
+	}
+
+	if err := kickClient(r.PathValue("address"), body.Reason); err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, bans.List())
+}
+
+func handleAdminBan(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		// IP, or the address of a client as listed by /admin/clients
+		Address  string   `json:"address"`
+		Duration Duration `json:"duration"` // Permanent when omitted
+		Reason   string   `json:"reason"`
+	}
+	if err := readJSONBody(w, r, &body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Duration.Duration < 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("the ban duration cannot be negative"))
+		return
+	}
+
+	ban, err := bans.Add(hostOf(body.Address), body.Duration.Duration, body.Reason)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	kicked := kickIP(ban.IP, "banned")
+	slog.Info("IP banned", "ip", ban.IP, "duration", body.Duration.Duration, "reason", ban.Reason, "kicked", kicked)
+	writeJSON(w, http.StatusCreated, ban)
+}
+
+func handleAdminUnban(w http.ResponseWriter, r *http.Request) {
+	if !bans.Remove(r.PathValue("ip")) {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("%s is not banned", r.PathValue("ip")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// gameSummary describes a game for the admin and REST APIs
+type gameSummary struct {
+	ID          string     `json:"id"`
+	Lobby       string     `json:"lobby,omitempty"`
+	White       string     `json:"white,omitempty"`
+	Black       string     `json:"black,omitempty"`
+	Players     []string   `json:"players,omitempty"`
+	State       string     `json:"state"` // waiting, playing, finished or archived
+	Moves       int        `json:"moves"`
+	Result      string     `json:"result"`
+	Termination string     `json:"termination,omitempty"`
+	Opening     string     `json:"opening,omitempty"`
+	TimeControl string     `json:"time_control,omitempty"`
+	Rated       bool       `json:"rated"`
+	Variant     Variant    `json:"variant,omitempty"` // See ParseVariant, empty for standard chess
+	Game        string     `json:"game,omitempty"`    // Game played instead of chess, see gameRegistry
+	Clock       *clockView `json:"clock,omitempty"`
+	StartFEN    string     `json:"start_fen,omitempty"` // Starting position when not the standard one
+	FEN         string     `json:"fen,omitempty"`
+	LegalMoves  []string   `json:"legal_moves,omitempty"` // In UCI notation or as actions, while the game is being played
+	PGN         string     `json:"pgn,omitempty"`
+}
+
+// clockView is the time left to each side of a timed game, in milliseconds
+type clockView struct {
+	White   int64  `json:"white_ms"`
+	Black   int64  `json:"black_ms"`
+	Running string `json:"running,omitempty"` // white or black, empty once the game is over
+}
+
+// describeGame summarizes a session, with its FEN and PGN when detailed (the caller must hold gameMutex)
+func describeGame(session GameSession, detailed bool) gameSummary {
+	game := gameSummary{
+		ID:      session.ID.String(),
+		Lobby:   session.LobbyName,
+		White:   session.WhitePlayer,
+		Black:   session.BlackPlayer,
+		Players: session.JoinedPlayers,
+		Moves:   session.Plies(),
+		Result:  session.Outcome().String(),
+		Rated:   session.Rated,
+		Variant: session.Variant.nonStandard(),
+		Game:    session.Kind,
+	}
+	switch {
+	case session.Outcome() != chess.NoOutcome:
+		game.State = "finished"
+		game.Termination = session.TerminationMethod()
+	case session.IsLocked:
+		game.State = "playing"
+	default:
+		game.State = "waiting"
+	}
+	if session.OpeningCode != "" {
+		game.Opening = session.OpeningCode + " " + session.OpeningName
+	}
+	if session.TimeControl.Initial > 0 {
+		game.TimeControl = session.TimeControl.String()
+		game.Clock = describeClock(session, game.State == "playing", time.Now())
+	}
+	if detailed {
+		game.FEN = session.FEN()
+		game.PGN = ExportPGN(session, nil)
+		if game.State == "playing" {
+			game.LegalMoves = session.TurnGame().LegalActions()
+		}
+		if session.Kind == "" && session.StartFEN != chess.StartingPosition().String() {
+			game.StartFEN = session.StartFEN
+		}
+	}
+	return game
+}
+
+// describeClock reads the clock of a timed session, the side to move's running when the game is on
+func describeClock(session GameSession, running bool, now time.Time) *clockView {
+	if !running {
+		return &clockView{White: session.Clock.White.Milliseconds(), Black: session.Clock.Black.Milliseconds()}
+	}
+	turn := session.Turn()
+	white, black := session.Clock.Remaining(turn, now)
+	view := &clockView{White: white.Milliseconds(), Black: black.Milliseconds(), Running: "black"}
+	if turn == chess.White {
+		view.Running = "white"
+	}
+	return view
+}
+
+// describeArchivedGame summarizes a finished game of the archive
+func describeArchivedGame(archived ArchivedGame, detailed bool) gameSummary {
+	game := gameSummary{
+		ID:          archived.ID.String(),
+		White:       archived.White,
+		Black:       archived.Black,
+		State:       "archived",
+		Moves:       archived.Moves,
+		Result:      archived.Result,
+		Termination: archived.Method,
+		TimeControl: archived.TimeControl,
+		Rated:       archived.Rated,
+		Variant:     archived.Variant.nonStandard(),
+		Game:        archived.Kind,
+	}
+	if archived.OpeningCode != "" {
+		game.Opening = archived.OpeningCode + " " + archived.OpeningName
+	}
+	if detailed {
+		game.StartFEN = archived.StartFEN
+		game.PGN = archived.PGN
+	}
+	return game
+}
+
+// lookupGame finds a game in progress or, since games restored from disk may only be left
+// there, in the archive, and describes it in detail
+func lookupGame(gameID uuid.UUID) (gameSummary, bool) {
+	gameMutex.RLock()
+	session, exists := GameStore[gameID]
+	var game gameSummary
+	if exists {
+		game = describeGame(session, true)
+	}
+	gameMutex.RUnlock()
+	if exists {
+		return game, true
+	}
+
+	archived, exists := gameArchive.Get(gameID)
+	if !exists {
+		return gameSummary{}, false
+	}
+	return describeArchivedGame(archived, true), true
+}
+
+func handleAdminGames(w http.ResponseWriter, r *http.Request) {
+	gameMutex.RLock()
+	games := make([]gameSummary, 0, len(GameStore))
+	for _, session := range GameStore {
+		games = append(games, describeGame(session, false))
+	}
+	gameMutex.RUnlock()
+
+	sort.Slice(games, func(i, j int) bool { return games[i].Lobby < games[j].Lobby })
+	writeJSON(w, http.StatusOK, games)
+}
+
+func handleAdminGame(w http.ResponseWriter, r *http.Request) {
+	gameID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid game ID: %w", err))
+		return
+	}
+
+	game, exists := lookupGame(gameID)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("game %s not found", gameID))
+		return
+	}
+	writeJSON(w, http.StatusOK, game)
+}
+
+func handleAdminEndGame(w http.ResponseWriter, r *http.Request) {
+	gameID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid game ID: %w", err))
+		return
+	}
+
+	var body struct {
+		Result string `json:"result"` // "1-0", "0-1" or "1/2-1/2"
+		Reason string `json:"reason"`
+	}
+	if err := readJSONBody(w, r, &body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	outcome := chess.Outcome(body.Result)
+	if outcome != chess.WhiteWon && outcome != chess.BlackWon && outcome != chess.Draw {
+		writeJSONError(w, http.StatusBadRequest, errors.New(`result must be "1-0", "0-1" or "1/2-1/2"`))
+		return
+	}
+
+	gameMutex.RLock()
+	_, exists := GameStore[gameID]
+	gameMutex.RUnlock()
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("game %s not found", gameID))
+		return
+	}
+
+	session, err := endGame(gameID, outcome, "Adjudication")
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	if body.Reason != "" {
+		notice, err := encodeTLVFields(tlvField{String, []byte("The game was adjudicated " + body.Result + ": " + body.Reason)})
+		if err == nil {
+			for _, player := range session.JoinedPlayers {
+				pushToPlayer(player, ServerNotice, notice)
+			}
+		}
+	}
+
+	gameMutex.RLock()
+	game := describeGame(session, true)
+	gameMutex.RUnlock()
+	writeJSON(w, http.StatusOK, game)
+}
+
+func handleAdminCloseLobby(w http.ResponseWriter, r *http.Request) {
+	lobbyName := r.PathValue("name")
+	players, err := closeLobby(lobbyName)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+
+	notice, err := encodeTLVFields(tlvField{String, []byte("The lobby " + lobbyName + " was closed by an administrator.")})
+	if err == nil {
+		for _, player := range players {
+			pushToPlayer(player, ServerNotice, notice)
+		}
+	}
+	slog.Info("Lobby closed", "lobby", lobbyName, "players", len(players))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// announce sends a ServerNotice to every client, returning how many received it
+func announce(message string) (int, error) {
+	notice, err := encodeTLVFields(tlvField{String, []byte(message)})
+	if err != nil {
+		return 0, err
+	}
+	return peers.Broadcast(ServerNotice, notice), nil
+}
+
+func handleAdminAnnounce(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := readJSONBody(w, r, &body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(body.Message) == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("the message is empty"))
+		return
+	}
+
+	sent, err := announce(body.Message)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
+
+// maintenanceMode turns away the requests that start something new, such as creating a
+// lobby or joining the queue, while the games in progress go on
+type maintenanceMode struct {
+	mu      sync.RWMutex
+	enabled bool
+	message string
+	since   time.Time
+}
+
+var maintenance = &maintenanceMode{}
+
+// maintenanceRefusedTags are the requests refused during maintenance
+var maintenanceRefusedTags = map[Tag]bool{
+	GameRequest:      true,
+	JoinLobbyRequest: true,
+	QueueRequest:     true,
+	BotGameRequest:   true,
+}
+
+// Set turns maintenance mode on or off with the message given to refused clients
+func (m *maintenanceMode) Set(enabled bool, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enabled && !m.enabled {
+		m.since = time.Now()
+	}
+	m.enabled, m.message = enabled, message
+}
+
+// Refuse returns ErrMaintenance when a request must be turned away
+func (m *maintenanceMode) Refuse(tag Tag) error {
+	if !maintenanceRefusedTags[tag] {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.enabled {
+		return nil
+	}
+	if m.message != "" {
+		return fmt.Errorf("%w: %s", ErrMaintenance, m.message)
+	}
+	return ErrMaintenance
+}
+
+type maintenanceState struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+func (m *maintenanceMode) State() maintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state := maintenanceState{Enabled: m.enabled, Message: m.message}
+	if m.enabled {
+		since := m.since
+		state.Since = &since
+	}
+	return state
+}
+
+func handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, maintenance.State())
+}
+
+func handleAdminSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Enabled bool   `json:"enabled"`
+		Message string `json:"message"`
+	}
+	if err := readJSONBody(w, r, &body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	maintenance.Set(body.Enabled, body.Message)
+	slog.Warn("Maintenance mode changed", "enabled", body.Enabled, "message", body.Message)
+	if body.Enabled && body.Message != "" {
+		announce(body.Message)
+	}
+	writeJSON(w, http.StatusOK, maintenance.State())
+}
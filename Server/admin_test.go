@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "admin-secret"
+
+// connectAt registers a simulated player at an address, as Hello does
+func connectAt(t *testing.T, name, address string) *recordingPeer {
+	t.Helper()
+	peer := &recordingPeer{}
+	clientList.AddClient(address, Client{FirstName: name, Address: address})
+	peers.Register(address, peer)
+	t.Cleanup(func() {
+		clientList.RemoveClient(address)
+		peers.Unregister(address)
+	})
+	return peer
+}
+
+// withBanList gives the test its own ban list on a clock it sets
+func withBanList(t *testing.T) *time.Time {
+	t.Helper()
+	saved := bans
+	t.Cleanup(func() { bans = saved })
+	var now *time.Time
+	bans, now = testBanList()
+	return now
+}
+
+func TestAdminRefusesRequestsWithoutTheToken(t *testing.T) {
+	admin := adminHandler(testAdminToken)
+	address := "192.0.2.60:4000"
+	connectAt(t, "AdminSafe", address)
+
+	for _, token := range []string{"", "wrong-secret", testAdminToken + "x"} {
+		for _, request := range []struct{ method, path, body string }{
+			{http.MethodGet, "/admin/clients", ""},
+			{http.MethodPost, "/admin/clients/" + address + "/kick", ""},
+			{http.MethodPost, "/admin/bans", `{"address": "192.0.2.60"}`},
+			{http.MethodPut, "/admin/maintenance", `{"enabled": true}`},
+		} {
+			response := serveHTTP(t, admin, request.method, request.path, token, request.body)
+			if response.Code != http.StatusUnauthorized || response.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s %s with token %q: got %d, want 401 with a challenge", request.method, request.path, token, response.Code)
+			}
+		}
+	}
+
+	if _, connected := clientList.GetClient(address); !connected || bans.IsBanned("192.0.2.60") || maintenance.State().Enabled {
+		t.Error("a refused admin request was carried out")
+	}
+}
+
+func TestAdminKick(t *testing.T) {
+	admin := adminHandler(testAdminToken)
+	address := "192.0.2.61:4000"
+	peer := connectAt(t, "AdminKicked", address)
+
+	response := serveHTTP(t, admin, http.MethodPost, "/admin/clients/"+address+"/kick", testAdminToken, `{"reason": "spam"}`)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("got %d %s, want 204", response.Code, response.Body)
+	}
+	if _, connected := clientList.GetClient(address); connected {
+		t.Error("the kicked client is still connected")
+	}
+	notices := peer.received(ServerNotice)
+	if len(notices) != 1 || string(notices[0].fields[0].Value) != "You have been disconnected by an administrator: spam" {
+		t.Errorf("got notices %+v, want the reason of the kick", notices)
+	}
+
+	for path, want := range map[string]int{
+		"/admin/clients/" + address + "/kick": http.StatusNotFound, // Already gone
+		"/admin/clients/192.0.2.99:1/kick":    http.StatusNotFound,
+	} {
+		if response := serveHTTP(t, admin, http.MethodPost, path, testAdminToken, ""); response.Code != want {
+			t.Errorf("%s: got %d, want %d", path, response.Code, want)
+		}
+	}
+}
+
+func TestAdminBans(t *testing.T) {
+	admin := adminHandler(testAdminToken)
+	now := withBanList(t)
+	connectAt(t, "AdminBannedAnn", "192.0.2.62:4000")
+	connectAt(t, "AdminBannedBob", "192.0.2.62:4001")
+	connectAt(t, "AdminNeighbour", "192.0.2.63:4000")
+
+	// A client address bans its whole IP and disconnects every client of it
+	response := serveHTTP(t, admin, http.MethodPost, "/admin/bans", testAdminToken, `{"address": "192.0.2.62:4000", "duration": "10m", "reason": "flood"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("got %d %s, want 201", response.Code, response.Body)
+	}
+	var ban Ban
+	decodeResponse(t, response, &ban)
+	if ban.IP != "192.0.2.62" || ban.Reason != "flood" || ban.Until == nil || !ban.Until.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("got %+v, want a 10 minute ban of 192.0.2.62", ban)
+	}
+	for address, want := range map[string]bool{"192.0.2.62:4000": false, "192.0.2.62:4001": false, "192.0.2.63:4000": true} {
+		if _, connected := clientList.GetClient(address); connected != want {
+			t.Errorf("%s connected %v, want %v", address, connected, want)
+		}
+	}
+
+	var listed []Ban
+	decodeResponse(t, serveHTTP(t, admin, http.MethodGet, "/admin/bans", testAdminToken, ""), &listed)
+	if len(listed) != 1 || listed[0].IP != "192.0.2.62" {
+		t.Errorf("got %+v, want the ban listed", listed)
+	}
+
+	for _, test := range []struct {
+		name, body string
+	}{
+		{"negative duration", `{"address": "192.0.2.64", "duration": "-1m"}`},
+		{"invalid address", `{"address": "nowhere"}`},
+		{"unknown field", `{"address": "192.0.2.64", "until": "tomorrow"}`},
+	} {
+		if response := serveHTTP(t, admin, http.MethodPost, "/admin/bans", testAdminToken, test.body); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400", test.name, response.Code)
+		}
+	}
+
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		if response := serveHTTP(t, admin, http.MethodDelete, "/admin/bans/192.0.2.62", testAdminToken, ""); response.Code != want {
+			t.Errorf("lifting the ban: got %d, want %d", response.Code, want)
+		}
+	}
+	if bans.IsBanned("192.0.2.62") {
+		t.Error("the lifted ban still applies")
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	admin := adminHandler(testAdminToken)
+	t.Cleanup(func() { maintenance.Set(false, "") })
+	peer := connectAt(t, "AdminWarned", "192.0.2.65:4000")
+
+	var state maintenanceState
+	response := serveHTTP(t, admin, http.MethodPut, "/admin/maintenance", testAdminToken, `{"enabled": true, "message": "back in 5 minutes"}`)
+	decodeResponse(t, response, &state)
+	if response.Code != http.StatusOK || !state.Enabled || state.Since == nil {
+		t.Fatalf("got %d %+v, want maintenance on", response.Code, state)
+	}
+	if notices := peer.received(ServerNotice); len(notices) != 1 || string(notices[0].fields[0].Value) != "back in 5 minutes" {
+		t.Errorf("got notices %+v, want the maintenance message announced", notices)
+	}
+	if err := maintenance.Refuse(GameRequest); err == nil {
+		t.Error("a new lobby is allowed during maintenance")
+	}
+	if err := maintenance.Refuse(ActionRequest); err != nil {
+		t.Errorf("a move was refused during maintenance: %v", err)
+	}
+
+	decodeResponse(t, serveHTTP(t, admin, http.MethodGet, "/admin/maintenance", testAdminToken, ""), &state)
+	if !state.Enabled || state.Message != "back in 5 minutes" {
+		t.Errorf("got %+v, want the maintenance state", state)
+	}
+
+	var off maintenanceState
+	decodeResponse(t, serveHTTP(t, admin, http.MethodPut, "/admin/maintenance", testAdminToken, `{"enabled": false}`), &off)
+	if off.Enabled || off.Since != nil || maintenance.Refuse(GameRequest) != nil {
+		t.Errorf("got %+v, want maintenance off", off)
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// analysisEngine evaluates positions for AnalyzeRequest. It defaults to the built-in
+// engine and is replaced by a UCI engine when one is configured.
+var analysisEngine Analyzer = mustAlphaBetaEngine(4)
+
+// analysisTimeout bounds a whole game analysis
+var analysisTimeout = 5 * time.Minute
+
+// analysisSlots limits how many games are analysed at the same time
+var analysisSlots = make(chan struct{}, 1)
+
+func mustAlphaBetaEngine(level int) *AlphaBetaEngine {
+	engine, err := NewAlphaBetaEngine(level)
+	if err != nil {
+		panic(err)
+	}
+	return engine
+}
+
+// Move classifications, from the drop in winning chances caused by the move
+const (
+	ClassGood       = ""
+	ClassInaccuracy = "Inaccuracy"
+	ClassMistake    = "Mistake"
+	ClassBlunder    = "Blunder"
+)
+
+// MoveAnalysis is the verdict on a single move of a game
+type MoveAnalysis struct {
+	Ply            int         // Half-move number, starting at 1
+	Color          chess.Color // Side that played the move
+	SAN            string      // Move played
+	BestMove       string      // Move the engine preferred, in algebraic notation
+	EvalBefore     int         // Evaluation before the move, in centipawns from White's point of view
+	EvalAfter      int         // Evaluation after the move, in centipawns from White's point of view
+	MateAfter      int         // Mate announced after the move (positive for White), 0 if none
+	GameOver       bool        // The move ended the game
+	Loss           int         // Centipawns the mover lost compared to the best move
+	Classification string      // Inaccuracy, Mistake, Blunder or "" for a fine move
+	Accuracy       float64     // Move accuracy between 0 and 100
+}
+
+// GameAnalysis is the full report for a game
+type GameAnalysis struct {
+	GameID        uuid.UUID
+	Moves         []MoveAnalysis
+	WhiteAccuracy float64
+	BlackAccuracy float64
+}
+
+// evaluation is a position score from White's point of view
+type evaluation struct {
+	cp   int
+	mate int
+	best *chess.Move
+	over bool // The game is over in this position
+}
+
+// checkmateCentipawns scores a position where one side is already mated
+const checkmateCentipawns = 10000
+
+// mateCentipawns turns a mate announcement into a very large centipawn score so losses can be compared
+func mateCentipawns(mate int) int {
+	if mate > 0 {
+		return checkmateCentipawns - 10*mate
+	}
+	return -checkmateCentipawns - 10*mate
+}
+
+// winningChances maps centipawns to a winning probability in [-1, 1], like lichess does
+func winningChances(cp int) float64 {
+	return 2/(1+math.Exp(-0.00368208*float64(cp))) - 1
+}
+
+// evaluatePosition asks the analyzer for the position at the end of the game,
+// handling finished positions itself since engines have nothing to search there
+func evaluatePosition(ctx context.Context, analyzer Analyzer, game *chess.Game) (evaluation, error) {
+	pos := game.Position()
+	if len(pos.ValidMoves()) == 0 {
+		if pos.Status() == chess.Checkmate {
+			// The side to move is mated
+			if pos.Turn() == chess.White {
+				return evaluation{cp: -checkmateCentipawns, over: true}, nil
+			}
+			return evaluation{cp: checkmateCentipawns, over: true}, nil
+		}
+		return evaluation{over: true}, nil
+	}
+
+	result, err := analyzer.Analyze(ctx, game)
+	if err != nil {
+		return evaluation{}, err
+	}
+
+	best, err := chess.UCINotation{}.Decode(pos, result.BestMove)
+	if err != nil {
+		return evaluation{}, fmt.Errorf("analyzer returned an invalid move %q: %w", result.BestMove, err)
+	}
+	// Use the legal move itself, which knows whether it gives check
+	for _, move := range pos.ValidMoves() {
+		if move.S1() == best.S1() && move.S2() == best.S2() && move.Promo() == best.Promo() {
+			best = move
+			break
+		}
+	}
+
+	eval := evaluation{cp: result.ScoreCP, mate: result.Mate, best: best}
+	if eval.mate != 0 {
+		eval.cp = mateCentipawns(eval.mate)
+	}
+
+	// Engines report from the side to move's point of view
+	if pos.Turn() == chess.Black {
+		eval.cp, eval.mate = -eval.cp, -eval.mate
+	}
+	return eval, nil
+}
+
+// AnalyzeGame runs every position of a game through the analyzer and classifies each move
+func AnalyzeGame(ctx context.Context, analyzer Analyzer, gameID uuid.UUID, game *chess.Game) (*GameAnalysis, error) {
+	moves := game.Moves()
+	positions := game.Positions()
+
+	// Replay the game from its starting position so the analyzer sees the move history
+	startFEN, err := chess.FEN(positions[0].String())
+	if err != nil {
+		return nil, err
+	}
+	replay := chess.NewGame(startFEN)
+
+	evals := make([]evaluation, 0, len(positions))
+	eval, err := evaluatePosition(ctx, analyzer, replay)
+	if err != nil {
+		return nil, err
+	}
+	evals = append(evals, eval)
+
+	for _, move := range moves {
+		if err := replay.Move(move); err != nil {
+			return nil, fmt.Errorf("error replaying move %s: %w", move, err)
+		}
+		eval, err := evaluatePosition(ctx, analyzer, replay)
+		if err != nil {
+			return nil, err
+		}
+		evals = append(evals, eval)
+	}
+
+	report := &GameAnalysis{GameID: gameID}
+	var accuracy [3]float64
+	var counted [3]int
+
+	for i, move := range moves {
+		pos := positions[i]
+		mover := pos.Turn()
+		before, after := evals[i], evals[i+1]
+
+		// Losses and winning chances are measured from the mover's point of view
+		sign := 1
+		if mover == chess.Black {
+			sign = -1
+		}
+		loss := sign * (before.cp - after.cp)
+		if loss < 0 {
+			loss = 0
+		}
+		drop := winningChances(sign*before.cp) - winningChances(sign*after.cp)
+
+		analysis := MoveAnalysis{
+			Ply:        i + 1,
+			Color:      mover,
+			SAN:        chess.AlgebraicNotation{}.Encode(pos, move),
+			EvalBefore: before.cp,
+			EvalAfter:  after.cp,
+			MateAfter:  after.mate,
+			GameOver:   after.over,
+			Loss:       loss,
+		}
+		if before.best != nil {
+			analysis.BestMove = chess.AlgebraicNotation{}.Encode(pos, before.best)
+		}
+
+		// Playing the engine's move is never a mistake, whatever the search noise says
+		if analysis.BestMove != analysis.SAN {
+			switch {
+			case drop >= 0.3:
+				analysis.Classification = ClassBlunder
+			case drop >= 0.2:
+				analysis.Classification = ClassMistake
+			case drop >= 0.1:
+				analysis.Classification = ClassInaccuracy
+			}
+		}
+
+		// Accuracy formula from lichess, on win percentages between 0 and 100
+		winDrop := math.Max(0, drop*50)
+		analysis.Accuracy = math.Max(0, math.Min(100, 103.1668*math.Exp(-0.04354*winDrop)-3.1669))
+		accuracy[mover] += analysis.Accuracy
+		counted[mover]++
+
+		report.Moves = append(report.Moves, analysis)
+	}
+
+	if counted[chess.White] > 0 {
+		report.WhiteAccuracy = accuracy[chess.White] / float64(counted[chess.White])
+	}
+	if counted[chess.Black] > 0 {
+		report.BlackAccuracy = accuracy[chess.Black] / float64(counted[chess.Black])
+	}
+	return report, nil
+}
+
+// Comment returns the PGN comment describing the move
+func (m MoveAnalysis) Comment() string {
+	// There is nothing left to evaluate once the game is over
+	comment := ""
+	if !m.GameOver {
+		comment = fmt.Sprintf("[%%eval %s]", formatEval(m.EvalAfter, m.MateAfter))
+	}
+	if m.Classification != ClassGood {
+		comment += fmt.Sprintf(" %s.", m.Classification)
+		if m.BestMove != "" {
+			comment += fmt.Sprintf(" %s was best.", m.BestMove)
+		}
+	}
+	return strings.TrimSpace(comment)
+}
+
+// formatEval formats an evaluation the way PGN %eval comments expect: pawns, or #N for mates
+func formatEval(cp int, mate int) string {
+	if mate != 0 {
+		return fmt.Sprintf("#%d", mate)
+	}
+	return strconv.FormatFloat(float64(cp)/100, 'f', 2, 64)
+}
+
+// maxStoredAnalyses bounds the reports kept in memory, the least recently used going first.
+// A game whose report was dropped is analysed again when asked for.
+const maxStoredAnalyses = 200
+
+// analysisCache keeps the last reports asked for, so a game is not analysed again for every
+// export
+type analysisCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // Game IDs, most recently used first
+	reports  map[uuid.UUID]*list.Element
+}
+
+type cachedAnalysis struct {
+	gameID uuid.UUID
+	report *GameAnalysis
+}
+
+// analyses keeps finished reports so they are attached to later exports of the game
+var analyses = newAnalysisCache(maxStoredAnalyses)
+
+func newAnalysisCache(capacity int) *analysisCache {
+	return &analysisCache{capacity: capacity, order: list.New(), reports: make(map[uuid.UUID]*list.Element)}
+}
+
+// Get returns the report of a game, marking it as used
+func (c *analysisCache) Get(gameID uuid.UUID) (*GameAnalysis, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, exists := c.reports[gameID]
+	if !exists {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(cachedAnalysis).report, true
+}
+
+// Put stores the report of a game, dropping the least recently used one when full
+func (c *analysisCache) Put(gameID uuid.UUID, report *GameAnalysis) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, exists := c.reports[gameID]; exists {
+		element.Value = cachedAnalysis{gameID, report}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.reports[gameID] = c.order.PushFront(cachedAnalysis{gameID, report})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.reports, oldest.Value.(cachedAnalysis).gameID)
+	}
+}
+
+// Len returns the number of reports kept
+func (c *analysisCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// GetGameAnalysis returns the stored analysis of a game, if it was analysed
+func GetGameAnalysis(gameID uuid.UUID) (*GameAnalysis, bool) {
+	return analyses.Get(gameID)
+}
+
+// finishedGame returns the session of a game, live or archived. The chess game of a live
+// session is a copy, for the session to be read without holding gameMutex.
+func finishedGame(gameID uuid.UUID) (GameSession, error) {
+	gameMutex.RLock()
+	session, ok := GameStore[gameID]
+	if ok && session.Board == nil {
+		session.Game = session.Game.Clone()
+	}
+	gameMutex.RUnlock()
+	if ok {
+		return session, nil
+	}
+
+	// Games restored from disk or removed from the GameStore are only left in the archive
+	archived, exists := gameArchive.Get(gameID)
+	if !exists {
+		return GameSession{}, fmt.Errorf("game session not found for gameID %v", gameID)
+	}
+	if archived.Kind != "" {
+		return GameSession{}, fmt.Errorf("only chess games can be analysed, not %s", archived.Kind)
+	}
+	session, err := archived.session()
+	if err != nil {
+		return GameSession{}, fmt.Errorf("error replaying archived game %v: %w", gameID, err)
+	}
+	return session, nil
+}
+
+// runGameAnalysis analyses a finished game, live or archived, stores the report and returns
+// it with the game's session. Cancelling ctx stops the analysis.
+func runGameAnalysis(ctx context.Context, gameID uuid.UUID) (GameSession, *GameAnalysis, error) {
+	session, err := finishedGame(gameID)
+	if err != nil {
+		return GameSession{}, nil, err
+	}
+	if report, exists := GetGameAnalysis(gameID); exists {
+		return session, report, nil
+	}
+
+	if session.Board != nil {
+		return GameSession{}, nil, fmt.Errorf("only chess games can be analysed, not %s", session.Kind)
+	}
+	if session.Outcome() == chess.NoOutcome {
+		return GameSession{}, nil, fmt.Errorf("game %v is still in progress", gameID)
+	}
+	if session.Variant != Standard && session.Variant != FromPosition {
+		// The engines play standard chess by the chess package's rules
+		return GameSession{}, nil, fmt.Errorf("%s games cannot be analysed", session.Variant.PGNName())
+	}
+
+	select {
+	case analysisSlots <- struct{}{}:
+	case <-ctx.Done():
+		return GameSession{}, nil, ctx.Err()
+	}
+	defer func() { <-analysisSlots }()
+
+	ctx, cancel := context.WithTimeout(ctx, analysisTimeout)
+	defer cancel()
+
+	started := time.Now()
+	report, err := AnalyzeGame(ctx, analysisEngine, gameID, session.Game)
+	if err != nil {
+		return GameSession{}, nil, err
+	}
+	gameLogger(gameID).Info("Game analysed", "moves", len(report.Moves), "duration", time.Since(started))
+
+	analyses.Put(gameID, report)
+	return session, report, nil
+}
+
+// encodeAnalysisResponse builds the AnalyzeResponse payload: the annotated PGN, both accuracies,
+// then one ByteData group per move (ply, SAN, evaluation, best move, classification)
+func encodeAnalysisResponse(session GameSession, report *GameAnalysis) ([]byte, error) {
+	fields := []tlvField{
+		{String, []byte(ExportPGN(session, report))},
+		{String, []byte(strconv.FormatFloat(report.WhiteAccuracy, 'f', 1, 64))},
+		{String, []byte(strconv.FormatFloat(report.BlackAccuracy, 'f', 1, 64))},
+	}
+
+	for _, move := range report.Moves {
+		group, err := encodeTLVFields(
+			tlvField{Int, []byte(strconv.Itoa(move.Ply))},
+			tlvField{String, []byte(move.SAN)},
+			tlvField{String, []byte(formatEval(move.EvalAfter, move.MateAfter))},
+			tlvField{String, []byte(move.BestMove)},
+			tlvField{String, []byte(move.Classification)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, tlvField{ByteData, group})
+	}
+	return encodeTLVFields(fields...)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// playedGame plays the moves in a new game between white and black
+func playedGame(t *testing.T, white, black string, moves []string) uuid.UUID {
+	t.Helper()
+	gameID, err := createMatchedGame(white, black, TimeControl{}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { gameEvents.Close(gameID) })
+	for i, move := range moves {
+		player := white
+		if i%2 == 1 {
+			player = black
+		}
+		if _, err := MoveInLobby(gameID, move, player); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return gameID
+}
+
+// forgetGame removes a game from the GameStore, leaving its archived record as after a restart
+func forgetGame(gameID uuid.UUID) {
+	gameMutex.Lock()
+	delete(LobbyNameToUUID, GameStore[gameID].LobbyName)
+	delete(GameStore, gameID)
+	gameMutex.Unlock()
+}
+
+func TestArchivedGameIsAnalysed(t *testing.T) {
+	mated := playedGame(t, "ArchiveAnn", "ArchiveBob", []string{"f3", "e5", "g4", "Qh4"})
+	forgetGame(mated)
+
+	session, report, err := runGameAnalysis(context.Background(), mated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Moves) != 4 || session.Outcome() != chess.BlackWon {
+		t.Errorf("%d moves analysed in a game won by %s, want 4 in a game Black won", len(report.Moves), session.Outcome())
+	}
+	if pgn := ExportPGN(session, report); !strings.Contains(pgn, `[White "ArchiveAnn"]`) || !strings.Contains(pgn, "2. g4") {
+		t.Errorf("the PGN of the analysed archived game is not the game played:\n%s", pgn)
+	}
+}
+
+func TestArchivedGameEndedByTheServerIsAnalysed(t *testing.T) {
+	gameID := playedGame(t, "ForfeitAnn", "ForfeitBob", []string{"e4", "e5"})
+	if _, err := endGame(gameID, chess.WhiteWon, "Time forfeit"); err != nil {
+		t.Fatal(err)
+	}
+	forgetGame(gameID)
+
+	session, report, err := runGameAnalysis(context.Background(), gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Moves) != 2 || session.Outcome() != chess.WhiteWon || session.TerminationMethod() != "Time forfeit" {
+		t.Errorf("%d moves analysed in a game ended by %s with %s, want 2 in a game White won on time",
+			len(report.Moves), session.TerminationMethod(), session.Outcome())
+	}
+}
+
+func TestUnknownGameIsNotAnalysed(t *testing.T) {
+	if _, _, err := runGameAnalysis(context.Background(), uuid.New()); err == nil {
+		t.Error("a game neither live nor archived was analysed")
+	}
+}
+
+// analyzeRequest builds the AnalyzeRequest of a game
+func analyzeRequest(t *testing.T, signature string, gameID uuid.UUID) []byte {
+	t.Helper()
+	return signRequest(t, signature,
+		tlvField{AnalyzeRequest, []byte("AnalyzeRequest")}, tlvField{String, []byte(gameID.String())})
+}
+
+// blockAnalyses takes every analysis slot until the test releases them, returning the
+// release, and gives the test its own request gate to drain
+func blockAnalyses(t *testing.T) func() {
+	t.Helper()
+	gate := inFlightRequests
+	inFlightRequests = &requestGate{}
+	t.Cleanup(func() { inFlightRequests = gate })
+
+	for range cap(analysisSlots) {
+		analysisSlots <- struct{}{}
+	}
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			for range cap(analysisSlots) {
+				<-analysisSlots
+			}
+		})
+	}
+	t.Cleanup(release)
+	return release
+}
+
+func TestShutdownCancelsAnalyses(t *testing.T) {
+	gameID := playedGame(t, "DrainAnn", "DrainBob", []string{"f3", "e5", "g4", "Qh4"})
+	conn := connectClient(t, "DrainAnn", "ann-signature", gameID)
+	blockAnalyses(t)
+
+	if err := HandleAnalyzeRequest(conn, nil, nil, analyzeRequest(t, "ann-signature", gameID), true); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := inFlightRequests.Drain(ctx); err != nil {
+		t.Fatalf("the shutdown did not wait for the analysis to be cancelled: %v", err)
+	}
+	if tag, _ := conn.next(t); tag != ErrorResponse {
+		t.Errorf("got %s, want the cancelled analysis refused", GetTagName(tag))
+	}
+
+	// Once draining, analyses are refused at once
+	if err := HandleAnalyzeRequest(conn, nil, nil, analyzeRequest(t, "ann-signature", gameID), true); err != nil {
+		t.Fatal(err)
+	}
+	if tag, _ := conn.next(t); tag != ErrorResponse {
+		t.Errorf("got %s, want the analysis refused while shutting down", GetTagName(tag))
+	}
+}
+
+func TestAnalysisIsNotSentToAClientGone(t *testing.T) {
+	gameID := playedGame(t, "GoneAnn", "GoneBob", []string{"f3", "e5", "g4", "Qh4"})
+	conn := connectClient(t, "GoneAnn", "ann-signature", gameID)
+	release := blockAnalyses(t)
+
+	if err := HandleAnalyzeRequest(conn, nil, nil, analyzeRequest(t, "ann-signature", gameID), true); err != nil {
+		t.Fatal(err)
+	}
+	disconnectClient(conn.addr.String(), "connection closed")
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := inFlightRequests.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if len(conn.pending) != 0 {
+		t.Errorf("%d bytes written to the connection of a client gone", len(conn.pending))
+	}
+}
+
+func TestAnalysisCacheDropsTheLeastRecentlyUsed(t *testing.T) {
+	cache := newAnalysisCache(2)
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+	cache.Put(first, &GameAnalysis{GameID: first})
+	cache.Put(second, &GameAnalysis{GameID: second})
+	cache.Get(first) // Used again, the second game's report is now the oldest
+	cache.Put(third, &GameAnalysis{GameID: third})
+
+	for gameID, want := range map[uuid.UUID]bool{first: true, second: false, third: true} {
+		if report, kept := cache.Get(gameID); kept != want || (kept && report.GameID != gameID) {
+			t.Errorf("report of %s kept %v, want %v", gameID, kept, want)
+		}
+	}
+	cache.Put(third, &GameAnalysis{GameID: third, WhiteAccuracy: 90})
+	if report, _ := cache.Get(third); cache.Len() != 2 || report.WhiteAccuracy != 90 {
+		t.Errorf("storing a report again left %d reports, the last one %+v", cache.Len(), report)
+	}
+}
+
+func TestAnalysedGameIsNotAnalysedAgain(t *testing.T) {
+	gameID := playedGame(t, "CachedAnn", "CachedBob", []string{"f3", "e5", "g4", "Qh4"})
+	_, report, err := runGameAnalysis(context.Background(), gameID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With every slot taken, only a stored report can be returned
+	blockAnalyses(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, again, err := runGameAnalysis(ctx, gameID); err != nil || again != report {
+		t.Errorf("the analysis was run again: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The REST API opens the lobbies, games and history to web front-ends and scripts that do
+// not speak TLV. It reads and changes the same GameStore as the binary protocol, so a lobby
+// created here can be joined by a CLI player and the other way round.
+//
+//	GET  /lobbies            lobbies waiting for a player
+//	GET  /games/{id}         FEN, PGN, clocks and players of a game, in progress or archived
+//	GET  /games/{id}/stream  moves, clocks and result as they happen, see stream.go
+//	GET  /players/{name}     a player's presence, current games, record and recent games
+//	POST /games              open a lobby, needs "Authorization: Bearer <player token>"
+//	GET  /openapi.json       the OpenAPI document describing the API
+
+// openAPIDocument describes the REST API, keep it in step with apiHandler
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// recentGamesShown is the number of archived games listed on a player's profile
+const recentGamesShown = 10
+
+// apiHandler builds the REST API, tokens mapping the players allowed to create lobbies to
+// their bearer token
+func apiHandler(tokens map[string]string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /lobbies", handleAPILobbies)
+	mux.HandleFunc("GET /games/{id}", handleAPIGame)
+	mux.HandleFunc("GET /games/{id}/stream", handleAPIGameStream)
+	mux.HandleFunc("GET /players/{name}", handleAPIPlayer)
+	mux.Handle("POST /games", requirePlayerToken(tokens, handleAPICreateGame))
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+	return mux
+}
+
+// requirePlayerToken authenticates a request by its bearer token and passes the player it
+// belongs to on to next
+func requirePlayerToken(tokens map[string]string, next func(w http.ResponseWriter, r *http.Request, player string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		player := ""
+		if ok {
+			// Every token is compared so the time taken tells nothing about which one matched
+			for name, token := range tokens {
+				if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+					player = name
+				}
+			}
+		}
+		if player == "" {
+			slog.Warn("Unauthorized REST API request", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+			return
+		}
+		next(w, r, player)
+	})
+}
+
+func handleAPILobbies(w http.ResponseWriter, r *http.Request) {
+	gameMutex.RLock()
+	lobbies := []gameSummary{}
+	for _, session := range GameStore {
+		if !session.IsLocked {
+			lobbies = append(lobbies, describeGame(session, false))
+		}
+	}
+	gameMutex.RUnlock()
+
+	sort.Slice(lobbies, func(i, j int) bool { return lobbies[i].Lobby < lobbies[j].Lobby })
+	writeJSON(w, http.StatusOK, lobbies)
+}
+
+func handleAPIGame(w http.ResponseWriter, r *http.Request) {
+	gameID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid game ID: %w", err))
+		return
+	}
+
+	game, exists := lookupGame(gameID)
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("game %s not found", gameID))
+		return
+	}
+	writeJSON(w, http.StatusOK, game)
+}
+
+// playerProfile is what the REST API tells about a player
+type playerProfile struct {
+	Name   string        `json:"name"`
+	Online bool          `json:"online"`
+	Status string        `json:"status,omitempty"`
+	Level  int           `json:"level,omitempty"`
+	Games  []gameSummary `json:"games"`  // Lobbies and games in progress
+	Record playerRecord  `json:"record"` // Over the archived games
+	Recent []gameSummary `json:"recent"` // Last archived games, most recent first
+}
+
+type playerRecord struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+}
+
+func handleAPIPlayer(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	profile := playerProfile{Name: name, Games: []gameSummary{}, Recent: []gameSummary{}}
+
+	if clients := clientList.GetClientByName(name); len(clients) > 0 {
+		profile.Online = true
+		profile.Status = clients[0].Status
+		profile.Level = clients[0].Level
+	}
+
+	gameMutex.RLock()
+	for _, session := range GameStore {
+		if session.WhitePlayer == name || session.BlackPlayer == name || slices.Contains(session.JoinedPlayers, name) {
+			profile.Games = append(profile.Games, describeGame(session, false))
+		}
+	}
+	gameMutex.RUnlock()
+	sort.Slice(profile.Games, func(i, j int) bool { return profile.Games[i].Lobby < profile.Games[j].Lobby })
+
+	archived := gameArchive.Find(ArchiveFilter{Player: name})
+	for i, game := range archived {
+		switch {
+		case game.Result == "1/2-1/2":
+			profile.Record.Draws++
+		case game.Result == "1-0" && game.White == name, game.Result == "0-1" && game.Black == name:
+			profile.Record.Wins++
+		case game.Result == "1-0" || game.Result == "0-1":
+			profile.Record.Losses++
+		}
+		if i < recentGamesShown {
+			profile.Recent = append(profile.Recent, describeArchivedGame(game, false))
+		}
+	}
+
+	if !profile.Online && len(profile.Games) == 0 && len(archived) == 0 {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("player %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// handleAPICreateGame opens a lobby for the authenticated player, like a GameRequest does,
+// under the same maintenance mode, rate limits and lobby limits
+func handleAPICreateGame(w http.ResponseWriter, r *http.Request, player string) {
+	var body struct {
+		Lobby    string `json:"lobby"`    // "Lobby-<player>" when empty, as for the binary protocol
+		Variant  string `json:"variant"`  // Chess variant or another game, see ParseGameSetup
+		Position string `json:"position"` // Chess960 position number or FEN, see Rules.StartingFEN
+	}
+	if err := readJSONBody(w, r, &body); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	lobbyName := strings.TrimSpace(body.Lobby)
+	if lobbyName == "" {
+		lobbyName = "Lobby-" + player
+	}
+	setup, err := ParseGameSetup(body.Variant, body.Position)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := maintenance.Refuse(GameRequest); err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	// The account shares its bucket with the player's binary requests
+	if !rateLimiter.Allow("account:"+player, GameRequest, time.Now()) {
+		serverMetrics.rateLimited.Inc(GetTagName(GameRequest), "account")
+		writeJSONError(w, http.StatusTooManyRequests, ErrRateLimited)
+		return
+	}
+
+	gameID, err := createNewGame(player, player, lobbyName, setup)
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err)
+		return
+	}
+	slog.Info("Lobby created through the REST API", "player", player, "lobby", lobbyName, "game_id", gameID, "variant", setup.Variant, "kind", setup.Kind, "remote", r.RemoteAddr)
+
+	game, _ := lookupGame(gameID)
+	w.Header().Set("Location", "/games/"+gameID.String())
+	writeJSON(w, http.StatusCreated, game)
+}
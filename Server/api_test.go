@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// serveHTTP sends a request to handler with a bearer token, none when token is empty
+func serveHTTP(t *testing.T, handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+// decodeResponse reads the JSON body of a response into v
+func decodeResponse(t *testing.T, response *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(response.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", response.Body.String(), err)
+	}
+}
+
+// closeLobbyAfter closes a lobby created by a test once it ends
+func closeLobbyAfter(t *testing.T, lobbyName string) {
+	t.Helper()
+	t.Cleanup(func() { closeLobby(lobbyName) })
+}
+
+func TestAPIRefusesRequestsWithoutAValidToken(t *testing.T) {
+	api := apiHandler(map[string]string{"ApiAnn": "ann-token"})
+	for _, test := range []struct {
+		name   string
+		header string
+	}{
+		{"no token", ""},
+		{"wrong token", "Bearer bob-token"},
+		{"empty token", "Bearer "},
+		{"other scheme", "Basic ann-token"},
+		{"token without its scheme", "ann-token"},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/games", strings.NewReader(`{"lobby": "Lobby-Refused"}`))
+		if test.header != "" {
+			request.Header.Set("Authorization", test.header)
+		}
+		response := httptest.NewRecorder()
+		api.ServeHTTP(response, request)
+		if response.Code != http.StatusUnauthorized || response.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: got %d, want 401 with a challenge", test.name, response.Code)
+		}
+	}
+
+	gameMutex.RLock()
+	_, created := LobbyNameToUUID["Lobby-Refused"]
+	gameMutex.RUnlock()
+	if created {
+		t.Error("a refused request created its lobby")
+	}
+}
+
+func TestAPICreatesLobbiesForTheTokensPlayer(t *testing.T) {
+	api := apiHandler(map[string]string{"ApiAnn": "ann-token", "ApiBob": "bob-token"})
+	lobbyName := "Lobby-ApiBob-" + uuid.NewString()[:8]
+	closeLobbyAfter(t, lobbyName)
+
+	response := serveHTTP(t, api, http.MethodPost, "/games", "bob-token", `{"lobby": "`+lobbyName+`", "variant": "chess960", "position": "518"}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("got %d %s, want 201", response.Code, response.Body)
+	}
+	var game gameSummary
+	decodeResponse(t, response, &game)
+	if response.Header().Get("Location") != "/games/"+game.ID {
+		t.Errorf("Location %q for game %s", response.Header().Get("Location"), game.ID)
+	}
+	if game.Lobby != lobbyName || game.State != "waiting" || game.Variant != Chess960 || len(game.Players) != 1 || game.Players[0] != "ApiBob" {
+		t.Errorf("got %+v, want a waiting Chess960 lobby with the token's player", game)
+	}
+
+	gameMutex.RLock()
+	session := GameStore[uuid.MustParse(game.ID)]
+	gameMutex.RUnlock()
+	if session.Owner != "ApiBob" || session.CreatorName != "ApiBob" {
+		t.Errorf("lobby owned by %q and created by %q, want ApiBob", session.Owner, session.CreatorName)
+	}
+
+	// The lobby is listed and described like one created over TLV
+	var lobbies []gameSummary
+	decodeResponse(t, serveHTTP(t, api, http.MethodGet, "/lobbies", "", ""), &lobbies)
+	listed := false
+	for _, lobby := range lobbies {
+		listed = listed || lobby.ID == game.ID
+	}
+	if !listed {
+		t.Error("the lobby is not listed")
+	}
+	var described gameSummary
+	decodeResponse(t, serveHTTP(t, api, http.MethodGet, "/games/"+game.ID, "", ""), &described)
+	if described.StartFEN != chess960FEN(518) || described.FEN != described.StartFEN {
+		t.Errorf("got start %q and position %q, want Chess960 position 518", described.StartFEN, described.FEN)
+	}
+
+	// The default lobby is named after the player, a second one under that name conflicts
+	closeLobbyAfter(t, "Lobby-ApiAnn")
+	for _, want := range []int{http.StatusCreated, http.StatusConflict} {
+		if response := serveHTTP(t, api, http.MethodPost, "/games", "ann-token", ""); response.Code != want {
+			t.Errorf("got %d %s, want %d", response.Code, response.Body, want)
+		}
+	}
+}
+
+func TestAPIRefusedLobbies(t *testing.T) {
+	api := apiHandler(map[string]string{"ApiEve": "eve-token"})
+	closeLobbyAfter(t, "Lobby-ApiEve")
+	for _, test := range []struct {
+		name  string
+		body  string
+		setup func(t *testing.T)
+		want  int
+	}{
+		{name: "unknown field", body: `{"lobyb": "x"}`, want: http.StatusBadRequest},
+		{name: "unknown variant", body: `{"variant": "bughouse"}`, want: http.StatusBadRequest},
+		{name: "maintenance", setup: func(t *testing.T) {
+			maintenance.Set(true, "upgrade")
+			t.Cleanup(func() { maintenance.Set(false, "") })
+		}, want: http.StatusServiceUnavailable},
+		{name: "rate limited", setup: func(t *testing.T) {
+			withRateLimits(t, map[string]RateLimit{"GameRequest": {Rate: 0.001, Burst: 1}})
+			rateLimiter.Allow("account:ApiEve", GameRequest, time.Now())
+		}, want: http.StatusTooManyRequests},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.setup != nil {
+				test.setup(t)
+			}
+			if response := serveHTTP(t, api, http.MethodPost, "/games", "eve-token", test.body); response.Code != test.want {
+				t.Errorf("got %d %s, want %d", response.Code, response.Body, test.want)
+			}
+		})
+	}
+}
+
+func TestAPIGameLookup(t *testing.T) {
+	api := apiHandler(nil)
+	for path, want := range map[string]int{
+		"/games/not-a-uuid":          http.StatusBadRequest,
+		"/games/" + uuid.NewString(): http.StatusNotFound,
+		"/players/NobodyAtAll":       http.StatusNotFound,
+		"/openapi.json":              http.StatusOK,
+	} {
+		if response := serveHTTP(t, api, http.MethodGet, path, "", ""); response.Code != want {
+			t.Errorf("%s: got %d, want %d", path, response.Code, want)
+		}
+	}
+	// Without tokens nobody may create a lobby
+	if response := serveHTTP(t, api, http.MethodPost, "/games", "any", ""); response.Code != http.StatusUnauthorized {
+		t.Errorf("got %d, want 401 without any token configured", response.Code)
+	}
+}
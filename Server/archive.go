@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// ArchivedGame is the record kept for a finished game
+type ArchivedGame struct {
+	ID          uuid.UUID
+	White       string
+	Black       string
+	Result      string // "1-0", "0-1" or "1/2-1/2"
+	Method      string // How the game ended, e.g. "Checkmate"
+	OpeningCode string
+	OpeningName string
+	TimeControl string
+	Rated       bool
+	Variant     Variant `json:",omitempty"` // Standard chess when empty
+	Kind        string  `json:",omitempty"` // Game of gameRegistry, chess when empty
+	StartFEN    string  `json:",omitempty"` // Starting position when not the standard one
+	Moves       int     // Number of half-moves played
+	StartedAt   time.Time
+	EndedAt     time.Time
+	PGN         string
+}
+
+// GameArchive keeps finished games, oldest first
+type GameArchive struct {
+	mu    sync.RWMutex
+	games []ArchivedGame
+	index map[uuid.UUID]int
+}
+
+// gameArchive holds every game finished since the server started
+var gameArchive = NewGameArchive()
+
+func NewGameArchive() *GameArchive {
+	return &GameArchive{index: make(map[uuid.UUID]int)}
+}
+
+// Add archives a finished game session. Archiving the same game twice replaces the first record.
+func (a *GameArchive) Add(session GameSession) {
+	white, black := session.WhitePlayer, session.BlackPlayer
+	if white == "" && len(session.JoinedPlayers) > 0 {
+		white = session.JoinedPlayers[0]
+	}
+	if black == "" && len(session.JoinedPlayers) > 1 {
+		black = session.JoinedPlayers[1]
+	}
+
+	record := ArchivedGame{
+		ID:          session.ID,
+		White:       white,
+		Black:       black,
+		Result:      session.Outcome().String(),
+		Method:      session.TerminationMethod(),
+		OpeningCode: session.OpeningCode,
+		OpeningName: session.OpeningName,
+		Rated:       session.Rated,
+		Variant:     session.Variant.nonStandard(),
+		Kind:        session.Kind,
+		Moves:       session.Plies(),
+		StartedAt:   session.StartedAt,
+		EndedAt:     time.Now(),
+		PGN:         ExportPGN(session, nil),
+	}
+	if session.TimeControl.Initial > 0 {
+		record.TimeControl = session.TimeControl.String()
+	}
+	if session.Kind == "" && session.StartFEN != chess.StartingPosition().String() {
+		record.StartFEN = session.StartFEN
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if i, exists := a.index[record.ID]; exists {
+		a.games[i] = record
+		return
+	}
+	a.index[record.ID] = len(a.games)
+	a.games = append(a.games, record)
+}
+
+// Get returns the archived record of a game
+func (a *GameArchive) Get(gameID uuid.UUID) (ArchivedGame, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	i, exists := a.index[gameID]
+	if !exists {
+		return ArchivedGame{}, false
+	}
+	return a.games[i], true
+}
+
+// session rebuilds the session of an archived chess game by replaying the moves of its PGN,
+// for the game to be analysed once it left the GameStore
+func (g ArchivedGame) session() (GameSession, error) {
+	if g.Kind != "" {
+		return GameSession{}, fmt.Errorf("only chess games can be replayed, not %s", g.Kind)
+	}
+	session := GameSession{
+		ID:          g.ID,
+		WhitePlayer: g.White,
+		BlackPlayer: g.Black,
+		Rated:       g.Rated,
+		Variant:     g.Variant,
+		StartFEN:    g.StartFEN,
+		StartedAt:   g.StartedAt,
+		IsLocked:    true,
+	}
+	if session.Variant == "" {
+		session.Variant = Standard
+	}
+	if session.StartFEN == "" {
+		session.StartFEN = chess.StartingPosition().String()
+	}
+	if g.TimeControl != "" {
+		var err error
+		if session.TimeControl, err = ParseTimeControl(g.TimeControl); err != nil {
+			return GameSession{}, err
+		}
+	}
+
+	// The movetext follows the tags: move numbers, moves and the result
+	var moves []string
+	for _, line := range strings.Split(g.PGN, "\n") {
+		if strings.HasPrefix(line, "[Event \"") {
+			session.LobbyName = strings.TrimSuffix(strings.TrimPrefix(line, "[Event \""), "\"]")
+		}
+		if strings.HasPrefix(line, "[") {
+			continue
+		}
+		for _, token := range strings.Fields(line) {
+			switch {
+			case strings.HasSuffix(token, "."), token == g.Result, token == "*":
+			default:
+				moves = append(moves, token)
+			}
+		}
+	}
+	if err := replayMoves(&session, moves); err != nil {
+		return GameSession{}, err
+	}
+
+	// A game the server ended, e.g. on time, is over only by its record
+	if session.Outcome() == chess.NoOutcome {
+		switch chess.Outcome(g.Result) {
+		case chess.WhiteWon:
+			session.Game.Resign(chess.Black)
+		case chess.BlackWon:
+			session.Game.Resign(chess.White)
+		case chess.Draw:
+			session.Game.Draw(chess.DrawOffer)
+		}
+	}
+	session.Termination = g.Method
+	return session, nil
+}
+
+// Snapshot returns a copy of every archived game, oldest first
+func (a *GameArchive) Snapshot() []ArchivedGame {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]ArchivedGame(nil), a.games...)
+}
+
+// Load adds previously saved games to the archive
+func (a *GameArchive) Load(games []ArchivedGame) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, game := range games {
+		if i, exists := a.index[game.ID]; exists {
+			a.games[i] = game
+			continue
+		}
+		a.index[game.ID] = len(a.games)
+		a.games = append(a.games, game)
+	}
+}
+
+// Len returns the number of archived games
+func (a *GameArchive) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.games)
+}
+
+// ArchiveFilter selects archived games. Empty fields match every game.
+type ArchiveFilter struct {
+	// Opening is either an ECO code prefix ("C6" matches C60 to C69, "B" every B opening)
+	// or part of an opening name ("sicilian"), compared without case
+	Opening string
+	// Player matches games where either side has this name
+	Player string
+	// Limit caps the number of games returned, 0 for no limit
+	Limit int
+}
+
+// Find returns the archived games matching the filter, most recent first
+func (a *GameArchive) Find(filter ArchiveFilter) []ArchivedGame {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var found []ArchivedGame
+	for i := len(a.games) - 1; i >= 0; i-- {
+		game := a.games[i]
+		if !filter.matches(game) {
+			continue
+		}
+		found = append(found, game)
+		if filter.Limit > 0 && len(found) >= filter.Limit {
+			break
+		}
+	}
+	return found
+}
+
+func (f ArchiveFilter) matches(game ArchivedGame) bool {
+	if f.Player != "" && game.White != f.Player && game.Black != f.Player {
+		return false
+	}
+
+	opening := strings.TrimSpace(f.Opening)
+	if opening == "" {
+		return true
+	}
+	if isECOCode(opening) {
+		return strings.HasPrefix(game.OpeningCode, strings.ToUpper(opening))
+	}
+	return strings.Contains(strings.ToLower(game.OpeningName), strings.ToLower(opening))
+}
+
+// isECOCode reports whether s looks like a full or partial ECO code: a volume letter A-E and up to two digits
+func isECOCode(s string) bool {
+	if len(s) == 0 || len(s) > 3 {
+		return false
+	}
+	volume := s[0] &^ 0x20 // Upper case
+	if volume < 'A' || volume > 'E' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// maxArchiveResponseGames caps how many games one ArchiveResponse lists
+const maxArchiveResponseGames = 50
+
+// encodeArchiveResponse builds the ArchiveResponse payload: one ByteData group per game
+// (game UUID, white, black, result, ECO code, opening name, number of moves, end date).
+// Games that would not fit in a single TLV are left out.
+func encodeArchiveResponse(games []ArchivedGame) ([]byte, error) {
+	var fields []tlvField
+	size := 0
+	for _, game := range games {
+		group, err := encodeTLVFields(
+			tlvField{String, []byte(game.ID.String())},
+			tlvField{String, []byte(game.White)},
+			tlvField{String, []byte(game.Black)},
+			tlvField{String, []byte(game.Result)},
+			tlvField{String, []byte(game.OpeningCode)},
+			tlvField{String, []byte(game.OpeningName)},
+			tlvField{Int, []byte(strconv.Itoa((game.Moves + 1) / 2))},
+			tlvField{String, []byte(game.EndedAt.Format(time.DateTime))},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if size+len(group)+3 > maxTLVLength {
+			break
+		}
+		size += len(group) + 3
+		fields = append(fields, tlvField{ByteData, group})
+	}
+	return encodeTLVFields(fields...)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban keeps an IP address off the server, until Until or for good when Until is nil
+type Ban struct {
+	IP     string     `json:"ip"`
+	Reason string     `json:"reason,omitempty"`
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// Active reports whether the ban still applies at the given time
+func (b Ban) Active(now time.Time) bool {
+	return b.Until == nil || now.Before(*b.Until)
+}
+
+// BanList holds the banned addresses. Bans are by IP, so every port of a banned host is refused.
+type BanList struct {
+	mu   sync.Mutex
+	bans map[string]Ban
+	now  func() time.Time // The clock bans start and expire by
+}
+
+// bans is checked by both transports before a connection or datagram is handled
+var bans = NewBanList()
+
+func NewBanList() *BanList {
+	return &BanList{bans: make(map[string]Ban), now: time.Now}
+}
+
+// Add bans an IP for the given duration, 0 meaning permanently, replacing any previous ban
+func (bl *BanList) Add(ip string, duration time.Duration, reason string) (Ban, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return Ban{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	now := bl.now()
+	ban := Ban{IP: parsed.String(), Reason: reason, Since: now}
+	if duration > 0 {
+		until := now.Add(duration)
+		ban.Until = &until
+	}
+
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.bans[ban.IP] = ban
+	return ban, nil
+}
+
+// Remove lifts the ban on an IP, reporting whether there was one
+func (bl *BanList) Remove(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	_, exists := bl.bans[ip]
+	delete(bl.bans, ip)
+	return exists
+}
+
+// IsBanned reports whether an IP is currently banned
+func (bl *BanList) IsBanned(ip string) bool {
+	now := bl.now()
+
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	ban, exists := bl.bans[ip]
+	if exists && !ban.Active(now) {
+		delete(bl.bans, ip)
+		return false
+	}
+	return exists
+}
+
+// List returns the active bans, oldest first
+func (bl *BanList) List() []Ban {
+	now := bl.now()
+
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	list := make([]Ban, 0, len(bl.bans))
+	for ip, ban := range bl.bans {
+		if !ban.Active(now) {
+			delete(bl.bans, ip)
+			continue
+		}
+		list = append(list, ban)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+// addrIP returns the IP of a TCP or UDP address in the form the ban list uses
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	return hostOf(addr.String())
+}
+
+// hostOf strips the port from a client address such as "192.0.2.1:5000"
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if parsed := net.ParseIP(host); parsed != nil {
+		return parsed.String()
+	}
+	return host
+}
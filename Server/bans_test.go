@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testBanList returns a ban list whose clock the test sets through the returned pointer
+func testBanList() (*BanList, *time.Time) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	list := NewBanList()
+	list.now = func() time.Time { return now }
+	return list, &now
+}
+
+func TestBanExpiry(t *testing.T) {
+	list, now := testBanList()
+	if _, err := list.Add("192.0.2.1", time.Minute, "flood"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.Add("192.0.2.2", 0, "for good"); err != nil {
+		t.Fatal(err)
+	}
+
+	*now = now.Add(59 * time.Second)
+	if !list.IsBanned("192.0.2.1") || len(list.List()) != 2 {
+		t.Error("the ban ended before its duration")
+	}
+	*now = now.Add(time.Second)
+	if list.IsBanned("192.0.2.1") {
+		t.Error("the ban outlived its duration")
+	}
+	*now = now.Add(365 * 24 * time.Hour)
+	if bans := list.List(); len(bans) != 1 || bans[0].IP != "192.0.2.2" || bans[0].Until != nil {
+		t.Errorf("got %+v, want the permanent ban only", bans)
+	}
+}
+
+func TestBanList(t *testing.T) {
+	list, now := testBanList()
+	if _, err := list.Add("not-an-ip", time.Minute, ""); err == nil {
+		t.Error("an invalid address was banned")
+	}
+
+	// Addresses are kept in one form, so an IPv4-mapped address is the IPv4 one
+	ban, err := list.Add("::ffff:192.0.2.1", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ban.IP != "192.0.2.1" || !ban.Since.Equal(*now) || !ban.Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("got %+v", ban)
+	}
+	*now = now.Add(time.Minute)
+	if _, err := list.Add("192.0.2.3", time.Hour, ""); err != nil {
+		t.Fatal(err)
+	}
+	if bans := list.List(); len(bans) != 2 || bans[0].IP != "192.0.2.1" {
+		t.Errorf("got %+v, want the oldest ban first", bans)
+	}
+
+	if !list.Remove("::ffff:192.0.2.1") || list.IsBanned("192.0.2.1") {
+		t.Error("the ban was not lifted")
+	}
+	if list.Remove("192.0.2.1") {
+		t.Error("a ban was lifted twice")
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	for address, want := range map[string]string{
+		"192.0.2.1:5000":         "192.0.2.1",
+		"[2001:db8::1]:5000":     "2001:db8::1",
+		"[::ffff:192.0.2.1]:443": "192.0.2.1",
+		"192.0.2.1":              "192.0.2.1",
+		"pipe":                   "pipe",
+	} {
+		if got := hostOf(address); got != want {
+			t.Errorf("%s: got %s, want %s", address, got, want)
+		}
+	}
+}
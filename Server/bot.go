@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// botThinkTimeout bounds how long an engine may think about a single move
+var botThinkTimeout = 10 * time.Second
+
+// botPeer seats an engine in a game. It is registered like any other client and
+// receives the same pushed messages, so the game code does not know it is not human.
+type botPeer struct {
+	gameID  uuid.UUID
+	name    string
+	address string
+	engine  Engine
+
+	mu       sync.Mutex
+	thinking bool
+}
+
+// Send reacts to pushed messages: whenever the board changes, the bot checks whether it has
+// to move. It grants every takeback.
+func (b *botPeer) Send(tag Tag, message []byte) error {
+	switch tag {
+	case BoardUpdate, MatchFound:
+		go b.play()
+	case UndoOffer:
+		go func() {
+			if err := answerUndo(b.gameID, b.name, true); err != nil {
+				gameLogger(b.gameID).Warn("Bot could not accept the takeback", "player", b.name, "err", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// play computes and plays a move if it is the bot's turn, and retires the bot once the game is over
+func (b *botPeer) play() {
+	b.mu.Lock()
+	if b.thinking {
+		b.mu.Unlock()
+		return
+	}
+	b.thinking = true
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.thinking = false
+		b.mu.Unlock()
+	}()
+
+	// A premove answering our move or a takeback comes in while we are still thinking, so keep
+	// playing as long as it is our turn
+	for b.playOnce() {
+	}
+}
+
+// playOnce plays one move if it is the bot's turn, and tells whether it did
+func (b *botPeer) playOnce() bool {
+	gameMutex.RLock()
+	session, ok := GameStore[b.gameID]
+	var game *chess.Game
+	outcome := chess.NoOutcome
+	if ok {
+		game = session.Game.Clone()
+		outcome = session.Outcome()
+	}
+	gameMutex.RUnlock()
+
+	if !ok || outcome != chess.NoOutcome {
+		b.retire()
+		return false
+	}
+	if session.PlayerColor(b.name) != game.Position().Turn() {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), botThinkTimeout)
+	defer cancel()
+
+	move, err := b.engine.BestMove(ctx, game)
+	if err != nil {
+		gameLogger(b.gameID).Warn("Engine failed to find a move", "engine", b.engine.Name(), "err", err)
+		return false
+	}
+
+	moveStr := chess.AlgebraicNotation{}.Encode(game.Position(), move)
+	if _, err := MoveInLobby(b.gameID, moveStr, b.name); err != nil {
+		// The position may have been taken back while we were thinking
+		gameMutex.RLock()
+		current, ok := GameStore[b.gameID]
+		changed := ok && current.Game.FEN() != game.FEN()
+		gameMutex.RUnlock()
+		if !changed {
+			gameLogger(b.gameID).Warn("Bot could not play its move", "player", b.name, "move", moveStr, "err", err)
+		}
+		return changed
+	}
+
+	// If our move ended the game nobody will push to us again, so retire now
+	gameMutex.RLock()
+	current := GameStore[b.gameID]
+	finished := current.Outcome() != chess.NoOutcome
+	gameMutex.RUnlock()
+	if finished {
+		b.retire()
+		return false
+	}
+	return true
+}
+
+// retire removes the bot from the client list once its game is over
+func (b *botPeer) retire() {
+	peers.Unregister(b.address)
+	clientList.RemoveClient(b.address)
+
+	// External engines hold a process that has to be stopped
+	if closer, ok := b.engine.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Warn("Error closing engine", "engine", b.engine.Name(), "err", err)
+		}
+	}
+}
+
+// seatBot registers an engine as a player named botName in the given game
+func seatBot(gameID uuid.UUID, botName string, engine Engine, rating int) *botPeer {
+	bot := &botPeer{
+		gameID:  gameID,
+		name:    botName,
+		address: fmt.Sprintf("bot:%s", gameID),
+		engine:  engine,
+	}
+
+	clientList.AddClient(bot.address, Client{
+		FirstName: botName,
+		LastName:  engine.Name(),
+		Status:    "Bot",
+		Level:     rating,
+		Address:   bot.address,
+		GameID:    gameID,
+	})
+	peers.Register(bot.address, bot)
+	return bot
+}
+
+// engineRating returns the advertised strength of an engine, if it has one
+func engineRating(engine Engine) int {
+	if rated, ok := engine.(interface{ Rating() int }); ok {
+		return rated.Rating()
+	}
+	return 0
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// chess960Rules are the rules of Chess960: standard chess from one of 960 starting positions,
+// castling the king and rook to the squares they reach in standard chess. The chess package
+// castles from the standard squares only, so the server plays castling itself, see
+// playCastling, and keeps the castling rights in the session.
+type chess960Rules struct{ standardRules }
+
+func (chess960Rules) StartingFEN(setup string) (string, error) {
+	setup = strings.TrimSpace(setup)
+	number := rand.Intn(960)
+	if setup != "" {
+		var err error
+		if number, err = strconv.Atoi(setup); err != nil || number < 0 || number > 959 {
+			return "", fmt.Errorf("invalid Chess960 position %q, expected a number from 0 to 959", setup)
+		}
+	}
+	return chess960FEN(number), nil
+}
+
+// Start leaves the castling rights out of the game, keeping them in the session
+func (chess960Rules) Start(session *GameSession) error {
+	fields := strings.Fields(session.StartFEN)
+	if len(fields) != 6 {
+		return fmt.Errorf("invalid starting position %q", session.StartFEN)
+	}
+	position, err := chess.FEN(withCastling(session.StartFEN, ""))
+	if err != nil {
+		return fmt.Errorf("invalid starting position: %w", err)
+	}
+	session.Game, session.Castling, session.Parts = chess.NewGame(position), "", nil
+	if fields[2] != "-" {
+		session.Castling = fields[2]
+	}
+	return nil
+}
+
+// LegalMoves lists castling as the king taking its own rook
+func (chess960Rules) LegalMoves(session *GameSession) []string {
+	moves := (standardRules{}).LegalMoves(session)
+	for _, castling := range []string{"O-O", "O-O-O"} {
+		trial := *session
+		trial.Parts = slices.Clip(trial.Parts)
+		if castled, err := playCastling(&trial, castling); castled && err == nil {
+			moves = append(moves, trial.Parts[len(trial.Parts)-1].UCI)
+		}
+	}
+	return moves
+}
+
+// Play plays the move, without opening names: the book knows the standard start only
+func (chess960Rules) Play(session *GameSession, notation string) error {
+	if castled, err := playCastling(session, notation); castled {
+		return err
+	}
+	position := session.Game.Position()
+	if err := playChessMove(session, notation); err != nil {
+		return err
+	}
+	moves := session.Game.Moves()
+	session.Castling = updateCastling(session.Castling, position, moves[len(moves)-1])
+	return nil
+}
+
+// Outcome goes on from a stalemate the chess package sees where castling is legal
+func (r chess960Rules) Outcome(session *GameSession) (chess.Outcome, string) {
+	if session.Game.Method() == chess.Stalemate && len(r.LegalMoves(session)) > 0 {
+		return chess.NoOutcome, chess.NoMethod.String()
+	}
+	return (standardRules{}).Outcome(session)
+}
+
+// FEN writes the position with its castling rights in Shredder-FEN
+func (chess960Rules) FEN(session *GameSession) string {
+	return withCastling(session.Game.FEN(), session.Castling)
+}
+
+// castlingPattern matches castling written in algebraic notation, with letters or zeros
+var castlingPattern = regexp.MustCompile(`^([O0]-[O0])(-[O0])?[+#]?$`)
+
+// playCastling plays a Chess960 castling written in algebraic notation (O-O, O-O-O) or as
+// the king taking its own rook in UCI notation (b1a1). It reports false for other moves,
+// left to the chess package. Castling follows the Chess960 rules: the king and the rook end
+// on the squares of standard castling, nothing but them stands on the squares either
+// crosses or lands on, and the king is not in check, does not cross an attacked square and
+// does not end in check.
+func playCastling(session *GameSession, notation string) (bool, error) {
+	position := session.Game.Position()
+	turn := position.Turn()
+	board := position.Board().SquareMap()
+	home := chess.Rank1
+	if turn == chess.Black {
+		home = chess.Rank8
+	}
+	king := chess.NoSquare
+	for square, piece := range board {
+		if piece == chess.NewPiece(chess.King, turn) {
+			king = square
+		}
+	}
+
+	var kingSide bool
+	rookFile := -1
+	text := strings.ToUpper(strings.TrimRight(strings.TrimSpace(notation), "!?"))
+	if match := castlingPattern.FindStringSubmatch(text); match != nil {
+		kingSide = match[2] == ""
+	} else {
+		move, err := chess.UCINotation{}.Decode(nil, strings.ToLower(text))
+		if err != nil || move.S1() != king || move.S2().Rank() != home {
+			return false, nil
+		}
+		kingSide = move.S2().File() > king.File()
+		switch to := move.S2().File(); {
+		case board[move.S2()] == chess.NewPiece(chess.Rook, turn):
+			rookFile = int(to)
+		case (to == chess.FileC || to == chess.FileG) && max(to-king.File(), king.File()-to) > 1:
+			// Castling in UCI notation as in standard chess, the king going two files or more
+		default:
+			return false, nil
+		}
+	}
+	if king == chess.NoSquare || king.Rank() != home {
+		return true, fmt.Errorf("%s cannot castle", turn.Name())
+	}
+
+	// The rights name the file of each rook that may still castle
+	for _, right := range session.Castling {
+		file := int(lowerRight(right) - 'a')
+		if rookFile < 0 && rightColor(right) == turn && (file > int(king.File())) == kingSide {
+			rookFile = file
+		}
+	}
+	wing := "queenside"
+	if kingSide {
+		wing = "kingside"
+	}
+	if rookFile < 0 {
+		return true, fmt.Errorf("%s can no longer castle %s", turn.Name(), wing)
+	}
+	rook := chess.NewSquare(chess.File(rookFile), home)
+	if !strings.ContainsRune(session.Castling, rightOf(rook, turn)) || board[rook] != chess.NewPiece(chess.Rook, turn) {
+		return true, fmt.Errorf("%s can no longer castle %s", turn.Name(), wing)
+	}
+	kingTo, rookTo := chess.NewSquare(chess.FileC, home), chess.NewSquare(chess.FileD, home)
+	if kingSide {
+		kingTo, rookTo = chess.NewSquare(chess.FileG, home), chess.NewSquare(chess.FileF, home)
+	}
+
+	for _, square := range append(squaresBetween(king, kingTo), squaresBetween(rook, rookTo)...) {
+		if square != king && square != rook && board[square] != chess.NoPiece {
+			return true, fmt.Errorf("%s stands in the way of castling %s", square, wing)
+		}
+	}
+	for _, square := range squaresBetween(king, kingTo) {
+		if attacked(board, square, turn.Other()) {
+			return true, fmt.Errorf("%s is attacked, %s cannot castle %s", square, turn.Name(), wing)
+		}
+	}
+	delete(board, king)
+	delete(board, rook)
+	board[kingTo], board[rookTo] = chess.NewPiece(chess.King, turn), chess.NewPiece(chess.Rook, turn)
+	if attacked(board, kingTo, turn.Other()) {
+		return true, fmt.Errorf("castling %s would leave the king in check", wing)
+	}
+
+	// The game goes on from the position after the castling, without the rights of the side
+	fields := strings.Fields(position.String())
+	halfMoves, _ := strconv.Atoi(fields[4])
+	fullMoves, _ := strconv.Atoi(fields[5])
+	if turn == chess.Black {
+		fullMoves++
+	}
+	fen := fmt.Sprintf("%s %s - - %d %d", chess.NewBoard(board), turn.Other(), halfMoves+1, fullMoves)
+	san := "O-O-O"
+	if kingSide {
+		san = "O-O"
+	}
+	if err := splitGame(session, fen, king.String()+rook.String(), san); err != nil {
+		return true, err
+	}
+	session.Castling = dropRights(session.Castling, turn)
+	return true, nil
+}
+
+// updateCastling drops the Chess960 castling rights a move played from position takes away:
+// all of a side's when its king moves, a rook's when it moves or is taken
+func updateCastling(castling string, position *chess.Position, move *chess.Move) string {
+	if castling == "" {
+		return ""
+	}
+	mover := position.Board().Piece(move.S1())
+	var rights strings.Builder
+	for _, right := range castling {
+		color := rightColor(right)
+		home := chess.Rank1
+		if color == chess.Black {
+			home = chess.Rank8
+		}
+		rook := chess.NewSquare(chess.File(lowerRight(right)-'a'), home)
+		if mover == chess.NewPiece(chess.King, color) || move.S1() == rook || move.S2() == rook {
+			continue
+		}
+		rights.WriteRune(right)
+	}
+	return rights.String()
+}
+
+// dropRights removes the Chess960 castling rights of a side, as castling does
+func dropRights(castling string, color chess.Color) string {
+	return strings.Map(func(right rune) rune {
+		if rightColor(right) == color {
+			return -1
+		}
+		return right
+	}, castling)
+}
+
+// rightColor returns the side a Shredder-FEN castling right belongs to, upper case for White
+func rightColor(right rune) chess.Color {
+	if right >= 'A' && right <= 'H' {
+		return chess.White
+	}
+	return chess.Black
+}
+
+// rightOf returns the Shredder-FEN castling right of a rook of color on square
+func rightOf(square chess.Square, color chess.Color) rune {
+	right := rune('a' + int(square.File()))
+	if color == chess.White {
+		right -= 'a' - 'A'
+	}
+	return right
+}
+
+// lowerRight returns a castling right in lower case
+func lowerRight(right rune) rune {
+	if right >= 'A' && right <= 'H' {
+		return right + 'a' - 'A'
+	}
+	return right
+}
+
+// squaresBetween lists the squares of a rank from one square to another, both included
+func squaresBetween(from, to chess.Square) []chess.Square {
+	step := 1
+	if to < from {
+		step = -1
+	}
+	var squares []chess.Square
+	for square := int(from); ; square += step {
+		squares = append(squares, chess.Square(square))
+		if square == int(to) {
+			return squares
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// chess960Session starts a Chess960 game from a position in Shredder-FEN
+func chess960Session(t *testing.T, fen string) *GameSession {
+	t.Helper()
+	session := &GameSession{Variant: Chess960, StartFEN: fen}
+	if err := startSession(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// playAll plays moves in a session, failing the test on the first refused
+func playAll(t *testing.T, session *GameSession, moves ...string) {
+	t.Helper()
+	for _, move := range moves {
+		if err := playMove(session, move); err != nil {
+			t.Fatalf("%s refused: %v", move, err)
+		}
+	}
+}
+
+func TestChess960Castling(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		start string
+		moves []string
+		want  string // Position after the moves, in Shredder-FEN
+	}{
+		{
+			"position 518, kingside",
+			chess960FEN(518),
+			[]string{"e4", "e5", "Nf3", "Nc6", "Bc4", "Bc5", "O-O"},
+			"r1bqk1nr/pppp1ppp/2n5/2b1p3/2B1P3/5N2/PPPP1PPP/RNBQ1RK1 b ha - 5 4",
+		},
+		{
+			"position 518, king taking its rook",
+			chess960FEN(518),
+			[]string{"e4", "e5", "Nf3", "Nc6", "Bc4", "Bc5", "e1h1"},
+			"r1bqk1nr/pppp1ppp/2n5/2b1p3/2B1P3/5N2/PPPP1PPP/RNBQ1RK1 b ha - 5 4",
+		},
+		{
+			"king on b, queenside to the next file",
+			"rk5r/pppppppp/8/8/8/8/PPPPPPPP/RK5R w HAha - 0 1",
+			[]string{"O-O-O"},
+			"rk5r/pppppppp/8/8/8/8/PPPPPPPP/2KR3R b ha - 1 1",
+		},
+		{
+			"king on b, kingside across the board",
+			"rk5r/pppppppp/8/8/8/8/PPPPPPPP/RK5R w HAha - 0 1",
+			[]string{"b1h1", "O-O-O"},
+			"2kr3r/pppppppp/8/8/8/8/PPPPPPPP/R4RK1 w - - 2 2",
+		},
+		{
+			"king on g, kingside without moving the king",
+			"r5kr/pppppppp/8/8/8/8/PPPPPPPP/R5KR w HAha - 0 1",
+			[]string{"g1h1"},
+			"r5kr/pppppppp/8/8/8/8/PPPPPPPP/R4RK1 b ha - 1 1",
+		},
+		{
+			"king on g, queenside",
+			"r5kr/pppppppp/8/8/8/8/PPPPPPPP/R5KR w HAha - 0 1",
+			[]string{"O-O-O", "g8a8"},
+			"2kr3r/pppppppp/8/8/8/8/PPPPPPPP/2KR3R w - - 2 2",
+		},
+		{
+			"the king does not cross b1 castling queenside from e1",
+			"4k3/1r6/8/8/8/8/8/R3K2R w HA - 0 1",
+			[]string{"O-O-O"},
+			"4k3/1r6/8/8/8/8/8/2KR3R b - - 1 1",
+		},
+		{
+			"the rook may be attacked",
+			"4k3/7r/8/8/8/8/8/R3K2R w HA - 0 1",
+			[]string{"O-O"},
+			"4k3/7r/8/8/8/8/8/R4RK1 b - - 1 1",
+		},
+	} {
+		session := chess960Session(t, test.start)
+		playAll(t, session, test.moves...)
+		if got := (chess960Rules{}).FEN(session); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChess960CastlingRefused(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		start string
+		move  string
+		want  string // Part of the error
+	}{
+		{"out of check", "4k3/8/8/8/4r3/8/8/R3K2R w HA - 0 1", "O-O", "attacked"},
+		{"through check", "4k3/8/8/8/5r2/8/8/R3K2R w HA - 0 1", "O-O", "f1 is attacked"},
+		{"into check", "4k3/8/8/8/6r1/8/8/R3K2R w HA - 0 1", "O-O", "attacked"},
+		{"piece between king and rook", "4k3/8/8/8/8/8/8/R3KB1R w HA - 0 1", "O-O", "f1 stands in the way"},
+		{"piece on the rook's square", "rk5r/pppppppp/8/8/8/8/PPPPPPPP/RK1N3R w HAha - 0 1", "O-O-O", "d1 stands in the way"},
+		{"piece on the king's square", "r5kr/pppppppp/8/8/8/8/PPPPPPPP/R1B3KR w HAha - 0 1", "O-O-O", "c1 stands in the way"},
+		{"other rook in the way", chess960FEN(0), "O-O", "stands in the way"},
+		{"no right left", "4k3/8/8/8/8/8/8/R3K2R w A - 0 1", "O-O", "can no longer castle kingside"},
+		{"no rook on the square of the right", "4k3/8/8/8/8/8/8/4K2R w HA - 0 1", "O-O-O", "can no longer castle queenside"},
+	} {
+		session := chess960Session(t, test.start)
+		err := playMove(session, test.move)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %s gave %v, want an error about %q", test.name, test.move, err, test.want)
+		}
+		if got := (chess960Rules{}).FEN(session); got != test.start {
+			t.Errorf("%s: the refused castling changed the position to %s", test.name, got)
+		}
+	}
+}
+
+func TestChess960CastlingRightsLost(t *testing.T) {
+	const start = "r3k2r/8/8/8/8/8/6B1/R3K2R w HAha - 0 1"
+	for _, test := range []struct {
+		name  string
+		moves []string
+		want  string
+	}{
+		{"king moved", []string{"Kd1"}, "ha"},
+		{"king moved back", []string{"Kd1", "Kd8", "Ke1", "Ke8"}, "-"},
+		{"queenside rook moved", []string{"Ra2"}, "Hha"},
+		{"kingside rook moved", []string{"Rh2", "Rh7"}, "Aa"},
+		{"rook taken by a rook", []string{"Rxa8+"}, "Hh"},
+		{"rook taken by a bishop", []string{"Bxa8"}, "HAh"},
+		{"rook taken, then the king moved", []string{"Rxh8+", "Kd7"}, "A"},
+		{"after castling", []string{"O-O"}, "ha"},
+	} {
+		session := chess960Session(t, start)
+		playAll(t, session, test.moves...)
+		fields := strings.Fields((chess960Rules{}).FEN(session))
+		if fields[2] != test.want {
+			t.Errorf("%s: castling rights %s, want %s", test.name, fields[2], test.want)
+		}
+	}
+}
+
+func TestChess960StartingFEN(t *testing.T) {
+	for number, want := range map[int]string{
+		0:   "bbqnnrkr/pppppppp/8/8/8/8/PPPPPPPP/BBQNNRKR w HFhf - 0 1",
+		518: "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w HAha - 0 1",
+		959: "rkrnnqbb/pppppppp/8/8/8/8/PPPPPPPP/RKRNNQBB w CAca - 0 1",
+	} {
+		if got := chess960FEN(number); got != want {
+			t.Errorf("position %d: got %s, want %s", number, got, want)
+		}
+		// The position is written back with its rights as it was given
+		if got := (chess960Rules{}).FEN(chess960Session(t, want)); got != want {
+			t.Errorf("position %d: started from %s, got %s", number, want, got)
+		}
+	}
+}
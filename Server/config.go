@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings. Each setting is read, in increasing priority, from the
+// defaults, the JSON config file, the TP2_* environment variables and the command line.
+type Config struct {
+	// Listen addresses and enabled transports
+	TCPAddr   string `json:"tcp_addr"`
+	UDPAddr   string `json:"udp_addr"`
+	EnableTCP bool   `json:"enable_tcp"`
+	EnableUDP bool   `json:"enable_udp"`
+
+	// Limits, 0 meaning unlimited
+	MaxConnections      int `json:"max_connections"`        // Simultaneous TCP and WebSocket connections and UDP clients
+	MaxLobbies          int `json:"max_lobbies"`            // Open lobbies waiting for players
+	MaxLobbiesPerPlayer int `json:"max_lobbies_per_player"` // Open lobbies opened by the same account
+	MaxUDPHandlers      int `json:"max_udp_handlers"`       // Datagrams handled at once, the next ones are dropped
+
+	// Token buckets by request tag name, "default" applying to the tags without an entry.
+	// Each client gets a bucket per request type for its IP and another one for its account.
+	RateLimits map[string]RateLimit `json:"rate_limits"`
+
+	// An IP sending AuthFailureLimit requests with a wrong signature or hash within
+	// AuthFailureWindow is banned for AuthBanDuration, 0 failures disabling the bans
+	AuthFailureLimit  int      `json:"auth_failure_limit"`
+	AuthFailureWindow Duration `json:"auth_failure_window"`
+	AuthBanDuration   Duration `json:"auth_ban_duration"`
+
+	// Heartbeat: clients are pinged every PingInterval, a client silent for IdleTimeout is
+	// disconnected and its opponent may claim the game once it has been gone AbandonTimeout
+	PingInterval   Duration `json:"ping_interval"`
+	IdleTimeout    Duration `json:"idle_timeout"`
+	AbandonTimeout Duration `json:"abandon_timeout"`
+
+	// TLS on the TCP listener, enabled by a certificate and its key or by TLSSelfSigned,
+	// which generates a development certificate when none exists at the paths, by default
+	// in DataDir/tls. Clients with a certificate signed by TLSClientCAFile are verified,
+	// and required to present one with TLSRequireClientCert.
+	TLSCertFile          string `json:"tls_cert_file"`
+	TLSKeyFile           string `json:"tls_key_file"`
+	TLSSelfSigned        bool   `json:"tls_self_signed"`
+	TLSClientCAFile      string `json:"tls_client_ca_file"`
+	TLSRequireClientCert bool   `json:"tls_require_client_cert"`
+
+	// WebSocket gateway for browsers on ws://WebSocketAddr/ws, wss:// when TLS is enabled,
+	// disabled when empty. Its connections are served by the TCP server. Browsers from
+	// other origins than WebSocketOrigins are refused, any origin is accepted when empty.
+	WebSocketAddr    string   `json:"websocket_addr"`
+	WebSocketOrigins []string `json:"websocket_origins"`
+
+	// HTTP address serving the Prometheus metrics on /metrics, disabled when empty
+	MetricsAddr string `json:"metrics_addr"`
+
+	// Admin HTTP channel for operators, disabled when AdminAddr is empty. Every request must
+	// carry "Authorization: Bearer <AdminToken>".
+	AdminAddr  string `json:"admin_addr"`
+	AdminToken string `json:"admin_token"`
+
+	// Public REST API on lobbies, games and players, disabled when APIAddr is empty. Reading
+	// is open, creating a lobby takes "Authorization: Bearer <token>" where APITokens maps
+	// each player allowed to use it to their token.
+	APIAddr   string            `json:"api_addr"`
+	APITokens map[string]string `json:"api_tokens"`
+
+	// Logging: level debug, info, warn or error, format text or json, written to
+	// LogFile or to the standard error when it is empty
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+	LogFile   string `json:"log_file"`
+
+	// Storage: games in progress and the game archive are saved here on shutdown
+	DataDir string `json:"data_dir"`
+
+	// How long the server waits for in-flight requests when shutting down
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	// External UCI engine for bot games and analysis, disabled when empty. Bot games search
+	// UCIDepth plies, or UCIMoveTime per move when it is set, analysis AnalysisDepth plies.
+	// The engine runs UCIThreads threads, 0 leaving its own default.
+	UCIEnginePath string   `json:"uci_engine_path"`
+	UCIDepth      int      `json:"uci_depth"`
+	UCIMoveTime   Duration `json:"uci_movetime"`
+	UCIThreads    int      `json:"uci_threads"`
+	AnalysisDepth int      `json:"analysis_depth"`
+
+	// Whether the players of a rated game may agree to take moves back
+	RatedTakebacks bool `json:"rated_takebacks"`
+}
+
+// Duration is a time.Duration written as "10s" or "1m30s" in the config file
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// DefaultConfig returns the settings the server used before it was configurable
+func DefaultConfig() Config {
+	return Config{
+		TCPAddr:             ":8080",
+		UDPAddr:             ":8081",
+		EnableTCP:           true,
+		EnableUDP:           true,
+		MaxLobbiesPerPlayer: 2,
+		MaxUDPHandlers:      256,
+		RateLimits:          DefaultRateLimits(),
+		AuthFailureLimit:    5,
+		AuthFailureWindow:   Duration{time.Minute},
+		AuthBanDuration:     Duration{15 * time.Minute},
+		LogLevel:            "info",
+		LogFormat:           "text",
+		PingInterval:        Duration{30 * time.Second},
+		IdleTimeout:         Duration{90 * time.Second},
+		AbandonTimeout:      Duration{2 * time.Minute},
+		DataDir:             "data",
+		ShutdownTimeout:     Duration{10 * time.Second},
+		UCIDepth:            12,
+		UCIThreads:          1,
+		AnalysisDepth:       14,
+		RatedTakebacks:      true,
+	}
+}
+
+// config is the configuration the server is running with
+var config = DefaultConfig()
+
+// bindFlags declares one command-line flag per setting, defaulting to the current values
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.TCPAddr, "tcp-addr", c.TCPAddr, "TCP listen address")
+	fs.StringVar(&c.UDPAddr, "udp-addr", c.UDPAddr, "UDP listen address")
+	fs.BoolVar(&c.EnableTCP, "tcp", c.EnableTCP, "enable the TCP transport")
+	fs.BoolVar(&c.EnableUDP, "udp", c.EnableUDP, "enable the UDP transport")
+	fs.IntVar(&c.MaxConnections, "max-connections", c.MaxConnections, "maximum simultaneous connections, TCP, WebSocket and UDP clients together (0 for no limit)")
+	fs.IntVar(&c.MaxLobbies, "max-lobbies", c.MaxLobbies, "maximum open lobbies (0 for no limit)")
+	fs.IntVar(&c.MaxLobbiesPerPlayer, "max-lobbies-per-player", c.MaxLobbiesPerPlayer, "maximum open lobbies opened by one account (0 for no limit)")
+	fs.IntVar(&c.MaxUDPHandlers, "max-udp-handlers", c.MaxUDPHandlers, "maximum datagrams handled at once (0 for no limit)")
+	fs.Var(rateLimitsFlag{&c.RateLimits}, "rate-limit", "request limits as Tag=rate:burst, e.g. LobbyRequest=1:5, comma separated or repeated")
+	fs.IntVar(&c.AuthFailureLimit, "auth-failure-limit", c.AuthFailureLimit, "signature or hash failures that get an IP banned (0 to never ban)")
+	fs.DurationVar(&c.AuthFailureWindow.Duration, "auth-failure-window", c.AuthFailureWindow.Duration, "window in which the authentication failures are counted")
+	fs.DurationVar(&c.AuthBanDuration.Duration, "auth-ban-duration", c.AuthBanDuration.Duration, "how long an IP is banned after too many authentication failures")
+	fs.DurationVar(&c.PingInterval.Duration, "ping-interval", c.PingInterval.Duration, "interval between the server's pings")
+	fs.DurationVar(&c.IdleTimeout.Duration, "idle-timeout", c.IdleTimeout.Duration, "how long a silent client stays connected")
+	fs.DurationVar(&c.AbandonTimeout.Duration, "abandon-timeout", c.AbandonTimeout.Duration, "how long a player must be gone before the opponent can claim the game")
+	fs.StringVar(&c.TLSCertFile, "tls-cert", c.TLSCertFile, "PEM certificate enabling TLS on the TCP listener")
+	fs.StringVar(&c.TLSKeyFile, "tls-key", c.TLSKeyFile, "PEM private key of the TLS certificate")
+	fs.BoolVar(&c.TLSSelfSigned, "tls-self-signed", c.TLSSelfSigned, "enable TLS with a generated self-signed certificate, for development")
+	fs.StringVar(&c.TLSClientCAFile, "tls-client-ca", c.TLSClientCAFile, "PEM CA verifying client certificates (mutual TLS)")
+	fs.BoolVar(&c.TLSRequireClientCert, "tls-require-client-cert", c.TLSRequireClientCert, "refuse TLS clients without a certificate signed by the client CA")
+	fs.StringVar(&c.WebSocketAddr, "ws-addr", c.WebSocketAddr, "HTTP address of the WebSocket gateway for browsers, e.g. :8082 (disabled when empty)")
+	fs.Func("ws-origins", "comma separated origins allowed to open a WebSocket, e.g. https://chess.example.com (default: any)", func(value string) error {
+		c.WebSocketOrigins = splitList(value)
+		return nil
+	})
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "HTTP address serving /metrics, e.g. :9100 (disabled when empty)")
+	fs.StringVar(&c.AdminAddr, "admin-addr", c.AdminAddr, "HTTP address of the admin API, e.g. 127.0.0.1:9200 (disabled when empty)")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token of the admin API, better passed as TP2_ADMIN_TOKEN")
+	fs.StringVar(&c.APIAddr, "api-addr", c.APIAddr, "HTTP address of the REST API, e.g. :8080 (disabled when empty)")
+	fs.Func("api-token", "player=token allowed to create lobbies through the REST API, repeatable", func(value string) error {
+		return addAPITokens(&c.APITokens, value)
+	})
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log format: text or json")
+	fs.StringVar(&c.LogFile, "log-file", c.LogFile, "file the logs are appended to instead of the standard error")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "directory where games are saved")
+	fs.DurationVar(&c.ShutdownTimeout.Duration, "shutdown-timeout", c.ShutdownTimeout.Duration, "how long to wait for in-flight requests on shutdown")
+	fs.StringVar(&c.UCIEnginePath, "uci-engine", c.UCIEnginePath, "path to a UCI engine for bot games and analysis")
+	fs.IntVar(&c.UCIDepth, "uci-depth", c.UCIDepth, "search depth of the UCI engine in bot games")
+	fs.DurationVar(&c.UCIMoveTime.Duration, "uci-movetime", c.UCIMoveTime.Duration, "time the UCI engine thinks per move in bot games, instead of searching to -uci-depth")
+	fs.IntVar(&c.UCIThreads, "uci-threads", c.UCIThreads, "threads of the UCI engine (0 for the engine's default)")
+	fs.IntVar(&c.AnalysisDepth, "analysis-depth", c.AnalysisDepth, "search depth of the UCI engine for analysis")
+	fs.BoolVar(&c.RatedTakebacks, "rated-takebacks", c.RatedTakebacks, "let the players of rated games agree to take moves back")
+}
+
+// applyEnv overrides settings from TP2_* environment variables
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	// Kept for setups that predate the config file, TP2_UCI_ENGINE_PATH wins over it
+	if value, ok := lookup("UCI_ENGINE_PATH"); ok {
+		c.UCIEnginePath = value
+	}
+
+	stringVars := map[string]*string{
+		"TP2_TCP_ADDR":           &c.TCPAddr,
+		"TP2_UDP_ADDR":           &c.UDPAddr,
+		"TP2_METRICS_ADDR":       &c.MetricsAddr,
+		"TP2_WS_ADDR":            &c.WebSocketAddr,
+		"TP2_TLS_CERT_FILE":      &c.TLSCertFile,
+		"TP2_TLS_KEY_FILE":       &c.TLSKeyFile,
+		"TP2_TLS_CLIENT_CA_FILE": &c.TLSClientCAFile,
+		"TP2_ADMIN_ADDR":         &c.AdminAddr,
+		"TP2_ADMIN_TOKEN":        &c.AdminToken,
+		"TP2_API_ADDR":           &c.APIAddr,
+		"TP2_LOG_LEVEL":          &c.LogLevel,
+		"TP2_LOG_FORMAT":         &c.LogFormat,
+		"TP2_LOG_FILE":           &c.LogFile,
+		"TP2_DATA_DIR":           &c.DataDir,
+		"TP2_UCI_ENGINE_PATH":    &c.UCIEnginePath,
+	}
+	for name, field := range stringVars {
+		if value, ok := lookup(name); ok {
+			*field = value
+		}
+	}
+
+	boolVars := map[string]*bool{
+		"TP2_ENABLE_TCP":              &c.EnableTCP,
+		"TP2_ENABLE_UDP":              &c.EnableUDP,
+		"TP2_TLS_SELF_SIGNED":         &c.TLSSelfSigned,
+		"TP2_TLS_REQUIRE_CLIENT_CERT": &c.TLSRequireClientCert,
+		"TP2_RATED_TAKEBACKS":         &c.RatedTakebacks,
+	}
+	for name, field := range boolVars {
+		if value, ok := lookup(name); ok {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	intVars := map[string]*int{
+		"TP2_MAX_CONNECTIONS":        &c.MaxConnections,
+		"TP2_MAX_LOBBIES":            &c.MaxLobbies,
+		"TP2_MAX_LOBBIES_PER_PLAYER": &c.MaxLobbiesPerPlayer,
+		"TP2_MAX_UDP_HANDLERS":       &c.MaxUDPHandlers,
+		"TP2_AUTH_FAILURE_LIMIT":     &c.AuthFailureLimit,
+		"TP2_UCI_DEPTH":              &c.UCIDepth,
+		"TP2_UCI_THREADS":            &c.UCIThreads,
+		"TP2_ANALYSIS_DEPTH":         &c.AnalysisDepth,
+	}
+	for name, field := range intVars {
+		if value, ok := lookup(name); ok {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	durationVars := map[string]*time.Duration{
+		"TP2_PING_INTERVAL":       &c.PingInterval.Duration,
+		"TP2_IDLE_TIMEOUT":        &c.IdleTimeout.Duration,
+		"TP2_ABANDON_TIMEOUT":     &c.AbandonTimeout.Duration,
+		"TP2_SHUTDOWN_TIMEOUT":    &c.ShutdownTimeout.Duration,
+		"TP2_AUTH_FAILURE_WINDOW": &c.AuthFailureWindow.Duration,
+		"TP2_AUTH_BAN_DURATION":   &c.AuthBanDuration.Duration,
+		"TP2_UCI_MOVETIME":        &c.UCIMoveTime.Duration,
+	}
+	for name, field := range durationVars {
+		if value, ok := lookup(name); ok {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	if value, ok := lookup("TP2_WS_ORIGINS"); ok {
+		c.WebSocketOrigins = splitList(value)
+	}
+
+	if value, ok := lookup("TP2_API_TOKENS"); ok {
+		if err := addAPITokens(&c.APITokens, value); err != nil {
+			return fmt.Errorf("invalid TP2_API_TOKENS: %w", err)
+		}
+	}
+
+	if value, ok := lookup("TP2_RATE_LIMITS"); ok {
+		if err := (rateLimitsFlag{&c.RateLimits}).Set(value); err != nil {
+			return fmt.Errorf("invalid TP2_RATE_LIMITS: %w", err)
+		}
+	}
+	return nil
+}
+
+// botEngineOptions returns the settings of the UCI engine playing bot games
+func (c *Config) botEngineOptions() UCIOptions {
+	return UCIOptions{Path: c.UCIEnginePath, Depth: c.UCIDepth, MoveTime: c.UCIMoveTime.Duration, Threads: c.UCIThreads}
+}
+
+// analysisEngineOptions returns the settings of the UCI engine analysing finished games
+func (c *Config) analysisEngineOptions() UCIOptions {
+	return UCIOptions{Path: c.UCIEnginePath, Depth: c.AnalysisDepth, Threads: c.UCIThreads}
+}
+
+// splitList reads a comma separated list, ignoring blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// addAPITokens adds the tokens written as "alice=token1,bob=token2" to tokens
+func addAPITokens(tokens *map[string]string, value string) error {
+	for _, entry := range splitList(value) {
+		player, token, found := strings.Cut(entry, "=")
+		player, token = strings.TrimSpace(player), strings.TrimSpace(token)
+		if !found || player == "" || token == "" {
+			return fmt.Errorf("API token %q must look like player=token", entry)
+		}
+		if *tokens == nil {
+			*tokens = make(map[string]string)
+		}
+		(*tokens)[player] = token
+	}
+	return nil
+}
+
+// loadFile overrides settings with the ones present in a JSON config file
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that the settings can be used to start the server
+func (c *Config) Validate() error {
+	if !c.EnableTCP && !c.EnableUDP {
+		return errors.New("at least one of the TCP and UDP transports must be enabled")
+	}
+	if c.EnableTCP && c.TCPAddr == "" {
+		return errors.New("TCP is enabled without a listen address")
+	}
+	if c.EnableUDP && c.UDPAddr == "" {
+		return errors.New("UDP is enabled without a listen address")
+	}
+	if c.MaxConnections < 0 || c.MaxLobbies < 0 || c.MaxLobbiesPerPlayer < 0 || c.MaxUDPHandlers < 0 || c.AuthFailureLimit < 0 {
+		return errors.New("limits cannot be negative")
+	}
+	for name, limit := range c.RateLimits {
+		if _, known := tagByName(name); !known && name != defaultRateLimitKey {
+			return fmt.Errorf("rate limit for unknown request type %q", name)
+		}
+		if limit.Rate < 0 || (limit.Rate > 0 && limit.Burst < 1) {
+			return fmt.Errorf("rate limit %s=%s needs a positive rate and a burst of at least 1", name, limit)
+		}
+	}
+	if c.AuthFailureLimit > 0 && (c.AuthFailureWindow.Duration <= 0 || c.AuthBanDuration.Duration <= 0) {
+		return errors.New("the authentication failure window and ban duration must be positive")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS needs both a certificate and its key")
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		return errors.New("mutual TLS needs TLS to be enabled")
+	}
+	if c.TLSRequireClientCert && c.TLSClientCAFile == "" {
+		return errors.New("requiring client certificates needs a client CA")
+	}
+	if c.WebSocketAddr != "" && !c.EnableTCP {
+		return errors.New("the WebSocket gateway is served by the TCP server, which must be enabled")
+	}
+	if c.AdminAddr != "" && c.AdminToken == "" {
+		return errors.New("the admin API needs a token")
+	}
+	owners := make(map[string]string, len(c.APITokens))
+	for player, token := range c.APITokens {
+		if player == "" || token == "" {
+			return fmt.Errorf("the REST API token of %q is empty", player)
+		}
+		if other, taken := owners[token]; taken {
+			return fmt.Errorf("players %q and %q share a REST API token", other, player)
+		}
+		owners[token] = player
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+	if c.PingInterval.Duration <= 0 || c.AbandonTimeout.Duration <= 0 {
+		return errors.New("the ping interval and the abandon timeout must be positive")
+	}
+	if c.IdleTimeout.Duration <= c.PingInterval.Duration {
+		return errors.New("the idle timeout must be longer than the ping interval, or clients answering pings would be dropped")
+	}
+	if c.ShutdownTimeout.Duration <= 0 {
+		return errors.New("the shutdown timeout must be positive")
+	}
+	if c.UCIDepth < 0 || c.AnalysisDepth < 0 || c.UCIThreads < 0 || c.UCIMoveTime.Duration < 0 {
+		return errors.New("the UCI engine depths, threads and move time cannot be negative")
+	}
+	return nil
+}
+
+// LoadConfig builds the configuration from the defaults, the config file given by -config
+// or TP2_CONFIG, the environment and the command-line arguments
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	cfg := DefaultConfig()
+
+	// A first pass only finds the config file, the other flags are applied last so they win
+	var path string
+	scan := flag.NewFlagSet("server", flag.ContinueOnError)
+	scan.SetOutput(io.Discard)
+	scan.StringVar(&path, "config", "", "")
+	scratch := cfg
+	scratch.RateLimits = maps.Clone(cfg.RateLimits)
+	scratch.APITokens = maps.Clone(cfg.APITokens)
+	scratch.bindFlags(scan)
+	if err := scan.Parse(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return cfg, err
+	}
+	if path == "" {
+		path, _ = lookupEnv("TP2_CONFIG")
+	}
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return cfg, err
+	}
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.String("config", path, "JSON config file (also TP2_CONFIG)")
+	cfg.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	return cfg, cfg.Validate()
+}
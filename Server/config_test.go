@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envOf is an environment holding only the given variables
+func envOf(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// configFile writes a config file for the test and returns its path
+func configFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := configFile(t, `{"tcp_addr": ":1000", "max_lobbies": 3, "uci_depth": 5, "idle_timeout": "5m",
+		"rate_limits": {"ChatRequest": {"rate": 2, "burst": 4}}}`)
+	env := envOf(map[string]string{
+		"TP2_CONFIG":       path,
+		"TP2_TCP_ADDR":     ":2000",
+		"TP2_MAX_LOBBIES":  "4",
+		"TP2_IDLE_TIMEOUT": "4m",
+	})
+
+	cfg, err := LoadConfig([]string{"-tcp-addr", ":3000", "-rate-limit", "LobbyRequest=3:6"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range []struct {
+		setting   string
+		got, want any
+	}{
+		{"flag over environment and file", cfg.TCPAddr, ":3000"},
+		{"environment over file", cfg.MaxLobbies, 4},
+		{"environment duration over file", cfg.IdleTimeout.Duration, 4 * time.Minute},
+		{"file over default", cfg.UCIDepth, 5},
+		{"default", cfg.UDPAddr, ":8081"},
+		{"rate limit of the file", cfg.RateLimits["ChatRequest"], RateLimit{Rate: 2, Burst: 4}},
+		{"rate limit of the flag", cfg.RateLimits["LobbyRequest"], RateLimit{Rate: 3, Burst: 6}},
+	} {
+		if check.got != check.want {
+			t.Errorf("%s: got %v, want %v", check.setting, check.got, check.want)
+		}
+	}
+
+	// The -config flag wins over TP2_CONFIG
+	other := configFile(t, `{"tcp_addr": ":4000"}`)
+	cfg, err = LoadConfig([]string{"-config", other}, envOf(map[string]string{"TP2_CONFIG": path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TCPAddr != ":4000" || cfg.MaxLobbies != 0 {
+		t.Errorf("got %s and %d lobbies, want the -config file only", cfg.TCPAddr, cfg.MaxLobbies)
+	}
+}
+
+func TestConfigRefusedInput(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string // Part of the error
+	}{
+		{name: "unknown field in the file", file: `{"tcp_adress": ":1000"}`, want: `unknown field "tcp_adress"`},
+		{name: "wrong type in the file", file: `{"max_lobbies": "many"}`, want: "error reading config file"},
+		{name: "duration without a unit", file: `{"idle_timeout": 90}`, want: "duration must be a string"},
+		{name: "invalid environment number", env: map[string]string{"TP2_UCI_DEPTH": "deep"}, want: "invalid TP2_UCI_DEPTH"},
+		{name: "invalid environment flag", env: map[string]string{"TP2_ENABLE_UDP": "maybe"}, want: "invalid TP2_ENABLE_UDP"},
+		{name: "unknown flag", args: []string{"-tcp-adress", ":1000"}, want: "tcp-adress"},
+		{name: "stray argument", args: []string{"serve"}, want: "unexpected arguments: serve"},
+		{name: "invalid rate limit", args: []string{"-rate-limit", "LobbyRequest=1"}, want: "must look like"},
+	} {
+		args := test.args
+		if test.file != "" {
+			args = append([]string{"-config", configFile(t, test.file)}, args...)
+		}
+		_, err := LoadConfig(args, envOf(test.env))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want an error about %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if cfg := DefaultConfig(); cfg.Validate() != nil {
+		t.Fatalf("the default configuration is invalid: %v", cfg.Validate())
+	}
+	for _, test := range []struct {
+		name   string
+		change func(c *Config)
+	}{
+		{"no transport", func(c *Config) { c.EnableTCP, c.EnableUDP = false, false }},
+		{"negative limit", func(c *Config) { c.MaxLobbies = -1 }},
+		{"unknown rate limit", func(c *Config) { c.RateLimits = map[string]RateLimit{"MoveRequest": {Rate: 1, Burst: 1}} }},
+		{"rate limit without a burst", func(c *Config) { c.RateLimits = map[string]RateLimit{"ChatRequest": {Rate: 1}} }},
+		{"TLS key without its certificate", func(c *Config) { c.TLSKeyFile = "server.key" }},
+		{"admin API without a token", func(c *Config) { c.AdminAddr = ":9200" }},
+		{"shared API token", func(c *Config) { c.APITokens = map[string]string{"ann": "secret", "bob": "secret"} }},
+		{"idle timeout shorter than the pings", func(c *Config) { c.IdleTimeout = c.PingInterval }},
+		{"negative UCI depth", func(c *Config) { c.UCIDepth = -1 }},
+		{"negative analysis depth", func(c *Config) { c.AnalysisDepth = -1 }},
+		{"negative UCI threads", func(c *Config) { c.UCIThreads = -1 }},
+		{"negative UCI move time", func(c *Config) { c.UCIMoveTime = Duration{-time.Second} }},
+	} {
+		cfg := DefaultConfig()
+		test.change(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: the configuration was accepted", test.name)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// RequestHandler handles one request of the TLV protocol. TCP requests come with conn,
+// UDP ones with udpConn and clientAddr; data starts with the request TLV.
+type RequestHandler func(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) error
+
+// requestHandlers maps each request tag to its handler, for both transports. A tag added
+// here is served over TCP and UDP and shows up in the request metrics without more work.
+var requestHandlers = map[Tag]RequestHandler{
+	HelloRequest:        HandleHelloRequest,
+	GameRequest:         HandleGameRequest,
+	LobbyRequest:        HandleLobbyListRequest,
+	JoinLobbyRequest:    HandleJoinRequest,
+	BoardRequest:        HandleBoardRequest,
+	ActionRequest:       HandleMoveRequest,
+	QueueRequest:        HandleQueueRequest,
+	QueueCancelRequest:  HandleQueueCancelRequest,
+	BotGameRequest:      HandleBotGameRequest,
+	AnalyzeRequest:      HandleAnalyzeRequest,
+	ArchiveRequest:      HandleArchiveRequest,
+	AbandonClaimRequest: HandleAbandonClaimRequest,
+	ChatRequest:         HandleChatRequest,
+	PremoveRequest:      HandlePremoveRequest,
+	UndoRequest:         HandleUndoRequest,
+	UndoAnswer:          HandleUndoAnswer,
+	Ping:                HandlePing,
+	Pong:                HandlePong,
+}
+
+// dispatchRequest runs the handler registered for tag and records the request in the
+// metrics. Requests over the client's rate limit are refused, and failed signature or hash
+// checks count towards a ban. It reports false when no handler is registered for the tag.
+func dispatchRequest(tag Tag, conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, data []byte, isTCP bool) (bool, error) {
+	transport := "udp"
+	if isTCP {
+		transport = streamTransport(conn)
+	}
+
+	handler, exists := requestHandlers[tag]
+	if !exists {
+		serverMetrics.requests.Inc("unknown", transport)
+		return false, nil
+	}
+
+	started := time.Now()
+	if err := maintenance.Refuse(tag); err != nil {
+		// The client is told why, its connection stays open for the games in progress
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, tag, err)
+		serverMetrics.ObserveRequest(tag, transport, time.Since(started), err)
+		return true, nil
+	}
+
+	if scope, allowed := checkRateLimit(tag, conn, clientAddr, isTCP, started); !allowed {
+		serverMetrics.rateLimited.Inc(GetTagName(tag), scope)
+		SendErrorResponse(conn, udpConn, clientAddr, isTCP, tag, ErrRateLimited)
+		serverMetrics.ObserveRequest(tag, transport, time.Since(started), ErrRateLimited)
+		return true, nil
+	}
+
+	err := handler(conn, udpConn, clientAddr, data, isTCP)
+	serverMetrics.ObserveRequest(tag, transport, time.Since(started), err)
+	if isAuthFailure(err) {
+		recordAuthFailure(conn, clientAddr, isTCP)
+	}
+	return true, err
+}
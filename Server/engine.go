@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+)
+
+// Engine chooses moves for a seat played by the server
+type Engine interface {
+	// Name identifies the engine in logs and to the opponent
+	Name() string
+	// BestMove returns the move the engine wants to play in the game's current position
+	BestMove(ctx context.Context, game *chess.Game) (*chess.Move, error)
+}
+
+// Analyzer evaluates positions, for post-game analysis
+type Analyzer interface {
+	Analyze(ctx context.Context, game *chess.Game) (*EngineAnalysis, error)
+}
+
+// EngineAnalysis is an engine's verdict on a position
+type EngineAnalysis struct {
+	BestMove string   // Best move in UCI notation
+	Depth    int      // Depth reached by the search
+	ScoreCP  int      // Score in centipawns from the side to move's point of view
+	Mate     int      // Moves to mate (negative when the side to move is mated), 0 if none
+	PV       []string // Principal variation in UCI notation
+}
+
+// EngineFactory builds an engine playing at the given strength level
+type EngineFactory func(level int) (Engine, error)
+
+var (
+	engineFactoriesMu sync.RWMutex
+	engineFactories   = map[string]EngineFactory{
+		"alphabeta": func(level int) (Engine, error) { return NewAlphaBetaEngine(level) },
+	}
+)
+
+// DefaultEngine is the engine used when a request does not name one
+const DefaultEngine = "alphabeta"
+
+// RegisterEngine makes an engine available to bot games under the given name
+func RegisterEngine(name string, factory EngineFactory) {
+	engineFactoriesMu.Lock()
+	defer engineFactoriesMu.Unlock()
+	engineFactories[strings.ToLower(name)] = factory
+}
+
+// NewEngine builds the named engine at the given strength level
+func NewEngine(name string, level int) (Engine, error) {
+	if name == "" {
+		name = DefaultEngine
+	}
+
+	engineFactoriesMu.RLock()
+	factory, exists := engineFactories[strings.ToLower(name)]
+	engineFactoriesMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown engine %q", name)
+	}
+	return factory(level)
+}
+
+// engineLevel describes how hard the built-in engine tries at each strength level
+type engineLevel struct {
+	Depth      int // Full-width search depth in plies
+	Quiescence int // Extra plies of capture-only search at the leaves
+	Noise      int // Random centipawns added to root moves so weak levels make mistakes
+	Rating     int // Approximate strength, used for matchmaking and display
+}
+
+var alphaBetaLevels = []engineLevel{
+	{Depth: 1, Quiescence: 0, Noise: 150, Rating: 800},
+	{Depth: 2, Quiescence: 0, Noise: 60, Rating: 1100},
+	{Depth: 2, Quiescence: 2, Noise: 20, Rating: 1400},
+	{Depth: 3, Quiescence: 4, Noise: 0, Rating: 1700},
+	{Depth: 4, Quiescence: 6, Noise: 0, Rating: 1900},
+}
+
+// MaxEngineLevel is the strongest level accepted by the built-in engine
+var MaxEngineLevel = len(alphaBetaLevels)
+
+// AlphaBetaEngine is the built-in minimax engine with alpha-beta pruning
+type AlphaBetaEngine struct {
+	level engineLevel
+	index int
+}
+
+// NewAlphaBetaEngine creates the built-in engine at a level between 1 and MaxEngineLevel
+func NewAlphaBetaEngine(level int) (*AlphaBetaEngine, error) {
+	if level < 1 || level > MaxEngineLevel {
+		return nil, fmt.Errorf("engine level must be between 1 and %d, got %d", MaxEngineLevel, level)
+	}
+	return &AlphaBetaEngine{level: alphaBetaLevels[level-1], index: level}, nil
+}
+
+func (e *AlphaBetaEngine) Name() string {
+	return fmt.Sprintf("AlphaBeta level %d", e.index)
+}
+
+// Rating returns the approximate strength of the engine's level
+func (e *AlphaBetaEngine) Rating() int {
+	return e.level.Rating
+}
+
+func (e *AlphaBetaEngine) BestMove(ctx context.Context, game *chess.Game) (*chess.Move, error) {
+	pos := game.Position()
+	moves := orderMoves(pos, pos.ValidMoves())
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("no legal moves in position %s", pos.String())
+	}
+
+	var best *chess.Move
+	bestScore := -mateScore - 1
+	alpha, beta := -mateScore-1, mateScore+1
+
+	for _, move := range moves {
+		if err := ctx.Err(); err != nil {
+			if best != nil {
+				return best, nil
+			}
+			return nil, err
+		}
+
+		var score int
+		if e.level.Noise > 0 {
+			// Noise is only meaningful on exact scores, so weak levels search every root move with a full window
+			score = -e.search(ctx, pos.Update(move), e.level.Depth-1, -beta, mateScore+1, 1)
+			score += rand.Intn(2*e.level.Noise+1) - e.level.Noise
+		} else {
+			score = -e.search(ctx, pos.Update(move), e.level.Depth-1, -beta, -alpha, 1)
+		}
+
+		if score > bestScore {
+			best, bestScore = move, score
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return best, nil
+}
+
+// Analyze searches the game's current position and reports the best move with an exact score
+func (e *AlphaBetaEngine) Analyze(ctx context.Context, game *chess.Game) (*EngineAnalysis, error) {
+	pos := game.Position()
+	moves := orderMoves(pos, pos.ValidMoves())
+	if len(moves) == 0 {
+		return nil, fmt.Errorf("no legal moves in position %s", pos.String())
+	}
+
+	var best *chess.Move
+	alpha, beta := -mateScore-1, mateScore+1
+	for _, move := range moves {
+		score := -e.search(ctx, pos.Update(move), e.level.Depth-1, -beta, -alpha, 1)
+		if best == nil || score > alpha {
+			best, alpha = move, score
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	analysis := &EngineAnalysis{
+		BestMove: best.String(),
+		Depth:    e.level.Depth,
+		ScoreCP:  alpha,
+		PV:       []string{best.String()},
+	}
+	// Convert mate scores back to a number of moves, like a UCI engine reports them
+	if alpha > mateScore-1000 {
+		analysis.Mate = (mateScore - alpha + 1) / 2
+	} else if alpha < -mateScore+1000 {
+		analysis.Mate = -(mateScore + alpha) / 2
+	}
+	return analysis, nil
+}
+
+const mateScore = 100000
+
+// search is a negamax alpha-beta search returning the score from the side to move's point of view
+func (e *AlphaBetaEngine) search(ctx context.Context, pos *chess.Position, depth, alpha, beta, ply int) int {
+	moves := pos.ValidMoves()
+	if len(moves) == 0 {
+		if pos.Status() == chess.Checkmate {
+			// Prefer the fastest mate and the slowest defeat
+			return -mateScore + ply
+		}
+		return 0
+	}
+	if pos.HalfMoveClock() >= 100 {
+		return 0
+	}
+
+	if depth <= 0 {
+		return e.quiescence(pos, moves, e.level.Quiescence, alpha, beta)
+	}
+	if ctx.Err() != nil {
+		return evaluate(pos)
+	}
+
+	for _, move := range orderMoves(pos, moves) {
+		score := -e.search(ctx, pos.Update(move), depth-1, -beta, -alpha, ply+1)
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+// quiescence keeps searching captures so the evaluation is not taken in the middle of an exchange
+func (e *AlphaBetaEngine) quiescence(pos *chess.Position, moves []*chess.Move, depth, alpha, beta int) int {
+	standPat := evaluate(pos)
+	if depth <= 0 || standPat >= beta {
+		return standPat
+	}
+	if standPat > alpha {
+		alpha = standPat
+	}
+
+	for _, move := range orderMoves(pos, moves) {
+		if !move.HasTag(chess.Capture) && move.Promo() == chess.NoPieceType {
+			continue
+		}
+
+		next := pos.Update(move)
+		nextMoves := next.ValidMoves()
+		var score int
+		if len(nextMoves) == 0 {
+			if next.Status() == chess.Checkmate {
+				score = mateScore
+			}
+		} else {
+			score = -e.quiescence(next, nextMoves, depth-1, -beta, -alpha)
+		}
+
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+// orderMoves sorts moves so that promotions and the most valuable captures are searched first
+func orderMoves(pos *chess.Position, moves []*chess.Move) []*chess.Move {
+	board := pos.Board()
+	ordered := append([]*chess.Move(nil), moves...)
+	priority := func(move *chess.Move) int {
+		score := 0
+		if move.Promo() != chess.NoPieceType {
+			score += pieceValues[move.Promo()]
+		}
+		if move.HasTag(chess.Capture) {
+			victim := board.Piece(move.S2()).Type()
+			if move.HasTag(chess.EnPassant) {
+				victim = chess.Pawn
+			}
+			attacker := board.Piece(move.S1()).Type()
+			score += 10*pieceValues[victim] - pieceValues[attacker]
+		}
+		if move.HasTag(chess.Check) {
+			score += 50
+		}
+		return score
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return priority(ordered[i]) > priority(ordered[j])
+	})
+	return ordered
+}
+
+var pieceValues = map[chess.PieceType]int{
+	chess.Pawn:   100,
+	chess.Knight: 320,
+	chess.Bishop: 330,
+	chess.Rook:   500,
+	chess.Queen:  900,
+	chess.King:   0,
+}
+
+// Piece-square bonuses from White's point of view, indexed from a8 to h1 as they read on a diagram
+var pieceSquareTables = map[chess.PieceType][64]int{
+	chess.Pawn: {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		50, 50, 50, 50, 50, 50, 50, 50,
+		10, 10, 20, 30, 30, 20, 10, 10,
+		5, 5, 10, 25, 25, 10, 5, 5,
+		0, 0, 0, 20, 20, 0, 0, 0,
+		5, -5, -10, 0, 0, -10, -5, 5,
+		5, 10, 10, -20, -20, 10, 10, 5,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	},
+	chess.Knight: {
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-30, 5, 15, 20, 20, 15, 5, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 5, 10, 15, 15, 10, 5, -30,
+		-40, -20, 0, 5, 5, 0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	},
+	chess.Bishop: {
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 5, 5, 10, 10, 5, 5, -10,
+		-10, 0, 10, 10, 10, 10, 0, -10,
+		-10, 10, 10, 10, 10, 10, 10, -10,
+		-10, 5, 0, 0, 0, 0, 5, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	},
+	chess.Rook: {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		5, 10, 10, 10, 10, 10, 10, 5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		0, 0, 0, 5, 5, 0, 0, 0,
+	},
+	chess.Queen: {
+		-20, -10, -10, -5, -5, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-5, 0, 5, 5, 5, 5, 0, -5,
+		0, 0, 5, 5, 5, 5, 0, -5,
+		-10, 5, 5, 5, 5, 5, 0, -10,
+		-10, 0, 5, 0, 0, 0, 0, -10,
+		-20, -10, -10, -5, -5, -10, -10, -20,
+	},
+	chess.King: {
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-20, -30, -30, -40, -40, -30, -30, -20,
+		-10, -20, -20, -20, -20, -20, -20, -10,
+		20, 20, 0, 0, 0, 0, 20, 20,
+		20, 30, 10, 0, 0, 10, 30, 20,
+	},
+}
+
+// evaluate scores a position in centipawns from the side to move's point of view
+func evaluate(pos *chess.Position) int {
+	score := 0
+	for square, piece := range pos.Board().SquareMap() {
+		// Tables read from a8, so White uses the mirrored rank
+		rank, file := int(square.Rank()), int(square.File())
+		index := (7-rank)*8 + file
+		if piece.Color() == chess.Black {
+			index = rank*8 + file
+		}
+
+		value := pieceValues[piece.Type()] + pieceSquareTables[piece.Type()][index]
+		if piece.Color() == chess.White {
+			score += value
+		} else {
+			score -= value
+		}
+	}
+
+	if pos.Turn() == chess.Black {
+		return -score
+	}
+	return score
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// gridGame is a game of placing stones on a grid, won by the first player to line up
+// enough of them: tic-tac-toe, or Connect Four when the stones fall down their column. The
+// first player's stones are x, the second's o.
+//
+// Cells are named like chess squares, a1 being the bottom left one: an action is the cell
+// a stone is placed on ("b2"), or the column it is dropped in ("d") when stones fall. The
+// state writes the rows from the top, then the stone to play: "x.o/.x./... o".
+type gridGame struct {
+	width, height int
+	connect       int    // Stones in a row that win
+	gravity       bool   // Stones fall to the lowest empty cell of their column
+	method        string // Method of a win, e.g. "ThreeInARow"
+	cells         []byte // Row by row from the bottom, '.' when empty
+	actions       []string
+	outcome       chess.Outcome
+	ending        string // Method of the outcome
+}
+
+// newTicTacToe starts a game of tic-tac-toe: three in a row on a 3x3 grid
+func newTicTacToe() TurnGame {
+	return newGridGame(3, 3, 3, false, "ThreeInARow")
+}
+
+// newConnectFour starts a game of Connect Four: four in a row on a grid of 7 columns and 6
+// rows, the stones falling down their column
+func newConnectFour() TurnGame {
+	return newGridGame(7, 6, 4, true, "FourInARow")
+}
+
+func newGridGame(width, height, connect int, gravity bool, method string) *gridGame {
+	return &gridGame{
+		width:   width,
+		height:  height,
+		connect: connect,
+		gravity: gravity,
+		method:  method,
+		cells:   []byte(strings.Repeat(".", width*height)),
+		outcome: chess.NoOutcome,
+		ending:  chess.NoMethod.String(),
+	}
+}
+
+func (g *gridGame) Turn() chess.Color {
+	if len(g.actions)%2 == 0 {
+		return chess.White
+	}
+	return chess.Black
+}
+
+func (g *gridGame) LegalActions() []string {
+	if g.outcome != chess.NoOutcome {
+		return nil
+	}
+	var actions []string
+	for column := 0; column < g.width; column++ {
+		for row := 0; row < g.height; row++ {
+			if g.cells[row*g.width+column] != '.' {
+				continue
+			}
+			if g.gravity {
+				actions = append(actions, g.cellName(column, row)[:1])
+				break
+			}
+			actions = append(actions, g.cellName(column, row))
+		}
+	}
+	return actions
+}
+
+func (g *gridGame) Play(action string) error {
+	if g.outcome != chess.NoOutcome {
+		return errors.New("the game is over")
+	}
+	column, row, err := g.parseAction(action)
+	if err != nil {
+		return err
+	}
+	g.cells[row*g.width+column] = g.stone(g.Turn())
+	played := g.cellName(column, row)
+	if g.gravity {
+		played = played[:1]
+	}
+	g.actions = append(g.actions, played)
+
+	switch {
+	case g.lineThrough(column, row) >= g.connect:
+		g.outcome, g.ending = wonBy(g.Turn().Other()), g.method
+	case !slices.Contains(g.cells, '.'):
+		g.outcome, g.ending = chess.Draw, "FullBoard"
+	}
+	return nil
+}
+
+// parseAction finds the cell an action puts a stone on
+func (g *gridGame) parseAction(action string) (int, int, error) {
+	text := strings.ToLower(strings.TrimSpace(action))
+	if g.gravity {
+		if len(text) != 1 || text[0] < 'a' || int(text[0]-'a') >= g.width {
+			return 0, 0, fmt.Errorf("%q is not a column, expected a to %c", action, 'a'+g.width-1)
+		}
+		column := int(text[0] - 'a')
+		for row := 0; row < g.height; row++ {
+			if g.cells[row*g.width+column] == '.' {
+				return column, row, nil
+			}
+		}
+		return 0, 0, fmt.Errorf("column %s is full", text)
+	}
+
+	if len(text) != 2 || text[0] < 'a' || int(text[0]-'a') >= g.width || text[1] < '1' || int(text[1]-'1') >= g.height {
+		return 0, 0, fmt.Errorf("%q is not a cell, expected a1 to %c%d", action, 'a'+g.width-1, g.height)
+	}
+	column, row := int(text[0]-'a'), int(text[1]-'1')
+	if g.cells[row*g.width+column] != '.' {
+		return 0, 0, fmt.Errorf("%s is taken", text)
+	}
+	return column, row, nil
+}
+
+// lineThrough returns the length of the longest line of the same stones through a cell
+func (g *gridGame) lineThrough(column, row int) int {
+	stone := g.cells[row*g.width+column]
+	longest := 0
+	for _, direction := range [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}} {
+		length := 1
+		for _, sign := range []int{1, -1} {
+			c, r := column+sign*direction[0], row+sign*direction[1]
+			for c >= 0 && c < g.width && r >= 0 && r < g.height && g.cells[r*g.width+c] == stone {
+				length++
+				c, r = c+sign*direction[0], r+sign*direction[1]
+			}
+		}
+		longest = max(longest, length)
+	}
+	return longest
+}
+
+func (g *gridGame) Outcome() (chess.Outcome, string) {
+	return g.outcome, g.ending
+}
+
+func (g *gridGame) State() string {
+	rows := make([]string, 0, g.height)
+	for row := g.height - 1; row >= 0; row-- {
+		rows = append(rows, string(g.cells[row*g.width:(row+1)*g.width]))
+	}
+	return strings.Join(rows, "/") + " " + string(g.stone(g.Turn()))
+}
+
+func (g *gridGame) Actions() []string {
+	return slices.Clone(g.actions)
+}
+
+func (g *gridGame) LastAction() string {
+	if len(g.actions) == 0 {
+		return ""
+	}
+	return g.actions[len(g.actions)-1]
+}
+
+// stone returns the stone of a seat
+func (g *gridGame) stone(seat chess.Color) byte {
+	if seat == chess.White {
+		return 'x'
+	}
+	return 'o'
+}
+
+// cellName names a cell like a chess square, e.g. "b2"
+func (g *gridGame) cellName(column, row int) string {
+	return fmt.Sprintf("%c%d", 'a'+column, row+1)
+}
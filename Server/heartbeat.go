@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// Heartbeat pings the clients at a regular interval so dead peers are noticed, and cleans up
+// after the players who left: it records since when each player has no connected client,
+// closes the lobbies nobody waits in any more and lets the opponent of a player gone for
+// longer than the abandon timeout claim the game.
+type Heartbeat struct {
+	mu          sync.Mutex
+	absentSince map[string]time.Time // Players of open games without a connected client
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Global Heartbeat instance
+var heartbeat = NewHeartbeat()
+
+func NewHeartbeat() *Heartbeat {
+	return &Heartbeat{
+		absentSince: make(map[string]time.Time),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start runs the heartbeat in the background, ticking at the given interval
+func (h *Heartbeat) Start(interval time.Duration) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.stopChan:
+				slog.Info("Heartbeat stopped")
+				return
+			case now := <-ticker.C:
+				h.Tick(now)
+			}
+		}
+	}()
+}
+
+// Stop ends the heartbeat loop
+func (h *Heartbeat) Stop() {
+	close(h.stopChan)
+	h.wg.Wait()
+}
+
+// Tick pings every client, closes the lobbies whose players are all gone and prunes the
+// abuse protection state
+func (h *Heartbeat) Tick(now time.Time) {
+	ping := []byte(strconv.FormatInt(now.UnixMilli(), 10))
+	peers.Broadcast(Ping, ping)
+
+	for _, lobbyName := range h.sweep(now) {
+		if _, err := closeLobby(lobbyName); err == nil {
+			slog.Info("Abandoned lobby closed", "lobby", lobbyName)
+		}
+	}
+
+	// The rate limits and authentication failures of clients gone quiet need no memory
+	rateLimiter.Prune(now)
+	authFailures.Prune(now)
+}
+
+// sweep updates the absence of the players of open games and returns the lobbies in which
+// every player has been gone for longer than the idle timeout
+func (h *Heartbeat) sweep(now time.Time) []string {
+	gameMutex.RLock()
+	defer gameMutex.RUnlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var abandoned []string
+	seen := make(map[string]bool)
+	for lobbyName, gameID := range LobbyNameToUUID {
+		session := GameStore[gameID]
+		if session.Outcome() != chess.NoOutcome {
+			continue
+		}
+
+		everyoneGone := true
+		for _, player := range session.JoinedPlayers {
+			seen[player] = true
+			if isConnected(player) {
+				delete(h.absentSince, player)
+				everyoneGone = false
+				continue
+			}
+			// Players of games restored from disk are absent from the first sweep on
+			since, known := h.absentSince[player]
+			if !known {
+				since = now
+				h.absentSince[player] = now
+			}
+			if now.Sub(since) < config.IdleTimeout.Duration {
+				everyoneGone = false
+			}
+		}
+		if !session.IsLocked && everyoneGone {
+			abandoned = append(abandoned, lobbyName)
+		}
+	}
+
+	// Players who are no longer in an open game need no tracking
+	for player := range h.absentSince {
+		if !seen[player] {
+			delete(h.absentSince, player)
+		}
+	}
+	return abandoned
+}
+
+// PlayerLeft records that a player's last client is gone
+func (h *Heartbeat) PlayerLeft(playerName string, at time.Time) {
+	if playerName == "" || isConnected(playerName) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, known := h.absentSince[playerName]; !known {
+		h.absentSince[playerName] = at
+	}
+}
+
+// PlayerReturned forgets the absence of a player who connected again
+func (h *Heartbeat) PlayerReturned(playerName string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.absentSince, playerName)
+}
+
+// AbsentFor returns how long a player has been without a connected client
+func (h *Heartbeat) AbsentFor(playerName string, now time.Time) (time.Duration, bool) {
+	if isConnected(playerName) {
+		return 0, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	since, known := h.absentSince[playerName]
+	if !known {
+		// Gone before the server knew them, e.g. since a restart: the wait starts now
+		h.absentSince[playerName] = now
+		return 0, true
+	}
+	return now.Sub(since), true
+}
+
+// isConnected reports whether a player has at least one client connected, bots included
+func isConnected(playerName string) bool {
+	return len(clientList.GetClientByName(playerName)) > 0
+}
+
+// disconnectClient forgets a client whose connection closed or who stopped answering: its
+// peer, its place in the matchmaking queue and its ClientList entry
+func disconnectClient(address string, reason string) {
+	client, known := clientList.GetClient(address)
+	peers.Unregister(address)
+	clientList.RemoveClient(address)
+	if !known {
+		return
+	}
+
+	matchmaker.Cancel(client.FirstName)
+	heartbeat.PlayerLeft(client.FirstName, time.Now())
+	slog.Info("Client disconnected", "remote", address, "player", client.FirstName, "reason", reason)
+}
+
+// claimAbandonedGame ends a game as won by the claimant when the opponent has been gone
+// for longer than the abandon timeout
+func claimAbandonedGame(gameID uuid.UUID, claimant string, now time.Time) error {
+	gameMutex.RLock()
+	session, ok := GameStore[gameID]
+	over := ok && session.Outcome() != chess.NoOutcome
+	gameMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("game session not found for gameID %v", gameID)
+	}
+	if over {
+		return fmt.Errorf("game %v is already over", gameID)
+	}
+	if !session.IsLocked {
+		return fmt.Errorf("game %v has not started", gameID)
+	}
+
+	var opponent string
+	var outcome chess.Outcome
+	switch session.PlayerColor(claimant) {
+	case chess.White:
+		opponent, outcome = session.BlackPlayer, chess.WhiteWon
+	case chess.Black:
+		opponent, outcome = session.WhitePlayer, chess.BlackWon
+	default:
+		return fmt.Errorf("player %s is not seated in game %v", claimant, gameID)
+	}
+
+	absent, gone := heartbeat.AbsentFor(opponent, now)
+	if !gone {
+		return fmt.Errorf("%s is still connected", opponent)
+	}
+	if absent < config.AbandonTimeout.Duration {
+		wait := (config.AbandonTimeout.Duration - absent).Round(time.Second)
+		return fmt.Errorf("%s left %s ago, the game can be claimed in %s", opponent, absent.Round(time.Second), wait)
+	}
+
+	_, err := endGame(gameID, outcome, "Abandonment")
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+func TestClaimAbandonedGame(t *testing.T) {
+	gameID := openJoinedLobby(t, "ClaimAnn", "ClaimBob")
+	// The seats decide who claims, not the order the players joined in
+	gameMutex.Lock()
+	session := GameStore[gameID]
+	session.WhitePlayer, session.BlackPlayer = "ClaimBob", "ClaimAnn"
+	GameStore[gameID] = session
+	gameMutex.Unlock()
+
+	connectClient(t, "ClaimAnn", "ann-signature", gameID)
+	bob := connectClient(t, "ClaimBob", "bob-signature", gameID)
+	if err := claimAbandonedGame(gameID, "ClaimAnn", time.Now()); err == nil || !strings.Contains(err.Error(), "still connected") {
+		t.Errorf("claim against a connected opponent gave %v", err)
+	}
+
+	disconnectClient(bob.addr.String(), "connection closed")
+	t.Cleanup(func() { heartbeat.PlayerReturned("ClaimBob") })
+	left := time.Now() // The disconnect recorded the absence just before
+
+	for _, test := range []struct {
+		claimant string
+		at       time.Time
+		want     string // Part of the error
+	}{
+		{"ClaimEve", left.Add(config.AbandonTimeout.Duration), "not seated"},
+		{"ClaimAnn", left.Add(config.AbandonTimeout.Duration / 2), "can be claimed in"},
+	} {
+		if err := claimAbandonedGame(gameID, test.claimant, test.at); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("claim by %s gave %v, want an error about %q", test.claimant, err, test.want)
+		}
+	}
+
+	if err := claimAbandonedGame(gameID, "ClaimAnn", left.Add(config.AbandonTimeout.Duration)); err != nil {
+		t.Fatalf("the claim once the timeout passed was refused: %v", err)
+	}
+	gameMutex.RLock()
+	session = GameStore[gameID]
+	gameMutex.RUnlock()
+	if session.Outcome() != chess.BlackWon || session.TerminationMethod() != "Abandonment" {
+		t.Errorf("the claimed game ended %s by %s, want Black won by abandonment", session.Outcome(), session.TerminationMethod())
+	}
+	if err := claimAbandonedGame(gameID, "ClaimAnn", left.Add(config.AbandonTimeout.Duration)); err == nil {
+		t.Error("a game over was claimed")
+	}
+}
+
+func TestUnstartedGameCannotBeClaimed(t *testing.T) {
+	setup, err := ParseGameSetup("standard", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameID, err := createNewGame("WaitAnn", "WaitAnn", "Lobby-WaitAnn", setup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeLobby("Lobby-WaitAnn") })
+	if err := claimAbandonedGame(gameID, "WaitAnn", time.Now().Add(time.Hour)); err == nil || !strings.Contains(err.Error(), "not started") {
+		t.Errorf("claim of an open lobby gave %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// hordeFEN is the starting position of Horde: 36 white pawns and no white king
+const hordeFEN = "rnbqkbnr/pppppppp/8/1PP2PP1/PPPPPPPP/PPPPPPPP/PPPPPPPP/PPPPPPPP w kq - 0 1"
+
+// hordeRules are the rules of Horde: White's pawns win by checkmating Black, Black wins by
+// taking all of them. The pawns of the first rank may move two squares, which the chess
+// package does not know: the server plays these moves itself, without en passant.
+type hordeRules struct{ standardRules }
+
+func (hordeRules) StartingFEN(setup string) (string, error) {
+	return withoutSetup(setup, hordeFEN)
+}
+
+func (hordeRules) LegalMoves(session *GameSession) []string {
+	return append((standardRules{}).LegalMoves(session), firstRankSteps(session.Game.Position())...)
+}
+
+// Play plays the move, without opening names: the book knows the standard start only
+func (hordeRules) Play(session *GameSession, notation string) error {
+	position := session.Game.Position()
+	text := strings.TrimRight(strings.TrimSpace(notation), "+#!?")
+	for _, step := range firstRankSteps(position) {
+		if text != step && text != step[2:] {
+			continue
+		}
+		board := position.Board().SquareMap()
+		from, to := chess.NewSquare(chess.File(step[0]-'a'), chess.Rank1), chess.NewSquare(chess.File(step[2]-'a'), chess.Rank3)
+		board[to] = board[from]
+		delete(board, from)
+		fields := strings.Fields(position.String())
+		fen := fmt.Sprintf("%s b %s - 0 %s", chess.NewBoard(board), fields[2], fields[5])
+		return splitGame(session, fen, step, step[2:])
+	}
+	return playChessMove(session, notation)
+}
+
+// Outcome ends the game when White has nothing left, which the chess package takes for a
+// stalemate
+func (hordeRules) Outcome(session *GameSession) (chess.Outcome, string) {
+	for _, piece := range session.Game.Position().Board().SquareMap() {
+		if piece.Color() == chess.White {
+			return (standardRules{}).Outcome(session)
+		}
+	}
+	return chess.BlackWon, "HordeCaptured"
+}
+
+// firstRankSteps lists the moves of White's pawns two squares up from the first rank, in
+// UCI notation
+func firstRankSteps(position *chess.Position) []string {
+	if position.Turn() != chess.White {
+		return nil
+	}
+	board := position.Board()
+	var steps []string
+	for file := chess.FileA; file <= chess.FileH; file++ {
+		from := chess.NewSquare(file, chess.Rank1)
+		if board.Piece(from) == chess.WhitePawn &&
+			board.Piece(chess.NewSquare(file, chess.Rank2)) == chess.NoPiece &&
+			board.Piece(chess.NewSquare(file, chess.Rank3)) == chess.NoPiece {
+			steps = append(steps, from.String()+chess.NewSquare(file, chess.Rank3).String())
+		}
+	}
+	return steps
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startHTTPServer serves handler on addr in the background until the returned server is
+// shut down, over HTTPS when tlsConfig is set. The port is bound before returning, so
+// startup errors are known right away, and the server's Addr is the bound address.
+func startHTTPServer(name, addr string, handler http.Handler, tlsConfig *tls.Config) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the %s server on %s: %w", name, addr, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	srv := &http.Server{Addr: listener.Addr().String(), Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", "server", name, "err", err)
+		}
+	}()
+	slog.Info("HTTP server listening", "server", name, "addr", listener.Addr().String(), "tls", tlsConfig != nil)
+	return srv, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// redactedValue replaces secrets in log records
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeys are attribute key fragments whose values never reach the logs
+var sensitiveKeys = []string{"signature", "hash", "token", "secret", "password"}
+
+// isSensitiveKey reports whether an attribute with this key holds a secret
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr is the slog ReplaceAttr hook hiding secrets, including inside groups
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if isSensitiveKey(attr.Key) && attr.Value.Kind() != slog.KindGroup {
+		return slog.String(attr.Key, redactedValue)
+	}
+	return attr
+}
+
+// parseLogLevel maps the configured level name onto a slog level
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// newLogHandler builds the handler for the configured format, level and redaction
+func newLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, options), nil
+	case "json":
+		return slog.NewJSONHandler(w, options), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// SetupLogging installs the configured logger as the default one. Lines still written
+// with the standard log package go through it too, at the info level.
+func SetupLogging(cfg Config) (io.Closer, error) {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	var output io.WriteCloser = os.Stderr
+	if cfg.LogFile != "" {
+		file, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file: %w", err)
+		}
+		output = file
+	}
+
+	handler, err := newLogHandler(output, cfg.LogFormat, level)
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	return output, nil
+}
+
+// connLogger returns a logger carrying the transport and remote address of a client
+func connLogger(transport string, remote net.Addr) *slog.Logger {
+	if remote == nil {
+		return slog.With("transport", transport)
+	}
+	return slog.With("transport", transport, "remote", remote.String())
+}
+
+// requestLogger returns a logger for a request, tagged with its connection and request name
+func requestLogger(conn net.Conn, clientAddr *net.UDPAddr, isTCP bool, request Tag) *slog.Logger {
+	var logger *slog.Logger
+	switch {
+	case isTCP && conn != nil:
+		logger = connLogger(streamTransport(conn), conn.RemoteAddr())
+	case clientAddr != nil:
+		logger = connLogger("udp", clientAddr)
+	default:
+		logger = slog.Default()
+	}
+	return logger.With("request", GetTagName(request))
+}
+
+// gameLogger returns a logger tagged with a game
+func gameLogger(gameID uuid.UUID) *slog.Logger {
+	return slog.With("game", gameID.String())
+}
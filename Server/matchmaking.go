@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueueKey identifies a matchmaking pool: players only meet others with the same game, time control and rated flag
+type QueueKey struct {
+	Game        string // Game of gameRegistry, "" for standard chess
+	TimeControl string
+	Rated       bool
+}
+
+// QueueEntry is a player waiting in the matchmaking pool
+type QueueEntry struct {
+	PlayerName   string
+	Address      string
+	Rating       int
+	Key          QueueKey
+	TimeControl  TimeControl
+	JoinedAt     time.Time
+	LastStatusAt time.Time
+}
+
+// Matchmaker pairs queued players whose ratings fall within a window that widens the longer they wait
+type Matchmaker struct {
+	mu     sync.Mutex
+	queues map[QueueKey][]*QueueEntry
+
+	BaseWindow     int           // Rating difference accepted as soon as a player joins
+	WindowGrowth   int           // Extra rating difference accepted per second of waiting
+	MaxWindow      int           // Upper bound for the rating window
+	StatusInterval time.Duration // How often waiting players receive a QueueStatus update
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Global Matchmaker instance
+var matchmaker = NewMatchmaker()
+
+// NewMatchmaker creates a matchmaker with the default rating window settings
+func NewMatchmaker() *Matchmaker {
+	return &Matchmaker{
+		queues:         make(map[QueueKey][]*QueueEntry),
+		BaseWindow:     100,
+		WindowGrowth:   10,
+		MaxWindow:      800,
+		StatusInterval: 5 * time.Second,
+		stopChan:       make(chan struct{}),
+	}
+}
+
+// Window returns the rating difference the entry accepts at the given time
+func (mm *Matchmaker) Window(entry *QueueEntry, now time.Time) int {
+	waited := int(now.Sub(entry.JoinedAt).Seconds())
+	window := mm.BaseWindow + waited*mm.WindowGrowth
+	if window > mm.MaxWindow {
+		window = mm.MaxWindow
+	}
+	return window
+}
+
+// Enqueue adds a player to the pool matching its time control and rated flag
+func (mm *Matchmaker) Enqueue(entry *QueueEntry) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for _, queue := range mm.queues {
+		for _, queued := range queue {
+			if queued.PlayerName == entry.PlayerName {
+				return fmt.Errorf("player %s is already in the matchmaking queue", entry.PlayerName)
+			}
+		}
+	}
+
+	if entry.JoinedAt.IsZero() {
+		entry.JoinedAt = time.Now()
+	}
+	mm.queues[entry.Key] = append(mm.queues[entry.Key], entry)
+	return nil
+}
+
+// Cancel removes a player from whichever pool it is waiting in
+func (mm *Matchmaker) Cancel(playerName string) (*QueueEntry, bool) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for key, queue := range mm.queues {
+		for i, queued := range queue {
+			if queued.PlayerName == playerName {
+				mm.queues[key] = append(queue[:i], queue[i+1:]...)
+				if len(mm.queues[key]) == 0 {
+					delete(mm.queues, key)
+				}
+				return queued, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// QueueSize returns the number of players waiting in the given pool
+func (mm *Matchmaker) QueueSize(key QueueKey) int {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	return len(mm.queues[key])
+}
+
+// Pair removes and returns every pair of players that can be matched at the given time.
+// Players are considered in the order they joined, and each is paired with the closest
+// rating that both players' windows accept.
+func (mm *Matchmaker) Pair(now time.Time) [][2]*QueueEntry {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var pairs [][2]*QueueEntry
+	for key, queue := range mm.queues {
+		sort.SliceStable(queue, func(i, j int) bool {
+			return queue[i].JoinedAt.Before(queue[j].JoinedAt)
+		})
+
+		matched := make([]bool, len(queue))
+		for i, entry := range queue {
+			if matched[i] {
+				continue
+			}
+
+			best := -1
+			bestDiff := 0
+			for j := i + 1; j < len(queue); j++ {
+				if matched[j] {
+					continue
+				}
+				diff := entry.Rating - queue[j].Rating
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff > mm.Window(entry, now) || diff > mm.Window(queue[j], now) {
+					continue
+				}
+				if best == -1 || diff < bestDiff {
+					best, bestDiff = j, diff
+				}
+			}
+
+			if best != -1 {
+				matched[i], matched[best] = true, true
+				pairs = append(pairs, [2]*QueueEntry{entry, queue[best]})
+			}
+		}
+
+		// Keep only the players that are still waiting
+		remaining := queue[:0]
+		for i, entry := range queue {
+			if !matched[i] {
+				remaining = append(remaining, entry)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(mm.queues, key)
+		} else {
+			mm.queues[key] = remaining
+		}
+	}
+	return pairs
+}
+
+// dueForStatus returns the waiting entries that have not received a QueueStatus recently
+func (mm *Matchmaker) dueForStatus(now time.Time) []*QueueEntry {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var due []*QueueEntry
+	for _, queue := range mm.queues {
+		for _, entry := range queue {
+			if now.Sub(entry.LastStatusAt) >= mm.StatusInterval {
+				entry.LastStatusAt = now
+				due = append(due, entry)
+			}
+		}
+	}
+	return due
+}
+
+// Tick pairs everyone it can, starts their games and sends status updates to those still waiting
+func (mm *Matchmaker) Tick(now time.Time) {
+	for _, pair := range mm.Pair(now) {
+		startMatchedGame(pair[0], pair[1])
+	}
+
+	for _, entry := range mm.dueForStatus(now) {
+		sendQueueStatus(entry, "searching", mm.Window(entry, now), mm.QueueSize(entry.Key))
+	}
+}
+
+// Start runs the matchmaking loop in the background, pairing players every interval
+func (mm *Matchmaker) Start(interval time.Duration) {
+	mm.wg.Add(1)
+	go func() {
+		defer mm.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-mm.stopChan:
+				slog.Info("Matchmaker stopped")
+				return
+			case now := <-ticker.C:
+				mm.Tick(now)
+			}
+		}
+	}()
+}
+
+// Stop ends the matchmaking loop
+func (mm *Matchmaker) Stop() {
+	close(mm.stopChan)
+	mm.wg.Wait()
+}
+
+// startMatchedGame creates the game for a matched pair, assigns colors at random and notifies both players
+func startMatchedGame(a, b *QueueEntry) {
+	white, black := a, b
+	if rand.Intn(2) == 1 {
+		white, black = b, a
+	}
+
+	gameID, err := createMatchedGame(white.PlayerName, black.PlayerName, white.TimeControl, white.Key.Rated, white.Key.Game)
+	if err != nil {
+		slog.Error("Error creating the game of matched players", "white", white.PlayerName, "black", black.PlayerName, "game", white.Key.Game, "err", err)
+		return
+	}
+	gameLogger(gameID).Info("Players matched", "white", white.PlayerName, "white_rating", white.Rating, "black", black.PlayerName, "black_rating", black.Rating)
+
+	for _, side := range []struct {
+		entry    *QueueEntry
+		color    string
+		opponent *QueueEntry
+	}{
+		{white, "white", black},
+		{black, "black", white},
+	} {
+		if err := clientList.SetClientGameID(side.entry.Address, gameID); err != nil {
+			gameLogger(gameID).Warn("Error setting GameID for matched player", "player", side.entry.PlayerName, "err", err)
+		}
+
+		message, err := encodeMatchFound(gameID[:], side.color, side.opponent)
+		if err != nil {
+			gameLogger(gameID).Error("Error encoding MatchFound", "player", side.entry.PlayerName, "err", err)
+			continue
+		}
+		if err := pushToAddress(side.entry.Address, MatchFound, message); err != nil {
+			gameLogger(gameID).Warn("Error notifying player of match", "player", side.entry.PlayerName, "err", err)
+		}
+	}
+}
+
+// encodeMatchFound builds the MatchFound payload: game UUID, assigned color, opponent name and rating
+func encodeMatchFound(gameID []byte, color string, opponent *QueueEntry) ([]byte, error) {
+	return encodeTLVFields(
+		tlvField{UUIDPartie, gameID},
+		tlvField{String, []byte(color)},
+		tlvField{String, []byte(opponent.PlayerName)},
+		tlvField{Int, []byte(strconv.Itoa(opponent.Rating))},
+		tlvField{String, []byte(opponent.TimeControl.String())},
+	)
+}
+
+// sendQueueStatus pushes the state of a queued player: state, seconds waited, rating window and pool size
+func sendQueueStatus(entry *QueueEntry, state string, window int, queueSize int) {
+	waited := int(time.Since(entry.JoinedAt).Seconds())
+	message, err := encodeTLVFields(
+		tlvField{String, []byte(state)},
+		tlvField{Int, []byte(strconv.Itoa(waited))},
+		tlvField{Int, []byte(strconv.Itoa(window))},
+		tlvField{Int, []byte(strconv.Itoa(queueSize))},
+	)
+	if err != nil {
+		slog.Error("Error encoding QueueStatus", "player", entry.PlayerName, "err", err)
+		return
+	}
+	if err := pushToAddress(entry.Address, QueueStatus, message); err != nil {
+		slog.Warn("Error sending QueueStatus", "player", entry.PlayerName, "err", err)
+	}
+}
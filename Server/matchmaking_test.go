@@ -0,0 +1,254 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// recordingPeer is a simulated player: it keeps what the server pushes to it
+type recordingPeer struct {
+	mu       sync.Mutex
+	messages []pushedMessage
+}
+
+type pushedMessage struct {
+	tag    Tag
+	fields []tlvField
+}
+
+func (p *recordingPeer) Send(tag Tag, message []byte) error {
+	fields, err := decodeTLVFields(message)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, pushedMessage{tag, fields})
+	return nil
+}
+
+// received returns the messages of a tag pushed so far
+func (p *recordingPeer) received(tag Tag) []pushedMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var messages []pushedMessage
+	for _, message := range p.messages {
+		if message.tag == tag {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// connectPlayer registers a simulated player at a unique address, as Hello does
+func connectPlayer(t *testing.T, name string, rating int) (string, *recordingPeer) {
+	t.Helper()
+	address := "sim-" + name + "-" + uuid.NewString()[:8]
+	peer := &recordingPeer{}
+	clientList.AddClient(address, Client{FirstName: name, Level: rating, Address: address})
+	peers.Register(address, peer)
+	t.Cleanup(func() {
+		clientList.RemoveClient(address)
+		peers.Unregister(address)
+	})
+	return address, peer
+}
+
+// queueEntry builds the entry of a player who joined a pool at the given time
+func queueEntry(name string, rating int, key QueueKey, joinedAt time.Time) *QueueEntry {
+	timeControl, _ := ParseTimeControl(key.TimeControl)
+	return &QueueEntry{PlayerName: name, Address: "sim-" + name, Rating: rating, Key: key, TimeControl: timeControl, JoinedAt: joinedAt}
+}
+
+func TestPairOnlyWithinSamePool(t *testing.T) {
+	mm := NewMatchmaker()
+	now := time.Now()
+	blitz := QueueKey{TimeControl: "5+3", Rated: true}
+	casualBlitz := QueueKey{TimeControl: "5+3"}
+	rapid := QueueKey{TimeControl: "15+10", Rated: true}
+
+	for _, entry := range []*QueueEntry{
+		queueEntry("ann", 1500, blitz, now),
+		queueEntry("bob", 1500, casualBlitz, now),
+		queueEntry("cid", 1500, rapid, now),
+		queueEntry("dan", 1520, blitz, now),
+	} {
+		if err := mm.Enqueue(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pairs := mm.Pair(now)
+	if len(pairs) != 1 {
+		t.Fatalf("got %d pairs, want 1", len(pairs))
+	}
+	if names := pairs[0][0].PlayerName + "-" + pairs[0][1].PlayerName; names != "ann-dan" {
+		t.Errorf("paired %s, want ann-dan", names)
+	}
+	if size := mm.QueueSize(casualBlitz) + mm.QueueSize(rapid); size != 2 {
+		t.Errorf("%d players left in the other pools, want 2", size)
+	}
+	if size := mm.QueueSize(blitz); size != 0 {
+		t.Errorf("%d players left in the paired pool, want 0", size)
+	}
+}
+
+func TestEnqueueRefusesPlayerAlreadyQueued(t *testing.T) {
+	mm := NewMatchmaker()
+	if err := mm.Enqueue(queueEntry("ann", 1500, QueueKey{TimeControl: "5+3"}, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.Enqueue(queueEntry("ann", 1500, QueueKey{TimeControl: "1+0"}, time.Now())); err == nil {
+		t.Error("a player was queued twice")
+	}
+}
+
+func TestRatingWindowWidensWhileWaiting(t *testing.T) {
+	mm := NewMatchmaker()
+	joined := time.Now()
+	key := QueueKey{TimeControl: "3+2"}
+	mm.Enqueue(queueEntry("ann", 1500, key, joined))
+	mm.Enqueue(queueEntry("bob", 1800, key, joined))
+
+	if window := mm.Window(queueEntry("x", 0, key, joined), joined); window != mm.BaseWindow {
+		t.Errorf("window on joining is %d, want %d", window, mm.BaseWindow)
+	}
+	if pairs := mm.Pair(joined.Add(10 * time.Second)); len(pairs) != 0 {
+		t.Fatalf("paired 300 points apart with a window of %d", mm.Window(queueEntry("x", 0, key, joined), joined.Add(10*time.Second)))
+	}
+	// 100 + 20s * 10 reaches the 300 points between them
+	if pairs := mm.Pair(joined.Add(20 * time.Second)); len(pairs) != 1 {
+		t.Fatal("not paired once the windows widened")
+	}
+	if window := mm.Window(queueEntry("x", 0, key, joined), joined.Add(time.Hour)); window != mm.MaxWindow {
+		t.Errorf("window after an hour is %d, want the maximum %d", window, mm.MaxWindow)
+	}
+}
+
+func TestPairPrefersClosestRating(t *testing.T) {
+	mm := NewMatchmaker()
+	now := time.Now()
+	key := QueueKey{TimeControl: "5+0"}
+	mm.Enqueue(queueEntry("ann", 1500, key, now))
+	mm.Enqueue(queueEntry("bob", 1580, key, now.Add(time.Millisecond)))
+	mm.Enqueue(queueEntry("cid", 1510, key, now.Add(2*time.Millisecond)))
+
+	pairs := mm.Pair(now.Add(time.Second))
+	if len(pairs) != 1 || pairs[0][1].PlayerName != "cid" {
+		t.Fatalf("ann was not paired with cid: %v", pairs)
+	}
+	if mm.QueueSize(key) != 1 {
+		t.Error("bob should still be waiting")
+	}
+}
+
+func TestCancelLeavesQueue(t *testing.T) {
+	mm := NewMatchmaker()
+	now := time.Now()
+	key := QueueKey{TimeControl: "5+3"}
+	mm.Enqueue(queueEntry("ann", 1500, key, now))
+	mm.Enqueue(queueEntry("bob", 1500, key, now))
+
+	entry, ok := mm.Cancel("ann")
+	if !ok || entry.PlayerName != "ann" {
+		t.Fatal("ann could not leave the queue")
+	}
+	if _, ok := mm.Cancel("ann"); ok {
+		t.Error("ann left the queue twice")
+	}
+	if pairs := mm.Pair(now); len(pairs) != 0 {
+		t.Errorf("a cancelled player was paired: %v", pairs)
+	}
+	if err := mm.Enqueue(queueEntry("ann", 1500, key, now)); err != nil {
+		t.Errorf("ann could not queue again after leaving: %v", err)
+	}
+}
+
+func TestStartMatchedGameNotifiesBothPlayers(t *testing.T) {
+	annAddress, ann := connectPlayer(t, "MatchAnn", 1500)
+	bobAddress, bob := connectPlayer(t, "MatchBob", 1550)
+	key := QueueKey{TimeControl: "5+3"}
+	a := queueEntry("MatchAnn", 1500, key, time.Now())
+	a.Address = annAddress
+	b := queueEntry("MatchBob", 1550, key, time.Now())
+	b.Address = bobAddress
+
+	startMatchedGame(a, b)
+
+	annMatch, bobMatch := ann.received(MatchFound), bob.received(MatchFound)
+	if len(annMatch) != 1 || len(bobMatch) != 1 {
+		t.Fatalf("MatchFound received %d and %d times, want once each", len(annMatch), len(bobMatch))
+	}
+	annFields, bobFields := annMatch[0].fields, bobMatch[0].fields
+	if string(annFields[0].Value) != string(bobFields[0].Value) {
+		t.Error("the players were told of different games")
+	}
+	colors := string(annFields[1].Value) + "/" + string(bobFields[1].Value)
+	if colors != "white/black" && colors != "black/white" {
+		t.Errorf("colors %s, want one white and one black", colors)
+	}
+	if string(annFields[2].Value) != "MatchBob" || string(bobFields[2].Value) != "MatchAnn" {
+		t.Error("the players were not told who their opponent is")
+	}
+	if string(annFields[3].Value) != "1550" || string(annFields[4].Value) != "5+3" {
+		t.Errorf("ann was told rating %s and time control %s", annFields[3].Value, annFields[4].Value)
+	}
+
+	gameID, err := uuid.FromBytes(annFields[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameMutex.RLock()
+	session, ok := GameStore[gameID]
+	gameMutex.RUnlock()
+	if !ok || !session.IsLocked || session.TimeControl.String() != "5+3" {
+		t.Fatalf("the matched game is missing or not started: %+v", session)
+	}
+	if seated := session.WhitePlayer + "/" + session.BlackPlayer; seated != "MatchAnn/MatchBob" && seated != "MatchBob/MatchAnn" {
+		t.Errorf("seated %s, want one player on each side", seated)
+	}
+	for _, address := range []string{annAddress, bobAddress} {
+		if current, _ := clientList.GetClientGameID(address); current != gameID {
+			t.Errorf("the game of %s is %v, want %v", address, current, gameID)
+		}
+	}
+}
+
+func TestRefusedQueueRequestsKeepTheConnection(t *testing.T) {
+	conn := connectClient(t, "QueueRefusedAnn", "ann-signature", uuid.Nil)
+	t.Cleanup(func() { matchmaker.Cancel("QueueRefusedAnn") })
+
+	queue := func(timeControl string) []byte {
+		return signRequest(t, "ann-signature",
+			tlvField{QueueRequest, []byte("QueueRequest")}, tlvField{String, []byte(timeControl)}, tlvField{Int, []byte("0")})
+	}
+	cancel := signRequest(t, "ann-signature", tlvField{QueueCancelRequest, []byte("QueueCancelRequest")})
+
+	for _, test := range []struct {
+		name    string
+		queued  bool // The player queues first
+		handler RequestHandler
+		request []byte
+	}{
+		{"invalid time control", false, HandleQueueRequest, queue("soon")},
+		{"cancel while not queued", false, HandleQueueCancelRequest, cancel},
+		{"queued twice", true, HandleQueueRequest, queue("5+3")},
+	} {
+		if test.queued {
+			if err := HandleQueueRequest(conn, nil, nil, queue("5+3"), true); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// An error returned to the TCP server would close the connection
+		if err := test.handler(conn, nil, nil, test.request, true); err != nil {
+			t.Errorf("%s: returned %v, want the request refused with an ErrorResponse", test.name, err)
+			continue
+		}
+		if tag, _ := conn.next(t); tag != ErrorResponse {
+			t.Errorf("%s: got %s, want an ErrorResponse", test.name, GetTagName(tag))
+		}
+	}
+}
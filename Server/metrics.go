@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics are written in the Prometheus text exposition format. Counters and histograms
+// are updated as the server runs, gauges are computed from the server state at scrape time.
+
+// metricsContentType is the content type of the Prometheus text format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// serverMetrics are the metrics of this server, exposed on /metrics when MetricsAddr is set
+var serverMetrics = newServerMetrics()
+
+type ServerMetrics struct {
+	registry *MetricsRegistry
+
+	requests        *CounterVec   // By request tag and transport
+	requestDuration *HistogramVec // By request tag
+	handlerErrors   *CounterVec   // By request tag and error code
+	moves           *CounterVec
+	udpRetransmits  *CounterVec
+	bytesReceived   *CounterVec // By transport
+	bytesSent       *CounterVec // By transport
+	rateLimited     *CounterVec // By request tag and scope, address or account
+	refused         *CounterVec // Connections and datagrams turned away, by transport and reason
+	authFailures    *CounterVec
+	authBans        *CounterVec
+
+	retransmits retransmitDetector
+}
+
+func newServerMetrics() *ServerMetrics {
+	r := &MetricsRegistry{}
+	m := &ServerMetrics{registry: r}
+
+	r.newGaugeFunc("chess_connected_clients", "Clients that said hello and are still reachable, by transport.", func() []GaugeSample {
+		counts := peers.CountByTransport()
+		samples := make([]GaugeSample, 0, len(counts))
+		for _, transport := range sortedKeys(counts) {
+			samples = append(samples, GaugeSample{[]string{transport}, float64(counts[transport])})
+		}
+		return samples
+	}, "transport")
+	r.newGaugeFunc("chess_open_lobbies", "Lobbies waiting for players.", func() []GaugeSample {
+		gameMutex.RLock()
+		defer gameMutex.RUnlock()
+		return []GaugeSample{{nil, float64(openLobbyCount())}}
+	})
+	r.newGaugeFunc("chess_active_games", "Games being played.", func() []GaugeSample {
+		return []GaugeSample{{nil, float64(activeGameCount())}}
+	})
+
+	r.newGaugeFunc("chess_connections", "TCP connections and UDP clients counted against the connection limit.", func() []GaugeSample {
+		return []GaugeSample{{nil, float64(connections.Count())}}
+	})
+	r.newGaugeFunc("chess_game_viewers", "HTTP viewers following a game stream.", func() []GaugeSample {
+		return []GaugeSample{{nil, float64(gameEvents.Count())}}
+	})
+	r.newGaugeFunc("chess_banned_addresses", "IP addresses currently banned.", func() []GaugeSample {
+		return []GaugeSample{{nil, float64(len(bans.List()))}}
+	})
+
+	m.moves = r.newCounterVec("chess_moves_total", "Moves played in every game, rate() gives moves per second.")
+	m.requests = r.newCounterVec("chess_requests_total", "Requests received, by request tag and transport.", "tag", "transport")
+	m.requestDuration = r.newHistogramVec("chess_request_duration_seconds", "Time spent handling a request, by request tag.", latencyBuckets, "tag")
+	m.handlerErrors = r.newCounterVec("chess_handler_errors_total", "Requests whose handler failed, by request tag and error code.", "tag", "code")
+	m.udpRetransmits = r.newCounterVec("chess_udp_retransmits_total", "UDP requests changing the server's state received again from the same client within "+retransmitWindow.String()+".")
+	m.bytesReceived = r.newCounterVec("chess_received_bytes_total", "Bytes read from clients, by transport.", "transport")
+	m.bytesSent = r.newCounterVec("chess_sent_bytes_total", "Bytes written to clients, by transport.", "transport")
+	m.rateLimited = r.newCounterVec("chess_rate_limited_total", "Requests refused for going over a rate limit, by request tag and scope.", "tag", "scope")
+	m.refused = r.newCounterVec("chess_refused_total", "Connections and datagrams turned away, by transport and reason.", "transport", "reason")
+	m.authFailures = r.newCounterVec("chess_auth_failures_total", "Requests with a wrong signature or hash.")
+	m.authBans = r.newCounterVec("chess_auth_bans_total", "IP addresses banned after repeated authentication failures.")
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *ServerMetrics) Handler() http.Handler {
+	return m.registry
+}
+
+// ObserveRequest records a handled request, its latency and, when it failed, its error code
+func (m *ServerMetrics) ObserveRequest(tag Tag, transport string, elapsed time.Duration, err error) {
+	name := GetTagName(tag)
+	m.requests.Inc(name, transport)
+	m.requestDuration.Observe(elapsed.Seconds(), name)
+	if err != nil {
+		m.handlerErrors.Inc(name, handlerErrorCode(err))
+	}
+}
+
+// ObserveDatagram counts a received datagram and whether the client sent it before
+func (m *ServerMetrics) ObserveDatagram(address string, datagram []byte) {
+	m.bytesReceived.Add(float64(len(datagram)), "udp")
+	if m.retransmits.Seen(address, datagram, time.Now()) {
+		m.udpRetransmits.Inc()
+	}
+}
+
+// metricsHandler routes /metrics to the server metrics
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", serverMetrics.Handler())
+	return mux
+}
+
+// metricCollector is one metric family of the registry
+type metricCollector interface {
+	writeTo(w *bufio.Writer)
+}
+
+// MetricsRegistry holds every metric family exposed on /metrics
+type MetricsRegistry struct {
+	mu         sync.Mutex
+	collectors []metricCollector
+}
+
+// register adds a metric family, written in registration order
+func (r *MetricsRegistry) register(c metricCollector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// WriteTo writes every metric family in the text format
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := append([]metricCollector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	counting := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counting)
+	for _, c := range collectors {
+		c.writeTo(buffered)
+	}
+	err := buffered.Flush()
+	return counting.n, err
+}
+
+// ServeHTTP serves the metrics, so the registry can be mounted on /metrics
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", metricsContentType)
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// metricHeader writes the HELP and TYPE lines of a family
+func metricHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders label pairs as {a="x",b="y"}, or nothing when there are none
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelKey joins label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// newCounterVec creates a counter and registers it
+func (r *MetricsRegistry) newCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterSeries)}
+	if len(labels) == 0 {
+		// A counter without labels has a single series, exposed at 0 before its first increment
+		c.values[""] = &counterSeries{}
+	}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the series with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the series with the given label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	series, exists := c.values[key]
+	if !exists {
+		series = &counterSeries{labels: append([]string(nil), labelValues...)}
+		c.values[key] = series
+	}
+	series.value += v
+}
+
+// Value returns the current value of a series, 0 when it was never incremented
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if series, exists := c.values[labelKey(labelValues)]; exists {
+		return series.value
+	}
+	return 0
+}
+
+func (c *CounterVec) writeTo(w *bufio.Writer) {
+	metricHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		series := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, series.labels), formatFloat(series.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // Per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// newHistogramVec creates a histogram with the given bucket upper bounds and registers it
+func (r *MetricsRegistry) newHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramSeries)}
+	r.register(h)
+	return h
+}
+
+// Observe records a value in the series with the given label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, exists := h.values[key]
+	if !exists {
+		series = &histogramSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = series
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		series.counts[i]++
+	}
+	series.count++
+	series.sum += v
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	metricHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.values) {
+		series := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			values := append(append([]string(nil), series.labels...), formatFloat(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, values), cumulative)
+		}
+		values := append(append([]string(nil), series.labels...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, values), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.labels), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.labels), series.count)
+	}
+}
+
+// GaugeSample is one series of a gauge computed at scrape time
+type GaugeSample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc is a gauge whose series are read from the server state when scraped
+type GaugeFunc struct {
+	name, help string
+	labels     []string
+	collect    func() []GaugeSample
+}
+
+// newGaugeFunc creates a gauge computed by collect and registers it
+func (r *MetricsRegistry) newGaugeFunc(name, help string, collect func() []GaugeSample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) writeTo(w *bufio.Writer) {
+	metricHeader(w, g.name, g.help, "gauge")
+	for _, sample := range g.collect() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, sample.Labels), formatFloat(sample.Value))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// retransmitWindow is how long a datagram is remembered to spot a client sending it again
+const retransmitWindow = 5 * time.Second
+
+// changesState tells whether a request changes the state of the server, so that receiving it
+// twice from a client is a retransmission. Reading requests and keepalives are legitimately
+// sent again with the same bytes, e.g. a client polling the board.
+func changesState(tag Tag) bool {
+	switch tag {
+	case GameRequest, JoinLobbyRequest, ActionRequest, QueueRequest, QueueCancelRequest,
+		BotGameRequest, AbandonClaimRequest, ChatRequest, PremoveRequest, UndoRequest, UndoAnswer:
+		return true
+	}
+	return false
+}
+
+// retransmitDetector spots UDP requests changing the server's state that a client sends twice.
+// The protocol has no acknowledgements, so a client that gets no answer in time simply sends
+// its request again.
+type retransmitDetector struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// Seen records a datagram and reports whether the same address sent it within the window.
+// Datagrams of requests that do not change the server's state are never retransmissions.
+func (d *retransmitDetector) Seen(address string, datagram []byte, now time.Time) bool {
+	if len(datagram) == 0 || !changesState(Tag(datagram[0])) {
+		return false
+	}
+	digest := fnv.New64a()
+	digest.Write(datagram)
+	key := address + "\x00" + strconv.FormatUint(digest.Sum64(), 16)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	}
+	if now.Sub(d.lastPrune) > retransmitWindow {
+		for k, at := range d.seen {
+			if now.Sub(at) > retransmitWindow {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPrune = now
+	}
+
+	at, exists := d.seen[key]
+	d.seen[key] = now
+	return exists && now.Sub(at) <= retransmitWindow
+}
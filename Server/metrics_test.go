@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetransmitDetectorIgnoresReadingRequests(t *testing.T) {
+	var detector retransmitDetector
+	now := time.Now()
+	const address = "127.0.0.1:4000"
+
+	board := []byte{byte(BoardRequest), 0, 1, 0}
+	ping := []byte{byte(Ping), 0, 0}
+	for i := 0; i < 3; i++ {
+		if detector.Seen(address, board, now) || detector.Seen(address, ping, now) {
+			t.Fatal("a polled board or a keepalive was taken for a retransmission")
+		}
+	}
+
+	move := []byte{byte(ActionRequest), 0, 1, 0}
+	if detector.Seen(address, move, now) {
+		t.Error("the first move request was taken for a retransmission")
+	}
+	if !detector.Seen(address, move, now.Add(time.Second)) {
+		t.Error("the move request sent again was not taken for a retransmission")
+	}
+	if detector.Seen("127.0.0.1:4001", move, now.Add(time.Second)) {
+		t.Error("the same move from another client was taken for a retransmission")
+	}
+	if detector.Seen(address, move, now.Add(time.Second+2*retransmitWindow)) {
+		t.Error("a move request sent again after the window was taken for a retransmission")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/opening"
+)
+
+// The ECO table is parsed on first use, which takes a moment, and shared by every game
+var (
+	ecoBookOnce sync.Once
+	ecoBook     *opening.BookECO
+)
+
+func openingBook() *opening.BookECO {
+	ecoBookOnce.Do(func() {
+		ecoBook = opening.NewBookECO()
+	})
+	return ecoBook
+}
+
+// classifyOpening returns the ECO code and name of the most specific opening the game
+// went through, or empty strings while no opening is recognised. Once the players leave
+// the book the game keeps the last opening it reached.
+func classifyOpening(game *chess.Game) (string, string) {
+	// The table only describes games from the standard starting position
+	positions := game.Positions()
+	if len(positions) == 0 || positions[0].String() != chess.StartingPosition().String() {
+		return "", ""
+	}
+
+	found := openingBook().Find(game.Moves())
+	if found == nil {
+		return "", ""
+	}
+	return found.Code(), found.Title()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// pgnLineWidth is the maximum length of a movetext line, as recommended by the PGN standard
+const pgnLineWidth = 80
+
+// ExportPGN renders a game session as PGN. When an analysis is given, each move
+// carries its evaluation and classification as a comment. Games other than chess have no
+// PGN, "" is returned for them.
+func ExportPGN(session GameSession, report *GameAnalysis) string {
+	if session.Board != nil {
+		return ""
+	}
+	outcome := session.Outcome()
+	whitePlayer, blackPlayer := session.WhitePlayer, session.BlackPlayer
+	if whitePlayer == "" {
+		whitePlayer = session.CreatorName
+	}
+	if blackPlayer == "" {
+		blackPlayer = "?"
+	}
+
+	// The date the game was played, unknown for games saved before it was kept
+	date := "????.??.??"
+	if !session.StartedAt.IsZero() {
+		date = session.StartedAt.Format("2006.01.02")
+	}
+
+	var pgn strings.Builder
+	tags := [][2]string{
+		{"Event", session.LobbyName},
+		{"Site", "TP2Reseau"},
+		{"Date", date},
+		{"Round", "-"},
+		{"White", whitePlayer},
+		{"Black", blackPlayer},
+		{"Result", outcome.String()},
+	}
+	if name := session.Variant.PGNName(); name != "" {
+		tags = append(tags, [2]string{"Variant", name})
+	}
+	if session.TimeControl.Initial > 0 {
+		tags = append(tags, [2]string{"TimeControl", fmt.Sprintf("%d+%d",
+			int(session.TimeControl.Initial.Seconds()), int(session.TimeControl.Increment.Seconds()))})
+	}
+	if session.OpeningCode != "" {
+		tags = append(tags, [2]string{"ECO", session.OpeningCode}, [2]string{"Opening", session.OpeningName})
+	}
+	if outcome != chess.NoOutcome {
+		tags = append(tags, [2]string{"Termination", session.TerminationMethod()})
+	}
+	if session.StartFEN != "" && session.StartFEN != chess.StartingPosition().String() {
+		tags = append(tags, [2]string{"SetUp", "1"}, [2]string{"FEN", session.StartFEN})
+	}
+	if report != nil {
+		tags = append(tags,
+			[2]string{"Annotator", analysisEngineName()},
+			[2]string{"WhiteAccuracy", fmt.Sprintf("%.1f", report.WhiteAccuracy)},
+			[2]string{"BlackAccuracy", fmt.Sprintf("%.1f", report.BlackAccuracy)},
+		)
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(&pgn, "[%s \"%s\"]\n", tag[0], strings.ReplaceAll(tag[1], `"`, `\"`))
+	}
+	pgn.WriteString("\n")
+
+	// Build the movetext token by token, then wrap it. Moves are numbered from the starting
+	// position, which may not be the first move of a game from a position.
+	var tokens []string
+	for i, move := range session.History() {
+		number := moveNumber(move.Before)
+		if move.Before.Turn() == chess.White {
+			tokens = append(tokens, fmt.Sprintf("%d.", number))
+		} else if i == 0 || (report != nil && i-1 < len(report.Moves) && report.Moves[i-1].Comment() != "") {
+			// Black's move needs its number again after a comment or at the start
+			tokens = append(tokens, fmt.Sprintf("%d...", number))
+		}
+		tokens = append(tokens, move.SAN)
+
+		if report != nil && i < len(report.Moves) {
+			if comment := report.Moves[i].Comment(); comment != "" {
+				tokens = append(tokens, "{ "+comment+" }")
+			}
+		}
+	}
+	tokens = append(tokens, outcome.String())
+
+	lineLength := 0
+	for i, token := range tokens {
+		if i > 0 {
+			if lineLength+1+len(token) > pgnLineWidth {
+				pgn.WriteString("\n")
+				lineLength = 0
+			} else {
+				pgn.WriteString(" ")
+				lineLength++
+			}
+		}
+		pgn.WriteString(token)
+		lineLength += len(token)
+	}
+	pgn.WriteString("\n")
+	return pgn.String()
+}
+
+// moveNumber returns the full move number of a position, as its FEN tells
+func moveNumber(position *chess.Position) int {
+	fields := strings.Fields(position.String())
+	number, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || number < 1 {
+		return 1
+	}
+	return number
+}
+
+// analysisEngineName names the analyzer in exported PGN
+func analysisEngineName() string {
+	if engine, ok := analysisEngine.(Engine); ok {
+		return engine.Name()
+	}
+	return "TP2Reseau"
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPGNDateIsWhenTheGameStarted(t *testing.T) {
+	gameID, err := createMatchedGame("DateWhite", "DateBlack", TimeControl{}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameMutex.Lock()
+	session := GameStore[gameID]
+	session.StartedAt = time.Date(2024, time.March, 9, 21, 30, 0, 0, time.UTC)
+	err = Move(&session, "e4")
+	gameMutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pgn := ExportPGN(session, nil); !strings.Contains(pgn, `[Date "2024.03.09"]`) {
+		t.Errorf("the PGN is not dated from the start of the game:\n%s", pgn)
+	}
+
+	// The date survives a restart
+	restored, err := restoreGame(saveGame(session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pgn := ExportPGN(restored, nil); !strings.Contains(pgn, `[Date "2024.03.09"]`) {
+		t.Errorf("the restored game lost its date:\n%s", pgn)
+	}
+
+	restored.StartedAt = time.Time{}
+	if pgn := ExportPGN(restored, nil); !strings.Contains(pgn, `[Date "????.??.??"]`) {
+		t.Errorf("a game of unknown date is dated:\n%s", pgn)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// anyMove stands for whatever move the opponent plays, which makes a reply a premove
+const anyMove = "*"
+
+// maxQueuedMoves bounds the replies a player may queue in one game
+const maxQueuedMoves = 100
+
+// MoveTree holds the moves a player queued while the opponent is thinking: a reply to some of
+// the opponent's possible moves, each followed by the tree for the moves after it
+type MoveTree []ConditionalMove
+
+// ConditionalMove answers one move of the opponent, or any move for a premove. Moves are in
+// algebraic notation, normalized when the tree was queued except after a premove, where the
+// position could not be foreseen.
+type ConditionalMove struct {
+	If    string // The opponent's move, anyMove for a premove
+	Reply string
+	Then  MoveTree `json:",omitempty"`
+}
+
+// String writes the tree as the lines queueMoves reads, e.g. "Nf6 e5 Nd5 d4; * d5"
+func (t MoveTree) String() string {
+	var lines []string
+	t.collectLines(nil, &lines)
+	return strings.Join(lines, "; ")
+}
+
+func (t MoveTree) collectLines(prefix []string, lines *[]string) {
+	for _, branch := range t {
+		line := append(slices.Clip(prefix), branch.If, branch.Reply)
+		if len(branch.Then) == 0 {
+			*lines = append(*lines, strings.Join(line, " "))
+			continue
+		}
+		branch.Then.collectLines(line, lines)
+	}
+}
+
+// size counts the replies of the tree
+func (t MoveTree) size() int {
+	n := len(t)
+	for _, branch := range t {
+		n += branch.Then.size()
+	}
+	return n
+}
+
+// parseMoveTree reads the lines a player queues from the position of game: lines are
+// separated by semicolons or newlines, each alternating the opponent's move and the reply
+// to it, "*" standing for any move of the opponent. A line of a single move is a premove.
+// Lines starting alike share their moves, two replies to the same move are refused.
+// Chess960 castling and the double steps of Horde's first rank cannot be queued, the lines
+// are checked by the chess package.
+func parseMoveTree(game *chess.Game, text string) (MoveTree, error) {
+	var tree MoveTree
+	lines := strings.FieldsFunc(text, func(r rune) bool { return r == ';' || r == '\n' })
+	for _, line := range lines {
+		moves := strings.Fields(line)
+		switch {
+		case len(moves) == 0:
+			continue
+		case len(moves) == 1:
+			moves = []string{anyMove, moves[0]}
+		case len(moves)%2 != 0:
+			return nil, fmt.Errorf("line %q: moves go in pairs, the opponent's then your reply", strings.TrimSpace(line))
+		}
+		if err := tree.insert(game.Clone(), moves); err != nil {
+			return nil, fmt.Errorf("line %q: %w", strings.TrimSpace(line), err)
+		}
+	}
+	if tree.size() > maxQueuedMoves {
+		return nil, fmt.Errorf("at most %d moves may be queued", maxQueuedMoves)
+	}
+	return tree, nil
+}
+
+// insert adds a line of moves to the tree, checking them on game as long as the position
+// is known
+func (t *MoveTree) insert(game *chess.Game, moves []string) error {
+	known := true
+	for i := 0; i < len(moves); i += 2 {
+		opponent, reply := moves[i], moves[i+1]
+		if reply == anyMove {
+			return fmt.Errorf("%s only stands for the opponent's moves", anyMove)
+		}
+		if opponent == anyMove {
+			known = false
+		}
+		if known {
+			var err error
+			if opponent, err = playNotation(game, opponent); err != nil {
+				return fmt.Errorf("the opponent cannot play %s", moves[i])
+			}
+			if reply, err = playNotation(game, reply); err != nil {
+				return fmt.Errorf("%s is illegal after %s", moves[i+1], opponent)
+			}
+		}
+
+		index := slices.IndexFunc(*t, func(branch ConditionalMove) bool { return branch.If == opponent })
+		switch {
+		case index < 0:
+			*t = append(*t, ConditionalMove{If: opponent, Reply: reply})
+			index = len(*t) - 1
+		case (*t)[index].Reply != reply:
+			return fmt.Errorf("%s is already answered by %s", opponent, (*t)[index].Reply)
+		}
+		t = &(*t)[index].Then
+	}
+	return nil
+}
+
+// answer finds the branch answering the last move of game: the one naming it, or else a
+// premove. A game without moves follows a move the chess package could not play, see
+// splitGame, which only a premove answers.
+func (t MoveTree) answer(game *chess.Game) (ConditionalMove, bool) {
+	moves, positions := game.Moves(), game.Positions()
+	for _, branch := range t {
+		if branch.If == anyMove || len(moves) == 0 {
+			continue
+		}
+		last, before := moves[len(moves)-1], positions[len(positions)-2]
+		if move, err := decodeNotation(before, branch.If); err == nil && sameMove(move, last) {
+			return branch, true
+		}
+	}
+	index := slices.IndexFunc(t, func(branch ConditionalMove) bool { return branch.If == anyMove })
+	if index < 0 {
+		return ConditionalMove{}, false
+	}
+	return t[index], true
+}
+
+// decodeNotation reads a move of position in algebraic notation, or in UCI notation
+func decodeNotation(position *chess.Position, notation string) (*chess.Move, error) {
+	if move, err := (chess.AlgebraicNotation{}).Decode(position, notation); err == nil {
+		return move, nil
+	}
+	return chess.UCINotation{}.Decode(position, notation)
+}
+
+// playNotation plays a move on game and returns it in algebraic notation
+func playNotation(game *chess.Game, notation string) (string, error) {
+	position := game.Position()
+	move, err := decodeNotation(position, notation)
+	if err != nil {
+		return "", err
+	}
+	san := chess.AlgebraicNotation{}.Encode(position, move)
+	if err := game.Move(move); err != nil {
+		return "", err
+	}
+	return san, nil
+}
+
+func sameMove(a, b *chess.Move) bool {
+	return a.S1() == b.S1() && a.S2() == b.S2() && a.Promo() == b.Promo()
+}
+
+// queue returns the moves a side has queued
+func (s *GameSession) queue(side chess.Color) *MoveTree {
+	if side == chess.White {
+		return &s.WhiteQueue
+	}
+	return &s.BlackQueue
+}
+
+// queueMoves replaces the moves a player queued in a game with the lines of text, see
+// parseMoveTree, and returns them. An empty text clears them. Moves are queued while the
+// opponent is to move, once the game started and the seats are given.
+func queueMoves(gameID uuid.UUID, playerName string, text string) (MoveTree, error) {
+	gameMutex.Lock()
+	defer gameMutex.Unlock()
+
+	session, ok := GameStore[gameID]
+	if !ok {
+		return nil, fmt.Errorf("no game to queue moves in, create or join one first")
+	}
+	side := session.PlayerColor(playerName)
+	switch {
+	case side == chess.NoColor:
+		return nil, fmt.Errorf("player %s has no color in game %v", playerName, gameID)
+	case session.Board != nil:
+		return nil, fmt.Errorf("moves are only queued in chess games")
+	case strings.TrimSpace(text) == "":
+		// Clearing is always allowed
+	case session.Outcome() != chess.NoOutcome:
+		return nil, fmt.Errorf("game %v is over", gameID)
+	case session.Turn() == side:
+		return nil, fmt.Errorf("it is your turn, play your move instead")
+	}
+
+	tree, err := parseMoveTree(session.Game, text)
+	if err != nil {
+		return nil, err
+	}
+	*session.queue(side) = tree
+	GameStore[gameID] = session
+	gameLogger(gameID).Debug("Moves queued", "player", playerName, "moves", tree.String())
+	return tree, nil
+}
+
+// playQueuedMove answers the move just played with the reply the side to move queued for
+// it, and keeps the rest of its tree. The tree is dropped when it did not foresee the move or
+// its reply is illegal. It returns who replied and the BoardUpdate of the reply, nil when
+// there was none (the caller must hold gameMutex).
+func playQueuedMove(session *GameSession, now time.Time) (string, []byte) {
+	side := session.Turn()
+	tree := *session.queue(side)
+	*session.queue(side) = nil
+	if len(tree) == 0 || session.Outcome() != chess.NoOutcome {
+		return "", nil
+	}
+
+	replier := session.WhitePlayer
+	if side == chess.Black {
+		replier = session.BlackPlayer
+	}
+	logger := gameLogger(session.ID).With("player", replier)
+
+	branch, ok := tree.answer(session.Game)
+	if !ok {
+		logger.Debug("Queued moves dropped, the opponent's move was not foreseen", "move", session.LastMoveSAN())
+		return "", nil
+	}
+	move, err := decodeNotation(session.Game.Position(), branch.Reply)
+	if err != nil {
+		logger.Debug("Queued move dropped, it is illegal", "move", branch.Reply)
+		return "", nil
+	}
+	if err := Move(session, chess.AlgebraicNotation{}.Encode(session.Game.Position(), move)); err != nil {
+		logger.Warn("Queued move could not be played", "move", branch.Reply, "err", err)
+		return "", nil
+	}
+	session.Clock.Punch(side, session.TimeControl.Increment, now)
+	*session.queue(side) = branch.Then
+	serverMetrics.moves.Inc()
+	logger.Debug("Queued move played", "move", branch.Reply)
+
+	gameEvents.Publish(session.ID, moveEvents(*session, replier, now)...)
+	update, err := encodeBoardUpdate(*session, replier)
+	if err != nil {
+		logger.Error("Error encoding BoardUpdate", "err", err)
+		return replier, nil
+	}
+	return replier, update
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestPremoveInJoinedLobby(t *testing.T) {
+	gameID := openJoinedLobby(t, "PremoveAnn", "PremoveBob")
+
+	if _, err := queueMoves(gameID, "PremoveAnn", "e4"); err == nil {
+		t.Error("white queued a move on its own turn")
+	}
+	tree, err := queueMoves(gameID, "PremoveBob", "e5")
+	if err != nil {
+		t.Fatalf("black could not queue a premove: %v", err)
+	}
+	if queued := tree.String(); queued != "* e5" {
+		t.Errorf("queued %q, want any move answered by e5", queued)
+	}
+
+	if _, err := MoveInLobby(gameID, "e4", "PremoveAnn"); err != nil {
+		t.Fatal(err)
+	}
+	gameMutex.RLock()
+	session := GameStore[gameID]
+	gameMutex.RUnlock()
+	if moves := len(session.Game.Moves()); moves != 2 || session.LastMoveSAN() != "e5" {
+		t.Errorf("after e4, %d moves played ending with %s, want the premove e5", moves, session.LastMoveSAN())
+	}
+	if len(session.BlackQueue) != 0 {
+		t.Error("the premove stayed queued once played")
+	}
+}
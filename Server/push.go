@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Peer is anything the server can push a message to outside of a request/response exchange
+type Peer interface {
+	Send(tag Tag, message []byte) error
+}
+
+// tcpPeer pushes messages on an open TCP connection
+type tcpPeer struct {
+	conn net.Conn
+}
+
+func (p *tcpPeer) Send(tag Tag, message []byte) error {
+	return SendMessageTCP(p.conn, tag, message)
+}
+
+// udpPeer pushes datagrams to the address the client last spoke from
+type udpPeer struct {
+	conn *net.UDPConn
+	addr *net.UDPAddr
+}
+
+func (p *udpPeer) Send(tag Tag, message []byte) error {
+	return SendMessageUDP(p.conn, p.addr, tag, message)
+}
+
+// PeerRegistry keeps track of how to reach each connected client, keyed by address
+type PeerRegistry struct {
+	mu    sync.RWMutex
+	peers map[string]Peer
+}
+
+// Global PeerRegistry instance
+var peers = NewPeerRegistry()
+
+// NewPeerRegistry creates and returns a new PeerRegistry
+func NewPeerRegistry() *PeerRegistry {
+	return &PeerRegistry{
+		peers: make(map[string]Peer),
+	}
+}
+
+// Register adds or replaces the peer reachable at the given address
+func (pr *PeerRegistry) Register(address string, peer Peer) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.peers[address] = peer
+}
+
+// Unregister forgets the peer at the given address
+func (pr *PeerRegistry) Unregister(address string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	delete(pr.peers, address)
+}
+
+// Get retrieves the peer at the given address
+func (pr *PeerRegistry) Get(address string) (Peer, bool) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	peer, exists := pr.peers[address]
+	return peer, exists
+}
+
+// CountByTransport counts the registered peers reached over TCP, WebSocket and UDP
+func (pr *PeerRegistry) CountByTransport() map[string]int {
+	counts := map[string]int{"tcp": 0, "websocket": 0, "udp": 0}
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	for _, peer := range pr.peers {
+		// Bots are played by the server itself and not counted as connected clients
+		if transport := peerTransport(peer); transport != "bot" {
+			counts[transport]++
+		}
+	}
+	return counts
+}
+
+// Broadcast pushes a message to every registered peer, returning how many received it
+func (pr *PeerRegistry) Broadcast(tag Tag, message []byte) int {
+	pr.mu.RLock()
+	targets := make(map[string]Peer, len(pr.peers))
+	for address, peer := range pr.peers {
+		targets[address] = peer
+	}
+	pr.mu.RUnlock()
+
+	sent := 0
+	for address, peer := range targets {
+		if err := peer.Send(tag, message); err != nil {
+			slog.Warn("Error broadcasting message", "tag", GetTagName(tag), "remote", address, "err", err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// newPeer builds the Peer matching the transport a request came in on
+func newPeer(conn net.Conn, udpConn *net.UDPConn, clientAddr *net.UDPAddr, isTCP bool) Peer {
+	if isTCP && conn != nil {
+		return &tcpPeer{conn: conn}
+	}
+	if udpConn != nil && clientAddr != nil {
+		return &udpPeer{conn: udpConn, addr: clientAddr}
+	}
+	return nil
+}
+
+// pushToAddress sends an unsolicited message to the client at the given address
+func pushToAddress(address string, tag Tag, message []byte) error {
+	peer, exists := peers.Get(address)
+	if !exists {
+		return fmt.Errorf("no peer registered for address %s", address)
+	}
+	return peer.Send(tag, message)
+}
+
+// pushToPlayer sends an unsolicited message to every client playing under the given name
+func pushToPlayer(playerName string, tag Tag, message []byte) error {
+	matchedClients := clientList.GetClientByName(playerName)
+	if len(matchedClients) == 0 {
+		return fmt.Errorf("no client found for player %s", playerName)
+	}
+
+	var lastErr error
+	for _, client := range matchedClients {
+		if err := pushToAddress(client.Address, tag, message); err != nil {
+			slog.Warn("Error pushing message", "tag", GetTagName(tag), "player", playerName, "remote", client.Address, "err", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// notifyGameUpdate pushes an encoded BoardUpdate to every player except the one who just moved
+func notifyGameUpdate(players []string, moverName string, message []byte) {
+	for _, playerName := range players {
+		if playerName == moverName {
+			continue
+		}
+		if err := pushToPlayer(playerName, BoardUpdate, message); err != nil {
+			slog.Warn("Error pushing BoardUpdate", "player", playerName, "err", err)
+		}
+	}
+}
+
+// maxChatLength is the longest chat line relayed, in bytes
+const maxChatLength = 500
+
+// relayChat pushes a chat line to the other players of a game, the sender having to be one
+// of them, and to its viewers
+func relayChat(gameID uuid.UUID, sender string, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("the chat line is empty")
+	}
+	if len(text) > maxChatLength {
+		return fmt.Errorf("chat lines are limited to %d bytes", maxChatLength)
+	}
+	// Control characters could drive the terminal of the players reading the line
+	if !utf8.ValidString(text) || strings.IndexFunc(text, unicode.IsControl) >= 0 {
+		return fmt.Errorf("the chat line contains invalid characters")
+	}
+
+	// Events are published under the write lock, see GameEventBus
+	gameMutex.Lock()
+	session, ok := GameStore[gameID]
+	if !ok {
+		gameMutex.Unlock()
+		return fmt.Errorf("no game to chat in, create or join one first")
+	}
+	if !slices.Contains(session.JoinedPlayers, sender) {
+		gameMutex.Unlock()
+		return fmt.Errorf("player %s is not in game %v", sender, gameID)
+	}
+	gameEvents.Publish(gameID, GameEvent{Type: "chat", Data: chatEvent{Player: sender, Text: text}})
+	gameMutex.Unlock()
+
+	message, err := encodeTLVFields(tlvField{String, []byte(sender)}, tlvField{String, []byte(text)})
+	if err != nil {
+		return err
+	}
+	for _, playerName := range session.JoinedPlayers {
+		if playerName == sender {
+			continue
+		}
+		if err := pushToPlayer(playerName, ChatMessage, message); err != nil {
+			slog.Warn("Error pushing ChatMessage", "player", playerName, "err", err)
+		}
+	}
+	return nil
+}
+
+// encodeBoardUpdate builds the BoardUpdate payload (the caller must hold gameMutex): FEN or
+// the state of a game other than chess, last move, mover, outcome, method, the ECO code and
+// name of the opening, and the milliseconds left to White and Black, empty for untimed games
+func encodeBoardUpdate(session GameSession, moverName string) ([]byte, error) {
+	var whiteClock, blackClock string
+	if !session.Clock.TurnStart.IsZero() {
+		white, black := session.Clock.Remaining(session.Turn(), time.Now())
+		whiteClock = strconv.FormatInt(white.Milliseconds(), 10)
+		blackClock = strconv.FormatInt(black.Milliseconds(), 10)
+	}
+	return encodeTLVFields(
+		tlvField{String, []byte(session.GetBoardState())},
+		tlvField{String, []byte(session.LastMoveSAN())},
+		tlvField{String, []byte(moverName)},
+		tlvField{String, []byte(session.Outcome().String())},
+		tlvField{String, []byte(session.TerminationMethod())},
+		tlvField{String, []byte(session.OpeningCode)},
+		tlvField{String, []byte(session.OpeningName)},
+		tlvField{String, []byte(whiteClock)},
+		tlvField{String, []byte(blackClock)},
+	)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit lets a client send Burst requests at once, then Rate requests per second.
+// A Rate of 0 leaves the request type unlimited.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (l RateLimit) String() string {
+	return strconv.FormatFloat(l.Rate, 'g', -1, 64) + ":" + strconv.Itoa(l.Burst)
+}
+
+// defaultRateLimitKey holds the limit of the request types without their own entry
+const defaultRateLimitKey = "default"
+
+// DefaultRateLimits returns the limits by request tag name. Requests that create state or
+// cost CPU, like lobbies, bot games and analyses, are kept well below the cheap ones.
+func DefaultRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		defaultRateLimitKey: {Rate: 10, Burst: 30},
+		"HelloRequest":      {Rate: 0.5, Burst: 5},
+		"GameRequest":       {Rate: 0.1, Burst: 3},
+		"LobbyRequest":      {Rate: 1, Burst: 5},
+		"JoinLobbyRequest":  {Rate: 0.5, Burst: 5},
+		"QueueRequest":      {Rate: 0.2, Burst: 3},
+		"BotGameRequest":    {Rate: 0.1, Burst: 3},
+		"AnalyzeRequest":    {Rate: 0.05, Burst: 2},
+		"ArchiveRequest":    {Rate: 0.5, Burst: 5},
+		"ChatRequest":       {Rate: 1, Burst: 5},
+		"PremoveRequest":    {Rate: 2, Burst: 10},
+		"UndoRequest":       {Rate: 0.2, Burst: 3},
+	}
+}
+
+// parseRateLimits reads limits written as "LobbyRequest=1:5,GameRequest=0.1:3", the rate
+// in requests per second before the colon and the burst after it
+func parseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		rate, burst, hasBurst := strings.Cut(value, ":")
+		if !found || !hasBurst {
+			return nil, fmt.Errorf("rate limit %q must look like LobbyRequest=1:5", entry)
+		}
+
+		var limit RateLimit
+		var err error
+		if limit.Rate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return nil, fmt.Errorf("invalid rate in %q: %w", entry, err)
+		}
+		if limit.Burst, err = strconv.Atoi(burst); err != nil {
+			return nil, fmt.Errorf("invalid burst in %q: %w", entry, err)
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return limits, nil
+}
+
+// tokenBucket holds the requests a client may still send right away
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time elapsed since the last request and spends a token
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type bucketKey struct {
+	key string // "ip:<address>" or "account:<name>"
+	tag Tag
+}
+
+// RateLimiter keeps a token bucket per client and request type. Clients are identified both
+// by IP, which catches floods before the hello, and by account, so a player cannot get
+// around the limits by connecting from several ports.
+type RateLimiter struct {
+	mu       sync.Mutex
+	limits   map[Tag]RateLimit
+	fallback RateLimit
+	buckets  map[bucketKey]*tokenBucket
+}
+
+// rateLimiter is checked by the dispatcher before every request, set up from the config
+var rateLimiter = NewRateLimiter(nil)
+
+// NewRateLimiter builds a limiter from limits keyed by request tag name, see DefaultRateLimits
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	rl := &RateLimiter{
+		limits:  make(map[Tag]RateLimit),
+		buckets: make(map[bucketKey]*tokenBucket),
+	}
+	for name, limit := range limits {
+		if name == defaultRateLimitKey {
+			rl.fallback = limit
+		} else if tag, ok := tagByName(name); ok {
+			rl.limits[tag] = limit
+		}
+	}
+	return rl
+}
+
+// limitOf returns the limit of a request type
+func (rl *RateLimiter) limitOf(tag Tag) RateLimit {
+	if limit, ok := rl.limits[tag]; ok {
+		return limit
+	}
+	return rl.fallback
+}
+
+// Allow spends a token of the client's bucket for the request type, reporting false when
+// the bucket is empty
+func (rl *RateLimiter) Allow(key string, tag Tag, now time.Time) bool {
+	limit := rl.limitOf(tag)
+	if limit.Rate <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	bucket, exists := rl.buckets[bucketKey{key, tag}]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[bucketKey{key, tag}] = bucket
+	}
+	return bucket.take(limit, now)
+}
+
+// Prune forgets the buckets that had time to fill up again, they would start full anyway
+func (rl *RateLimiter) Prune(now time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for key, bucket := range rl.buckets {
+		limit := rl.limitOf(key.tag)
+		if limit.Rate <= 0 || bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// checkRateLimit applies the request type's limit to the client's IP and, once it said
+// hello, to its account. It returns the scope that ran out, "address" or "account".
+func checkRateLimit(tag Tag, conn net.Conn, clientAddr *net.UDPAddr, isTCP bool, now time.Time) (string, bool) {
+	clientAddress, err := clientAddressOf(conn, clientAddr, isTCP)
+	if err != nil {
+		return "", true
+	}
+
+	if !rateLimiter.Allow("ip:"+hostOf(clientAddress), tag, now) {
+		return "address", false
+	}
+	if client, known := clientList.GetClient(clientAddress); known && client.FirstName != "" {
+		if !rateLimiter.Allow("account:"+client.FirstName, tag, now) {
+			return "account", false
+		}
+	}
+	return "", true
+}
+
+// ConnectionLimit caps the TCP connections and UDP clients served at the same time, both
+// transports together, at config.MaxConnections
+type ConnectionLimit struct {
+	mu    sync.Mutex
+	count int
+}
+
+// connections counts the TCP connections and the UDP clients of both servers
+var connections = &ConnectionLimit{}
+
+// Acquire takes a slot for a new connection, reporting false when the server is full
+func (cl *ConnectionLimit) Acquire() bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if config.MaxConnections > 0 && cl.count >= config.MaxConnections {
+		return false
+	}
+	cl.count++
+	return true
+}
+
+// Release frees the slot of a closed connection or expired UDP client
+func (cl *ConnectionLimit) Release() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.count > 0 {
+		cl.count--
+	}
+}
+
+// Count returns the number of slots in use
+func (cl *ConnectionLimit) Count() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.count
+}
+
+// AuthFailures bans for a while the IPs sending requests with a wrong signature or hash too
+// often: config.AuthFailureLimit failures within config.AuthFailureWindow.
+type AuthFailures struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time // By IP, the failures still within the window
+}
+
+// authFailures is fed by the dispatcher with the requests failing authentication
+var authFailures = NewAuthFailures()
+
+func NewAuthFailures() *AuthFailures {
+	return &AuthFailures{failures: make(map[string][]time.Time)}
+}
+
+// Record counts a failure from an IP and reports whether it got the IP banned
+func (af *AuthFailures) Record(ip string, now time.Time) bool {
+	if config.AuthFailureLimit <= 0 {
+		return false
+	}
+
+	af.mu.Lock()
+	recent := af.failures[ip][:0]
+	for _, at := range af.failures[ip] {
+		if now.Sub(at) < config.AuthFailureWindow.Duration {
+			recent = append(recent, at)
+		}
+	}
+	recent = append(recent, now)
+	exceeded := len(recent) >= config.AuthFailureLimit
+	if exceeded {
+		delete(af.failures, ip)
+	} else {
+		af.failures[ip] = recent
+	}
+	af.mu.Unlock()
+
+	if !exceeded {
+		return false
+	}
+	if _, err := bans.Add(ip, config.AuthBanDuration.Duration, "repeated signature or hash failures"); err != nil {
+		slog.Error("Error banning address", "ip", ip, "err", err)
+		return false
+	}
+	slog.Warn("Address banned after repeated authentication failures", "ip", ip,
+		"failures", config.AuthFailureLimit, "duration", config.AuthBanDuration.Duration)
+	return true
+}
+
+// Prune forgets the IPs whose failures all left the window
+func (af *AuthFailures) Prune(now time.Time) {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	for ip, failures := range af.failures {
+		if len(failures) == 0 || now.Sub(failures[len(failures)-1]) >= config.AuthFailureWindow.Duration {
+			delete(af.failures, ip)
+		}
+	}
+}
+
+// isAuthFailure tells the errors a client gets for a forged or corrupted request
+func isAuthFailure(err error) bool {
+	return errors.Is(err, ErrHashMismatch) || errors.Is(err, ErrSignatureMismatch)
+}
+
+// formatRateLimits writes limits the way parseRateLimits reads them, sorted by name
+func formatRateLimits(limits map[string]RateLimit) string {
+	entries := make([]string, 0, len(limits))
+	for name, limit := range limits {
+		entries = append(entries, name+"="+limit.String())
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// rateLimitsFlag sets request type limits from the command line, keeping the other ones
+type rateLimitsFlag struct {
+	limits *map[string]RateLimit
+}
+
+func (f rateLimitsFlag) String() string {
+	if f.limits == nil {
+		return ""
+	}
+	return formatRateLimits(*f.limits)
+}
+
+func (f rateLimitsFlag) Set(s string) error {
+	parsed, err := parseRateLimits(s)
+	if err != nil {
+		return err
+	}
+	if *f.limits == nil {
+		*f.limits = make(map[string]RateLimit)
+	}
+	for name, limit := range parsed {
+		(*f.limits)[name] = limit
+	}
+	return nil
+}
+
+// recordAuthFailure counts a failed signature or hash check against the client's IP and, when
+// it gets the IP banned, disconnects every client of that IP
+func recordAuthFailure(conn net.Conn, clientAddr *net.UDPAddr, isTCP bool) {
+	clientAddress, err := clientAddressOf(conn, clientAddr, isTCP)
+	if err != nil {
+		return
+	}
+
+	ip := hostOf(clientAddress)
+	serverMetrics.authFailures.Inc()
+	if authFailures.Record(ip, time.Now()) {
+		serverMetrics.authBans.Inc()
+		kickIP(ip, "banned after repeated authentication failures")
+	}
+}
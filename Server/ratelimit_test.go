@@ -0,0 +1,202 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// withConfig changes the server's configuration for the length of a test
+func withConfig(t *testing.T, change func(c *Config)) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	change(&config)
+}
+
+// withRateLimits gives the test its own rate limiter
+func withRateLimits(t *testing.T, limits map[string]RateLimit) {
+	t.Helper()
+	saved := rateLimiter
+	t.Cleanup(func() { rateLimiter = saved })
+	rateLimiter = NewRateLimiter(limits)
+}
+
+func TestTokenBucket(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"ChatRequest": {Rate: 1, Burst: 3}})
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, step := range []struct {
+		after time.Duration
+		want  int // Requests allowed at that time, out of 5 sent
+	}{
+		{0, 3},                      // The burst
+		{500 * time.Millisecond, 0}, // Half a token
+		{time.Second, 1},            // One token per second
+		{3 * time.Second, 2},
+		{time.Hour, 3}, // Never more than the burst
+	} {
+		allowed := 0
+		for range 5 {
+			if limiter.Allow("ip:192.0.2.1", ChatRequest, start.Add(step.after)) {
+				allowed++
+			}
+		}
+		if allowed != step.want {
+			t.Errorf("after %s: %d requests allowed, want %d", step.after, allowed, step.want)
+		}
+	}
+
+	// Each client and request type has its bucket, types without a limit have none
+	if !limiter.Allow("ip:192.0.2.2", ChatRequest, start.Add(time.Hour)) {
+		t.Error("another client was limited by the first one's bucket")
+	}
+	for range 100 {
+		if !limiter.Allow("ip:192.0.2.1", ActionRequest, start) {
+			t.Fatal("a request type without a limit was limited")
+		}
+	}
+}
+
+func TestRateLimiterDefault(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{
+		defaultRateLimitKey: {Rate: 1, Burst: 1},
+		"ChatRequest":       {Rate: 0, Burst: 0},
+		"NotARequest":       {Rate: 1, Burst: 1},
+	})
+	now := time.Now()
+	if !limiter.Allow("ip:192.0.2.1", ActionRequest, now) || limiter.Allow("ip:192.0.2.1", ActionRequest, now) {
+		t.Error("the default limit was not applied to a request type without its own")
+	}
+	for range 10 {
+		if !limiter.Allow("ip:192.0.2.1", ChatRequest, now) {
+			t.Fatal("a rate of 0 did not leave the request type unlimited")
+		}
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"ChatRequest": {Rate: 1, Burst: 3}})
+	now := time.Now()
+	limiter.Allow("ip:192.0.2.1", ChatRequest, now)
+	limiter.Allow("ip:192.0.2.2", ChatRequest, now)
+	limiter.Allow("ip:192.0.2.2", ChatRequest, now)
+
+	// One token short of full: the first bucket goes, the second one has a token to get back
+	limiter.Prune(now.Add(time.Second))
+	if _, kept := limiter.buckets[bucketKey{"ip:192.0.2.1", ChatRequest}]; kept || len(limiter.buckets) != 1 {
+		t.Errorf("%d buckets kept, want only the one still filling up", len(limiter.buckets))
+	}
+	limiter.Prune(now.Add(2 * time.Second))
+	if len(limiter.buckets) != 0 {
+		t.Errorf("%d buckets kept once full", len(limiter.buckets))
+	}
+}
+
+func TestCheckRateLimitScopes(t *testing.T) {
+	withRateLimits(t, map[string]RateLimit{"ChatRequest": {Rate: 1, Burst: 2}})
+	now := time.Now()
+	check := func(address string) string {
+		t.Helper()
+		scope, allowed := checkRateLimit(ChatRequest, &recordingConn{addr: testAddr(address)}, nil, true, now)
+		if allowed {
+			return "allowed"
+		}
+		return scope
+	}
+
+	// Before the hello, the ports of a host share its bucket
+	if got := []string{check("192.0.2.1:5000"), check("192.0.2.1:5001"), check("192.0.2.1:5002")}; got[2] != "address" {
+		t.Errorf("got %v, want the third request of the host refused for its address", got)
+	}
+
+	// Once the client said hello, its account is limited across hosts
+	for _, address := range []string{"192.0.2.10:5000", "192.0.2.11:5000"} {
+		clientList.AddClient(address, Client{FirstName: "LimitAnn", Address: address})
+		t.Cleanup(func() { clientList.RemoveClient(address) })
+	}
+	if got := []string{check("192.0.2.10:5000"), check("192.0.2.11:5000"), check("192.0.2.11:5000")}; got[0] != "allowed" || got[1] != "allowed" || got[2] != "account" {
+		t.Errorf("got %v, want the account refused on its third request", got)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxConnections = 2 })
+	limit := &ConnectionLimit{}
+	if !limit.Acquire() || !limit.Acquire() {
+		t.Fatal("a connection below the limit was refused")
+	}
+	if limit.Acquire() || limit.Count() != 2 {
+		t.Errorf("a connection over the limit was accepted, %d in use", limit.Count())
+	}
+	limit.Release()
+	if !limit.Acquire() {
+		t.Error("the slot of a closed connection was not freed")
+	}
+
+	for range 5 {
+		limit.Release()
+	}
+	if limit.Count() != 0 {
+		t.Errorf("%d slots in use after releasing more than were taken", limit.Count())
+	}
+
+	config.MaxConnections = 0
+	for range 10 {
+		if !limit.Acquire() {
+			t.Fatal("a connection was refused without a limit")
+		}
+	}
+}
+
+func TestAuthFailuresBan(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AuthFailureLimit = 3
+		c.AuthFailureWindow = Duration{time.Minute}
+		c.AuthBanDuration = Duration{time.Hour}
+	})
+	savedBans := bans
+	t.Cleanup(func() { bans = savedBans })
+	var now *time.Time
+	bans, now = testBanList()
+	failures := NewAuthFailures()
+
+	for i, step := range []struct {
+		after time.Duration
+		want  bool
+	}{
+		{0, false},
+		{30 * time.Second, false},
+		{70 * time.Second, false}, // The first failure left the window
+		{80 * time.Second, true},
+	} {
+		if got := failures.Record("192.0.2.1", now.Add(step.after)); got != step.want {
+			t.Errorf("failure %d after %s: banned %v, want %v", i+1, step.after, got, step.want)
+		}
+	}
+	if !bans.IsBanned("192.0.2.1") || bans.IsBanned("192.0.2.2") {
+		t.Error("the ban is not on the address that failed")
+	}
+	if list := bans.List(); len(list) != 1 || !list[0].Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("got %+v, want a ban for the AuthBanDuration", list)
+	}
+	*now = now.Add(time.Hour)
+	if bans.IsBanned("192.0.2.1") {
+		t.Error("the ban outlived the AuthBanDuration")
+	}
+
+	// The count starts over after a ban, and is forgotten once out of the window
+	if failures.Record("192.0.2.1", *now) {
+		t.Error("the failures before the ban were counted again")
+	}
+	failures.Prune(now.Add(time.Minute))
+	if len(failures.failures) != 0 {
+		t.Errorf("%d addresses kept after their failures left the window", len(failures.failures))
+	}
+
+	config.AuthFailureLimit = 0
+	for range 10 {
+		if failures.Record("192.0.2.3", *now) {
+			t.Fatal("an address was banned with the bans disabled")
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// Rules are the rules a variant is played by. The chess package plays standard chess, each
+// variant changes what it needs to on top of it. Rules hold no state, what a game needs
+// beyond its chess.Game is kept in the GameSession.
+type Rules interface {
+	// StartingFEN returns the position a game starts from, given the setup of its creator
+	StartingFEN(setup string) (string, error)
+	// Start sets the session's game at the position of its StartFEN
+	Start(session *GameSession) error
+	// LegalMoves lists the moves the side to move may play, in UCI notation
+	LegalMoves(session *GameSession) []string
+	// Play plays a move written in algebraic or UCI notation
+	Play(session *GameSession, notation string) error
+	// Outcome returns the result of the game and the method it was reached by, chess.NoOutcome
+	// while the game goes on
+	Outcome(session *GameSession) (chess.Outcome, string)
+	// FEN writes the current position
+	FEN(session *GameSession) string
+}
+
+// GamePart is the part of a game played before a move the chess package cannot play, such
+// as a Chess960 castling. The game goes on in a new chess.Game from the position after the
+// move, see splitGame.
+type GamePart struct {
+	Game *chess.Game
+	UCI  string // The move the part ends with, e.g. "b1a1" for a Chess960 castling
+	SAN  string // The move in algebraic notation, with the check or mate it gives
+}
+
+// splitGame plays a move the chess package cannot play: the game so far becomes a part of
+// the session and goes on from fen, the position after the move. A move cannot be undone
+// across parts, so no repetition spans them.
+func splitGame(session *GameSession, fen string, uci string, san string) error {
+	position, err := chess.FEN(fen)
+	if err != nil {
+		return fmt.Errorf("error playing %s: %w", uci, err)
+	}
+	game := chess.NewGame(position)
+	switch {
+	case game.Method() == chess.Checkmate:
+		san += "#"
+	case inCheck(game.Position()):
+		san += "+"
+	}
+	session.Parts = append(session.Parts, GamePart{Game: session.Game, UCI: uci, SAN: san})
+	session.Game = game
+	return nil
+}
+
+// standardRules are the rules of standard chess, played by the chess package
+type standardRules struct{}
+
+func (standardRules) StartingFEN(setup string) (string, error) {
+	return withoutSetup(setup, chess.StartingPosition().String())
+}
+
+func (standardRules) Start(session *GameSession) error {
+	position, err := chess.FEN(session.StartFEN)
+	if err != nil {
+		return fmt.Errorf("invalid starting position: %w", err)
+	}
+	session.Game, session.Castling, session.Parts = chess.NewGame(position), "", nil
+	return nil
+}
+
+func (standardRules) LegalMoves(session *GameSession) []string {
+	var moves []string
+	for _, move := range session.Game.ValidMoves() {
+		moves = append(moves, move.String())
+	}
+	return moves
+}
+
+// Play plays the move and classifies the opening as long as the game follows the book
+func (standardRules) Play(session *GameSession, notation string) error {
+	if err := playChessMove(session, notation); err != nil {
+		return err
+	}
+	if code, name := classifyOpening(session.Game); code != "" {
+		session.OpeningCode, session.OpeningName = code, name
+	}
+	return nil
+}
+
+func (standardRules) Outcome(session *GameSession) (chess.Outcome, string) {
+	return session.Game.Outcome(), session.Game.Method().String()
+}
+
+func (standardRules) FEN(session *GameSession) string {
+	return session.Game.FEN()
+}
+
+// fromPositionRules are standard chess from a position its creator gives in FEN
+type fromPositionRules struct{ standardRules }
+
+func (fromPositionRules) StartingFEN(setup string) (string, error) {
+	setup = strings.TrimSpace(setup)
+	if setup == "" {
+		return "", fmt.Errorf("a game from a position needs its FEN")
+	}
+	if err := validateFEN(setup); err != nil {
+		return "", err
+	}
+	position, _ := chess.FEN(setup)
+	return chess.NewGame(position).Position().String(), nil
+}
+
+// kingOfTheHillRules are standard chess, won as well by bringing the king to one of the
+// four center squares
+type kingOfTheHillRules struct{ standardRules }
+
+// hill is the center of the board, where a king wins King of the Hill games
+var hill = []chess.Square{chess.D4, chess.E4, chess.D5, chess.E5}
+
+func (kingOfTheHillRules) Outcome(session *GameSession) (chess.Outcome, string) {
+	if outcome, method := (standardRules{}).Outcome(session); outcome != chess.NoOutcome {
+		return outcome, method
+	}
+	position := session.Game.Position()
+	mover := position.Turn().Other()
+	for _, square := range hill {
+		if position.Board().Piece(square) == chess.NewPiece(chess.King, mover) {
+			return wonBy(mover), "KingOfTheHill"
+		}
+	}
+	return chess.NoOutcome, chess.NoMethod.String()
+}
+
+// threeCheckRules are standard chess, won as well by giving check three times
+type threeCheckRules struct{ standardRules }
+
+func (threeCheckRules) Outcome(session *GameSession) (chess.Outcome, string) {
+	if outcome, method := (standardRules{}).Outcome(session); outcome != chess.NoOutcome {
+		return outcome, method
+	}
+	white, black := checksGiven(session.Game)
+	switch {
+	case white >= 3:
+		return chess.WhiteWon, "ThreeChecks"
+	case black >= 3:
+		return chess.BlackWon, "ThreeChecks"
+	}
+	return chess.NoOutcome, chess.NoMethod.String()
+}
+
+// FEN writes the position followed by the checks each side gave, e.g. "+2+0"
+func (threeCheckRules) FEN(session *GameSession) string {
+	white, black := checksGiven(session.Game)
+	return session.Game.FEN() + checkCount(white, black)
+}
+
+// checksGiven counts the checks White and Black gave in a game
+func checksGiven(game *chess.Game) (white int, black int) {
+	positions := game.Positions()
+	for i, move := range game.Moves() {
+		switch {
+		case !move.HasTag(chess.Check):
+		case positions[i].Turn() == chess.White:
+			white++
+		default:
+			black++
+		}
+	}
+	return white, black
+}
+
+// checkCount writes the checks of a Three-check game as its FEN ends with
+func checkCount(white, black int) string {
+	return fmt.Sprintf(" +%d+%d", white, black)
+}
+
+// playChessMove plays a move of the chess package, written in algebraic or UCI notation
+func playChessMove(session *GameSession, notation string) error {
+	move, err := decodeNotation(session.Game.Position(), notation)
+	if err != nil {
+		return err
+	}
+	return session.Game.Move(move)
+}
+
+// withoutSetup returns the starting position of a variant that takes no setup, refusing one
+func withoutSetup(setup string, fen string) (string, error) {
+	if strings.TrimSpace(setup) != "" {
+		return "", fmt.Errorf("only chess960 and fromposition games take a starting position")
+	}
+	return fen, nil
+}
+
+// wonBy returns the outcome of a game the color won
+func wonBy(color chess.Color) chess.Outcome {
+	if color == chess.White {
+		return chess.WhiteWon
+	}
+	return chess.BlackWon
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// variantSession starts a game of the variant from fen, or from the variant's own start when
+// fen is empty
+func variantSession(t *testing.T, variant Variant, fen string) *GameSession {
+	t.Helper()
+	if fen == "" {
+		var err error
+		if fen, err = variant.Rules().StartingFEN(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session := &GameSession{Variant: variant, StartFEN: fen}
+	if err := startSession(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestVariantOutcomes(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		variant Variant
+		start   string
+		moves   []string
+		want    chess.Outcome
+		method  string
+	}{
+		{"standard checkmate", Standard, "", []string{"f3", "e5", "g4", "Qh4"}, chess.BlackWon, "Checkmate"},
+		{"standard stalemate", Standard, "7k/8/8/6Q1/8/8/8/K7 w - - 0 1", []string{"Qg6"}, chess.Draw, "Stalemate"},
+		{"standard game going on", Standard, "", []string{"e4", "e5"}, chess.NoOutcome, "NoMethod"},
+
+		{"White king on the hill", KingOfTheHill, "4k3/p7/8/8/8/4K3/P7/8 w - - 0 1", []string{"Ke4"}, chess.WhiteWon, "KingOfTheHill"},
+		{"Black king on the hill", KingOfTheHill, "8/p7/4k3/8/8/8/P7/4K3 b - - 0 1", []string{"Kd5"}, chess.BlackWon, "KingOfTheHill"},
+		{"king next to the hill", KingOfTheHill, "4k3/p7/8/8/8/4K3/P7/8 w - - 0 1", []string{"Kf4"}, chess.NoOutcome, "NoMethod"},
+		{"king of the hill checkmate", KingOfTheHill, "", []string{"f3", "e5", "g4", "Qh4"}, chess.BlackWon, "Checkmate"},
+		{"king of the hill stalemate", KingOfTheHill, "7k/8/8/6Q1/8/8/8/K7 w - - 0 1", []string{"Qg6"}, chess.Draw, "Stalemate"},
+
+		{"White gives three checks", ThreeCheck, "4k3/8/8/8/8/8/8/R3K3 w - - 0 1",
+			[]string{"Ra8+", "Kd7", "Ra7+", "Kd6", "Ra6+"}, chess.WhiteWon, "ThreeChecks"},
+		{"Black gives three checks", ThreeCheck, "r3k3/8/8/8/8/8/8/4K3 b - - 0 1",
+			[]string{"Ra1+", "Kd2", "Ra2+", "Kd3", "Ra3+"}, chess.BlackWon, "ThreeChecks"},
+		{"two checks", ThreeCheck, "4k3/8/8/8/8/8/8/R3K3 w - - 0 1",
+			[]string{"Ra8+", "Kd7", "Ra7+", "Kd6"}, chess.NoOutcome, "NoMethod"},
+		{"three-check checkmate", ThreeCheck, "", []string{"f3", "e5", "g4", "Qh4"}, chess.BlackWon, "Checkmate"},
+		{"three-check stalemate", ThreeCheck, "7k/8/8/6Q1/8/8/8/K7 w - - 0 1", []string{"Qg6"}, chess.Draw, "Stalemate"},
+
+		{"horde taken", Horde, "4k3/8/8/8/8/8/r7/P7 b - - 0 1", []string{"Rxa1"}, chess.BlackWon, "HordeCaptured"},
+		{"horde checkmates", Horde, "k7/p1P5/PP6/8/8/8/8/8 w - - 0 1", []string{"b7"}, chess.WhiteWon, "Checkmate"},
+		{"horde stalemated", Horde, "4k3/8/8/8/8/8/p7/P7 b - - 0 1", []string{"Kd7"}, chess.Draw, "Stalemate"},
+		{"horde game going on", Horde, "", []string{"e5", "e6"}, chess.NoOutcome, "NoMethod"},
+	} {
+		session := variantSession(t, test.variant, test.start)
+		playAll(t, session, test.moves...)
+		got, method := session.Variant.Rules().Outcome(session)
+		if got != test.want || method != test.method {
+			t.Errorf("%s: got %s by %s, want %s by %s", test.name, got, method, test.want, test.method)
+		}
+	}
+}
+
+func TestThreeCheckFEN(t *testing.T) {
+	session := variantSession(t, ThreeCheck, "")
+	if fen := session.FEN(); !strings.HasSuffix(fen, " w KQkq - 0 1 +0+0") {
+		t.Errorf("starting FEN %s, want the checks counted from +0+0", fen)
+	}
+	playAll(t, session, "e4", "e5", "Bc4", "Bc5", "Bxf7+", "Kxf7", "Qh5+")
+	const want = "rnbq2nr/pppp1kpp/8/2b1p2Q/4P3/8/PPPP1PPP/RNB1K1NR b KQ - 1 4 +2+0"
+	if fen := session.FEN(); fen != want {
+		t.Errorf("got %s, want %s", fen, want)
+	}
+
+	// The counter is not saved, it comes back from the moves
+	restored, err := restoreGame(saveGame(*session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fen := restored.FEN(); fen != want {
+		t.Errorf("restored as %s, want %s", fen, want)
+	}
+	playAll(t, &restored, "g6", "Qf3+")
+	if got, method := (threeCheckRules{}).Outcome(&restored); got != chess.WhiteWon || method != "ThreeChecks" {
+		t.Errorf("the third check after the restore gave %s by %s, want White won by three checks", got, method)
+	}
+}
+
+func TestFirstRankSteps(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		fen  string
+		want []string
+	}{
+		{"horde start, every pawn blocked", hordeFEN, nil},
+		{"free and blocked files", "4k3/8/8/8/8/8/1P6/PP1P4 w - - 0 1", []string{"a1a3", "d1d3"}},
+		{"piece on the third rank", "4k3/8/8/8/8/3p4/8/PP1P4 w - - 0 1", []string{"a1a3", "b1b3"}},
+		{"black pieces do not step", "4k3/8/8/8/8/8/8/PP1P4 b - - 0 1", nil},
+		{"pawns only", "4k3/8/8/8/8/8/8/RN1P4 w - - 0 1", []string{"d1d3"}},
+	} {
+		position, err := chess.FEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := firstRankSteps(chess.NewGame(position).Position())
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHordeDoubleSteps(t *testing.T) {
+	const start = "4k3/8/8/8/8/1p6/8/PP1P4 w - - 0 1"
+	for _, move := range []string{"a1a3", "a3"} {
+		session := variantSession(t, Horde, start)
+		if !slices.Contains((hordeRules{}).LegalMoves(session), "a1a3") {
+			t.Fatalf("a1a3 is not among the legal moves %v", (hordeRules{}).LegalMoves(session))
+		}
+		playAll(t, session, move)
+		// The step leaves no en passant square, b3 cannot take on a2
+		if fen := session.FEN(); fen != "4k3/8/8/8/8/Pp6/8/1P1P4 b - - 0 1" {
+			t.Errorf("%s: got %s", move, fen)
+		}
+		if slices.Contains((hordeRules{}).LegalMoves(session), "b3a2") {
+			t.Errorf("%s: the double step was taken en passant", move)
+		}
+	}
+
+	// The steps are replayed with the rest of the game
+	session := variantSession(t, Horde, start)
+	playAll(t, session, "d1d3", "Kd7", "d4", "Kd6", "a1a3")
+	restored, err := restoreGame(saveGame(*session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.FEN(), session.FEN(); got != want {
+		t.Errorf("restored as %s, want %s", got, want)
+	}
+
+	// A step blocked by a piece on the second or third rank is refused
+	for _, blocked := range []string{"4k3/8/8/8/8/8/P7/P7 w - - 0 1", "4k3/8/8/8/8/p7/8/P7 w - - 0 1"} {
+		session := variantSession(t, Horde, blocked)
+		if err := playMove(session, "a1a3"); err == nil {
+			t.Errorf("%s: a1a3 was played through a piece", blocked)
+		}
+	}
+}
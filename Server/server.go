@@ -1,17 +1,135 @@
 package main
 
-import "time"
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
 
 func main() {
-	// Lancer le serveur TCP en goroutine
-	go startTCPServer()
+	// Read the configuration: file, environment variables, then command line
+	cfg, err := LoadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	config = cfg
+
+	// Set up structured logging: level, format and file come from the configuration
+	logOutput, err := SetupLogging(config)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer logOutput.Close()
+
+	// Limit the rate of each client per request type
+	rateLimiter = NewRateLimiter(config.RateLimits)
+	slog.Debug("Rate limits", "rate_limits", formatRateLimits(config.RateLimits))
+
+	// Plug in an external UCI engine for games against the computer, if configured
+	if config.UCIEnginePath != "" {
+		RegisterUCIEngine(config.botEngineOptions())
+
+		// The same engine analyses finished games
+		analysisEngine = NewUCIEngine(config.analysisEngineOptions())
+	}
+
+	// Resume the games saved at the last shutdown
+	if err := LoadGames(config.DataDir); err != nil {
+		fatal("Failed to load the saved games", err)
+	}
 
-	// Lancer le serveur UDP en goroutine
-	go startUDPServer()
+	// Open the ports of the enabled transports, then serve them until shutdown
+	var tcpServer *TCPServer
+	var udpServer *UDPServer
+	if config.EnableTCP {
+		tcpServer = NewTCPServer(config.TCPAddr)
+		if tcpServer.TLSConfig, err = serverTLSConfig(config); err != nil {
+			fatal("Failed to configure TLS", err)
+		}
+		if err := tcpServer.Listen(); err != nil {
+			fatal("Failed to start the TCP server", err)
+		}
+	}
+	if config.EnableUDP {
+		udpServer = NewUDPServer(config.UDPAddr)
+		if err := udpServer.Listen(); err != nil {
+			fatal("Failed to start the UDP server", err)
+		}
+	}
+
+	// Open the WebSocket gateway for browsers, served by the TCP server
+	var websocketServer *http.Server
+	if config.WebSocketAddr != "" {
+		websocketServer, err = startHTTPServer("websocket", config.WebSocketAddr, websocketHandler(tcpServer, config.WebSocketOrigins), tcpServer.TLSConfig)
+		if err != nil {
+			fatal("Failed to start the WebSocket gateway", err)
+		}
+	}
 
-	// Attendre pendant 24 heures (1 jour)
-	select {
-	case <-time.After(time.Hour * 24):
-		// Rien à faire, le programme attend
+	// Expose the Prometheus metrics, if an address is configured
+	var metricsServer *http.Server
+	if config.MetricsAddr != "" {
+		metricsServer, err = startHTTPServer("metrics", config.MetricsAddr, metricsHandler(), nil)
+		if err != nil {
+			fatal("Failed to start the metrics server", err)
+		}
 	}
+
+	// Open the admin API, if an address is configured
+	var adminServer *http.Server
+	if config.AdminAddr != "" {
+		adminServer, err = startHTTPServer("admin", config.AdminAddr, adminHandler(config.AdminToken), nil)
+		if err != nil {
+			fatal("Failed to start the admin API", err)
+		}
+	}
+
+	// Open the public REST API, if an address is configured
+	var apiServer *http.Server
+	if config.APIAddr != "" {
+		apiServer, err = startHTTPServer("api", config.APIAddr, apiHandler(config.APITokens), nil)
+		if err != nil {
+			fatal("Failed to start the REST API", err)
+		}
+	}
+
+	// The transports are stopped by shutdown, after the games are saved
+	ctx := context.Background()
+	if tcpServer != nil {
+		go tcpServer.Serve(ctx)
+	}
+	if udpServer != nil {
+		go udpServer.Serve(ctx)
+	}
+
+	// Start the matchmaking queue
+	matchmaker.Start(time.Second)
+
+	// Ping the clients and clean up after those who left
+	heartbeat.Start(config.PingInterval.Duration)
+
+	// Wait for SIGINT or SIGTERM, then shut down gracefully
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	received := <-signals
+	slog.Info("Signal received, shutting down the server...", "signal", received.String())
+	signal.Stop(signals)
+
+	shutdown(tcpServer, udpServer, websocketServer, metricsServer, adminServer, apiServer)
+}
+
+// fatal logs a startup error, then exits the program
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"os"
 	"sync"
 	"time"
 )
@@ -16,29 +19,61 @@ type ClientInfo struct {
 	Status      string
 	Level       int
 	ConnectedAt time.Time
+	LastSeen    time.Time // Last message received, to expire silent UDP clients
 }
 
-// ClientRegistry gère les clients connectés
+// ClientRegistry keeps track of the connected clients
 type ClientRegistry struct {
 	mu      sync.RWMutex
 	clients map[string]*ClientInfo
 }
 
-// NewClientRegistry crée un nouveau registre de clients
+// NewClientRegistry creates a new client registry
 func NewClientRegistry() *ClientRegistry {
 	return &ClientRegistry{
 		clients: make(map[string]*ClientInfo),
 	}
 }
 
-// AddClient ajoute ou met à jour un client dans le registre
+// AddClient adds or updates a client in the registry
 func (cr *ClientRegistry) AddClient(address string, info *ClientInfo) {
 	cr.mu.Lock()
 	defer cr.mu.Unlock()
 	cr.clients[address] = info
 }
 
-// GetClient récupère un client par son adresse
+// Touch records a message received from an address and returns its client, created on
+// the first message if admit accepts it. It returns nil for a refused new client.
+func (cr *ClientRegistry) Touch(address string, now time.Time, admit func() bool) *ClientInfo {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	info, exists := cr.clients[address]
+	if !exists {
+		if !admit() {
+			return nil
+		}
+		info = &ClientInfo{ConnectedAt: now}
+		cr.clients[address] = info
+	}
+	info.LastSeen = now
+	return info
+}
+
+// RemoveIdle removes the clients silent since cutoff and returns their addresses
+func (cr *ClientRegistry) RemoveIdle(cutoff time.Time) []string {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	var removed []string
+	for address, info := range cr.clients {
+		if info.LastSeen.Before(cutoff) {
+			delete(cr.clients, address)
+			removed = append(removed, address)
+		}
+	}
+	return removed
+}
+
+// GetClient retrieves a client by its address
 func (cr *ClientRegistry) GetClient(address string) (*ClientInfo, bool) {
 	cr.mu.RLock()
 	defer cr.mu.RUnlock()
@@ -46,197 +81,335 @@ func (cr *ClientRegistry) GetClient(address string) (*ClientInfo, bool) {
 	return client, exists
 }
 
-// TCPServer gère le serveur TCP et les connexions des clients
+// TCPServer runs the TCP server and the connections of its clients
 type TCPServer struct {
+	addr           string
 	listener       net.Listener
 	clientRegistry *ClientRegistry
-	stopChan       chan struct{}
-	wg             sync.WaitGroup
+
+	// TLSConfig encrypts the connections, nil for plain TCP. To be set before Listen.
+	TLSConfig *tls.Config
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{} // Open connections, closed on shutdown
+	closed bool                  // No more connections are accepted
+	cancel context.CancelFunc    // Stops Serve, nil while Serve is not running
+	done   chan struct{}         // Closed once Serve is done
+
+	wg sync.WaitGroup // Goroutines of the connections
 }
 
-// NewTCPServer crée une nouvelle instance du serveur TCP
-func NewTCPServer(port int) *TCPServer {
+// NewTCPServer creates a TCP server listening on addr (e.g. ":8080")
+func NewTCPServer(addr string) *TCPServer {
 	return &TCPServer{
+		addr:           addr,
 		clientRegistry: NewClientRegistry(),
-		stopChan:       make(chan struct{}),
+		conns:          make(map[net.Conn]struct{}),
 	}
 }
 
 func (srv *TCPServer) handleClientConnection(conn net.Conn) {
 	clientAddress := conn.RemoteAddr().String()
+	transport := streamTransport(conn)
+	logger := connLogger(transport, conn.RemoteAddr())
+	logger.Debug("Connection opened")
 
-	// Tampon pour accumuler les données entrantes
+	// Forget the client, its games stay open for it to come back
+	reason := "connection closed"
+	defer func() { disconnectClient(clientAddress, reason) }()
+
+	// With TLS, the handshake comes before any message and must not drag on
+	clientCert, err := handshakeTLS(conn, time.Now().Add(config.IdleTimeout.Duration))
+	if err != nil {
+		reason = "TLS handshake failed"
+		logger.Warn("TLS handshake failed", "err", err)
+		return
+	}
+	if clientCert != "" {
+		logger = logger.With("client_cert", clientCert)
+		logger.Info("Client authenticated by certificate")
+	}
+
+	// Buffer to accumulate the incoming data
 	buf := make([]byte, 2048)
 	remainingData := []byte{}
 
 	for {
+		// The client answers the server's pings, a silence longer than IdleTimeout disconnects it
+		conn.SetReadDeadline(time.Now().Add(config.IdleTimeout.Duration))
 		n, err := conn.Read(buf)
 		if err != nil {
-			if err == net.ErrClosed {
-				log.Printf("Connexion fermée : %s\n", clientAddress)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				reason = "idle timeout"
+				logger.Info("Client idle, closing the connection", "idle_timeout", config.IdleTimeout.Duration)
+			} else if errors.Is(err, net.ErrClosed) {
+				logger.Debug("Connection closed")
 			} else if err.Error() == "EOF" {
-				log.Printf("Le client a fermé la connexion : %s\n", clientAddress)
+				logger.Info("The client closed the connection")
 			} else {
-				log.Printf("Erreur de lecture de la connexion TCP %s : %v\n", clientAddress, err)
+				logger.Warn("Error reading from the TCP connection", "err", err)
 			}
 			return
 		}
+		serverMetrics.bytesReceived.Add(float64(n), transport)
 
-		// Traiter les données lues
+		// Process the data read, unless the server is shutting down
+		if !inFlightRequests.Begin() {
+			logger.Info("Server shutting down, closing the connection")
+			return
+		}
 		fullData := append(remainingData, buf[:n]...)
 
-		// After processing the data, clear the buffer
+		// A read may hold several messages or part of one: the incomplete end is kept
+		// for the following reads to complete
 		remainingData, err = srv.processIncomingData(fullData, clientAddress, conn)
+		inFlightRequests.End()
 		if err != nil {
-			log.Printf("Erreur lors du traitement des données entrantes de %s : %v\n", clientAddress, err)
+			logger.Warn("Error processing incoming data", "err", err)
 			return
 		}
-
-		// Clear the remainingData buffer for the next batch of data
-		remainingData = []byte{}
 	}
 }
 
+// processIncomingData handles every whole message of data, returning the bytes of the
+// incomplete message that follows them, if any
 func (srv *TCPServer) processIncomingData(data []byte, clientAddress string, conn net.Conn) ([]byte, error) {
 	// Log the raw data received
-	log.Printf("Raw data received from %s: %x", clientAddress, data)
+	logger := connLogger(streamTransport(conn), conn.RemoteAddr())
+	logger.Debug("Raw data received", "bytes", len(data))
 
-	// Ensure valid data is provided (at least tag + length)
-	if len(data) < 3 {
-		log.Println("Insufficient data received, waiting for more.")
-		return data, nil
+	for {
+		length, err := messageLength(data)
+		if err != nil {
+			logger.Warn("Error framing message", "err", err)
+			return nil, err
+		}
+		if length == 0 {
+			if len(data) > 0 {
+				logger.Debug("Insufficient data received, waiting for more", "bytes", len(data))
+			}
+			return data, nil
+		}
+		message := data[:length]
+		data = data[length:]
+
+		// The first TLV of a message gives its tag
+		tag, value, err := DecodeTLV(message)
+		if err != nil {
+			logger.Warn("Error decoding TLV tag", "err", err)
+			return nil, fmt.Errorf("failed to decode TLV tag: %w", err)
+		}
+		logger.Debug("Decoded tag", "tag", GetTagName(tag), "length", len(value))
+
+		// Hand the request to the handler registered for its tag
+		handled, err := dispatchRequest(tag, conn, nil, nil, message, true)
+		if !handled {
+			// Skip unknown tags so the following messages can still be processed
+			logger.Warn("Unknown tag encountered", "tag", GetTagName(tag))
+			continue
+		}
+		if err != nil {
+			logger.Warn("Error handling request", "request", GetTagName(tag), "err", err)
+			return nil, err
+		}
+		logger.Debug("Request processed", "request", GetTagName(tag))
 	}
+}
 
-	// Decode the first TLV message to get the tag
-	tag, value, err := DecodeTLV(data)
+// messageLength returns the length of the first message of a stream, 0 while it is not
+// whole. A request is the TLV of its tag followed by the fields requestLayouts lists for it.
+// A field of another tag ends the request early, for its handler to refuse it. A message of
+// a tag without a layout is an error: where it ends, and so where the next one starts, is
+// unknown.
+func messageLength(data []byte) (int, error) {
+	tag, _, length, err := SafeDecodeTLV(data)
 	if err != nil {
-		log.Printf("Error decoding TLV tag: %v", err)
-		return nil, fmt.Errorf("failed to decode TLV tag: %w", err)
+		return 0, nil
 	}
 
-	// Log the decoded tag and the value associated with it
-	log.Printf("Decoded tag: %d (%s), Value: %v", tag, GetTagName(tag), value)
+	layout, known := requestLayouts[tag]
+	if !known {
+		return 0, fmt.Errorf("%w: %s", ErrUnframedMessage, GetTagName(tag))
+	}
 
-	// Handle known tags based on your protocol
-	switch tag {
-	case HelloRequest:
-		// Handle the HelloRequest for a TCP connection
-		if err := HandleHelloRequest(conn, nil, nil, data, true); err != nil {
-			log.Printf("Error handling HelloRequest: %v", err)
-			return nil, err
+	for _, field := range layout {
+		if length == len(data) {
+			// The next field, optional or not, is still on its way
+			return 0, nil
 		}
-		log.Println("HelloRequest successfully processed.")
-		return data[len(value)+3:], nil // Skip the processed bytes
-
-	case GameRequest:
-		// Handle the GameRequest
-		if err := HandleGameRequest(conn, nil, nil, data, true); err != nil {
-			log.Printf("Error handling GameRequest: %v", err)
-			return nil, err
+		next := Tag(data[length])
+		if field.Optional && next != field.Tag {
+			continue
 		}
-		log.Println("GameRequest successfully processed, GameResponse sent.")
-		return data[len(value)+3:], nil // Skip the processed bytes
-
-	case LobbyRequest:
-		// Handle the LobbyListRequest
-		if err := HandleLobbyListRequest(conn, nil, nil, data, true); err != nil {
-			log.Printf("Error handling LobbyListRequest: %v", err)
-			return nil, err
+		if next != field.Tag {
+			return length, nil
 		}
-		log.Println("LobbyListRequest successfully processed, LobbyResponse sent.")
-		return data[len(value)+3:], nil // Skip the processed bytes
-
-	case JoinLobbyRequest:
-		// Handle the JoinLobbyRequest
-		if err := HandleJoinRequest(conn, nil, nil, data, true); err != nil {
-			log.Printf("Error handling JoinLobbyRequest: %v", err)
-			return nil, err
+		_, _, size, err := SafeDecodeTLV(data[length:])
+		if err != nil {
+			return 0, nil
 		}
-		log.Println("JoinLobbyRequest successfully processed.")
-		return data[len(value)+3:], nil // Skip the processed bytes
+		length += size
+	}
+	return length, nil
+}
 
-	case BoardRequest:
-		// Handle the BoardRequest (game board-related logic)
-		if err := HandleBoardRequest(conn, nil, nil, data, true); err != nil {
-			log.Printf("Error handling BoardRequest: %v", err)
-			return nil, err
-		}
-		log.Println("BoardRequest successfully processed, BoardResponse sent.")
-		return data[len(value)+3:], nil // Skip the processed bytes
+// Listen opens the listening port without accepting connections yet, so that startup
+// errors are known before Serve
+func (srv *TCPServer) Listen() error {
+	listener, err := net.Listen("tcp", srv.addr)
+	if err != nil {
+		return fmt.Errorf("error starting the TCP server on %s: %w", srv.addr, err)
+	}
+	if srv.TLSConfig != nil {
+		listener = tls.NewListener(listener, srv.TLSConfig)
+	}
+	srv.listener = listener
+	slog.Info("TCP server listening", "addr", listener.Addr().String(), "tls", srv.TLSConfig != nil)
+	return nil
+}
 
-	case ActionRequest:
-		// Handle the MoveRequest (ActionRequest for making a move)
-		if err := HandleMoveRequest(conn, nil, nil, data, true); err != nil {
-			log.Printf("Error handling ActionRequest (MoveRequest): %v", err)
-			return nil, err
-		}
-		log.Println("ActionRequest (MoveRequest) successfully processed.")
-		return data[len(value)+3:], nil // Skip the processed bytes
+// Addr returns the actual listening address, useful with an ephemeral port (":0")
+func (srv *TCPServer) Addr() net.Addr {
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Addr()
+}
 
-	default:
-		// Log unknown tags for debugging
-		log.Printf("Unknown tag encountered: %d (%s)", tag, GetTagName(tag))
-		// Return the remaining data for further processing
-		return data[len(value)+3:], nil // Skip the processed bytes
+// Start listens on the server's address and serves connections until ctx is cancelled
+func (srv *TCPServer) Start(ctx context.Context) error {
+	if err := srv.Listen(); err != nil {
+		return err
 	}
+	return srv.Serve(ctx)
 }
 
-// Start commence à écouter les connexions TCP entrantes
-func (srv *TCPServer) Start(port int) error {
-	var err error
-	srv.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		return fmt.Errorf("erreur lors du démarrage du serveur TCP sur le port %d : %w", port, err)
+// Serve accepts connections until ctx is cancelled or Stop is called. It then closes the
+// listener and every connection, waits for their goroutines to end and returns.
+func (srv *TCPServer) Serve(ctx context.Context) error {
+	if srv.listener == nil {
+		return errors.New("the TCP server is not listening, Listen must be called before Serve")
 	}
 
-	srv.wg.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srv.mu.Lock()
+	srv.cancel, srv.done = cancel, make(chan struct{})
+	done := srv.done
+	srv.mu.Unlock()
+	defer close(done)
+
+	// Closing the listener unblocks Accept, closing the connections unblocks their reads
 	go func() {
-		defer srv.wg.Done()
-		defer srv.listener.Close()
-
-		log.Printf("Serveur TCP en écoute sur le port %d...\n", port)
-
-		for {
-			select {
-			case <-srv.stopChan:
-				log.Println("Arrêt du serveur TCP...")
-				return
-			default:
-				conn, err := srv.listener.Accept()
-				if err != nil {
-					log.Printf("Erreur lors de l'acceptation de la connexion TCP : %v\n", err)
-					continue
-				}
-				go srv.handleClientConnection(conn)
+		<-ctx.Done()
+		srv.listener.Close()
+		srv.closeConnections()
+	}()
+
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
 			}
+			slog.Warn("Error accepting a TCP connection", "err", err)
+			continue
 		}
-	}()
 
+		if err := srv.track(conn); err != nil {
+			slog.Warn("Connection refused", "remote", conn.RemoteAddr().String(), "err", err)
+			conn.Close()
+			continue
+		}
+
+		go func() {
+			defer srv.wg.Done()
+			defer srv.untrack(conn)
+			srv.handleClientConnection(conn)
+		}()
+	}
+
+	// Make sure the connections are closed even if the listener was closed elsewhere
+	cancel()
+	srv.closeConnections()
+	srv.wg.Wait()
+	slog.Info("TCP server stopped")
 	return nil
 }
 
-// Stop arrête proprement le serveur TCP
-func (srv *TCPServer) Stop() {
-	close(srv.stopChan)
-	srv.wg.Wait()
-	if srv.listener != nil {
-		srv.listener.Close()
+// ServeConn serves a connection accepted elsewhere, such as a WebSocket, exactly like a TCP
+// connection: same limits, same sessions and same shutdown. It blocks until it is closed.
+func (srv *TCPServer) ServeConn(conn net.Conn) error {
+	if err := srv.track(conn); err != nil {
+		conn.Close()
+		return err
 	}
+	defer srv.wg.Done()
+	defer srv.untrack(conn)
+	srv.handleClientConnection(conn)
+	return nil
 }
 
-// Erreur personnalisée pour gérer les données insuffisantes lors du décodage TLV
-var ErrInsufficientData = errors.New("données insuffisantes pour le décodage TLV")
+// track records a new connection, unless shutting down or over the configured limit. An
+// accepted connection counts in srv.wg, to be released by wg.Done once served.
+func (srv *TCPServer) track(conn net.Conn) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closed {
+		return ErrShuttingDown
+	}
+	if bans.IsBanned(addrIP(conn.RemoteAddr())) {
+		serverMetrics.refused.Inc(streamTransport(conn), "banned")
+		return errors.New("banned address")
+	}
+	// The limit counts the UDP clients too
+	if !connections.Acquire() {
+		serverMetrics.refused.Inc(streamTransport(conn), "connection_limit")
+		return fmt.Errorf("limit of %d connections reached", config.MaxConnections)
+	}
+	srv.conns[conn] = struct{}{}
+	// Under the lock, so that shutdown cannot wait on srv.wg before this addition
+	srv.wg.Add(1)
+	return nil
+}
 
-// startTCPServer est une fonction de commodité pour démarrer le serveur TCP
-func startTCPServer() {
-	server := NewTCPServer(8080)
+func (srv *TCPServer) untrack(conn net.Conn) {
+	srv.mu.Lock()
+	delete(srv.conns, conn)
+	srv.mu.Unlock()
+	connections.Release()
+	conn.Close()
+}
 
-	// Démarrer le serveur
-	if err := server.Start(8080); err != nil {
-		log.Fatalf("Échec du démarrage du serveur TCP : %v", err)
+// closeConnections closes every open connection and refuses the following ones
+func (srv *TCPServer) closeConnections() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.closed = true
+	for conn := range srv.conns {
+		conn.Close()
 	}
+}
 
-	// Optionnel : Ajouter un moyen d'arrêter le serveur proprement (par exemple, sur signal)
-	// Pour l'instant, le serveur fonctionnera indéfiniment
-	select {}
+// Stop gracefully stops the TCP server and waits for Serve to end
+func (srv *TCPServer) Stop() {
+	srv.mu.Lock()
+	cancel, done := srv.cancel, srv.done
+	srv.mu.Unlock()
+
+	if cancel == nil {
+		// Serve never ran, releasing the port is enough
+		if srv.listener != nil {
+			srv.listener.Close()
+		}
+		return
+	}
+	cancel()
+	<-done
 }
+
+// ErrInsufficientData is returned when there is not enough data to decode a TLV
+var ErrInsufficientData = errors.New("insufficient data for TLV decoding")
+
+// ErrUnframedMessage is returned when a stream starts with a tag requestLayouts does not describe
+var ErrUnframedMessage = errors.New("no layout to frame the message")
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// helloRequest builds the HelloRequest of a player, signed and hashed as the client does
+func helloRequest(t *testing.T, name string) (request []byte, hash string) {
+	t.Helper()
+	message, err := encodeTLVFields(
+		tlvField{HelloRequest, []byte("HelloRequest")},
+		tlvField{String, []byte(name)},
+		tlvField{String, []byte("Stream")},
+		tlvField{String, []byte("Player")},
+		tlvField{Int, []byte("1500")},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash = GenerateSignature(message)
+	trailer, err := encodeTLVFields(tlvField{ByteData, []byte("0123456789abcdef")}, tlvField{ByteData, []byte(hash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(message, trailer...), hash
+}
+
+// serveStream serves one end of a pipe as a TCP connection, returning the other end
+func serveStream(t *testing.T) net.Conn {
+	t.Helper()
+	server, client := net.Pipe()
+	srv := NewTCPServer("")
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		srv.ServeConn(server)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-served
+	})
+	return client
+}
+
+// readMessage reads the next message the server sends on a stream
+func readMessage(t *testing.T, conn net.Conn, pending *[]byte) (Tag, []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for {
+		if tag, value, length, err := SafeDecodeTLV(*pending); err == nil {
+			*pending = (*pending)[length:]
+			return tag, value
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("error reading the server's answer: %v", err)
+		}
+		*pending = append(*pending, buf[:n]...)
+	}
+}
+
+// writeChunks writes data in pieces of the given size, as separate reads on the server
+func writeChunks(t *testing.T, conn net.Conn, data []byte, size int) {
+	t.Helper()
+	go func() {
+		for len(data) > 0 {
+			n := min(size, len(data))
+			if _, err := conn.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+	}()
+}
+
+func TestStreamHandlesPongAndRequestInOneRead(t *testing.T) {
+	conn := serveStream(t)
+	pong, err := EncodeTLV(Pong, []byte("ping-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello, hash := helloRequest(t, "StreamOneRead")
+	ping, err := EncodeTLV(Ping, []byte("ping-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeChunks(t, conn, append(append(pong, hello...), ping...), 1<<16)
+
+	var pending []byte
+	if tag, value := readMessage(t, conn, &pending); tag != HelloResponse || string(value) != hash {
+		t.Fatalf("got %s %q, want the HelloResponse", GetTagName(tag), value)
+	}
+	if tag, value := readMessage(t, conn, &pending); tag != Pong || string(value) != "ping-2" {
+		t.Fatalf("got %s %q, want the Pong of the last message", GetTagName(tag), value)
+	}
+}
+
+func TestStreamReassemblesSplitMessages(t *testing.T) {
+	conn := serveStream(t)
+	hello, hash := helloRequest(t, "StreamSplit")
+	ping, err := EncodeTLV(Ping, []byte("after"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads end in the middle of the tags, the lengths and the values of the TLVs
+	writeChunks(t, conn, append(hello, ping...), 5)
+
+	var pending []byte
+	if tag, value := readMessage(t, conn, &pending); tag != HelloResponse || string(value) != hash {
+		t.Fatalf("got %s %q, want the HelloResponse", GetTagName(tag), value)
+	}
+	if tag, value := readMessage(t, conn, &pending); tag != Pong || string(value) != "after" {
+		t.Fatalf("got %s %q, want the Pong", GetTagName(tag), value)
+	}
+}
+
+func TestMessageLength(t *testing.T) {
+	hello, _ := helloRequest(t, "Length")
+	pong, _ := EncodeTLV(Pong, []byte("p"))
+	for _, test := range []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 0},
+		{"partial header", pong[:2], 0},
+		{"partial value", pong[:len(pong)-1], 0},
+		{"single TLV", pong, len(pong)},
+		{"request then more", append(append([]byte{}, hello...), pong...), len(hello)},
+		{"request missing part of its hash", hello[:len(hello)-4], 0},
+		{"request missing its fields", hello[:len("HelloRequest")+3], 0},
+		{"message then partial message", append(append([]byte{}, pong...), hello[:10]...), len(pong)},
+	} {
+		if got := frameLength(t, test.data); got != test.want {
+			t.Errorf("%s: length %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	// A tag without a layout cannot be framed
+	unknown := append(mustEncodeTLV(t, ServerNotice, "notice"), mustEncodeTLV(t, String, "field")...)
+	if _, err := messageLength(unknown); !errors.Is(err, ErrUnframedMessage) {
+		t.Errorf("a message of a tag without a layout gave %v, want ErrUnframedMessage", err)
+	}
+}
+
+// frameLength returns messageLength of data, failing the test when it cannot be framed
+func frameLength(t *testing.T, data []byte) int {
+	t.Helper()
+	length, err := messageLength(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return length
+}
+
+func TestEveryRequestHasALayout(t *testing.T) {
+	for tag := range requestHandlers {
+		if _, exists := requestLayouts[tag]; !exists {
+			t.Errorf("%s is handled but has no layout to be framed on a stream", GetTagName(tag))
+		}
+	}
+}
+
+// signRequest builds a request signed and hashed as the client does
+func signRequest(t *testing.T, signature string, fields ...tlvField) []byte {
+	t.Helper()
+	message, err := encodeTLVFields(append(fields, tlvField{ByteData, []byte(signature)})...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := EncodeTLV(ByteData, []byte(GenerateSignature(message)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(message, hash...)
+}
+
+// fieldBoundaries returns where each TLV of a message ends but the last
+func fieldBoundaries(t *testing.T, message []byte) []int {
+	t.Helper()
+	var boundaries []int
+	for offset := 0; ; {
+		_, _, size, err := SafeDecodeTLV(message[offset:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset += size
+		if offset == len(message) {
+			return boundaries
+		}
+		boundaries = append(boundaries, offset)
+	}
+}
+
+// writeFields writes a message one TLV at a time, as separate reads on the server
+func writeFields(t *testing.T, conn net.Conn, message []byte) {
+	t.Helper()
+	start := 0
+	for _, end := range append(fieldBoundaries(t, message), len(message)) {
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(message[start:end]); err != nil {
+			t.Fatalf("error writing the request: %v", err)
+		}
+		start = end
+	}
+}
+
+func TestMessageLengthWaitsForEveryField(t *testing.T) {
+	hello, _ := helloRequest(t, "Fields")
+	pong, _ := EncodeTLV(Pong, []byte("p"))
+	for name, request := range map[string][]byte{
+		"HelloRequest": hello,
+		"GameRequest": signRequest(t, "sig",
+			tlvField{GameRequest, []byte("GameRequest")}, tlvField{ByteData, []byte("Fields")}),
+		"GameRequest with options": signRequest(t, "sig",
+			tlvField{GameRequest, []byte("GameRequest")}, tlvField{ByteData, []byte("Fields")},
+			tlvField{String, []byte("chess960")}, tlvField{String, []byte("518")}),
+		"QueueRequest with a game": signRequest(t, "sig",
+			tlvField{QueueRequest, []byte("QueueRequest")}, tlvField{String, []byte("5+3")},
+			tlvField{Int, []byte("0")}, tlvField{String, []byte("tictactoe")}),
+		"BotGameRequest": signRequest(t, "sig",
+			tlvField{BotGameRequest, []byte("BotGameRequest")}, tlvField{Int, []byte("1")}, tlvField{String, []byte("white")}),
+		"ActionRequest": signRequest(t, "sig",
+			tlvField{ActionRequest, []byte("e4")}, tlvField{ByteData, []byte("game")}, tlvField{ByteData, []byte("Fields")}),
+		"BoardRequest":   append(mustEncodeTLV(t, BoardRequest, "game"), mustEncodeTLV(t, ByteData, "sig")...),
+		"ArchiveRequest": signRequest(t, "sig", tlvField{ArchiveRequest, []byte("ArchiveRequest")}),
+		"ArchiveRequest with an opening": signRequest(t, "sig",
+			tlvField{ArchiveRequest, []byte("ArchiveRequest")}, tlvField{String, []byte("C60")}),
+		"ArchiveRequest with an opening and a player": signRequest(t, "sig",
+			tlvField{ArchiveRequest, []byte("ArchiveRequest")}, tlvField{String, []byte("")}, tlvField{String, []byte("Fields")}),
+	} {
+		for _, boundary := range fieldBoundaries(t, request) {
+			if got := frameLength(t, request[:boundary]); got != 0 {
+				t.Errorf("%s cut after %d bytes: length %d, want 0 until it is whole", name, boundary, got)
+			}
+		}
+		if got := frameLength(t, request); got != len(request) {
+			t.Errorf("%s: length %d, want %d", name, got, len(request))
+		}
+		if got := frameLength(t, append(append([]byte{}, request...), pong...)); got != len(request) {
+			t.Errorf("%s followed by a Pong: length %d, want %d", name, got, len(request))
+		}
+	}
+}
+
+// mustEncodeTLV encodes a single TLV of a string value
+func mustEncodeTLV(t *testing.T, tag Tag, value string) []byte {
+	t.Helper()
+	encoded, err := EncodeTLV(tag, []byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestStreamServesRequestsSplitAtEveryField(t *testing.T) {
+	conn := serveStream(t)
+	hello, hash := helloRequest(t, "StreamFields")
+	writeFields(t, conn, hello)
+
+	var pending []byte
+	if tag, value := readMessage(t, conn, &pending); tag != HelloResponse || string(value) != hash {
+		t.Fatalf("got %s %q, want the HelloResponse", GetTagName(tag), value)
+	}
+
+	// The creator and the signature are ByteData like the hash, the request only ends with it
+	writeFields(t, conn, signRequest(t, "0123456789abcdef",
+		tlvField{GameRequest, []byte("GameRequest")}, tlvField{ByteData, []byte("StreamFields")}))
+	if tag, _ := readMessage(t, conn, &pending); tag != UUIDPartie {
+		t.Fatalf("got %s, want the ID of the created game", GetTagName(tag))
+	}
+}
+
+func TestStreamServesArchiveRequestsWithAndWithoutFilters(t *testing.T) {
+	conn := serveStream(t)
+	hello, hash := helloRequest(t, "StreamArchive")
+	writeFields(t, conn, hello)
+
+	var pending []byte
+	if tag, value := readMessage(t, conn, &pending); tag != HelloResponse || string(value) != hash {
+		t.Fatalf("got %s %q, want the HelloResponse", GetTagName(tag), value)
+	}
+
+	for name, filters := range map[string][]tlvField{
+		"no filter":  nil,
+		"an opening": {{String, []byte("C60")}},
+	} {
+		fields := append([]tlvField{{ArchiveRequest, []byte("ArchiveRequest")}}, filters...)
+		writeFields(t, conn, signRequest(t, "0123456789abcdef", fields...))
+		if tag, value := readMessage(t, conn, &pending); tag != ArchiveResponse {
+			t.Fatalf("ArchiveRequest with %s: got %s %q, want the ArchiveResponse", name, GetTagName(tag), value)
+		}
+	}
+
+	// The connection is still open for the next request
+	writeFields(t, conn, mustEncodeTLV(t, Ping, "after"))
+	if tag, value := readMessage(t, conn, &pending); tag != Pong || string(value) != "after" {
+		t.Fatalf("got %s %q, want the Pong", GetTagName(tag), value)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// lifecycleRounds is how many times each transport is started and stopped
+const lifecycleRounds = 20
+
+// waitForGoroutines waits for the goroutines to fall back to at most want, failing the test
+// with their stacks when they do not
+func waitForGoroutines(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			stacks := make([]byte, 1<<20)
+			stacks = stacks[:runtime.Stack(stacks, true)]
+			t.Fatalf("%d goroutines left running, want at most %d:\n%s", runtime.NumGoroutine(), want, stacks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// serveInBackground runs serve, returning a channel its error is sent on once it returns
+func serveInBackground(serve func(context.Context) error) <-chan error {
+	served := make(chan error, 1)
+	go func() { served <- serve(context.Background()) }()
+	return served
+}
+
+// waitServed fails the test when Serve did not return after Stop
+func waitServed(t *testing.T, served <-chan error) {
+	t.Helper()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Stop")
+	}
+}
+
+func TestTCPServerReleasesPortAndGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for round := 0; round < lifecycleRounds; round++ {
+		srv := NewTCPServer("127.0.0.1:0")
+		if err := srv.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		addr := srv.Addr().String()
+		served := serveInBackground(srv.Serve)
+
+		// A client still connected when the server stops
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv.Stop()
+		waitServed(t, served)
+		// The server closed the connection
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("the connection is still open after Stop")
+		}
+		conn.Close()
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatalf("round %d: port not released: %v", round, err)
+		}
+		listener.Close()
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestUDPServerReleasesPortAndGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for round := 0; round < lifecycleRounds; round++ {
+		srv := NewUDPServer("127.0.0.1:0")
+		if err := srv.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		addr := srv.Addr().(*net.UDPAddr)
+		served := serveInBackground(srv.Serve)
+
+		// A datagram the server may still be handling when it stops
+		client, err := net.DialUDP("udp", nil, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write([]byte{0, 0, 0})
+		client.Close()
+
+		srv.Stop()
+		waitServed(t, served)
+
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			t.Fatalf("round %d: port not released: %v", round, err)
+		}
+		conn.Close()
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestHTTPServerReleasesPortAndGoroutines(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+
+	before := runtime.NumGoroutine()
+	for round := 0; round < lifecycleRounds; round++ {
+		srv, err := startHTTPServer("test", "127.0.0.1:0", handler, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := client.Get("http://" + srv.Addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = srv.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listener, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			t.Fatalf("round %d: port not released: %v", round, err)
+		}
+		listener.Close()
+	}
+	waitForGoroutines(t, before)
+}
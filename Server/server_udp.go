@@ -1,49 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 )
 
 type UDPServer struct {
+	addr           string
 	conn           *net.UDPConn
 	clientRegistry *ClientRegistry
-	gameRegistry   *GameRegistry
-	stopChan       chan struct{}
-	wg             sync.WaitGroup
-}
 
-type GameRegistry struct {
-	games map[string]*Game
-	mu    sync.Mutex
-}
+	mu     sync.Mutex
+	cancel context.CancelFunc // Stops Serve, nil while Serve is not running
+	done   chan struct{}      // Closed once Serve has returned
 
-type Game struct {
-	ID        string
-	Players   map[string]*Player
-	Started   bool
-	Actions   []string
-	CreatedAt time.Time
+	wg       sync.WaitGroup // Datagram handlers
+	handlers chan struct{}  // One slot per datagram being handled, nil for no limit
 }
 
-type Player struct {
-	Username string
-	Address  *net.UDPAddr
-}
-
-func NewUDPServer() *UDPServer {
+// NewUDPServer creates a UDP server listening on addr (e.g. ":8081")
+func NewUDPServer(addr string) *UDPServer {
 	return &UDPServer{
+		addr:           addr,
 		clientRegistry: NewClientRegistry(),
-		gameRegistry:   &GameRegistry{games: make(map[string]*Game)},
-		stopChan:       make(chan struct{}),
 	}
 }
 
-func (srv *UDPServer) Start(port int) error {
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
+// Listen binds the UDP socket, so startup errors are known before Serve
+func (srv *UDPServer) Listen() error {
+	addr, err := net.ResolveUDPAddr("udp", srv.addr)
 	if err != nil {
 		return fmt.Errorf("failed to resolve UDP address: %w", err)
 	}
@@ -52,55 +42,124 @@ func (srv *UDPServer) Start(port int) error {
 	if err != nil {
 		return fmt.Errorf("failed to start UDP server: %w", err)
 	}
-
-	srv.wg.Add(1)
-	go srv.listen()
-	log.Printf("UDP server listening on port %d...\n", port)
+	slog.Info("UDP server listening", "addr", srv.conn.LocalAddr().String())
 	return nil
 }
 
-func (srv *UDPServer) listen() {
-	defer srv.wg.Done()
-	defer srv.conn.Close()
+// Addr returns the bound address, useful with an ephemeral port (":0")
+func (srv *UDPServer) Addr() net.Addr {
+	if srv.conn == nil {
+		return nil
+	}
+	return srv.conn.LocalAddr()
+}
+
+// Start binds the server's address and serves datagrams until ctx is cancelled
+func (srv *UDPServer) Start(ctx context.Context) error {
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	return srv.Serve(ctx)
+}
+
+// Serve reads datagrams until ctx is cancelled or Stop is called. It then closes the
+// socket, waits for the handlers still running and returns.
+func (srv *UDPServer) Serve(ctx context.Context) error {
+	if srv.conn == nil {
+		return errors.New("the UDP server is not bound, call Listen before Serve")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srv.mu.Lock()
+	srv.cancel, srv.done = cancel, make(chan struct{})
+	done := srv.done
+	srv.mu.Unlock()
+	defer close(done)
+
+	// Closing the socket unblocks ReadFromUDP
+	go func() {
+		<-ctx.Done()
+		srv.conn.Close()
+	}()
+
+	srv.wg.Add(1)
+	go func() {
+		defer srv.wg.Done()
+		srv.expireIdleClients(ctx)
+	}()
+
+	if config.MaxUDPHandlers > 0 {
+		srv.handlers = make(chan struct{}, config.MaxUDPHandlers)
+	}
 
 	for {
-		select {
-		case <-srv.stopChan:
-			log.Println("Shutting down UDP server...")
-			return
-		default:
-			// Prepare buffer for reading and clear it
-			buf := make([]byte, 2048) // Initialize a new buffer for each iteration
-			n, clientAddr, err := srv.conn.ReadFromUDP(buf)
-			if err != nil {
-				log.Printf("Error reading UDP message: %v\n", err)
-				continue
+		// Prepare buffer for reading and clear it
+		buf := make([]byte, 2048) // Initialize a new buffer for each iteration
+		n, clientAddr, err := srv.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				break
 			}
+			slog.Warn("Error reading UDP message", "err", err)
+			continue
+		}
 
-			// Clear the buffer slice by resetting its length to 0
-			buf = buf[:n]
+		// Clear the buffer slice by resetting its length to 0
+		buf = buf[:n]
 
-			// Log received data (for debugging purposes)
-			log.Printf("[DEBUG] Received data from %s: %x\n", clientAddr.String(), buf)
+		// Log received data (for debugging purposes)
+		slog.Debug("Datagram received", "transport", "udp", "remote", clientAddr.String(), "bytes", n)
+		serverMetrics.ObserveDatagram(clientAddr.String(), buf)
 
-			// Process the received data in a goroutine
-			srv.wg.Add(1)
-			go func(data []byte, addr *net.UDPAddr) {
-				defer srv.wg.Done()
-				srv.handleClientConnection(addr, data)
-			}(buf, clientAddr)
+		// Drop datagrams from banned addresses without answering
+		if bans.IsBanned(clientAddr.IP.String()) {
+			serverMetrics.refused.Inc("udp", "banned")
+			continue
 		}
+
+		// A flood must not start an unbounded number of goroutines: drop what cannot be
+		// handled now, the client retransmits
+		if !srv.acquireHandler() {
+			serverMetrics.refused.Inc("udp", "overloaded")
+			continue
+		}
+
+		// Drop datagrams once the server is shutting down
+		if !inFlightRequests.Begin() {
+			srv.releaseHandler()
+			continue
+		}
+
+		// Process the received data in a goroutine
+		srv.wg.Add(1)
+		go func(data []byte, addr *net.UDPAddr) {
+			defer srv.wg.Done()
+			defer srv.releaseHandler()
+			defer inFlightRequests.End()
+			srv.handleClientConnection(addr, data)
+		}(buf, clientAddr)
 	}
+
+	cancel()
+	srv.wg.Wait()
+	slog.Info("UDP server stopped")
+	return nil
 }
 
 func (srv *UDPServer) handleClientConnection(clientAddr *net.UDPAddr, initialData []byte) {
 	if clientAddr == nil {
-		log.Println("Error: nil client address")
+		slog.Error("Nil client address")
 		return
 	}
 
 	clientAddress := clientAddr.String()
-	clientInfo := &ClientInfo{ConnectedAt: time.Now()}
+	clientInfo := srv.clientRegistry.Touch(clientAddress, time.Now(), connections.Acquire)
+	if clientInfo == nil {
+		// New client while the server is full, like a refused TCP connection
+		serverMetrics.refused.Inc("udp", "connection_limit")
+		return
+	}
 
 	remainingData := initialData
 
@@ -108,16 +167,52 @@ func (srv *UDPServer) handleClientConnection(clientAddr *net.UDPAddr, initialDat
 	var err error
 	remainingData, err = srv.processIncomingData(remainingData, clientInfo, clientAddress, srv.conn, clientAddr)
 	if err != nil {
-		log.Printf("Error processing incoming data from %s: %v\n", clientAddress, err)
-		return
+		connLogger("udp", clientAddr).Warn("Error processing incoming data", "err", err)
 	}
+}
+
+// expireIdleClients disconnects, until ctx is cancelled, the clients that sent nothing,
+// not even a Pong, for longer than the idle timeout. UDP has no connection to see close.
+func (srv *UDPServer) expireIdleClients(ctx context.Context) {
+	ticker := time.NewTicker(config.PingInterval.Duration)
+	defer ticker.Stop()
 
-	srv.clientRegistry.AddClient(clientAddress, clientInfo)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, address := range srv.clientRegistry.RemoveIdle(now.Add(-config.IdleTimeout.Duration)) {
+				connections.Release()
+				disconnectClient(address, "idle timeout")
+			}
+		}
+	}
+}
+
+// acquireHandler takes a handler slot for a datagram, reporting false when all are busy
+func (srv *UDPServer) acquireHandler() bool {
+	if srv.handlers == nil {
+		return true
+	}
+	select {
+	case srv.handlers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (srv *UDPServer) releaseHandler() {
+	if srv.handlers != nil {
+		<-srv.handlers
+	}
 }
 
 func (srv *UDPServer) processIncomingData(data []byte, clientInfo *ClientInfo, clientAddress string, conn *net.UDPConn, clientAddr *net.UDPAddr) ([]byte, error) {
 	// Log the raw data received
-	log.Printf("Raw data received from %s: %x", clientAddress, data)
+	logger := connLogger("udp", clientAddr)
+	logger.Debug("Raw data received", "bytes", len(data))
 
 	// Ensure all parameters are non-nil
 	if data == nil || clientInfo == nil || conn == nil || clientAddr == nil {
@@ -126,91 +221,48 @@ func (srv *UDPServer) processIncomingData(data []byte, clientInfo *ClientInfo, c
 
 	// Check if we have at least 3 bytes for the tag and length of the first TLV element
 	if len(data) < 3 {
-		log.Println("Insufficient data received, waiting for more.")
+		logger.Debug("Insufficient data received, waiting for more")
 		return data, nil
 	}
 
 	// Decode the first TLV message to get the tag
 	tag, value, err := DecodeTLV(data)
 	if err != nil {
-		log.Printf("Error decoding TLV tag: %v", err)
+		logger.Warn("Error decoding TLV tag", "err", err)
 		return nil, fmt.Errorf("failed to decode TLV tag: %w", err)
 	}
 
 	// Log the decoded tag and value associated with it
-	log.Printf("Decoded tag: %d (%s), Value: %v", tag, GetTagName(tag), value)
-
-	// Process the request based on the tag
-	switch tag {
-	case HelloRequest:
-		// Handle the HelloRequest for a UDP connection
-		if err := HandleHelloRequest(nil, conn, clientAddr, data, false); err != nil {
-			log.Printf("Error handling HelloRequest: %v", err)
-			return nil, err
-		}
-		log.Println("HelloRequest successfully processed.")
-		return data[len(value)+3:], nil // Skip the processed bytes
-
-	case GameRequest:
-		// Handle the GameRequest (game-related logic)
-		if err := HandleGameRequest(nil, conn, clientAddr, data, false); err != nil {
-			log.Printf("Error handling GameRequest: %v", err)
-			return nil, err
-		}
-		log.Println("GameRequest successfully processed.")
-		return data[len(value)+3:], nil // Skip the processed bytes
-
-	case LobbyRequest:
-		// Handle the LobbyListRequest
-		if err := HandleLobbyListRequest(nil, conn, clientAddr, data, false); err != nil {
-			log.Printf("Error handling LobbyListRequest: %v", err)
-			return nil, err
-		}
-		log.Println("LobbyListRequest successfully processed.")
-		return data[len(value)+3:], nil // Skip the processed bytes
-
-	case BoardRequest:
-		// Check if the data has enough bytes for the TLV structure
-		if len(data) < 3 { // Set `expectedLength` based on your protocol
-			log.Printf("Received BoardRequest with insufficient data length: %d bytes", len(data))
-			return nil, fmt.Errorf("insufficient data length")
-		}
-
-		// Handle the BoardRequest (game board-related logic)
-		if err := HandleBoardRequest(conn, nil, nil, data, true); err != nil {
-			log.Printf("Error handling BoardRequest: %v", err)
-			return nil, err
-		}
-		log.Println("BoardRequest successfully processed, BoardResponse sent.")
-		return data[len(value)+3:], nil // Skip the processed bytes
-
-	default:
-		// Log unknown tags for debugging
-		log.Printf("Unknown tag encountered: %d (%s)", tag, GetTagName(tag))
-		// Return the remaining data for further processing
-		return data[len(value)+3:], nil // Skip the processed bytes
+	logger.Debug("Decoded tag", "tag", GetTagName(tag), "length", len(value))
+
+	// Hand the request to the handler registered for its tag
+	handled, err := dispatchRequest(tag, nil, conn, clientAddr, data, false)
+	if !handled {
+		// Skip unknown tags so the following messages can still be processed
+		logger.Warn("Unknown tag encountered", "tag", GetTagName(tag))
+		return data[len(value)+3:], nil
 	}
+	if err != nil {
+		logger.Warn("Error handling request", "request", GetTagName(tag), "err", err)
+		return nil, err
+	}
+	logger.Debug("Request processed", "request", GetTagName(tag))
+	return data[len(value)+3:], nil // Skip the processed bytes
 }
 
-func (srv *UDPServer) handleGameRequest(clientAddr *net.UDPAddr, data []byte) error {
-	// Example game logic, modify based on your actual game rules and protocol
-	log.Printf("Processing game request from %s", clientAddr.String())
-	// You can use clientAddr to identify the player and handle their game actions
-	return nil
-}
-
+// Stop shuts the UDP server down and waits for Serve to return
 func (srv *UDPServer) Stop() {
-	close(srv.stopChan)
-	srv.wg.Wait()
-}
-
-func startUDPServer() {
-	server := NewUDPServer()
-
-	// Start the server
-	if err := server.Start(8081); err != nil {
-		log.Fatalf("Failed to start UDP server: %v", err)
+	srv.mu.Lock()
+	cancel, done := srv.cancel, srv.done
+	srv.mu.Unlock()
+
+	if cancel == nil {
+		// Serve never ran, only the socket has to be released
+		if srv.conn != nil {
+			srv.conn.Close()
+		}
+		return
 	}
-
-	select {}
+	cancel()
+	<-done
 }
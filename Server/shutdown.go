@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// requestGate tracks the requests being handled so shutdown can wait for them,
+// and turns new requests away once the server is draining
+type requestGate struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
+	ctx      context.Context // Given to background work, cancelled by Drain
+	cancel   context.CancelFunc
+}
+
+// inFlightRequests is shared by the TCP and UDP servers
+var inFlightRequests = &requestGate{}
+
+// Begin registers a request. It returns false when the server is shutting down,
+// in which case the request must be dropped and End must not be called.
+func (g *requestGate) Begin() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	g.inFlight.Add(1)
+	return true
+}
+
+// End marks a request started with Begin as finished
+func (g *requestGate) End() {
+	g.inFlight.Done()
+}
+
+// Go runs fn in the background as a request in flight, for Drain to wait for it, such as a
+// request answered once a long computation is done. The context of fn is cancelled when the
+// server starts draining. It returns false without running fn when the server is shutting down.
+func (g *requestGate) Go(fn func(ctx context.Context)) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining {
+		return false
+	}
+	if g.ctx == nil {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+	}
+	g.inFlight.Add(1)
+	go func(ctx context.Context) {
+		defer g.inFlight.Done()
+		fn(ctx)
+	}(g.ctx)
+	return true
+}
+
+// Drain refuses new requests and waits for the current ones until ctx expires
+func (g *requestGate) Drain(ctx context.Context) error {
+	g.mu.Lock()
+	g.draining = true
+	if g.cancel != nil {
+		g.cancel()
+	}
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops the server in order: refuse new requests, warn the players, let in-flight
+// requests finish, save the games, then close the transports and the HTTP servers
+func shutdown(tcpServer *TCPServer, udpServer *UDPServer, httpServers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout.Duration)
+	defer cancel()
+
+	draining := make(chan error, 1)
+	go func() { draining <- inFlightRequests.Drain(ctx) }()
+
+	notice, err := encodeTLVFields(tlvField{String, []byte("The server is shutting down. Games in progress are saved and can be resumed later.")})
+	if err == nil {
+		slog.Info("Shutdown notice sent", "clients", peers.Broadcast(ServerNotice, notice))
+	}
+
+	if err := <-draining; err != nil {
+		slog.Warn("Shutting down with requests still in flight", "err", err)
+	}
+
+	matchmaker.Stop()
+	heartbeat.Stop()
+
+	if err := SaveGames(config.DataDir); err != nil {
+		slog.Error("Error saving games", "dir", config.DataDir, "err", err)
+	}
+
+	if closer, ok := analysisEngine.(io.Closer); ok {
+		closer.Close()
+	}
+
+	// Game viewers hold their HTTP requests open, they would keep the API from shutting down
+	gameEvents.CloseAll()
+
+	// Stop closes every connection and waits for their goroutines, bounded by the same timeout
+	stopped := make(chan struct{})
+	go func() {
+		if tcpServer != nil {
+			tcpServer.Stop()
+		}
+		if udpServer != nil {
+			udpServer.Stop()
+		}
+		for _, srv := range httpServers {
+			if srv != nil {
+				srv.Shutdown(ctx)
+			}
+		}
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		slog.Info("Server stopped")
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for the transports to stop")
+	}
+}
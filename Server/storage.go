@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// Files written in the data directory
+const (
+	gamesFile   = "games.json"
+	archiveFile = "archive.json"
+)
+
+// savedGame is a game in progress as written to disk. Moves are stored in UCI notation
+// from the starting position, or as the actions of a game other than chess, which is enough
+// to rebuild the game exactly.
+type savedGame struct {
+	ID            uuid.UUID
+	LobbyName     string
+	CreatorName   string
+	Owner         string `json:",omitempty"`
+	JoinedPlayers []string
+	MaxPlayers    int
+	IsLocked      bool
+	WhitePlayer   string
+	BlackPlayer   string
+	TimeControl   string
+	WhiteClock    time.Duration `json:",omitempty"` // Time left to each side of a timed game
+	BlackClock    time.Duration `json:",omitempty"`
+	Rated         bool
+	OpeningCode   string
+	OpeningName   string
+	Variant       Variant `json:",omitempty"` // Standard chess when empty
+	Kind          string  `json:",omitempty"` // Game of gameRegistry, chess when empty
+	StartFEN      string
+	Moves         []string
+	WhiteQueue    MoveTree `json:",omitempty"` // Moves queued by each side, see queueMoves
+	BlackQueue    MoveTree `json:",omitempty"`
+	StartedAt     time.Time
+}
+
+// SaveGames writes the games in progress and the game archive to dir
+func SaveGames(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	gameMutex.RLock()
+	var saved []savedGame
+	for _, session := range GameStore {
+		// Finished games live in the archive
+		if session.Outcome() != chess.NoOutcome {
+			continue
+		}
+		saved = append(saved, saveGame(session))
+	}
+	gameMutex.RUnlock()
+
+	if err := writeJSONFile(filepath.Join(dir, gamesFile), saved); err != nil {
+		return err
+	}
+	if err := writeJSONFile(filepath.Join(dir, archiveFile), gameArchive.Snapshot()); err != nil {
+		return err
+	}
+	slog.Info("Games saved", "in_progress", len(saved), "archived", gameArchive.Len(), "dir", dir)
+	return nil
+}
+
+func saveGame(session GameSession) savedGame {
+	saved := savedGame{
+		ID:            session.ID,
+		LobbyName:     session.LobbyName,
+		CreatorName:   session.CreatorName,
+		Owner:         session.Owner,
+		JoinedPlayers: session.JoinedPlayers,
+		MaxPlayers:    session.MaxPlayers,
+		IsLocked:      session.IsLocked,
+		WhitePlayer:   session.WhitePlayer,
+		BlackPlayer:   session.BlackPlayer,
+		Rated:         session.Rated,
+		OpeningCode:   session.OpeningCode,
+		OpeningName:   session.OpeningName,
+		Variant:       session.Variant,
+		Kind:          session.Kind,
+		StartFEN:      session.StartFEN,
+		WhiteQueue:    session.WhiteQueue,
+		BlackQueue:    session.BlackQueue,
+		StartedAt:     session.StartedAt,
+	}
+	if session.TimeControl.Initial > 0 {
+		saved.TimeControl = session.TimeControl.String()
+		saved.WhiteClock, saved.BlackClock = session.Clock.Remaining(session.Turn(), time.Now())
+	}
+	saved.Moves = session.TurnGame().Actions()
+	return saved
+}
+
+// LoadGames restores the games and the archive saved in dir. A missing file is not an error.
+func LoadGames(dir string) error {
+	var saved []savedGame
+	if err := readJSONFile(filepath.Join(dir, gamesFile), &saved); err != nil {
+		return err
+	}
+	var archived []ArchivedGame
+	if err := readJSONFile(filepath.Join(dir, archiveFile), &archived); err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, game := range saved {
+		session, err := restoreGame(game)
+		if err != nil {
+			slog.Warn("Skipping saved game", "game", game.ID, "err", err)
+			continue
+		}
+		gameMutex.Lock()
+		GameStore[session.ID] = session
+		LobbyNameToUUID[session.LobbyName] = session.ID
+		gameMutex.Unlock()
+		restored++
+	}
+	gameArchive.Load(archived)
+
+	if restored > 0 || len(archived) > 0 {
+		slog.Info("Games restored", "in_progress", restored, "archived", len(archived), "dir", dir)
+	}
+	return nil
+}
+
+func restoreGame(saved savedGame) (GameSession, error) {
+	session := GameSession{
+		ID:            saved.ID,
+		CreatorName:   saved.CreatorName,
+		Owner:         saved.Owner,
+		LobbyName:     saved.LobbyName,
+		JoinedPlayers: saved.JoinedPlayers,
+		MaxPlayers:    saved.MaxPlayers,
+		IsLocked:      saved.IsLocked,
+		WhitePlayer:   saved.WhitePlayer,
+		BlackPlayer:   saved.BlackPlayer,
+		Rated:         saved.Rated,
+		Variant:       saved.Variant,
+		Kind:          saved.Kind,
+		StartFEN:      saved.StartFEN,
+		WhiteQueue:    saved.WhiteQueue,
+		BlackQueue:    saved.BlackQueue,
+		StartedAt:     saved.StartedAt,
+	}
+	if session.Variant == "" && session.Kind == "" {
+		session.Variant = Standard
+	}
+	// Lobbies used to start without seats
+	if session.IsLocked && session.WhitePlayer == "" && session.BlackPlayer == "" && len(session.JoinedPlayers) >= 2 {
+		seatPlayers(&session)
+	}
+	// Replaying the moves also finds the opening and the Chess960 castling rights
+	if err := replayMoves(&session, saved.Moves); err != nil {
+		return GameSession{}, err
+	}
+	if saved.TimeControl != "" {
+		var err error
+		if session.TimeControl, err = ParseTimeControl(saved.TimeControl); err != nil {
+			return GameSession{}, err
+		}
+		// The side to move gets back the time the server was down
+		session.Clock = Clock{White: saved.WhiteClock, Black: saved.BlackClock, TurnStart: time.Now()}
+	}
+	return session, nil
+}
+
+// writeJSONFile replaces path atomically so a crash never leaves a half-written file
+func writeJSONFile(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing %s: %w", path, err)
+	}
+	return nil
+}
+
+func readJSONFile(path string, value interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return nil
+}
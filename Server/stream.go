@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// Viewers follow a game over HTTP with Server-Sent Events on GET /games/{id}/stream. The
+// stream opens with a "state" event holding the game as GET /games/{id} describes it, then
+// carries "move", "clock", "chat" and "result" events as they are pushed to the players.
+//
+// Event IDs count the events published for the game, and only grow: a takeback is a new
+// "state" event, not a step back. The "state" a viewer opens with carries the ID of the last
+// event it accounts for. A viewer reconnecting with Last-Event-ID, or ?last_event_id= since
+// browsers cannot set the header on the first request, gets the events it missed from the
+// last ones the bus keeps, or a new "state" when they are gone. A viewer that saw the
+// result gets 204 No Content, which tells EventSource to stop reconnecting.
+
+// streamChannelSize is the number of events a viewer may lag behind before being dropped,
+// to reconnect and catch up with Last-Event-ID
+const streamChannelSize = 64
+
+// streamBacklogSize is the number of events of a game kept for the viewers reconnecting
+const streamBacklogSize = 256
+
+// GameEvent is something that happened in a game, as told to the viewers
+type GameEvent struct {
+	ID   int    // Set by GameEventBus.Publish, see gameStream.lastID
+	Type string // state, move, clock, chat or result
+	Data any
+}
+
+// moveEvent is the data of a "move" event
+type moveEvent struct {
+	Ply   int    `json:"ply"`
+	SAN   string `json:"san"`
+	Mover string `json:"mover,omitempty"`
+	FEN   string `json:"fen"`
+}
+
+// chatEvent is the data of a "chat" event
+type chatEvent struct {
+	Player string `json:"player"`
+	Text   string `json:"text"`
+}
+
+// resultEvent is the data of a "result" event
+type resultEvent struct {
+	Result      string `json:"result"`
+	Termination string `json:"termination"`
+}
+
+// GameEventBus numbers the events of each game and hands them to the viewers subscribed to
+// it. Events are published under gameMutex, so a viewer subscribing under it misses nothing.
+type GameEventBus struct {
+	mu      sync.Mutex
+	streams map[uuid.UUID]*gameStream
+}
+
+// gameStream is what the bus knows of a game
+type gameStream struct {
+	viewers map[chan GameEvent]struct{}
+	lastID  int         // ID of the last event published, 0 before the first
+	recent  []GameEvent // Last events published, oldest first, for the viewers reconnecting
+	trimmed int         // ID of the newest event dropped from recent
+	over    bool        // The result was published
+}
+
+// gameEvents is fed with the board updates pushed to the players
+var gameEvents = NewGameEventBus()
+
+func NewGameEventBus() *GameEventBus {
+	return &GameEventBus{streams: make(map[uuid.UUID]*gameStream)}
+}
+
+// stream returns what the bus knows of a game, starting it if needed (the caller must hold b.mu)
+func (b *GameEventBus) stream(gameID uuid.UUID) *gameStream {
+	stream, exists := b.streams[gameID]
+	if !exists {
+		stream = &gameStream{viewers: make(map[chan GameEvent]struct{})}
+		b.streams[gameID] = stream
+	}
+	return stream
+}
+
+// Subscribe returns the channel the events of a game will be sent on. It is closed when the
+// game is over, when the viewer lags too far behind or when the server shuts down.
+func (b *GameEventBus) Subscribe(gameID uuid.UUID) chan GameEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := make(chan GameEvent, streamChannelSize)
+	b.stream(gameID).viewers[events] = struct{}{}
+	return events
+}
+
+// Unsubscribe forgets a viewer that went away
+func (b *GameEventBus) Unsubscribe(gameID uuid.UUID, events chan GameEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if stream, exists := b.streams[gameID]; exists {
+		if _, subscribed := stream.viewers[events]; subscribed {
+			stream.drop(events)
+		}
+	}
+}
+
+// Publish numbers events and sends them to the viewers of a game without blocking,
+// dropping the ones whose channel is full. After a result the game has nothing more to
+// tell and its viewers are let go.
+func (b *GameEventBus) Publish(gameID uuid.UUID, events ...GameEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stream := b.stream(gameID)
+	for i := range events {
+		stream.lastID++
+		events[i].ID = stream.lastID
+		stream.recent = append(stream.recent, events[i])
+		if events[i].Type == "result" {
+			stream.over = true
+		}
+	}
+	if excess := len(stream.recent) - streamBacklogSize; excess > 0 {
+		stream.trimmed = stream.recent[excess-1].ID
+		stream.recent = slices.Clone(stream.recent[excess:])
+	}
+
+	for viewer := range stream.viewers {
+		for _, event := range events {
+			select {
+			case viewer <- event:
+			default:
+				slog.Debug("Dropping a game viewer lagging behind", "game_id", gameID)
+				stream.drop(viewer)
+			}
+			if _, subscribed := stream.viewers[viewer]; !subscribed {
+				break
+			}
+		}
+	}
+	if stream.over {
+		stream.closeViewers()
+	}
+}
+
+// LastID returns the ID of the last event published for a game, 0 before the first
+func (b *GameEventBus) LastID(gameID uuid.UUID) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if stream, exists := b.streams[gameID]; exists {
+		return stream.lastID
+	}
+	return 0
+}
+
+// Since returns the events of a game published after the event lastID, reporting false when
+// the bus no longer has them all or never published lastID, e.g. before a restart
+func (b *GameEventBus) Since(gameID uuid.UUID, lastID int) ([]GameEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stream, exists := b.streams[gameID]
+	if !exists {
+		return nil, lastID == 0
+	}
+	if lastID < stream.trimmed || lastID > stream.lastID {
+		return nil, false
+	}
+	first, _ := slices.BinarySearchFunc(stream.recent, lastID+1, func(event GameEvent, id int) int {
+		return event.ID - id
+	})
+	return slices.Clone(stream.recent[first:]), true
+}
+
+// Close forgets a game that no longer exists, e.g. a closed lobby, letting go of its viewers
+func (b *GameEventBus) Close(gameID uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if stream, exists := b.streams[gameID]; exists {
+		stream.closeViewers()
+		delete(b.streams, gameID)
+	}
+}
+
+// CloseAll lets go of every viewer, so the HTTP server can shut down
+func (b *GameEventBus) CloseAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, stream := range b.streams {
+		stream.closeViewers()
+	}
+}
+
+// Count returns the number of viewers following games
+func (b *GameEventBus) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := 0
+	for _, stream := range b.streams {
+		count += len(stream.viewers)
+	}
+	return count
+}
+
+// drop closes a viewer's channel and forgets it (the caller must hold the bus's mu)
+func (s *gameStream) drop(events chan GameEvent) {
+	close(events)
+	delete(s.viewers, events)
+}
+
+// closeViewers drops every viewer of the game (the caller must hold the bus's mu)
+func (s *gameStream) closeViewers() {
+	for viewer := range s.viewers {
+		s.drop(viewer)
+	}
+}
+
+// moveEvents describes the move that was just played, the clocks after it and the result
+// when it ended the game (the caller must hold gameMutex)
+func moveEvents(session GameSession, moverName string, now time.Time) []GameEvent {
+	ply := session.Plies()
+	events := []GameEvent{{Type: "move", Data: moveEvent{
+		Ply:   ply,
+		SAN:   session.LastMoveSAN(),
+		Mover: moverName,
+		FEN:   session.FEN(),
+	}}}
+	finished := session.Outcome() != chess.NoOutcome
+	if session.TimeControl.Initial > 0 {
+		events = append(events, GameEvent{Type: "clock", Data: describeClock(session, !finished, now)})
+	}
+	if finished {
+		events = append(events, resultEventOf(session))
+	}
+	return events
+}
+
+// resultEventOf describes how a finished game ended (the caller must hold gameMutex)
+func resultEventOf(session GameSession) GameEvent {
+	return GameEvent{Type: "result", Data: resultEvent{
+		Result:      session.Outcome().String(),
+		Termination: session.TerminationMethod(),
+	}}
+}
+
+// lastEventID reads where a reconnecting viewer stopped, reporting false for a new viewer
+func lastEventID(r *http.Request) (int, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return 0, false, fmt.Errorf("invalid last event ID %q", value)
+	}
+	return id, true, nil
+}
+
+func handleAPIGameStream(w http.ResponseWriter, r *http.Request) {
+	gameID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid game ID: %w", err))
+		return
+	}
+	lastID, resuming, err := lastEventID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// What the viewer missed and its subscription are taken together under the lock moves
+	// are published under, so no event falls in between
+	var backlog []GameEvent
+	var events chan GameEvent
+	gameMutex.RLock()
+	session, live := GameStore[gameID]
+	if live {
+		finished := session.Outcome() != chess.NoOutcome
+		if missed, known := gameEvents.Since(gameID, lastID); resuming && known {
+			// Nothing is left to send a viewer that saw the result
+			backlog = missed
+		} else {
+			// The state accounts for every event so far
+			current := gameEvents.LastID(gameID)
+			backlog = []GameEvent{{ID: current, Type: "state", Data: describeGame(session, true)}}
+			if finished {
+				result := resultEventOf(session)
+				result.ID = current
+				backlog = append(backlog, result)
+			}
+		}
+		if !finished {
+			events = gameEvents.Subscribe(gameID)
+		}
+	}
+	gameMutex.RUnlock()
+
+	if !live {
+		// Games restored from disk may only be left in the archive
+		archived, exists := gameArchive.Get(gameID)
+		if !exists {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("game %s not found", gameID))
+			return
+		}
+		// The events of the game are gone with the server that published them, the state
+		// and the result carry ID 0 for a viewer reconnecting after them to stop
+		if !resuming || lastID != 0 {
+			backlog = []GameEvent{
+				{Type: "state", Data: describeArchivedGame(archived, true)},
+				{Type: "result", Data: resultEvent{Result: archived.Result, Termination: archived.Method}},
+			}
+		}
+	}
+	if events != nil {
+		defer gameEvents.Unsubscribe(gameID, events)
+	}
+	if len(backlog) == 0 && events == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keeps reverse proxies from holding events back
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	slog.Debug("Game viewer connected", "game_id", gameID, "remote", r.RemoteAddr, "last_event_id", lastID, "resuming", resuming)
+
+	for _, event := range backlog {
+		if err := writeSSE(w, event); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil || events == nil {
+		return
+	}
+
+	// Comments keep proxies from closing a stream that stays quiet between moves
+	keepAlive := time.NewTicker(config.PingInterval.Duration)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			if err := writeSSE(w, event); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeSSE writes an event in the text/event-stream format, its data as one line of JSON
+func writeSSE(w http.ResponseWriter, event GameEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// eventIDs lists the IDs of events
+func eventIDs(events []GameEvent) []int {
+	ids := make([]int, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestEventIDsOnlyGrow(t *testing.T) {
+	bus := NewGameEventBus()
+	gameID := uuid.New()
+	viewer := bus.Subscribe(gameID)
+
+	bus.Publish(gameID, GameEvent{Type: "move"}, GameEvent{Type: "clock"})
+	bus.Publish(gameID, GameEvent{Type: "move"})
+	// A takeback goes back in the game, not in the stream
+	bus.Publish(gameID, GameEvent{Type: "state"})
+	bus.Publish(gameID, GameEvent{Type: "chat"})
+
+	last := 0
+	for range 5 {
+		event := <-viewer
+		if event.ID <= last {
+			t.Fatalf("%s event has ID %d after %d", event.Type, event.ID, last)
+		}
+		last = event.ID
+	}
+	if bus.LastID(gameID) != last {
+		t.Errorf("last ID %d, want %d", bus.LastID(gameID), last)
+	}
+}
+
+func TestSinceReplaysMissedEvents(t *testing.T) {
+	bus := NewGameEventBus()
+	gameID := uuid.New()
+	if missed, known := bus.Since(gameID, 0); !known || len(missed) != 0 {
+		t.Errorf("a game without events gave %v, %v", missed, known)
+	}
+	for range 4 {
+		bus.Publish(gameID, GameEvent{Type: "move"})
+	}
+
+	missed, known := bus.Since(gameID, 2)
+	if ids := eventIDs(missed); !known || len(ids) != 2 || ids[0] != 3 || ids[1] != 4 {
+		t.Errorf("missed %v after event 2, want 3 and 4", ids)
+	}
+	if missed, known := bus.Since(gameID, 4); !known || len(missed) != 0 {
+		t.Errorf("an up to date viewer missed %v", eventIDs(missed))
+	}
+	// An ID the bus never gave, e.g. from before a restart
+	if _, known := bus.Since(gameID, 9); known {
+		t.Error("events after an unknown ID were replayed")
+	}
+}
+
+func TestSinceForgetsTrimmedEvents(t *testing.T) {
+	bus := NewGameEventBus()
+	gameID := uuid.New()
+	for range streamBacklogSize + 10 {
+		bus.Publish(gameID, GameEvent{Type: "move"})
+	}
+	if _, known := bus.Since(gameID, 5); known {
+		t.Error("events dropped from the backlog were replayed")
+	}
+	missed, known := bus.Since(gameID, 10)
+	if !known || len(missed) != streamBacklogSize || missed[0].ID != 11 {
+		t.Errorf("got %d events from %v, want the whole backlog", len(missed), eventIDs(missed[:1]))
+	}
+}
+
+func TestResultLetsViewersGo(t *testing.T) {
+	bus := NewGameEventBus()
+	gameID := uuid.New()
+	viewer := bus.Subscribe(gameID)
+	bus.Publish(gameID, GameEvent{Type: "move"}, GameEvent{Type: "result"})
+
+	var types []string
+	for event := range viewer {
+		types = append(types, event.Type)
+	}
+	if len(types) != 2 || types[1] != "result" {
+		t.Errorf("got %v, want the move and the result before the channel closes", types)
+	}
+	if bus.Count() != 0 {
+		t.Errorf("%d viewers left", bus.Count())
+	}
+	// A viewer reconnecting after the move still gets the result
+	if missed, known := bus.Since(gameID, 1); !known || len(missed) != 1 || missed[0].Type != "result" {
+		t.Errorf("missed %v after the move, want the result", missed)
+	}
+}
+
+func TestChatReachesViewers(t *testing.T) {
+	gameID, err := createMatchedGame("ChatWhite", "ChatBlack", TimeControl{}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := gameEvents.Subscribe(gameID)
+	defer gameEvents.Unsubscribe(gameID, viewer)
+
+	if err := relayChat(gameID, "ChatWhite", "  good luck "); err != nil {
+		t.Fatal(err)
+	}
+	event := <-viewer
+	if event.Type != "chat" || event.Data != (chatEvent{Player: "ChatWhite", Text: "good luck"}) {
+		t.Errorf("got %s event %+v, want the chat line", event.Type, event.Data)
+	}
+
+	// A viewer cannot chat
+	if err := relayChat(gameID, "Onlooker", "hello"); err == nil {
+		t.Error("a line from outside the game was relayed")
+	}
+	select {
+	case event := <-viewer:
+		t.Errorf("got %s event %+v for a refused line", event.Type, event.Data)
+	default:
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+// maxUndoPlies is how far back a takeback goes: the last move, or the last two so the
+// player asking is to move again
+const maxUndoPlies = 2
+
+// UndoProposal is a takeback a player asked for, the zero value when there is none
+type UndoProposal struct {
+	By    string
+	Plies int
+}
+
+// requestUndo asks the other players of a game to take back the last plies, pushing them an
+// UndoOffer. A pending request of the same player is replaced, one of the opponent's has to
+// be answered first.
+func requestUndo(gameID uuid.UUID, playerName string, plies int) error {
+	gameMutex.Lock()
+	session, ok := GameStore[gameID]
+	if !ok {
+		gameMutex.Unlock()
+		return fmt.Errorf("no game to take a move back in, create or join one first")
+	}
+	played := session.Plies()
+	switch {
+	case !slices.Contains(session.JoinedPlayers, playerName):
+		gameMutex.Unlock()
+		return fmt.Errorf("player %s is not in game %v", playerName, gameID)
+	case len(session.JoinedPlayers) < 2:
+		gameMutex.Unlock()
+		return fmt.Errorf("there is no opponent to ask yet")
+	case session.Outcome() != chess.NoOutcome:
+		gameMutex.Unlock()
+		return fmt.Errorf("game %v is over", gameID)
+	case session.Rated && !config.RatedTakebacks:
+		gameMutex.Unlock()
+		return fmt.Errorf("takebacks are disabled in rated games")
+	case plies < 1 || plies > maxUndoPlies:
+		gameMutex.Unlock()
+		return fmt.Errorf("a takeback goes back 1 or %d moves", maxUndoPlies)
+	case played == 0:
+		gameMutex.Unlock()
+		return fmt.Errorf("no move was played yet")
+	case plies > played:
+		gameMutex.Unlock()
+		return fmt.Errorf("only %d move was played", played)
+	case session.PendingUndo.By != "" && session.PendingUndo.By != playerName:
+		gameMutex.Unlock()
+		return fmt.Errorf("answer the takeback %s asked for first", session.PendingUndo.By)
+	}
+	session.PendingUndo = UndoProposal{By: playerName, Plies: plies}
+	GameStore[gameID] = session
+	gameMutex.Unlock()
+
+	gameLogger(gameID).Info("Takeback requested", "player", playerName, "plies", plies)
+	message, err := encodeTLVFields(
+		tlvField{String, []byte(playerName)},
+		tlvField{Int, []byte(strconv.Itoa(plies))},
+	)
+	if err != nil {
+		return err
+	}
+	for _, other := range session.JoinedPlayers {
+		if other == playerName {
+			continue
+		}
+		if err := pushToPlayer(other, UndoOffer, message); err != nil {
+			slog.Warn("Error pushing UndoOffer", "player", other, "err", err)
+		}
+	}
+	return nil
+}
+
+// answerUndo accepts or declines the takeback the opponent asked for. The players are told
+// with an UndoResult, followed on acceptance by the BoardUpdate of the position taken back to.
+func answerUndo(gameID uuid.UUID, playerName string, accept bool) error {
+	gameMutex.Lock()
+	session, ok := GameStore[gameID]
+	if !ok {
+		gameMutex.Unlock()
+		return fmt.Errorf("no game to answer a takeback in")
+	}
+	proposal := session.PendingUndo
+	switch {
+	case !slices.Contains(session.JoinedPlayers, playerName):
+		gameMutex.Unlock()
+		return fmt.Errorf("player %s is not in game %v", playerName, gameID)
+	case proposal.By == "" || session.Outcome() != chess.NoOutcome:
+		gameMutex.Unlock()
+		return fmt.Errorf("no takeback is waiting for an answer")
+	case proposal.By == playerName:
+		gameMutex.Unlock()
+		return fmt.Errorf("your opponent answers your own takeback request")
+	}
+	session.PendingUndo = UndoProposal{}
+
+	result := "declined"
+	var update []byte
+	if accept {
+		if err := takeBack(&session, proposal.Plies, time.Now()); err != nil {
+			GameStore[gameID] = session
+			gameMutex.Unlock()
+			return err
+		}
+		result = "accepted"
+		var err error
+		if update, err = encodeBoardUpdate(session, ""); err != nil {
+			gameLogger(gameID).Error("Error encoding BoardUpdate", "err", err)
+		}
+		gameEvents.Publish(gameID, GameEvent{Type: "state", Data: describeGame(session, true)})
+	}
+	GameStore[gameID] = session
+	gameMutex.Unlock()
+
+	gameLogger(gameID).Info("Takeback answered", "player", playerName, "result", result, "plies", proposal.Plies)
+	message, err := encodeTLVFields(
+		tlvField{String, []byte(result)},
+		tlvField{Int, []byte(strconv.Itoa(proposal.Plies))},
+		tlvField{String, []byte(playerName)},
+	)
+	if err != nil {
+		return err
+	}
+	for _, player := range session.JoinedPlayers {
+		if err := pushToPlayer(player, UndoResult, message); err != nil {
+			slog.Warn("Error pushing UndoResult", "player", player, "err", err)
+		}
+	}
+	if update != nil {
+		notifyGameUpdate(session.JoinedPlayers, "", update)
+	}
+	return nil
+}
+
+// takeBack rebuilds the game of a session without its last plies, restores the clocks as
+// they stood before them and drops the queued moves (the caller must hold gameMutex). The
+// clocks of moves played before the server restarted are not known, they keep running.
+func takeBack(session *GameSession, plies int, now time.Time) error {
+	history := session.History()
+	if plies > len(history) {
+		return fmt.Errorf("only %d move(s) were played", len(history))
+	}
+
+	// Replaying the moves from the start also finds the opening the shorter game is in
+	var moves []string
+	for _, move := range history[:len(history)-plies] {
+		moves = append(moves, move.UCI)
+	}
+	replayed := *session
+	if err := replayMoves(&replayed, moves); err != nil {
+		return err
+	}
+	*session = replayed
+	session.WhiteQueue, session.BlackQueue = nil, nil
+
+	if clocks := session.ClockHistory; plies <= len(clocks) {
+		clock := clocks[len(clocks)-plies]
+		if !clock.TurnStart.IsZero() {
+			clock.TurnStart = now
+		}
+		session.Clock = clock
+		session.ClockHistory = clocks[:len(clocks)-plies]
+	} else {
+		session.ClockHistory = nil
+	}
+	return nil
+}
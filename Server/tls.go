@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// selfSignedValidity is how long a generated development certificate stays valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// TLSEnabled reports whether the TCP listener serves TLS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSSelfSigned || c.TLSCertFile != ""
+}
+
+// serverTLSConfig builds the TLS settings of the TCP listener, nil when TLS is off. With
+// TLSSelfSigned, a development certificate is generated at the configured paths, or in the
+// data directory, unless one is already there.
+func serverTLSConfig(cfg Config) (*tls.Config, error) {
+	if !cfg.TLSEnabled() {
+		return nil, nil
+	}
+
+	certFile, keyFile := cfg.TLSCertFile, cfg.TLSKeyFile
+	if cfg.TLSSelfSigned {
+		if certFile == "" {
+			certFile = filepath.Join(cfg.DataDir, "tls", "server.crt")
+			keyFile = filepath.Join(cfg.DataDir, "tls", "server.key")
+		}
+		if _, err := os.Stat(certFile); errors.Is(err, os.ErrNotExist) {
+			if err := generateSelfSignedCert(certFile, keyFile, cfg.TCPAddr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the TLS certificate: %w", err)
+	}
+	logger := slog.With("cert", certFile, "fingerprint", certFingerprint(cert.Certificate[0]))
+	if cfg.TLSSelfSigned {
+		logger.Warn("TLS uses a self-signed certificate, for development only")
+	} else {
+		logger.Info("TLS enabled on the TCP listener")
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// Mutual TLS: bots and other automated clients prove who they are with a certificate
+	// signed by the client CA. Players without one are still served unless it is required.
+	if cfg.TLSClientCAFile != "" {
+		pool, err := loadCertPool(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.TLSRequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
+
+// loadCertPool reads the PEM certificates of a CA file
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// generateSelfSignedCert writes an ECDSA certificate and its key, valid for localhost and
+// the host of listenAddr. The certificate is its own CA, so clients can pin it with -tls-ca.
+func generateSelfSignedCert(certFile string, keyFile string, listenAddr string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating the TLS key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("error generating the certificate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ServeurTP2 development certificate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host := hostOf(listenAddr); host != "" && host != "localhost" && host != listenAddr {
+		if ip := net.ParseIP(host); ip == nil {
+			template.DNSNames = append(template.DNSNames, host)
+		} else if !ip.IsUnspecified() {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("error creating the certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error encoding the TLS key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o755); err != nil {
+		return fmt.Errorf("error creating the certificate directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		return fmt.Errorf("error creating the key directory: %w", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return fmt.Errorf("error writing the certificate: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return fmt.Errorf("error writing the TLS key: %w", err)
+	}
+	slog.Info("Self-signed certificate generated", "cert", certFile, "key_file", keyFile, "valid_until", template.NotAfter)
+	return nil
+}
+
+// certFingerprint is the SHA-256 of a DER certificate, in hex, for operators to compare
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// handshakeTLS completes the TLS handshake of a connection before the deadline and returns
+// the common name of the verified client certificate, "" when the client sent none. Plain
+// TCP connections are left alone.
+func handshakeTLS(conn net.Conn, deadline time.Time) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	tlsConn.SetDeadline(deadline)
+	defer tlsConn.SetDeadline(time.Time{})
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", nil
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName, nil
+}
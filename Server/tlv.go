@@ -5,7 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 )
 
 // Tag represents the type of the TLV tag (Tag-Length-Value)
@@ -31,11 +31,102 @@ const (
 	LobbyRequest         = 169
 	JoinLobbyRequest     = 178
 	lobbyResponse        = 170
+
+	// Matchmaking queue
+	QueueRequest       Tag = 60
+	QueueCancelRequest Tag = 61
+	QueueStatus        Tag = 160
+	MatchFound         Tag = 161
+
+	// Games against the server
+	BotGameRequest Tag = 31
+	BoardUpdate    Tag = 151
+
+	// Post-game analysis
+	AnalyzeRequest  Tag = 71
+	AnalyzeResponse Tag = 171
+
+	// Finished games
+	ArchiveRequest  Tag = 72
+	ArchiveResponse Tag = 172
+
+	// Keepalive sent by either side, answered with a Pong carrying the same value
+	Ping Tag = 80
+	Pong Tag = 180
+
+	// Claim of a game whose opponent left for longer than the abandon timeout
+	AbandonClaimRequest Tag = 41
+
+	// Chat between the players of a game, relayed by the server to the others
+	ChatRequest Tag = 42
+	ChatMessage Tag = 152
+
+	// Premove or conditional moves, played as soon as the opponent's move comes in
+	PremoveRequest  Tag = 43
+	PremoveResponse Tag = 143
+
+	// Takebacks: a player asks, the opponent is offered to accept or decline, both are told
+	UndoRequest Tag = 44
+	UndoAnswer  Tag = 45
+	UndoOffer   Tag = 153
+	UndoResult  Tag = 154
+
+	// Message from the server to every client, e.g. before a shutdown
+	ServerNotice Tag = 254
+
+	// Sent instead of a response when a request fails
+	ErrorResponse Tag = 255
 )
 
+// isFieldTag tells whether a tag is the one of a field of a message rather than the one a
+// message starts with
+func isFieldTag(tag Tag) bool {
+	switch tag {
+	case UUIDClient, UUIDPartie, Signature, String, Int, ByteData:
+		return true
+	}
+	return false
+}
+
+// layoutField is one field of a request, see requestLayouts
+type layoutField struct {
+	Tag      Tag
+	Optional bool // Present only when the next TLV carries Tag
+}
+
+// requestLayouts lists the fields following the TLV each request starts with, signature and
+// hash included, for the TCP stream to tell where a request ends. Every tag of requestHandlers
+// needs its layout here: a stream starting with a tag that has none cannot be framed.
+var requestLayouts = map[Tag][]layoutField{
+	HelloRequest:        {{Tag: String}, {Tag: String}, {Tag: String}, {Tag: Int}, {Tag: ByteData}, {Tag: ByteData}},
+	GameRequest:         {{Tag: ByteData}, {Tag: String, Optional: true}, {Tag: String, Optional: true}, {Tag: ByteData}, {Tag: ByteData}},
+	LobbyRequest:        {{Tag: ByteData}, {Tag: ByteData}},
+	JoinLobbyRequest:    {{Tag: ByteData}, {Tag: ByteData}},
+	BoardRequest:        {{Tag: ByteData}},
+	ActionRequest:       {{Tag: ByteData}, {Tag: ByteData}, {Tag: ByteData}, {Tag: ByteData}},
+	QueueRequest:        {{Tag: String}, {Tag: Int}, {Tag: String, Optional: true}, {Tag: ByteData}, {Tag: ByteData}},
+	QueueCancelRequest:  {{Tag: ByteData}, {Tag: ByteData}},
+	BotGameRequest:      {{Tag: Int}, {Tag: String}, {Tag: String, Optional: true}, {Tag: ByteData}, {Tag: ByteData}},
+	AnalyzeRequest:      {{Tag: String}, {Tag: ByteData}, {Tag: ByteData}},
+	ArchiveRequest:      {{Tag: String, Optional: true}, {Tag: String, Optional: true}, {Tag: ByteData}, {Tag: ByteData}},
+	AbandonClaimRequest: {{Tag: String}, {Tag: ByteData}, {Tag: ByteData}},
+	ChatRequest:         {{Tag: ByteData}, {Tag: ByteData}},
+	PremoveRequest:      {{Tag: ByteData}, {Tag: ByteData}},
+	UndoRequest:         {{Tag: ByteData}, {Tag: ByteData}},
+	UndoAnswer:          {{Tag: ByteData}, {Tag: ByteData}},
+	Ping:                {},
+	Pong:                {},
+}
+
+// maxTLVLength is the largest value the 2-byte length field can describe
+const maxTLVLength = 0xFFFF
+
 // EncodeTLV encodes a message in TLV (Tag-Length-Value) format
 func EncodeTLV(tag Tag, value []byte) ([]byte, error) {
-	// Calculate the length of the value
+	// Calculate the length of the value, which must fit in 2 bytes
+	if len(value) > maxTLVLength {
+		return nil, fmt.Errorf("value of %d bytes is too long for a TLV message", len(value))
+	}
 	length := uint16(len(value))
 
 	// Create a buffer for TLV encoding
@@ -101,12 +192,55 @@ func DecodeTLV(data []byte) (Tag, []byte, error) {
 	// Read the value (length bytes)
 	value := data[3 : 3+length]
 
-	// Log the tag and value for debugging
-	log.Printf("Decoded TLV: Tag=%s, Length=%d, Value=%s", GetTagName(tag), length, string(value))
+	// The value is not logged, it may be a signature
+	slog.Debug("Decoded TLV", "tag", GetTagName(tag), "length", length)
 
 	return tag, value, nil
 }
 
+// tlvField is one element of a message made of several consecutive TLVs
+type tlvField struct {
+	Tag   Tag
+	Value []byte
+}
+
+// encodeTLVFields encodes each field as a TLV and concatenates them
+func encodeTLVFields(fields ...tlvField) ([]byte, error) {
+	var encoded []byte
+	for _, field := range fields {
+		fieldTLV, err := EncodeTLV(field.Tag, field.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding %s field: %w", GetTagName(field.Tag), err)
+		}
+		encoded = append(encoded, fieldTLV...)
+	}
+	return encoded, nil
+}
+
+// decodeTLVFields splits a buffer of consecutive TLVs into its fields
+func decodeTLVFields(data []byte) ([]tlvField, error) {
+	var fields []tlvField
+	for len(data) > 0 {
+		tag, value, consumed, err := SafeDecodeTLV(data)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, tlvField{Tag: tag, Value: value})
+		data = data[consumed:]
+	}
+	return fields, nil
+}
+
+// tagByName returns the tag GetTagName names name, e.g. "LobbyRequest"
+func tagByName(name string) (Tag, bool) {
+	for tag := 0; tag <= 255; tag++ {
+		if GetTagName(Tag(tag)) == name {
+			return Tag(tag), true
+		}
+	}
+	return 0, false
+}
+
 // GetTagName returns a string representation of the tag
 func GetTagName(tag Tag) string {
 	switch tag {
@@ -130,10 +264,66 @@ func GetTagName(tag Tag) string {
 		return "GameRequest"
 	case GameResponse:
 		return "GameResponse"
+	case BoardRequest:
+		return "BoardRequest"
+	case BoardResponse:
+		return "BoardResponse"
+	case LobbyRequest:
+		return "LobbyRequest"
+	case lobbyResponse:
+		return "LobbyResponse"
+	case JoinLobbyRequest:
+		return "JoinLobbyRequest"
 	case ActionRequest:
 		return "ActionRequest"
 	case ActionResponse:
 		return "ActionResponse"
+	case QueueRequest:
+		return "QueueRequest"
+	case QueueCancelRequest:
+		return "QueueCancelRequest"
+	case QueueStatus:
+		return "QueueStatus"
+	case MatchFound:
+		return "MatchFound"
+	case BotGameRequest:
+		return "BotGameRequest"
+	case BoardUpdate:
+		return "BoardUpdate"
+	case AnalyzeRequest:
+		return "AnalyzeRequest"
+	case AnalyzeResponse:
+		return "AnalyzeResponse"
+	case ArchiveRequest:
+		return "ArchiveRequest"
+	case ArchiveResponse:
+		return "ArchiveResponse"
+	case Ping:
+		return "Ping"
+	case Pong:
+		return "Pong"
+	case AbandonClaimRequest:
+		return "AbandonClaimRequest"
+	case ChatRequest:
+		return "ChatRequest"
+	case ChatMessage:
+		return "ChatMessage"
+	case PremoveRequest:
+		return "PremoveRequest"
+	case PremoveResponse:
+		return "PremoveResponse"
+	case UndoRequest:
+		return "UndoRequest"
+	case UndoAnswer:
+		return "UndoAnswer"
+	case UndoOffer:
+		return "UndoOffer"
+	case UndoResult:
+		return "UndoResult"
+	case ServerNotice:
+		return "ServerNotice"
+	case ErrorResponse:
+		return "ErrorResponse"
 	default:
 		return fmt.Sprintf("Unknown(%d)", tag)
 	}
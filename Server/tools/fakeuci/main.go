@@ -0,0 +1,136 @@
+// Command fakeuci is a tiny UCI engine used to exercise the server's UCI bridge
+// without installing a real engine. It always plays the first legal move in
+// UCI order and reports a material-only score.
+//
+// Usage:
+//
+//	go build -o /tmp/fakeuci ./tools/fakeuci
+//	UCI_ENGINE_PATH=/tmp/fakeuci go run .
+//
+// With -log, every command received is appended to the given file.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+var pieceValues = map[chess.PieceType]int{
+	chess.Pawn:   100,
+	chess.Knight: 300,
+	chess.Bishop: 300,
+	chess.Rook:   500,
+	chess.Queen:  900,
+}
+
+func main() {
+	logPath := flag.String("log", "", "file the commands received are appended to")
+	flag.Parse()
+
+	var commandLog *os.File
+	if *logPath != "" {
+		file, err := os.OpenFile(*logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		commandLog = file
+	}
+
+	game := chess.NewGame()
+	scanner := bufio.NewScanner(os.Stdin)
+
+	for scanner.Scan() {
+		if commandLog != nil {
+			fmt.Fprintln(commandLog, scanner.Text())
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "uci":
+			fmt.Println("id name FakeUCI")
+			fmt.Println("id author TP2Reseau")
+			fmt.Println("option name Threads type spin default 1 min 1 max 8")
+			fmt.Println("uciok")
+		case "isready":
+			fmt.Println("readyok")
+		case "ucinewgame":
+			game = chess.NewGame()
+		case "position":
+			next, err := parsePosition(fields[1:])
+			if err != nil {
+				fmt.Printf("info string %v\n", err)
+				continue
+			}
+			game = next
+		case "go":
+			moves := game.ValidMoves()
+			if len(moves) == 0 {
+				fmt.Println("bestmove (none)")
+				continue
+			}
+			sort.Slice(moves, func(i, j int) bool { return moves[i].String() < moves[j].String() })
+			fmt.Printf("info depth 1 score cp %d pv %s\n", material(game.Position()), moves[0])
+			fmt.Printf("bestmove %s\n", moves[0])
+		case "quit":
+			return
+		}
+	}
+}
+
+// parsePosition handles "startpos [moves ...]" and "fen <fen> [moves ...]"
+func parsePosition(args []string) (*chess.Game, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing position")
+	}
+
+	game := chess.NewGame(chess.UseNotation(chess.UCINotation{}))
+	rest := args[1:]
+	if args[0] == "fen" {
+		end := len(args)
+		for i, arg := range args {
+			if arg == "moves" {
+				end = i
+				break
+			}
+		}
+		fen, err := chess.FEN(strings.Join(args[1:end], " "))
+		if err != nil {
+			return nil, err
+		}
+		game = chess.NewGame(fen, chess.UseNotation(chess.UCINotation{}))
+		rest = args[end:]
+	}
+
+	if len(rest) > 0 && rest[0] == "moves" {
+		for _, move := range rest[1:] {
+			if err := game.MoveStr(move); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return game, nil
+}
+
+// material scores the position from the side to move's point of view
+func material(pos *chess.Position) int {
+	score := 0
+	for _, piece := range pos.Board().SquareMap() {
+		if piece.Color() == pos.Turn() {
+			score += pieceValues[piece.Type()]
+		} else {
+			score -= pieceValues[piece.Type()]
+		}
+	}
+	return score
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/notnil/chess"
+)
+
+// TurnGame is a two-player turn-based game as the server hosts it: lobbies, matchmaking,
+// clocks, pushes and storage only go through this interface. Chess is one such game, see
+// chessGame; the others are found by name in gameRegistry.
+//
+// The two seats are those of chess: chess.White for the player who moves first,
+// chess.Black for the other.
+type TurnGame interface {
+	// Turn returns the seat to move
+	Turn() chess.Color
+	// LegalActions lists the actions the seat to move may play, none once the game is over
+	LegalActions() []string
+	// Play plays an action of the seat to move, refusing an illegal one
+	Play(action string) error
+	// Outcome returns the result of the game and the method it was reached by, chess.NoOutcome
+	// while the game goes on
+	Outcome() (chess.Outcome, string)
+	// State writes the current state of the game, as pushed to the players
+	State() string
+	// Actions lists the actions played so far, in a notation Play reads back
+	Actions() []string
+	// LastAction returns the last action played as the players read it, "" before the first
+	LastAction() string
+}
+
+// GameSetup is what a lobby is opened for: a game of gameRegistry, or a chess variant from
+// its starting position
+type GameSetup struct {
+	Kind     string // Name of the game in gameRegistry, "" for chess
+	Variant  Variant
+	StartFEN string // Position a chess game starts from, see Rules.StartingFEN
+}
+
+// ParseGameSetup reads the game a lobby is opened for: the name of a game of gameRegistry,
+// or a chess variant and the setup of its starting position (see ParseVariant)
+func ParseGameSetup(name string, setup string) (GameSetup, error) {
+	if kind, ok := gameRegistry.Lookup(name); ok {
+		if strings.TrimSpace(setup) != "" {
+			return GameSetup{}, fmt.Errorf("%s games take no starting position", kind)
+		}
+		return GameSetup{Kind: kind}, nil
+	}
+	variant, err := ParseVariant(name)
+	if err != nil {
+		return GameSetup{}, fmt.Errorf("%w, or a game among %s", err, strings.Join(gameRegistry.Names(), ", "))
+	}
+	startFEN, err := variant.Rules().StartingFEN(setup)
+	if err != nil {
+		return GameSetup{}, err
+	}
+	return GameSetup{Variant: variant, StartFEN: startFEN}, nil
+}
+
+// GameRegistry holds the turn-based games the server hosts besides chess, by name
+type GameRegistry struct {
+	mu    sync.RWMutex
+	games map[string]func() TurnGame
+}
+
+// gameRegistry holds the games lobbies may be opened for besides chess
+var gameRegistry = NewGameRegistry()
+
+func NewGameRegistry() *GameRegistry {
+	registry := &GameRegistry{games: make(map[string]func() TurnGame)}
+	registry.Register("tictactoe", newTicTacToe)
+	registry.Register("connectfour", newConnectFour)
+	return registry
+}
+
+// Register adds a game, newGame returning it at its start
+func (r *GameRegistry) Register(name string, newGame func() TurnGame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.games[gameName(name)] = newGame
+}
+
+// Lookup finds the name a game is registered under, forgiving case, dashes and spaces
+// ("Tic-tac-toe")
+func (r *GameRegistry) Lookup(name string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name = gameName(name)
+	_, exists := r.games[name]
+	return name, exists
+}
+
+// New starts a game of the given name
+func (r *GameRegistry) New(name string) (TurnGame, error) {
+	r.mu.RLock()
+	newGame, exists := r.games[gameName(name)]
+	r.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown game %q", name)
+	}
+	return newGame(), nil
+}
+
+// Names lists the registered games, sorted
+func (r *GameRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.games))
+	for name := range r.games {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gameName is the name a game is registered under: lowercase, without dashes or spaces
+func gameName(name string) string {
+	return strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// chessGame plays the chess game of a session by the rules of its variant. It holds no
+// state of its own, see GameSession.TurnGame.
+type chessGame struct{ session *GameSession }
+
+func (g chessGame) Turn() chess.Color {
+	return g.session.Game.Position().Turn()
+}
+
+func (g chessGame) LegalActions() []string {
+	if outcome, _ := g.Outcome(); outcome != chess.NoOutcome {
+		return nil
+	}
+	return g.session.Variant.Rules().LegalMoves(g.session)
+}
+
+// Play plays a move written in algebraic or UCI notation
+func (g chessGame) Play(action string) error {
+	return g.session.Variant.Rules().Play(g.session, action)
+}
+
+func (g chessGame) Outcome() (chess.Outcome, string) {
+	return g.session.Variant.Rules().Outcome(g.session)
+}
+
+// State writes the position in FEN
+func (g chessGame) State() string {
+	return g.session.Variant.Rules().FEN(g.session)
+}
+
+// Actions lists the moves played in UCI notation
+func (g chessGame) Actions() []string {
+	var moves []string
+	for _, move := range g.session.History() {
+		moves = append(moves, move.UCI)
+	}
+	return moves
+}
+
+// LastAction returns the last move in algebraic notation
+func (g chessGame) LastAction() string {
+	moves := g.session.Game.Moves()
+	positions := g.session.Game.Positions()
+	if len(moves) == 0 || len(positions) < 2 {
+		if parts := g.session.Parts; len(parts) > 0 {
+			return parts[len(parts)-1].SAN
+		}
+		return ""
+	}
+	return chess.AlgebraicNotation{}.Encode(positions[len(positions)-2], moves[len(moves)-1])
+}
+
+// replayActions plays the actions of a game other than chess from its start, listing the
+// state after each (see GameSession.History)
+func replayActions(kind string, actions []string) ([]PlayedMove, error) {
+	game, err := gameRegistry.New(kind)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]PlayedMove, 0, len(actions))
+	for _, action := range actions {
+		mover := game.Turn()
+		if err := game.Play(action); err != nil {
+			return history, fmt.Errorf("error replaying %s: %w", action, err)
+		}
+		history = append(history, PlayedMove{Mover: mover, SAN: game.LastAction(), UCI: action, FEN: game.State()})
+	}
+	return history, nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// UCIOptions configures a UCI engine subprocess
+type UCIOptions struct {
+	Path     string        // Engine binary
+	Args     []string      // Extra command-line arguments for the binary
+	Depth    int           // Search depth for "go depth", used when MoveTime is zero
+	MoveTime time.Duration // Time per move for "go movetime"
+	Threads  int           // Value of the "Threads" option, if the engine has one
+}
+
+// uciHandshakeTimeout bounds how long the engine may take to answer uci/isready
+var uciHandshakeTimeout = 10 * time.Second
+
+// UCIEngine drives an external engine over the Universal Chess Interface.
+// The process is started lazily and restarted if it dies.
+type UCIEngine struct {
+	opts  UCIOptions
+	level int
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	lines   chan string
+	stop    chan struct{} // Closed when killing the engine, for its reader to stop sending lines
+	reader  chan struct{} // Closed once the reader of the engine output returned
+	name    string
+	options map[string]bool
+}
+
+// NewUCIEngine creates an engine bridge; the process starts on first use
+func NewUCIEngine(opts UCIOptions) *UCIEngine {
+	if opts.Depth <= 0 && opts.MoveTime <= 0 {
+		opts.Depth = 12
+	}
+	return &UCIEngine{opts: opts}
+}
+
+// RegisterUCIEngine makes the engine at opts.Path available to bot games as "uci".
+// The bot level (1-5) maps onto the engine's "Skill Level" when it supports one.
+func RegisterUCIEngine(opts UCIOptions) {
+	RegisterEngine("uci", func(level int) (Engine, error) {
+		if level < 1 || level > MaxEngineLevel {
+			return nil, fmt.Errorf("engine level must be between 1 and %d, got %d", MaxEngineLevel, level)
+		}
+		engine := NewUCIEngine(opts)
+		engine.level = level
+		return engine, nil
+	})
+}
+
+func (e *UCIEngine) Name() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.name != "" {
+		return e.name
+	}
+	return fmt.Sprintf("UCI %s", e.opts.Path)
+}
+
+// Start launches the engine process and completes the uci/isready handshake
+func (e *UCIEngine) Start(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.startLocked(ctx)
+}
+
+func (e *UCIEngine) startLocked(ctx context.Context) error {
+	if e.cmd != nil {
+		return nil
+	}
+
+	cmd := exec.Command(e.opts.Path, e.opts.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error opening engine stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error opening engine stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting engine %s: %w", e.opts.Path, err)
+	}
+
+	// Read the engine output line by line until the process exits or is killed
+	lines := make(chan string, 64)
+	stop := make(chan struct{})
+	reader := make(chan struct{})
+	go func() {
+		defer close(reader)
+		defer close(lines)
+		defer cmd.Wait()
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			select {
+			case lines <- strings.TrimSpace(scanner.Text()):
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	e.cmd, e.stdin, e.stdout, e.lines = cmd, stdin, stdout, lines
+	e.stop, e.reader = stop, reader
+	e.options = make(map[string]bool)
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, uciHandshakeTimeout)
+	defer cancel()
+
+	if err := e.sendLocked("uci"); err != nil {
+		e.killLocked()
+		return err
+	}
+	err = e.readUntilLocked(handshakeCtx, "uciok", func(line string) {
+		if name, found := strings.CutPrefix(line, "id name "); found {
+			e.name = name
+		}
+		if option, found := strings.CutPrefix(line, "option name "); found {
+			if i := strings.Index(option, " type "); i >= 0 {
+				e.options[option[:i]] = true
+			}
+		}
+	})
+	if err != nil {
+		e.killLocked()
+		return fmt.Errorf("engine did not complete the uci handshake: %w", err)
+	}
+
+	if e.opts.Threads > 0 && e.options["Threads"] {
+		e.sendLocked(fmt.Sprintf("setoption name Threads value %d", e.opts.Threads))
+	}
+	if e.level > 0 && e.options["Skill Level"] {
+		// Spread the bot levels over Stockfish's 0-20 skill range
+		skill := (e.level - 1) * 20 / (MaxEngineLevel - 1)
+		e.sendLocked(fmt.Sprintf("setoption name Skill Level value %d", skill))
+	}
+
+	if err := e.readyLocked(handshakeCtx); err != nil {
+		e.killLocked()
+		return err
+	}
+	slog.Info("UCI engine started", "engine", e.name, "path", e.opts.Path)
+	return nil
+}
+
+// Close asks the engine to quit and kills it if it does not
+func (e *UCIEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cmd == nil {
+		return nil
+	}
+
+	e.sendLocked("quit")
+	select {
+	case <-e.drainLocked():
+	case <-time.After(time.Second):
+	}
+	e.killLocked()
+	return nil
+}
+
+func (e *UCIEngine) BestMove(ctx context.Context, game *chess.Game) (*chess.Move, error) {
+	analysis, err := e.Analyze(ctx, game)
+	if err != nil {
+		return nil, err
+	}
+
+	move, err := chess.UCINotation{}.Decode(game.Position(), analysis.BestMove)
+	if err != nil {
+		return nil, fmt.Errorf("engine returned an invalid move %q: %w", analysis.BestMove, err)
+	}
+	return move, nil
+}
+
+// Analyze searches the game's current position with the configured depth or move time
+func (e *UCIEngine) Analyze(ctx context.Context, game *chess.Game) (*EngineAnalysis, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.startLocked(ctx); err != nil {
+		return nil, err
+	}
+
+	analysis, err := e.searchLocked(ctx, uciPositionCommand(game))
+	if err != nil {
+		// A confused or dead engine is restarted on the next request
+		e.killLocked()
+		return nil, err
+	}
+	return analysis, nil
+}
+
+func (e *UCIEngine) searchLocked(ctx context.Context, position string) (*EngineAnalysis, error) {
+	if err := e.sendLocked("ucinewgame"); err != nil {
+		return nil, err
+	}
+	if err := e.readyLocked(ctx); err != nil {
+		return nil, err
+	}
+	if err := e.sendLocked(position); err != nil {
+		return nil, err
+	}
+
+	goCommand := fmt.Sprintf("go depth %d", e.opts.Depth)
+	if e.opts.MoveTime > 0 {
+		goCommand = fmt.Sprintf("go movetime %d", e.opts.MoveTime.Milliseconds())
+	}
+	if err := e.sendLocked(goCommand); err != nil {
+		return nil, err
+	}
+
+	analysis := &EngineAnalysis{}
+	stopped := false
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return nil, errors.New("engine exited during search")
+			}
+			if bestMove, found := strings.CutPrefix(line, "bestmove "); found {
+				fields := strings.Fields(bestMove)
+				if len(fields) == 0 || fields[0] == "(none)" || fields[0] == "0000" {
+					return nil, errors.New("engine found no legal move")
+				}
+				analysis.BestMove = fields[0]
+				return analysis, nil
+			}
+			if strings.HasPrefix(line, "info ") {
+				parseUCIInfo(line, analysis)
+			}
+		case <-ctx.Done():
+			// Ask for the best move found so far, then give up if it never comes
+			if stopped {
+				return nil, ctx.Err()
+			}
+			stopped = true
+			e.sendLocked("stop")
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+		}
+	}
+}
+
+// parseUCIInfo copies depth, score and pv from an "info" line into the analysis
+func parseUCIInfo(line string, analysis *EngineAnalysis) {
+	fields := strings.Fields(line)
+	for i := 1; i < len(fields); i++ {
+		switch fields[i] {
+		case "depth":
+			if i+1 < len(fields) {
+				analysis.Depth, _ = strconv.Atoi(fields[i+1])
+				i++
+			}
+		case "score":
+			if i+2 < len(fields) {
+				value, _ := strconv.Atoi(fields[i+2])
+				switch fields[i+1] {
+				case "cp":
+					analysis.ScoreCP, analysis.Mate = value, 0
+				case "mate":
+					analysis.Mate = value
+				}
+				i += 2
+			}
+		case "pv":
+			analysis.PV = append([]string(nil), fields[i+1:]...)
+			return
+		}
+	}
+}
+
+// uciPositionCommand describes the game to the engine as its starting position plus the moves played
+func uciPositionCommand(game *chess.Game) string {
+	var command strings.Builder
+	start := game.Positions()[0].String()
+	if start == chess.StartingPosition().String() {
+		command.WriteString("position startpos")
+	} else {
+		command.WriteString("position fen " + start)
+	}
+
+	moves := game.Moves()
+	if len(moves) > 0 {
+		command.WriteString(" moves")
+		for _, move := range moves {
+			command.WriteString(" " + move.String())
+		}
+	}
+	return command.String()
+}
+
+func (e *UCIEngine) readyLocked(ctx context.Context) error {
+	if err := e.sendLocked("isready"); err != nil {
+		return err
+	}
+	if err := e.readUntilLocked(ctx, "readyok", nil); err != nil {
+		return fmt.Errorf("engine is not ready: %w", err)
+	}
+	return nil
+}
+
+func (e *UCIEngine) sendLocked(command string) error {
+	if e.stdin == nil {
+		return errors.New("engine is not running")
+	}
+	if _, err := io.WriteString(e.stdin, command+"\n"); err != nil {
+		return fmt.Errorf("error writing %q to engine: %w", command, err)
+	}
+	return nil
+}
+
+// readUntilLocked consumes engine output until a line equal to want, passing other lines to onLine
+func (e *UCIEngine) readUntilLocked(ctx context.Context, want string, onLine func(string)) error {
+	for {
+		select {
+		case line, ok := <-e.lines:
+			if !ok {
+				return errors.New("engine exited")
+			}
+			if line == want {
+				return nil
+			}
+			if onLine != nil {
+				onLine(line)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// drainLocked returns a channel closed once the engine's output ends
+func (e *UCIEngine) drainLocked() <-chan struct{} {
+	done := make(chan struct{})
+	lines := e.lines
+	go func() {
+		defer close(done)
+		for range lines {
+		}
+	}()
+	return done
+}
+
+// killLocked kills the engine process and waits for the reader of its output to return, so
+// that no goroutine is left behind on the old pipe when the engine is restarted
+func (e *UCIEngine) killLocked() {
+	if e.cmd == nil {
+		return
+	}
+	close(e.stop)
+	e.stdin.Close()
+	e.stdout.Close()
+	if e.cmd.Process != nil {
+		e.cmd.Process.Kill()
+	}
+	<-e.reader
+	e.cmd, e.stdin, e.stdout, e.lines = nil, nil, nil, nil
+	e.stop, e.reader = nil, nil
+}
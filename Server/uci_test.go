@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// buildFakeUCI compiles tools/fakeuci for the test, returning the path of the binary
+func buildFakeUCI(t *testing.T) string {
+	t.Helper()
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is needed to build the fake engine")
+	}
+	binary := filepath.Join(t.TempDir(), "fakeuci")
+	if output, err := exec.Command(goTool, "build", "-o", binary, "./tools/fakeuci").CombinedOutput(); err != nil {
+		t.Fatalf("error building the fake engine: %v\n%s", err, output)
+	}
+	return binary
+}
+
+// noEnv is an environment without any variable
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+// commandsReceived reads the commands the fake engine logged
+func commandsReceived(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestUCIEngineUsesConfiguredThreadsAndMoveTime(t *testing.T) {
+	binary := buildFakeUCI(t)
+	cfg, err := LoadConfig([]string{"-uci-engine", binary, "-uci-threads", "4", "-uci-movetime", "50ms"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := cfg.botEngineOptions()
+	logPath := filepath.Join(t.TempDir(), "commands")
+	opts.Args = []string{"-log", logPath}
+	engine := NewUCIEngine(opts)
+	defer engine.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	move, err := engine.BestMove(ctx, chess.NewGame())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake engine plays the first legal move in UCI order
+	if move.String() != "a2a3" {
+		t.Errorf("best move %s, want a2a3", move)
+	}
+	if name := engine.Name(); name != "FakeUCI" {
+		t.Errorf("engine name %q, want FakeUCI", name)
+	}
+
+	commands := strings.Join(commandsReceived(t, logPath), "\n")
+	for _, want := range []string{"setoption name Threads value 4", "position startpos", "go movetime 50"} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("the engine never received %q, got:\n%s", want, commands)
+		}
+	}
+}
+
+func TestUCIAnalysisSearchesToDepth(t *testing.T) {
+	binary := buildFakeUCI(t)
+	env := map[string]string{"TP2_UCI_ENGINE_PATH": binary, "TP2_UCI_THREADS": "2", "TP2_UCI_MOVETIME": "1s"}
+	cfg, err := LoadConfig([]string{"-analysis-depth", "9"}, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.UCIThreads != 2 || cfg.UCIMoveTime.Duration != time.Second {
+		t.Fatalf("threads %d and move time %v, want 2 and 1s from the environment", cfg.UCIThreads, cfg.UCIMoveTime)
+	}
+
+	opts := cfg.analysisEngineOptions()
+	logPath := filepath.Join(t.TempDir(), "commands")
+	opts.Args = []string{"-log", logPath}
+	engine := NewUCIEngine(opts)
+	defer engine.Close()
+
+	game := chess.NewGame(chess.UseNotation(chess.UCINotation{}))
+	for _, move := range []string{"e2e4", "d7d5", "e4d5"} {
+		if err := game.MoveStr(move); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	analysis, err := engine.Analyze(ctx, game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Black to move is a pawn down
+	if analysis.ScoreCP != -100 || analysis.Depth != 1 || len(analysis.PV) != 1 {
+		t.Errorf("got %+v, want a score of -100 at depth 1", analysis)
+	}
+
+	commands := strings.Join(commandsReceived(t, logPath), "\n")
+	for _, want := range []string{"setoption name Threads value 2", "position startpos moves e2e4 d7d5 e4d5", "go depth 9"} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("the engine never received %q, got:\n%s", want, commands)
+		}
+	}
+}
+
+func TestUCIEngineRestartLeavesNoReader(t *testing.T) {
+	binary := buildFakeUCI(t)
+	engine := NewUCIEngine(UCIOptions{Path: binary})
+	defer engine.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	before := runtime.NumGoroutine()
+	for range 5 {
+		if err := engine.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		engine.mu.Lock()
+		// Fill the output buffer nobody reads, leaving the reader blocked on the next line
+		for range 2 * cap(engine.lines) {
+			if err := engine.sendLocked("isready"); err != nil {
+				engine.mu.Unlock()
+				t.Fatal(err)
+			}
+		}
+		for len(engine.lines) < cap(engine.lines) && ctx.Err() == nil {
+			time.Sleep(time.Millisecond)
+		}
+		engine.killLocked()
+		engine.mu.Unlock()
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestConfigRefusesNegativeUCISettings(t *testing.T) {
+	for _, args := range [][]string{{"-uci-threads", "-1"}, {"-uci-movetime", "-1s"}} {
+		if _, err := LoadConfig(args, noEnv); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
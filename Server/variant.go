@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// Variant is the set of rules a game is played with
+type Variant string
+
+const (
+	Standard      Variant = "standard"
+	Chess960      Variant = "chess960"
+	FromPosition  Variant = "fromposition" // Standard rules from a position given in FEN
+	KingOfTheHill Variant = "kingofthehill"
+	ThreeCheck    Variant = "threecheck"
+	Horde         Variant = "horde"
+)
+
+// ParseVariant reads a variant name, "" standing for standard chess
+func ParseVariant(name string) (Variant, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "standard":
+		return Standard, nil
+	case "chess960", "960", "fischerandom":
+		return Chess960, nil
+	case "fromposition", "fen":
+		return FromPosition, nil
+	case "kingofthehill", "koth":
+		return KingOfTheHill, nil
+	case "threecheck", "three-check", "3check":
+		return ThreeCheck, nil
+	case "horde":
+		return Horde, nil
+	}
+	return "", fmt.Errorf("unknown variant %q, expected standard, chess960, fromposition, kingofthehill, threecheck or horde", name)
+}
+
+// Rules returns the rules the variant is played by
+func (v Variant) Rules() Rules {
+	switch v {
+	case Chess960:
+		return chess960Rules{}
+	case FromPosition:
+		return fromPositionRules{}
+	case KingOfTheHill:
+		return kingOfTheHillRules{}
+	case ThreeCheck:
+		return threeCheckRules{}
+	case Horde:
+		return hordeRules{}
+	}
+	return standardRules{}
+}
+
+// nonStandard returns the variant, "" for standard chess so it is left out of summaries
+func (v Variant) nonStandard() Variant {
+	if v == Standard {
+		return ""
+	}
+	return v
+}
+
+// PGNName is the variant as the PGN Variant tag writes it, "" for standard chess
+func (v Variant) PGNName() string {
+	switch v {
+	case Chess960:
+		return "Chess960"
+	case FromPosition:
+		return "From Position"
+	case KingOfTheHill:
+		return "King of the Hill"
+	case ThreeCheck:
+		return "Three-check"
+	case Horde:
+		return "Horde"
+	}
+	return ""
+}
+
+// chess960FEN returns the Chess960 starting position of a number, using Scharnagl's numbering,
+// with its castling rights in Shredder-FEN: the files of the rooks, e.g. "HAha"
+func chess960FEN(number int) string {
+	var rank [8]byte
+	free := func(nth int) int {
+		for file := range rank {
+			if rank[file] == 0 {
+				if nth == 0 {
+					return file
+				}
+				nth--
+			}
+		}
+		return -1
+	}
+
+	// The bishops on squares of both colors, the queen, the knights, then rook, king and rook
+	rank[number%4*2+1] = 'B'
+	number /= 4
+	rank[number%4*2] = 'B'
+	number /= 4
+	rank[free(number%6)] = 'Q'
+	number /= 6
+	knights := [10][2]int{{0, 1}, {0, 2}, {0, 3}, {0, 4}, {1, 2}, {1, 3}, {1, 4}, {2, 3}, {2, 4}, {3, 4}}[number]
+	second := free(knights[1])
+	rank[free(knights[0])] = 'N'
+	rank[second] = 'N'
+	rank[free(0)] = 'R'
+	rank[free(0)] = 'K'
+	rank[free(0)] = 'R'
+
+	var rooks []byte
+	for file := len(rank) - 1; file >= 0; file-- {
+		if rank[file] == 'R' {
+			rooks = append(rooks, 'A'+byte(file))
+		}
+	}
+	white := string(rank[:])
+	castling := string(rooks) + strings.ToLower(string(rooks))
+	return fmt.Sprintf("%s/pppppppp/8/8/8/8/PPPPPPPP/%s w %s - 0 1", strings.ToLower(white), white, castling)
+}
+
+// validateFEN checks a position a game may start from: one king each, no pawn on the first
+// or last rank, the side not to move not in check, castling rights matching the kings and
+// rooks on their squares, and some move to play
+func validateFEN(fen string) error {
+	position, err := chess.FEN(fen)
+	if err != nil {
+		return fmt.Errorf("invalid FEN: %w", err)
+	}
+	game := chess.NewGame(position)
+	pos := game.Position()
+	board := pos.Board().SquareMap()
+
+	kings := map[chess.Color][]chess.Square{}
+	for square, piece := range board {
+		switch {
+		case piece.Type() == chess.King:
+			kings[piece.Color()] = append(kings[piece.Color()], square)
+		case piece.Type() == chess.Pawn && (square.Rank() == chess.Rank1 || square.Rank() == chess.Rank8):
+			return fmt.Errorf("invalid FEN: a pawn stands on %s", square)
+		}
+	}
+	if len(kings[chess.White]) != 1 || len(kings[chess.Black]) != 1 {
+		return fmt.Errorf("invalid FEN: each side needs one king")
+	}
+	if waiting := pos.Turn().Other(); attacked(board, kings[waiting][0], pos.Turn()) {
+		return fmt.Errorf("invalid FEN: %s is in check but it is not to move", waiting.Name())
+	}
+
+	// The chess package castles from the standard squares only
+	rights := map[rune][2]chess.Square{'K': {chess.E1, chess.H1}, 'Q': {chess.E1, chess.A1}, 'k': {chess.E8, chess.H8}, 'q': {chess.E8, chess.A8}}
+	for _, right := range string(pos.CastleRights()) {
+		squares, ok := rights[right]
+		if !ok {
+			continue
+		}
+		color := chess.White
+		if right == 'k' || right == 'q' {
+			color = chess.Black
+		}
+		if board[squares[0]] != chess.NewPiece(chess.King, color) || board[squares[1]] != chess.NewPiece(chess.Rook, color) {
+			return fmt.Errorf("invalid FEN: castling right %c without the king and rook on %s and %s", right, squares[0], squares[1])
+		}
+	}
+
+	if game.Outcome() != chess.NoOutcome {
+		return fmt.Errorf("the game is already over in this position")
+	}
+	return nil
+}
+
+// withCastling replaces the castling rights of a FEN, "" for none
+func withCastling(fen string, castling string) string {
+	fields := strings.Fields(fen)
+	if len(fields) < 3 {
+		return fen
+	}
+	if castling == "" {
+		castling = "-"
+	}
+	fields[2] = castling
+	return strings.Join(fields, " ")
+}
+
+// inCheck tells whether the side to move is in check, false when it has no king
+func inCheck(position *chess.Position) bool {
+	board := position.Board().SquareMap()
+	for square, piece := range board {
+		if piece == chess.NewPiece(chess.King, position.Turn()) {
+			return attacked(board, square, position.Turn().Other())
+		}
+	}
+	return false
+}
+
+// attacked tells whether a piece of color by attacks target on a board
+func attacked(board map[chess.Square]chess.Piece, target chess.Square, by chess.Color) bool {
+	file, rank := int(target.File()), int(target.Rank())
+	pieceAt := func(f, r int) chess.Piece {
+		if f < 0 || f > 7 || r < 0 || r > 7 {
+			return chess.NoPiece
+		}
+		return board[chess.NewSquare(chess.File(f), chess.Rank(r))]
+	}
+	is := func(piece chess.Piece, types ...chess.PieceType) bool {
+		for _, t := range types {
+			if piece == chess.NewPiece(t, by) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Pawns take towards the other side
+	forward := 1
+	if by == chess.Black {
+		forward = -1
+	}
+	if is(pieceAt(file-1, rank-forward), chess.Pawn) || is(pieceAt(file+1, rank-forward), chess.Pawn) {
+		return true
+	}
+	for _, jump := range [8][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}} {
+		if is(pieceAt(file+jump[0], rank+jump[1]), chess.Knight) {
+			return true
+		}
+	}
+	for _, step := range [8][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}, {1, 1}, {1, -1}, {-1, 1}, {-1, -1}} {
+		sliders := []chess.PieceType{chess.Rook, chess.Queen}
+		if step[0] != 0 && step[1] != 0 {
+			sliders = []chess.PieceType{chess.Bishop, chess.Queen}
+		}
+		if is(pieceAt(file+step[0], rank+step[1]), chess.King) {
+			return true
+		}
+		for f, r := file+step[0], rank+step[1]; f >= 0 && f <= 7 && r >= 0 && r <= 7; f, r = f+step[0], r+step[1] {
+			if piece := pieceAt(f, r); piece != chess.NoPiece {
+				if is(piece, sliders...) {
+					return true
+				}
+				break
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestAttacked(t *testing.T) {
+	for _, test := range []struct {
+		fen    string
+		square chess.Square
+		by     chess.Color
+		want   bool
+	}{
+		{"4k3/8/8/8/8/8/3p4/4K3 w - - 0 1", chess.E1, chess.Black, true},  // Pawn taking down the board
+		{"4k3/8/8/8/8/8/4p3/4K3 w - - 0 1", chess.E1, chess.Black, false}, // Pawns do not take straight ahead
+		{"4k3/8/8/8/8/8/8/3PK3 w - - 0 1", chess.E2, chess.White, true},   // White pawns take up the board
+		{"4k3/8/8/8/8/5n2/8/4K3 w - - 0 1", chess.E1, chess.Black, true},
+		{"4k3/8/8/8/8/8/8/R5K1 w - - 0 1", chess.D1, chess.White, true},
+		{"4k3/8/8/8/8/8/8/RN4K1 w - - 0 1", chess.D1, chess.White, false}, // Rook behind the knight
+		{"4k3/8/8/b7/8/8/8/4K3 w - - 0 1", chess.E1, chess.Black, true},
+		{"4k3/8/8/b7/8/2P5/8/4K3 w - - 0 1", chess.E1, chess.Black, false}, // Bishop behind the pawn
+		{"4k3/8/8/8/8/8/8/q3K3 w - - 0 1", chess.E1, chess.Black, true},
+		{"8/8/8/8/8/8/3k4/4K3 w - - 0 1", chess.E1, chess.Black, true}, // Next to the other king
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", chess.E2, chess.Black, false},
+	} {
+		position, err := chess.FEN(test.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		board := chess.NewGame(position).Position().Board().SquareMap()
+		if got := attacked(board, test.square, test.by); got != test.want {
+			t.Errorf("%s: %s attacked by %s is %v, want %v", test.fen, test.square, test.by.Name(), got, test.want)
+		}
+	}
+}
+
+func TestValidateFEN(t *testing.T) {
+	for _, test := range []struct {
+		fen  string
+		want string // Part of the error, "" for a valid position
+	}{
+		{"4k3/8/8/8/8/8/8/R3K2R w KQ - 0 1", ""},
+		{"4k3/8/8/8/8/8/8/3QK3 b - - 0 1", ""},
+		{"not a position", "invalid FEN"},
+		{"4k3/8/8/8/8/8/8/P3K3 w - - 0 1", "a pawn stands on a1"},
+		{"4k3/8/8/8/8/8/8/8 w - - 0 1", "each side needs one king"},
+		{"4kk2/8/8/8/8/8/8/4K3 w - - 0 1", "each side needs one king"},
+		{"4k3/8/8/8/8/8/8/4K2R w - - 0 1", ""},
+		{"4k3/8/8/8/8/8/8/4K2R b - - 0 1", ""},
+		{"4k2R/8/8/8/8/8/8/4K3 w - - 0 1", "Black is in check but it is not to move"},
+		{"4k3/8/8/8/8/8/8/4K3 w K - 0 1", "castling right K"},
+		{"4k3/8/8/8/8/8/8/3K3R w K - 0 1", "castling right K"},
+		{"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", "already over"},
+	} {
+		err := validateFEN(test.fen)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: refused with %v", test.fen, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: got %v, want an error about %q", test.fen, err, test.want)
+		}
+	}
+}
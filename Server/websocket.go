@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The WebSocket gateway lets browsers, which cannot open raw TCP or UDP sockets, speak the
+// TLV protocol. Each binary message carries TLV bytes exactly as a TCP client would send
+// them, and the server answers and pushes with one binary message per TLV. The connection
+// is then served by the TCP server, so browser and CLI players share the same dispatcher,
+// sessions, limits and games.
+
+// websocketGUID is appended to the client key to compute Sec-WebSocket-Accept (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage bounds the size of a frame, a TLV message never comes close
+const maxWebSocketMessage = 64 * 1024
+
+// maxControlPayload bounds the payload of ping, pong and close frames (RFC 6455, 5.5)
+const maxControlPayload = 125
+
+// WebSocket frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// WebSocket close status codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseUnsupported   = 1003
+	wsCloseTooBig        = 1009
+)
+
+// websocketHandler upgrades GET /ws requests and hands the connections to the TCP server
+func websocketHandler(srv *TCPServer, allowedOrigins []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && len(allowedOrigins) > 0 && !slices.Contains(allowedOrigins, origin) {
+			slog.Warn("WebSocket origin refused", "remote", r.RemoteAddr, "origin", origin)
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			slog.Warn("WebSocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		if err := srv.ServeConn(conn); err != nil {
+			slog.Warn("WebSocket connection refused", "remote", r.RemoteAddr, "err", err)
+		}
+	})
+	return mux
+}
+
+// upgradeWebSocket checks the opening handshake, answers it and takes the connection over
+// from the HTTP server. On failure the HTTP error has already been written.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported WebSocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("invalid Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("the response writer cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("error taking over the connection: %w", err)
+	}
+	// The HTTP server's timeouts no longer apply, the TCP server sets its own deadlines
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error answering the handshake: %w", err)
+	}
+	return &wsConn{Conn: conn, reader: rw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma separated header holds a token, ignoring case
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is a server-side WebSocket seen as a net.Conn: Read returns the payload of the
+// binary messages as one byte stream, each Write is sent as one binary message. Deadlines
+// and addresses are those of the underlying connection.
+type wsConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	pending   []byte // Payload received and not read yet, only used by the reading goroutine
+	fragments bool   // A binary message is split in frames and its last one is still to come
+
+	writeMu   sync.Mutex // Responses and pushes are written from several goroutines
+	closeOnce sync.Once
+}
+
+// Read returns payload bytes, answering pings and the closing handshake on the way
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+
+		switch opcode {
+		case wsBinary:
+			if c.fragments {
+				return 0, c.protocolError("binary WebSocket message started before the previous one ended")
+			}
+			c.pending, c.fragments = payload, !fin
+		case wsContinuation:
+			if !c.fragments {
+				return 0, c.protocolError("WebSocket continuation frame without a message started")
+			}
+			c.pending, c.fragments = payload, !fin
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, err
+			}
+		case wsPong:
+			// Nothing to do, the TLV Ping/Pong keeps the connection alive
+		case wsClose:
+			c.closeWith(wsCloseNormal)
+			return 0, io.EOF
+		case wsText:
+			c.closeWith(wsCloseUnsupported)
+			return 0, errors.New("text WebSocket messages are not supported, TLV goes in binary messages")
+		default:
+			return 0, c.protocolError(fmt.Sprintf("unknown WebSocket opcode %#x", opcode))
+		}
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readFrame reads one frame and unmasks its payload. Client frames must be masked, use no
+// extension, and control frames must be whole and carry at most maxControlPayload bytes.
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if header[0]&0x70 != 0 {
+		// No extension was negotiated to give the RSV bits a meaning
+		return false, 0, nil, c.protocolError("WebSocket frame with RSV bits set")
+	}
+	if !masked {
+		return false, 0, nil, c.protocolError("unmasked WebSocket frame from the client")
+	}
+	if opcode&0x8 != 0 && (!fin || length > maxControlPayload) {
+		return false, 0, nil, c.protocolError(fmt.Sprintf("fragmented or %d bytes long WebSocket control frame", length))
+	}
+	if length > maxWebSocketMessage {
+		c.closeWith(wsCloseTooBig)
+		return false, 0, nil, fmt.Errorf("WebSocket frame of %d bytes is too large", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// protocolError closes the WebSocket with a protocol error, returning the reason as an error
+func (c *wsConn) protocolError(reason string) error {
+	c.closeWith(wsCloseProtocolError)
+	return errors.New(reason)
+}
+
+// Write sends p as one binary message
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends a single unmasked frame, as servers do
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// closeWith sends a close frame with a status code, then closes the connection
+func (c *wsConn) closeWith(code uint16) {
+	c.closeOnce.Do(func() {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, code))
+		c.Conn.Close()
+	})
+}
+
+// Close ends the WebSocket with a normal closure
+func (c *wsConn) Close() error {
+	c.closeWith(wsCloseNormal)
+	return nil
+}
+
+// streamTransport names the transport of a stream connection for logs and metrics
+func streamTransport(conn net.Conn) string {
+	if _, ok := conn.(*wsConn); ok {
+		return "websocket"
+	}
+	return "tcp"
+}
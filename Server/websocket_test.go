@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// testOrigin is the only origin the test gateway accepts
+const testOrigin = "https://play.example"
+
+// serveWebSocket starts the WebSocket gateway of a TCP server, returning its address
+func serveWebSocket(t *testing.T) string {
+	t.Helper()
+	gateway := httptest.NewServer(websocketHandler(NewTCPServer(""), []string{testOrigin}))
+	t.Cleanup(gateway.Close)
+	return gateway.Listener.Addr().String()
+}
+
+// wsClient is the browser end of a WebSocket
+type wsClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	pending []byte // Payload of binary frames not decoded yet
+}
+
+// upgradeRequest sends the opening handshake, returning the server's answer
+func upgradeRequest(t *testing.T, addr string, header http.Header) (*http.Response, net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request, err := http.NewRequest(http.MethodGet, "http://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header = header
+	if err := request.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, conn, reader
+}
+
+// upgradeHeader is the header of a browser opening a WebSocket from origin
+func upgradeHeader(origin string) http.Header {
+	return http.Header{
+		"Connection":            {"keep-alive, Upgrade"},
+		"Upgrade":               {"websocket"},
+		"Sec-Websocket-Version": {"13"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+		"Origin":                {origin},
+	}
+}
+
+// dialWebSocket opens a WebSocket to the gateway as a browser of the allowed origin
+func dialWebSocket(t *testing.T, addr string) *wsClient {
+	t.Helper()
+	response, conn, reader := upgradeRequest(t, addr, upgradeHeader(testOrigin))
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade answered %s", response.Status)
+	}
+	return &wsClient{conn: conn, reader: reader}
+}
+
+// writeFrame sends a frame whose first byte holds the FIN and RSV bits and the opcode,
+// masked as browsers do unless told otherwise
+func (c *wsClient) writeFrame(t *testing.T, first byte, payload []byte, masked bool) {
+	t.Helper()
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	default:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	if masked {
+		mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("error writing a frame: %v", err)
+	}
+}
+
+// readFrame reads the next frame of the server, which are never masked
+func (c *wsClient) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatalf("error reading a frame: %v", err)
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("the server masked its frame")
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// send writes a TLV message in one masked binary frame
+func (c *wsClient) send(t *testing.T, message []byte) {
+	t.Helper()
+	c.writeFrame(t, 0x80|wsBinary, message, true)
+}
+
+// next returns the next TLV message of the server, read from its binary frames
+func (c *wsClient) next(t *testing.T) (Tag, []byte) {
+	t.Helper()
+	for {
+		if tag, value, length, err := SafeDecodeTLV(c.pending); err == nil {
+			c.pending = c.pending[length:]
+			return tag, value
+		}
+		opcode, payload := c.readFrame(t)
+		if opcode != wsBinary {
+			t.Fatalf("got a frame of opcode %#x, want a binary message", opcode)
+		}
+		c.pending = append(c.pending, payload...)
+	}
+}
+
+// expectClose checks the server closes the WebSocket with the given status code
+func (c *wsClient) expectClose(t *testing.T, code uint16) {
+	t.Helper()
+	opcode, payload := c.readFrame(t)
+	if opcode != wsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != code {
+		t.Fatalf("got a frame of opcode %#x and payload %v, want a close frame with status %d", opcode, payload, code)
+	}
+	if _, err := c.reader.ReadByte(); err != io.EOF {
+		t.Fatalf("the connection is still open after the close frame: %v", err)
+	}
+}
+
+// hello says Hello as a player, checking the answer
+func (c *wsClient) hello(t *testing.T, name string) {
+	t.Helper()
+	hello, hash := helloRequest(t, name)
+	c.send(t, hello)
+	if tag, value := c.next(t); tag != HelloResponse || string(value) != hash {
+		t.Fatalf("got %s %q, want the HelloResponse", GetTagName(tag), value)
+	}
+}
+
+func TestWebSocketUpgradeChecksTheRequest(t *testing.T) {
+	addr := serveWebSocket(t)
+
+	plain := upgradeHeader(testOrigin)
+	plain.Del("Upgrade")
+	oldVersion := upgradeHeader(testOrigin)
+	oldVersion.Set("Sec-WebSocket-Version", "8")
+	badKey := upgradeHeader(testOrigin)
+	badKey.Set("Sec-WebSocket-Key", "short")
+	noOrigin := upgradeHeader("")
+	noOrigin.Del("Origin")
+
+	for _, test := range []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"other origin", upgradeHeader("https://evil.example"), http.StatusForbidden},
+		{"not an upgrade", plain, http.StatusBadRequest},
+		{"old version", oldVersion, http.StatusUpgradeRequired},
+		{"invalid key", badKey, http.StatusBadRequest},
+		{"allowed origin", upgradeHeader(testOrigin), http.StatusSwitchingProtocols},
+		{"no origin, not a browser", noOrigin, http.StatusSwitchingProtocols},
+	} {
+		response, _, _ := upgradeRequest(t, addr, test.header)
+		if response.StatusCode != test.want {
+			t.Errorf("%s: answered %s, want %d", test.name, response.Status, test.want)
+			continue
+		}
+		// The accept value of the key RFC 6455 takes as example
+		if test.want == http.StatusSwitchingProtocols && response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("%s: Sec-WebSocket-Accept %q", test.name, response.Header.Get("Sec-WebSocket-Accept"))
+		}
+	}
+}
+
+func TestWebSocketCarriesTLVInBinaryFrames(t *testing.T) {
+	ws := dialWebSocket(t, serveWebSocket(t))
+
+	// A message split in a binary frame and its continuation
+	hello, hash := helloRequest(t, "WsFrames")
+	ws.writeFrame(t, wsBinary, hello[:7], true)
+	ws.writeFrame(t, 0x80|wsContinuation, hello[7:], true)
+	if tag, value := ws.next(t); tag != HelloResponse || string(value) != hash {
+		t.Fatalf("got %s %q, want the HelloResponse", GetTagName(tag), value)
+	}
+
+	// Two messages in one frame
+	ping := append(mustEncodeTLV(t, Ping, "one"), mustEncodeTLV(t, Ping, "two")...)
+	ws.send(t, ping)
+	for _, want := range []string{"one", "two"} {
+		if tag, value := ws.next(t); tag != Pong || string(value) != want {
+			t.Fatalf("got %s %q, want the Pong %q", GetTagName(tag), value, want)
+		}
+	}
+}
+
+func TestWebSocketAnswersPings(t *testing.T) {
+	ws := dialWebSocket(t, serveWebSocket(t))
+
+	ws.writeFrame(t, 0x80|wsPing, []byte("are you there"), true)
+	if opcode, payload := ws.readFrame(t); opcode != wsPong || string(payload) != "are you there" {
+		t.Fatalf("got a frame of opcode %#x and payload %q, want the pong", opcode, payload)
+	}
+
+	// A pong from the browser needs no answer, the next message is served
+	ws.writeFrame(t, 0x80|wsPong, nil, true)
+	ws.send(t, mustEncodeTLV(t, Ping, "after"))
+	if tag, value := ws.next(t); tag != Pong || string(value) != "after" {
+		t.Fatalf("got %s %q, want the Pong", GetTagName(tag), value)
+	}
+}
+
+func TestWebSocketCloseHandshake(t *testing.T) {
+	ws := dialWebSocket(t, serveWebSocket(t))
+	ws.writeFrame(t, 0x80|wsClose, binary.BigEndian.AppendUint16(nil, wsCloseNormal), true)
+	ws.expectClose(t, wsCloseNormal)
+}
+
+func TestWebSocketRefusesInvalidFrames(t *testing.T) {
+	addr := serveWebSocket(t)
+	for _, test := range []struct {
+		name   string
+		frames func(t *testing.T, ws *wsClient)
+		code   uint16
+	}{
+		{"unmasked frame", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, 0x80|wsBinary, mustEncodeTLV(t, Ping, "p"), false)
+		}, wsCloseProtocolError},
+		{"RSV bit set", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, 0x80|0x40|wsBinary, mustEncodeTLV(t, Ping, "p"), true)
+		}, wsCloseProtocolError},
+		{"ping over 125 bytes", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, 0x80|wsPing, make([]byte, maxControlPayload+1), true)
+		}, wsCloseProtocolError},
+		{"fragmented ping", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, wsPing, []byte("part"), true)
+		}, wsCloseProtocolError},
+		{"continuation without a message", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, 0x80|wsContinuation, mustEncodeTLV(t, Ping, "p"), true)
+		}, wsCloseProtocolError},
+		{"message inside a fragmented message", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, wsBinary, []byte{byte(Ping)}, true)
+			ws.writeFrame(t, 0x80|wsBinary, mustEncodeTLV(t, Ping, "p"), true)
+		}, wsCloseProtocolError},
+		{"unknown opcode", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, 0x80|0x3, nil, true)
+		}, wsCloseProtocolError},
+		{"text message", func(t *testing.T, ws *wsClient) {
+			ws.writeFrame(t, 0x80|wsText, []byte("hello"), true)
+		}, wsCloseUnsupported},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ws := dialWebSocket(t, addr)
+			test.frames(t, ws)
+			ws.expectClose(t, test.code)
+		})
+	}
+}
+
+func TestWebSocketAndTCPPlayersShareAGame(t *testing.T) {
+	ws := dialWebSocket(t, serveWebSocket(t))
+	ws.hello(t, "WsAnn")
+
+	// The browser player opens a lobby
+	ws.send(t, signRequest(t, "0123456789abcdef",
+		tlvField{GameRequest, []byte("GameRequest")}, tlvField{ByteData, []byte("WsAnn")}))
+	tag, value := ws.next(t)
+	if tag != UUIDPartie {
+		t.Fatalf("got %s %q, want the ID of the created game", GetTagName(tag), value)
+	}
+	_, idBytes, err := DecodeTLV(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gameID, err := uuid.FromBytes(idBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		gameMutex.Lock()
+		delete(LobbyNameToUUID, GameStore[gameID].LobbyName)
+		delete(GameStore, gameID)
+		gameMutex.Unlock()
+		gameEvents.Close(gameID)
+	})
+
+	// The TCP player joins it
+	tcp := serveStream(t)
+	hello, hash := helloRequest(t, "TcpBob")
+	writeFields(t, tcp, hello)
+	var pending []byte
+	if tag, value := readMessage(t, tcp, &pending); tag != HelloResponse || string(value) != hash {
+		t.Fatalf("got %s %q, want the HelloResponse", GetTagName(tag), value)
+	}
+	writeFields(t, tcp, signRequest(t, "0123456789abcdef", tlvField{JoinLobbyRequest, []byte("Lobby-WsAnn")}))
+	if tag, value := readMessage(t, tcp, &pending); tag != JoinLobbyRequest {
+		t.Fatalf("got %s %q, want the join accepted", GetTagName(tag), value)
+	}
+
+	// The browser is told the game began, plays, and the TCP player sees the move
+	if tag, value := ws.next(t); tag != BoardUpdate {
+		t.Fatalf("got %s %q, want the BoardUpdate of the start", GetTagName(tag), value)
+	}
+	// The TCP end is a pipe: the push to it must be read before the mover gets its answer
+	ws.send(t, moveRequest(t, "0123456789abcdef", "e4", gameID, "WsAnn"))
+	tag, value = readMessage(t, tcp, &pending)
+	if tag != BoardUpdate {
+		t.Fatalf("got %s %q, want the BoardUpdate of the move", GetTagName(tag), value)
+	}
+	if fields := fmt.Sprint(decodeTLVFieldsOrNil(value)); !strings.Contains(fields, "e4") {
+		t.Errorf("the BoardUpdate of the TCP player does not carry e4: %s", fields)
+	}
+	if tag, value := ws.next(t); tag != ActionResponse {
+		t.Fatalf("got %s %q, want the move played", GetTagName(tag), value)
+	}
+}
+
+// decodeTLVFieldsOrNil returns the values of the TLV fields of data as strings
+func decodeTLVFieldsOrNil(data []byte) []string {
+	fields, err := decodeTLVFields(data)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, field := range fields {
+		values = append(values, string(field.Value))
+	}
+	return values
+}